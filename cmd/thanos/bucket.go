@@ -5,12 +5,18 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
 	"sort"
+	"strings"
 	"text/template"
 	"time"
 
 	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
 	"github.com/improbable-eng/thanos/pkg/block"
+	"github.com/improbable-eng/thanos/pkg/compact"
+	"github.com/improbable-eng/thanos/pkg/compact/downsample"
+	"github.com/improbable-eng/thanos/pkg/objstore/audit"
 	"github.com/improbable-eng/thanos/pkg/objstore/client"
 	"github.com/improbable-eng/thanos/pkg/runutil"
 	"github.com/improbable-eng/thanos/pkg/verifier"
@@ -19,6 +25,8 @@ import (
 	opentracing "github.com/opentracing/opentracing-go"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/prometheus/tsdb"
 	"gopkg.in/alecthomas/kingpin.v2"
 )
 
@@ -98,6 +106,7 @@ func registerBucket(m map[string]setupFunc, app *kingpin.Application, name strin
 		}
 
 		if *verifyRepair {
+			ctx = audit.WithReason(ctx, fmt.Sprintf("repair %s", strings.Join(*verifyIssues, ",")))
 			v = verifier.NewWithRepair(logger, bkt, backupBkt, issues)
 		} else {
 			v = verifier.New(logger, bkt, issues)
@@ -213,4 +222,283 @@ func registerBucket(m map[string]setupFunc, app *kingpin.Application, name strin
 			return printBlock(id)
 		})
 	}
+	auditCmd := cmd.Command("audit", "show the history of mutating operations recorded by the bucket audit log")
+	auditPrefix := auditCmd.Flag("prefix", "Bucket directory the audit records are stored in.").
+		Default(audit.DefaultPrefix).String()
+	auditFile := auditCmd.Flag("file", "Local audit file to read records from instead of the bucket.").
+		String()
+	auditSince := modelDuration(auditCmd.Flag("since", "Only show records newer than the given duration. 0s shows all records.").
+		Default("0s"))
+	auditIDs := auditCmd.Flag("id", "Only show records for objects of the given block IDs. Repeated field").Strings()
+	auditOps := auditCmd.Flag("op", "Only show records of the given operation. Repeated field").
+		Enums(string(audit.OpUpload), string(audit.OpDelete))
+	auditReason := auditCmd.Flag("reason", "Only show records whose reason contains the given string.").String()
+	auditOutput := auditCmd.Flag("output", "Format in which to print each record. May be 'json' or empty for a plain text line.").
+		Short('o').Default("").Enum("", "json")
+	m[name+" audit"] = func(g *run.Group, logger log.Logger, reg *prometheus.Registry, _ opentracing.Tracer, _ bool) error {
+		// Dummy actor to immediately kill the group after the run function returns.
+		g.Add(func() error { return nil }, func(error) {})
+
+		ops := map[string]struct{}{}
+		for _, op := range *auditOps {
+			ops[op] = struct{}{}
+		}
+		for _, id := range *auditIDs {
+			if _, err := ulid.Parse(id); err != nil {
+				return errors.Wrap(err, "invalid ULID found in --id flag")
+			}
+		}
+
+		var minTime time.Time
+		if *auditSince > 0 {
+			minTime = time.Now().Add(-time.Duration(*auditSince))
+		}
+
+		enc := json.NewEncoder(os.Stdout)
+		printRecord := func(r audit.Record) error {
+			if r.Time.Before(minTime) {
+				return nil
+			}
+			if _, ok := ops[string(r.Op)]; len(ops) > 0 && !ok {
+				return nil
+			}
+			if !strings.Contains(r.Reason, *auditReason) {
+				return nil
+			}
+			if len(*auditIDs) > 0 {
+				found := false
+				for _, id := range *auditIDs {
+					if strings.HasPrefix(r.Name, id) {
+						found = true
+						break
+					}
+				}
+				if !found {
+					return nil
+				}
+			}
+
+			if *auditOutput == "json" {
+				return enc.Encode(&r)
+			}
+			line := fmt.Sprintf("%s %s %s component=%s hostname=%s reason=%q",
+				r.Time.Format(time.RFC3339), r.Op, r.Name, r.Component, r.Hostname, r.Reason)
+			if r.Err != "" {
+				line += fmt.Sprintf(" err=%q", r.Err)
+			}
+			_, err := fmt.Fprintln(os.Stdout, line)
+			return err
+		}
+
+		if *auditFile != "" {
+			return audit.ReadFile(logger, *auditFile, printRecord)
+		}
+
+		bucketConfig, err := objStoreConfig.Content()
+		if err != nil {
+			return err
+		}
+
+		bkt, err := client.NewBucket(logger, bucketConfig, reg, name)
+		if err != nil {
+			return err
+		}
+		defer runutil.CloseWithLogOnErr(logger, bkt, "bucket client")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		defer cancel()
+
+		return audit.Read(ctx, logger, bkt, *auditPrefix, printRecord)
+	}
+
+	split := cmd.Command("split", "split a block into multiple blocks by time ranges or by values of a label")
+	splitID := split.Flag("id", "ID of the block to split.").Required().String()
+	splitTimeRange := modelDuration(split.Flag("time-range", "Split the block into blocks covering consecutive time ranges of the given duration.").
+		Default("0s"))
+	splitLabel := split.Flag("label", "Split the block into one block per value of the given label name.").String()
+	splitPromote := split.Flag("promote", "Add the value of the split label to the external labels of the new blocks, so that they do not overlap. "+
+		"Without it, the new blocks overlap in time and the compactor halts on them until they are merged again with 'bucket compact --merge-overlapping'.").
+		Default("true").Bool()
+	splitTmpDir := split.Flag("tmp-dir", "Directory in which to download and split the block.").
+		Default("./data").String()
+	m[name+" split"] = func(g *run.Group, logger log.Logger, reg *prometheus.Registry, _ opentracing.Tracer, _ bool) error {
+		id, err := ulid.Parse(*splitID)
+		if err != nil {
+			return errors.Wrap(err, "invalid ULID found in --id flag")
+		}
+		if (*splitTimeRange > 0) == (*splitLabel != "") {
+			return errors.New("exactly one of --time-range or --label has to be specified")
+		}
+
+		bucketConfig, err := objStoreConfig.Content()
+		if err != nil {
+			return err
+		}
+
+		bkt, err := client.NewBucket(logger, bucketConfig, reg, name)
+		if err != nil {
+			return err
+		}
+		defer runutil.CloseWithLogOnErr(logger, bkt, "bucket client")
+
+		backupBucketConfig, err := objStoreBackupConfig.Content()
+		if err != nil {
+			return err
+		}
+
+		// Backup bucket is optional. If configured, the source block is moved there instead of being deleted.
+		backupBkt, err := client.NewBucket(logger, backupBucketConfig, reg, name)
+		if err != nil && err != client.ErrNotFound {
+			return err
+		}
+		if backupBkt != nil {
+			defer runutil.CloseWithLogOnErr(logger, backupBkt, "backup bucket client")
+		}
+
+		// Dummy actor to immediately kill the group after the run function returns.
+		g.Add(func() error { return nil }, func(error) {})
+
+		ctx := audit.WithReason(context.Background(), "split")
+
+		dir := filepath.Join(*splitTmpDir, "split")
+		if err := os.RemoveAll(dir); err != nil {
+			return errors.Wrap(err, "clean up split dir")
+		}
+		defer func() {
+			if err := os.RemoveAll(dir); err != nil {
+				level.Warn(logger).Log("msg", "failed to delete dir", "dir", dir, "err", err)
+			}
+		}()
+
+		if err := block.Download(ctx, logger, bkt, id, filepath.Join(dir, id.String())); err != nil {
+			return errors.Wrapf(err, "download block %s", id)
+		}
+
+		var ids []ulid.ULID
+		if *splitLabel != "" {
+			ids, err = block.SplitByLabel(logger, dir, id, downsample.NewPool(), block.BucketSplitSource, *splitLabel, *splitPromote)
+		} else {
+			ids, err = block.SplitByTime(logger, dir, id, downsample.NewPool(), block.BucketSplitSource, time.Duration(*splitTimeRange))
+		}
+		if err != nil {
+			return errors.Wrapf(err, "split block %s", id)
+		}
+
+		for _, resid := range ids {
+			bdir := filepath.Join(dir, resid.String())
+
+			meta, err := block.ReadMetaFile(bdir)
+			if err != nil {
+				return errors.Wrapf(err, "read meta of %s", resid)
+			}
+			if err := block.VerifyIndex(logger, filepath.Join(bdir, block.IndexFilename), meta.MinTime, meta.MaxTime); err != nil {
+				return errors.Wrapf(err, "verify index of %s", resid)
+			}
+			if err := block.Upload(ctx, logger, bkt, bdir); err != nil {
+				return errors.Wrapf(err, "upload block %s", resid)
+			}
+			level.Info(logger).Log("msg", "uploaded split block", "source", id, "block", resid,
+				"mint", meta.MinTime, "maxt", meta.MaxTime, "labels", fmt.Sprintf("%v", meta.Thanos.Labels), "series", meta.Stats.NumSeries)
+		}
+
+		// The new blocks replace the source block entirely, which must not be served alongside them.
+		if backupBkt != nil {
+			if err := verifier.SafeDelete(ctx, logger, bkt, backupBkt, id); err != nil {
+				return errors.Wrapf(err, "move source block %s to backup bucket", id)
+			}
+		} else if err := block.Delete(ctx, bkt, id); err != nil {
+			return errors.Wrapf(err, "delete source block %s", id)
+		}
+		level.Info(logger).Log("msg", "split done, source block removed", "source", id, "blocks", len(ids))
+		return nil
+	}
+
+	compactCmd := cmd.Command("compact", "compact the given blocks into a single block right away")
+	compactIDs := compactCmd.Flag("id", "IDs of the blocks to compact. All blocks have to share external labels and resolution. Repeated field").
+		Required().Strings()
+	compactMergeOverlapping := compactCmd.Flag("merge-overlapping", "Merge the blocks even if they overlap in time. Only raw blocks can be merged.").
+		Default("false").Bool()
+	compactDryRun := compactCmd.Flag("dry-run", "Only validate the blocks and print what would be compacted.").
+		Default("false").Bool()
+	compactTmpDir := compactCmd.Flag("tmp-dir", "Directory in which to download and compact the blocks.").
+		Default("./data").String()
+	m[name+" compact"] = func(g *run.Group, logger log.Logger, reg *prometheus.Registry, _ opentracing.Tracer, _ bool) error {
+		var ids []ulid.ULID
+		for _, bid := range *compactIDs {
+			id, err := ulid.Parse(bid)
+			if err != nil {
+				return errors.Wrap(err, "invalid ULID found in --id flag")
+			}
+			ids = append(ids, id)
+		}
+
+		bucketConfig, err := objStoreConfig.Content()
+		if err != nil {
+			return err
+		}
+
+		bkt, err := client.NewBucket(logger, bucketConfig, reg, name)
+		if err != nil {
+			return err
+		}
+		defer runutil.CloseWithLogOnErr(logger, bkt, "bucket client")
+
+		// Dummy actor to immediately kill the group after the run function returns.
+		g.Add(func() error { return nil }, func(error) {})
+
+		ctx := context.Background()
+
+		grp, err := compact.NewGroupForBlocks(ctx, logger, bkt, ids, false)
+		if err != nil {
+			return err
+		}
+		metas := grp.Metas()
+		if len(metas) < 2 {
+			return errors.New("at least two distinct blocks are required for compaction")
+		}
+
+		overlapErr := grp.Overlaps()
+		if overlapErr != nil && !*compactMergeOverlapping {
+			return errors.Wrap(overlapErr, "blocks overlap, use --merge-overlapping to merge them")
+		}
+
+		if *compactDryRun {
+			maxt := metas[0].MaxTime
+			for _, m := range metas {
+				if m.MaxTime > maxt {
+					maxt = m.MaxTime
+				}
+				fmt.Fprintf(os.Stdout, "%s -- %s - %s Compaction: %d, Series: %d, Samples: %d\n",
+					m.ULID, timestamp.Time(m.MinTime).Format(time.RFC3339), timestamp.Time(m.MaxTime).Format(time.RFC3339),
+					m.Compaction.Level, m.Stats.NumSeries, m.Stats.NumSamples)
+			}
+			fmt.Fprintf(os.Stdout, "Would compact %d blocks of group %s into a block covering %s - %s. Overlapping: %v\n",
+				len(metas), grp.Key(), timestamp.Time(metas[0].MinTime).Format(time.RFC3339),
+				timestamp.Time(maxt).Format(time.RFC3339), overlapErr != nil)
+			return nil
+		}
+
+		levels, err := compactions.levels(compactions.maxLevel())
+		if err != nil {
+			return errors.Wrap(err, "get compaction levels")
+		}
+		comp, err := tsdb.NewLeveledCompactor(reg, logger, levels, downsample.NewPool())
+		if err != nil {
+			return errors.Wrap(err, "create compactor")
+		}
+
+		dir := filepath.Join(*compactTmpDir, "compact")
+		defer func() {
+			if err := os.RemoveAll(dir); err != nil {
+				level.Warn(logger).Log("msg", "failed to delete dir", "dir", dir, "err", err)
+			}
+		}()
+
+		compID, err := grp.CompactAll(audit.WithReason(ctx, "manual compaction"), dir, comp, *compactMergeOverlapping)
+		if err != nil {
+			return errors.Wrap(err, "compact blocks")
+		}
+		level.Info(logger).Log("msg", "compacted blocks", "blocks", len(metas), "result_block", compID)
+		return nil
+	}
 }
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/improbable-eng/thanos/pkg/objstore/client"
+	"github.com/oklog/run"
+	"github.com/opentracing/opentracing-go"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/pkg/rulefmt"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/template"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+const (
+	checkOutputText = "text"
+	checkOutputJSON = "json"
+)
+
+func registerCheck(m map[string]setupFunc, app *kingpin.Application, name string) {
+	cmd := app.Command(name, "validate configuration of components without running them")
+
+	rules := cmd.Command("rules", "validate rule files the way the ruler loads them")
+	ruleFiles := rules.Arg("rule-files", "Rule files to validate. Can be in glob format.").Required().Strings()
+	rulesOutput := rules.Flag("output", "Format of the validation results. May be 'text' or 'json'.").
+		Short('o').Default(checkOutputText).Enum(checkOutputText, checkOutputJSON)
+	m[name+" rules"] = func(g *run.Group, _ log.Logger, _ *prometheus.Registry, _ opentracing.Tracer, _ bool) error {
+		// Dummy actor to immediately kill the group after the run function returns.
+		g.Add(func() error { return nil }, func(error) {})
+
+		return printCheckResults(os.Stdout, *rulesOutput, checkRuleFiles(*ruleFiles))
+	}
+
+	objstore := cmd.Command("objstore-config", "validate object store configuration strictly without connecting to the object store")
+	objStoreConfig := regCommonObjStoreFlags(objstore, "")
+	objstoreOutput := objstore.Flag("output", "Format of the validation results. May be 'text' or 'json'.").
+		Short('o').Default(checkOutputText).Enum(checkOutputText, checkOutputJSON)
+	m[name+" objstore-config"] = func(g *run.Group, _ log.Logger, _ *prometheus.Registry, _ opentracing.Tracer, _ bool) error {
+		// Dummy actor to immediately kill the group after the run function returns.
+		g.Add(func() error { return nil }, func(error) {})
+
+		res := checkResult{Name: objStoreConfig.name}
+		if *objStoreConfig.path != "" {
+			res.Name = *objStoreConfig.path
+		}
+		conf, err := objStoreConfig.Content()
+		if err == nil {
+			err = client.ValidateConfig(conf)
+		}
+		if err == client.ErrNotFound {
+			err = errors.New("no object store configuration given")
+		}
+		if err != nil {
+			res.Errors = append(res.Errors, err.Error())
+		}
+		return printCheckResults(os.Stdout, *objstoreOutput, []checkResult{res})
+	}
+}
+
+// checkResult is the result of validating a single configuration file.
+type checkResult struct {
+	Name   string   `json:"name"`
+	Rules  int      `json:"rules,omitempty"`
+	Errors []string `json:"errors,omitempty"`
+}
+
+// printCheckResults prints the results in the given format. It returns an error if any result has errors.
+func printCheckResults(w io.Writer, output string, results []checkResult) error {
+	failed := 0
+	for _, res := range results {
+		if len(res.Errors) > 0 {
+			failed++
+		}
+	}
+
+	switch output {
+	case checkOutputJSON:
+		if err := json.NewEncoder(w).Encode(results); err != nil {
+			return errors.Wrap(err, "encode results")
+		}
+	default:
+		for _, res := range results {
+			if len(res.Errors) == 0 {
+				if res.Rules > 0 {
+					fmt.Fprintf(w, "SUCCESS: %s (%d rules)\n", res.Name, res.Rules)
+				} else {
+					fmt.Fprintf(w, "SUCCESS: %s\n", res.Name)
+				}
+				continue
+			}
+			fmt.Fprintf(w, "FAILED: %s\n", res.Name)
+			for _, err := range res.Errors {
+				fmt.Fprintf(w, "  %s\n", err)
+			}
+		}
+	}
+	if failed > 0 {
+		return errors.Errorf("%d of %d checked files are invalid", failed, len(results))
+	}
+	return nil
+}
+
+// checkRuleFiles validates the rule files matching the given patterns.
+func checkRuleFiles(patterns []string) (results []checkResult) {
+	for _, pat := range patterns {
+		files, err := filepath.Glob(pat)
+		if err != nil {
+			results = append(results, checkResult{Name: pat, Errors: []string{err.Error()}})
+			continue
+		}
+		if len(files) == 0 {
+			results = append(results, checkResult{Name: pat, Errors: []string{"no rule files found"}})
+			continue
+		}
+		for _, fn := range files {
+			results = append(results, checkRuleFile(fn))
+		}
+	}
+	return results
+}
+
+// checkTemplate parses the label or annotation template with the template functions and variables of the ruler.
+// Prometheus only exposes its template functions through the expander, so the template is expanded with an alert
+// without labels and queries without results, and only errors of parsing it are returned.
+func checkTemplate(name, text string) error {
+	// Variables defined by the ruler when expanding templates.
+	defs := "{{$labels := .Labels}}{{$value := .Value}}"
+	data := struct {
+		Labels map[string]string
+		Value  float64
+	}{}
+	noQuery := func(context.Context, string, time.Time) (promql.Vector, error) { return nil, nil }
+
+	_, err := template.NewTemplateExpander(context.Background(), defs+text, name, data, 0, noQuery, &url.URL{}).Expand()
+	if err != nil && strings.HasPrefix(err.Error(), "error parsing template") {
+		return err
+	}
+	return nil
+}
+
+// checkRuleFile validates the rule file with the parser of the rule manager, which validates the groups and PromQL
+// expressions, and additionally parses the label and annotation templates of alerting rules.
+func checkRuleFile(fn string) checkResult {
+	res := checkResult{Name: fn}
+
+	groups, errs := rulefmt.ParseFile(fn)
+	for _, err := range errs {
+		res.Errors = append(res.Errors, err.Error())
+	}
+	if groups == nil {
+		return res
+	}
+
+	for _, g := range groups.Groups {
+		res.Rules += len(g.Rules)
+
+		for _, r := range g.Rules {
+			if r.Alert == "" {
+				continue
+			}
+			for _, tmpls := range []map[string]string{r.Labels, r.Annotations} {
+				keys := make([]string, 0, len(tmpls))
+				for k := range tmpls {
+					keys = append(keys, k)
+				}
+				sort.Strings(keys)
+
+				for _, k := range keys {
+					if err := checkTemplate(k, tmpls[k]); err != nil {
+						res.Errors = append(res.Errors, fmt.Sprintf("group %q, alert %q: invalid template of %q: %s", g.Name, r.Alert, k, err))
+					}
+				}
+			}
+		}
+	}
+	return res
+}
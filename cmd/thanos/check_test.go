@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/improbable-eng/thanos/pkg/testutil"
+)
+
+func TestCheckRuleFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "check-rules")
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, os.RemoveAll(dir)) }()
+
+	for fn, content := range map[string]string{
+		"valid.rules.yaml": `
+groups:
+- name: valid
+  rules:
+  - record: job:up:sum
+    expr: sum(up) by (job)
+  - alert: JobDown
+    expr: job:up:sum == 0
+    labels:
+      severity: '{{ if eq $labels.job "critical" }}page{{ else }}ticket{{ end }}'
+    annotations:
+      summary: '{{ $labels.job }} is down for {{ $value | humanizeDuration }}'
+      # Queries return no results when checking templates, which must not fail them.
+      description: '{{ query "up" | first | value | humanize }} of {{ with query "count(up)" }}{{ . | first | value }}{{ end }} up'
+`,
+		"invalid.rules.yaml": `
+groups:
+- name: dup
+  rules:
+  - record: job:up:sum
+    expr: sum(up) by (job
+- name: dup
+  rules:
+  - alert: JobDown
+    expr: up == 0
+    annotations:
+      summary: '{{ $labels.job is down'
+      description: '{{ $value | humanizeBytes }}'
+`,
+		"broken.yaml": `groups: [`,
+	} {
+		testutil.Ok(t, ioutil.WriteFile(filepath.Join(dir, fn), []byte(content), 0666))
+	}
+
+	results := checkRuleFiles([]string{filepath.Join(dir, "valid.rules.yaml"), filepath.Join(dir, "invalid.rules.yaml")})
+	testutil.Equals(t, 2, len(results))
+	testutil.Equals(t, checkResult{Name: filepath.Join(dir, "valid.rules.yaml"), Rules: 2}, results[0])
+	// Invalid expression, duplicate group, invalid template and unknown template function.
+	testutil.Equals(t, 4, len(results[1].Errors))
+
+	results = checkRuleFiles([]string{filepath.Join(dir, "*.yaml"), filepath.Join(dir, "missing.yaml")})
+	testutil.Equals(t, 4, len(results))
+	testutil.Equals(t, filepath.Join(dir, "broken.yaml"), results[0].Name)
+	testutil.Equals(t, 1, len(results[0].Errors))
+	testutil.Equals(t, filepath.Join(dir, "missing.yaml"), results[3].Name)
+	testutil.Equals(t, 1, len(results[3].Errors))
+
+	var buf bytes.Buffer
+	testutil.NotOk(t, printCheckResults(&buf, checkOutputJSON, results))
+
+	var decoded []checkResult
+	testutil.Ok(t, json.Unmarshal(buf.Bytes(), &decoded))
+	testutil.Equals(t, results, decoded)
+
+	buf.Reset()
+	testutil.Ok(t, printCheckResults(&buf, checkOutputText, results[2:3]))
+	testutil.Equals(t, "SUCCESS: "+filepath.Join(dir, "valid.rules.yaml")+" (2 rules)\n", buf.String())
+}
@@ -92,6 +92,31 @@ func registerCompact(m map[string]setupFunc, app *kingpin.Application, name stri
 	maxCompactionLevel := cmd.Flag("debug.max-compaction-level", fmt.Sprintf("Maximum compaction level, default is %d: %s", compactions.maxLevel(), compactions.String())).
 		Hidden().Default(strconv.Itoa(compactions.maxLevel())).Int()
 
+	priority := cmd.Flag("compact.priority", "Order in which compaction groups are processed in every iteration. One of: "+strings.Join(compact.Priorities, ", ")+". "+
+		"'most-behind' compacts groups with the most blocks awaiting compaction first, 'newest' groups with the newest blocks awaiting compaction first.").
+		Default(string(compact.PriorityNone)).Enum(compact.Priorities...)
+
+	groupWeights := cmd.Flag("compact.group-weight", "Weight of compaction groups whose labels match the selector in the format <selector>=<weight>, e.g. '{env=\"prod\"}=10'. "+
+		"Groups with higher weight are compacted first, before ordering by priority, and up to their weight rounded up times per iteration. "+
+		"Groups not matching any selector have a weight of 1. May be repeated, the first matching selector applies.").
+		PlaceHolder("<selector>=<weight>").Strings()
+
+	maxIterations := cmd.Flag("compact.max-iterations", "Maximum number of compaction iterations before downsampling and retention are applied. "+
+		"Remaining compactions continue in the next run, so that a large backlog does not delay downsampling. 0 compacts until no work is left.").
+		Default("0").Int()
+
+	shards := cmd.Flag("compact.shards", "Number of blocks the output of compacting not yet sharded blocks is partitioned into by the hash of series labels. "+
+		"Sharded blocks are only compacted with blocks of the same shard. 1 disables sharding.").
+		Default("1").Uint64()
+
+	undersizedAge := regUndersizedBlockAgeFlag(cmd)
+
+	compactUndersized := cmd.Flag("downsample.compact-undersized", "Compact adjacent blocks of the same group that are downsampled according to "+
+		"--downsample.undersized-block-age into a single block before downsampling them.").
+		Default("false").Bool()
+
+	indexMaxStaleness := regBucketIndexMaxStalenessFlag(cmd)
+
 	m[name] = func(g *run.Group, logger log.Logger, reg *prometheus.Registry, tracer opentracing.Tracer, _ bool) error {
 		return runCompact(g, logger, reg,
 			*httpAddr,
@@ -108,6 +133,13 @@ func registerCompact(m map[string]setupFunc, app *kingpin.Application, name stri
 			name,
 			*disableDownsampling,
 			*maxCompactionLevel,
+			compact.Priority(*priority),
+			*groupWeights,
+			*maxIterations,
+			*shards,
+			time.Duration(*undersizedAge),
+			*compactUndersized,
+			time.Duration(*indexMaxStaleness),
 		)
 	}
 }
@@ -126,6 +158,13 @@ func runCompact(
 	component string,
 	disableDownsampling bool,
 	maxCompactionLevel int,
+	priority compact.Priority,
+	groupWeights []string,
+	maxIterations int,
+	shards uint64,
+	undersizedAge time.Duration,
+	compactUndersized bool,
+	indexMaxStaleness time.Duration,
 ) error {
 	halted := prometheus.NewGauge(prometheus.GaugeOpts{
 		Name: "thanos_compactor_halted",
@@ -186,7 +225,31 @@ func runCompact(
 		return errors.Wrap(err, "clean working downsample directory")
 	}
 
-	compactor := compact.NewBucketCompactor(logger, sy, comp, compactDir, bkt)
+	var weights []compact.GroupWeight
+	for _, s := range groupWeights {
+		w, err := compact.ParseGroupWeight(s)
+		if err != nil {
+			return err
+		}
+		weights = append(weights, w)
+	}
+	sched := compact.NewScheduler(reg, priority, weights, levels[len(levels)-1])
+
+	if shards < 1 {
+		return errors.New("number of shards must be at least 1")
+	}
+	if shards > 1 {
+		level.Info(logger).Log("msg", "series sharding of compacted blocks is enabled", "shards", shards)
+	}
+	compactor := compact.NewBucketCompactor(logger, sy, comp, compactDir, bkt, sched, shards, maxIterations)
+
+	policy := undersizedPolicy{minAge: undersizedAge}
+	if compactUndersized {
+		policy.comp = comp
+	}
+	if undersizedAge > 0 {
+		level.Info(logger).Log("msg", "downsampling of undersized blocks is enabled", "age", undersizedAge, "compact", compactUndersized)
+	}
 
 	if retentionByResolution[compact.ResolutionLevelRaw].Seconds() != 0 {
 		level.Info(logger).Log("msg", "retention policy of raw samples is enabled", "duration", retentionByResolution[compact.ResolutionLevelRaw])
@@ -212,13 +275,13 @@ func runCompact(
 			// for 5m downsamplings created in the first run.
 			level.Info(logger).Log("msg", "start first pass of downsampling")
 
-			if err := downsampleBucket(ctx, logger, bkt, downsamplingDir); err != nil {
+			if err := downsampleBucket(ctx, logger, bkt, downsamplingDir, policy, indexMaxStaleness, true); err != nil {
 				return errors.Wrap(err, "first pass of downsampling failed")
 			}
 
 			level.Info(logger).Log("msg", "start second pass of downsampling")
 
-			if err := downsampleBucket(ctx, logger, bkt, downsamplingDir); err != nil {
+			if err := downsampleBucket(ctx, logger, bkt, downsamplingDir, policy, indexMaxStaleness, true); err != nil {
 				return errors.Wrap(err, "second pass of downsampling failed")
 			}
 			level.Info(logger).Log("msg", "downsampling iterations done")
@@ -226,7 +289,7 @@ func runCompact(
 			level.Warn(logger).Log("msg", "downsampling was explicitly disabled")
 		}
 
-		if err := compact.ApplyRetentionPolicyByResolution(ctx, logger, bkt, retentionByResolution); err != nil {
+		if err := compact.ApplyRetentionPolicyByResolution(ctx, logger, bkt, retentionByResolution, indexMaxStaleness); err != nil {
 			return errors.Wrap(err, fmt.Sprintf("retention failed"))
 		}
 		return nil
@@ -2,10 +2,9 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"os"
-	"path"
 	"path/filepath"
+	"sort"
 	"time"
 
 	"github.com/prometheus/tsdb/chunkenc"
@@ -13,8 +12,10 @@ import (
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 	"github.com/improbable-eng/thanos/pkg/block"
+	"github.com/improbable-eng/thanos/pkg/compact"
 	"github.com/improbable-eng/thanos/pkg/compact/downsample"
 	"github.com/improbable-eng/thanos/pkg/objstore"
+	"github.com/improbable-eng/thanos/pkg/objstore/audit"
 	"github.com/improbable-eng/thanos/pkg/objstore/client"
 	"github.com/improbable-eng/thanos/pkg/runutil"
 	"github.com/oklog/run"
@@ -22,10 +23,22 @@ import (
 	"github.com/opentracing/opentracing-go"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/timestamp"
 	"github.com/prometheus/tsdb"
 	"gopkg.in/alecthomas/kingpin.v2"
 )
 
+const (
+	// downsampleRange0 is the minimum range of raw blocks before they are downsampled to 5m resolution.
+	// Only then we are sure to get roughly 2 chunks out of them.
+	// NOTE(fabxc): this must match with at which block size the compactor creates downsampled
+	// blocks. Otherwise we may never downsample some data.
+	downsampleRange0 = 40 * 60 * 60 * 1000
+	// downsampleRange1 is the minimum range of 5m blocks before they are downsampled to 1h resolution.
+	downsampleRange1 = 10 * 24 * 60 * 60 * 1000
+)
+
 func registerDownsample(m map[string]setupFunc, app *kingpin.Application, name string) {
 	cmd := app.Command(name, "continuously downsamples blocks in an object store bucket")
 
@@ -34,9 +47,45 @@ func registerDownsample(m map[string]setupFunc, app *kingpin.Application, name s
 
 	objStoreConfig := regCommonObjStoreFlags(cmd, "")
 
+	undersizedAge := regUndersizedBlockAgeFlag(cmd)
+
 	m[name] = func(g *run.Group, logger log.Logger, reg *prometheus.Registry, tracer opentracing.Tracer, _ bool) error {
-		return runDownsample(g, logger, reg, *dataDir, objStoreConfig, name)
+		return runDownsample(g, logger, reg, *dataDir, objStoreConfig, name, undersizedPolicy{minAge: time.Duration(*undersizedAge)})
+	}
+}
+
+func regUndersizedBlockAgeFlag(cmd *kingpin.CmdClause) *model.Duration {
+	return modelDuration(cmd.Flag("downsample.undersized-block-age", "Minimum age of blocks too small to be downsampled regularly, i.e. raw blocks "+
+		"spanning less than 40h and 5m blocks spanning less than 10d, before they are downsampled anyway. 0s disables downsampling of such blocks.").
+		Default("0s"))
+}
+
+// undersizedPolicy controls downsampling of blocks that never reach the size at which blocks are regularly downsampled,
+// e.g. blocks of short-lived producers or of buckets with limited compaction.
+type undersizedPolicy struct {
+	// minAge is the minimum age of undersized blocks before they are downsampled. Zero disables the policy.
+	minAge time.Duration
+	// comp compacts adjacent undersized blocks of the same group before they are downsampled, if set.
+	comp tsdb.Compactor
+}
+
+// undersized returns whether the block is smaller than the size at which blocks of its resolution are regularly downsampled.
+func undersized(m *block.Meta) bool {
+	switch m.Thanos.Downsample.Resolution {
+	case 0:
+		return m.MaxTime-m.MinTime < downsampleRange0
+	case downsample.ResLevel1:
+		return m.MaxTime-m.MinTime < downsampleRange1
+	}
+	return false
+}
+
+// eligible returns whether the undersized block is old enough to be downsampled.
+func (p undersizedPolicy) eligible(m *block.Meta) bool {
+	if p.minAge <= 0 {
+		return false
 	}
+	return m.MaxTime < timestamp.FromTime(time.Now().Add(-p.minAge))
 }
 
 func runDownsample(
@@ -46,6 +95,7 @@ func runDownsample(
 	dataDir string,
 	objStoreConfig *pathOrContent,
 	component string,
+	policy undersizedPolicy,
 ) error {
 	bucketConfig, err := objStoreConfig.Content()
 	if err != nil {
@@ -73,13 +123,15 @@ func runDownsample(
 
 			level.Info(logger).Log("msg", "start first pass of downsampling")
 
-			if err := downsampleBucket(ctx, logger, bkt, dataDir); err != nil {
+			// Only the compactor writes the bucket index, so the index misses the blocks downsampled here and
+			// the bucket is listed instead.
+			if err := downsampleBucket(ctx, logger, bkt, dataDir, policy, 0, false); err != nil {
 				return errors.Wrap(err, "downsampling failed")
 			}
 
 			level.Info(logger).Log("msg", "start second pass of downsampling")
 
-			if err := downsampleBucket(ctx, logger, bkt, dataDir); err != nil {
+			if err := downsampleBucket(ctx, logger, bkt, dataDir, policy, 0, false); err != nil {
 				return errors.Wrap(err, "downsampling failed")
 			}
 
@@ -98,6 +150,9 @@ func downsampleBucket(
 	logger log.Logger,
 	bkt objstore.Bucket,
 	dir string,
+	policy undersizedPolicy,
+	indexMaxStaleness time.Duration,
+	updateIndex bool,
 ) error {
 	if err := os.RemoveAll(dir); err != nil {
 		return errors.Wrap(err, "clean working directory")
@@ -105,103 +160,201 @@ func downsampleBucket(
 	if err := os.MkdirAll(dir, 0777); err != nil {
 		return errors.Wrap(err, "create dir")
 	}
-	var metas []*block.Meta
-
-	err := bkt.Iter(ctx, "", func(name string) error {
-		id, ok := block.IsBlockDir(name)
-		if !ok {
-			return nil
-		}
 
-		rc, err := bkt.Get(ctx, path.Join(id.String(), block.MetaFilename))
+	metas, err := block.BucketMetas(ctx, logger, bkt, indexMaxStaleness)
+	if err != nil {
+		return err
+	}
+	if policy.comp != nil {
+		compacted, err := compactUndersized(ctx, logger, bkt, filepath.Join(dir, "compact"), policy, metas, updateIndex)
 		if err != nil {
-			return errors.Wrapf(err, "get meta for block %s", id)
+			return errors.Wrap(err, "compact undersized blocks")
 		}
-		defer runutil.CloseWithLogOnErr(logger, rc, "block reader")
-
-		var m block.Meta
-		if err := json.NewDecoder(rc).Decode(&m); err != nil {
-			return errors.Wrap(err, "decode meta")
+		if compacted {
+			if metas, err = block.BucketMetas(ctx, logger, bkt, indexMaxStaleness); err != nil {
+				return err
+			}
 		}
-		metas = append(metas, &m)
+	}
 
-		return nil
-	})
+	// Mapping from source blocks to the series shards of them that were downsampled already. We don't need to
+	// downsample a block if all its sources were downsampled for its shard already.
+	sources5m, sources1h, err := downsampledSources(metas)
 	if err != nil {
-		return errors.Wrap(err, "retrieve bucket block metas")
+		return err
 	}
 
-	// mapping from a hash over all source IDs to blocks. We don't need to downsample a block
-	// if a downsampled version with the same hash already exists.
-	sources5m := map[ulid.ULID]struct{}{}
-	sources1h := map[ulid.ULID]struct{}{}
-
 	for _, m := range metas {
 		switch m.Thanos.Downsample.Resolution {
 		case 0:
-			continue
-		case 5 * 60 * 1000:
-			for _, id := range m.Compaction.Sources {
-				sources5m[id] = struct{}{}
+			if !sources5m.missing(m) {
+				continue
 			}
-		case 60 * 60 * 1000:
-			for _, id := range m.Compaction.Sources {
-				sources1h[id] = struct{}{}
+			// Only downsample blocks once they reached the regular size. Blocks that will never reach
+			// that size are downsampled anyway once they are old enough.
+			if undersized(m) && !policy.eligible(m) {
+				continue
+			}
+			if err := processDownsampling(ctx, logger, bkt, m, dir, downsample.ResLevel1, updateIndex); err != nil {
+				return err
+			}
+
+		case downsample.ResLevel1:
+			if !sources1h.missing(m) {
+				continue
+			}
+			// Only downsample blocks once they reached the regular size. Blocks that will never reach
+			// that size are downsampled anyway once they are old enough.
+			if undersized(m) && !policy.eligible(m) {
+				continue
+			}
+			if err := processDownsampling(ctx, logger, bkt, m, dir, downsample.ResLevel2, updateIndex); err != nil {
+				return err
 			}
-		default:
-			return errors.Errorf("unexpected downsampling resolution %d", m.Thanos.Downsample.Resolution)
 		}
 	}
+	return nil
+}
+
+// downsampledShards maps source blocks to the series shards of them contained in downsampled blocks.
+// Blocks split from the same block share its sources, so a source alone does not tell whether a block
+// was downsampled.
+type downsampledShards map[ulid.ULID][]block.ThanosShardMeta
+
+// downsampledSources returns the source blocks and their shards of all 5m and 1h blocks.
+func downsampledSources(metas []*block.Meta) (sources5m, sources1h downsampledShards, err error) {
+	sources5m = downsampledShards{}
+	sources1h = downsampledShards{}
 
 	for _, m := range metas {
 		switch m.Thanos.Downsample.Resolution {
 		case 0:
-			missing := false
+			continue
+		case downsample.ResLevel1:
 			for _, id := range m.Compaction.Sources {
-				if _, ok := sources5m[id]; !ok {
-					missing = true
-					break
-				}
+				sources5m[id] = append(sources5m[id], m.Thanos.Shard)
 			}
-			if !missing {
-				continue
-			}
-			// Only downsample blocks once we are sure to get roughly 2 chunks out of it.
-			// NOTE(fabxc): this must match with at which block size the compactor creates downsampled
-			// blocks. Otherwise we may never downsample some data.
-			if m.MaxTime-m.MinTime < 40*60*60*1000 {
-				continue
-			}
-			if err := processDownsampling(ctx, logger, bkt, m, dir, 5*60*1000); err != nil {
-				return err
+		case downsample.ResLevel2:
+			for _, id := range m.Compaction.Sources {
+				sources1h[id] = append(sources1h[id], m.Thanos.Shard)
 			}
+		default:
+			return nil, nil, errors.Errorf("unexpected downsampling resolution %d", m.Thanos.Downsample.Resolution)
+		}
+	}
+	return sources5m, sources1h, nil
+}
 
-		case 5 * 60 * 1000:
-			missing := false
-			for _, id := range m.Compaction.Sources {
-				if _, ok := sources1h[id]; !ok {
-					missing = true
-					break
-				}
+// missing returns whether the shard of the block was not downsampled for any of its sources.
+func (d downsampledShards) missing(m *block.Meta) bool {
+	for _, id := range m.Compaction.Sources {
+		if !coversShard(d[id], m.Thanos.Shard) {
+			return true
+		}
+	}
+	return false
+}
+
+// coversShard returns whether the given shards contain all series of the shard. This is the case if
+// one of them covers the shard or if they contain all shards of any count.
+func coversShard(shards []block.ThanosShardMeta, shard block.ThanosShardMeta) bool {
+	indices := map[uint64]map[uint64]struct{}{}
+	for _, s := range shards {
+		if s.Covers(shard) {
+			return true
+		}
+		if indices[s.Count] == nil {
+			indices[s.Count] = map[uint64]struct{}{}
+		}
+		indices[s.Count][s.Index] = struct{}{}
+	}
+	for count, idx := range indices {
+		if uint64(len(idx)) == count {
+			return true
+		}
+	}
+	return false
+}
+
+// compactUndersized compacts runs of adjacent undersized blocks of the same group that are old enough to be downsampled
+// according to the policy and were not downsampled yet. It returns whether any blocks were compacted.
+// The bucket index is only updated if updateIndex is set, which only the compactor may do.
+func compactUndersized(ctx context.Context, logger log.Logger, bkt objstore.Bucket, dir string, policy undersizedPolicy, metas []*block.Meta, updateIndex bool) (bool, error) {
+	sources5m, sources1h, err := downsampledSources(metas)
+	if err != nil {
+		return false, err
+	}
+	groups := map[string][]*block.Meta{}
+	for _, m := range metas {
+		if m.Thanos.Downsample.Resolution == downsample.ResLevel2 {
+			continue
+		}
+		groups[compact.GroupKey(*m)] = append(groups[compact.GroupKey(*m)], m)
+	}
+
+	compacted := false
+	for _, run := range undersizedRuns(groups, policy, sources5m, sources1h) {
+		ids := make([]ulid.ULID, 0, len(run))
+		for _, m := range run {
+			ids = append(ids, m.ULID)
+		}
+		grp, err := compact.NewGroupForBlocks(ctx, logger, bkt, ids, updateIndex)
+		if err != nil {
+			return compacted, errors.Wrap(err, "create group")
+		}
+		id, err := grp.CompactAll(audit.WithReason(ctx, "compaction of undersized blocks"), dir, policy.comp, false)
+		if err != nil {
+			return compacted, errors.Wrapf(err, "compact undersized blocks %v", ids)
+		}
+		level.Info(logger).Log("msg", "compacted undersized blocks", "blocks", len(ids), "result_block", id)
+		compacted = true
+	}
+	return compacted, nil
+}
+
+// undersizedRuns returns runs of at least two adjacent blocks of a group that are all undersized, eligible for
+// downsampling and not downsampled yet. Runs span no more than the size at which blocks are regularly downsampled.
+func undersizedRuns(groups map[string][]*block.Meta, policy undersizedPolicy, sources5m, sources1h downsampledShards) (runs [][]*block.Meta) {
+	keys := make([]string, 0, len(groups))
+	for k := range groups {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		metas := groups[k]
+		sort.Slice(metas, func(i, j int) bool {
+			return metas[i].MinTime < metas[j].MinTime
+		})
+
+		var cur []*block.Meta
+		flush := func() {
+			if len(cur) > 1 {
+				runs = append(runs, cur)
 			}
-			if !missing {
-				continue
+			cur = nil
+		}
+		for _, m := range metas {
+			sources, maxRange := sources5m, int64(downsampleRange0)
+			if m.Thanos.Downsample.Resolution == downsample.ResLevel1 {
+				sources, maxRange = sources1h, downsampleRange1
 			}
-			// Only downsample blocks once we are sure to get roughly 2 chunks out of it.
-			// NOTE(fabxc): this must match with at which block size the compactor creates downsampled
-			// blocks. Otherwise we may never downsample some data.
-			if m.MaxTime-m.MinTime < 10*24*60*60*1000 {
+			if !undersized(m) || !policy.eligible(m) || !sources.missing(m) {
+				flush()
 				continue
 			}
-			if err := processDownsampling(ctx, logger, bkt, m, dir, 60*60*1000); err != nil {
-				return err
+			// Overlapping blocks are left to the compactor.
+			if len(cur) > 0 && (m.MinTime < cur[len(cur)-1].MaxTime || m.MaxTime-cur[0].MinTime > maxRange) {
+				flush()
 			}
+			cur = append(cur, m)
 		}
+		flush()
 	}
-	return nil
+	return runs
 }
 
-func processDownsampling(ctx context.Context, logger log.Logger, bkt objstore.Bucket, m *block.Meta, dir string, resolution int64) error {
+func processDownsampling(ctx context.Context, logger log.Logger, bkt objstore.Bucket, m *block.Meta, dir string, resolution int64, updateIndex bool) error {
 	begin := time.Now()
 	bdir := filepath.Join(dir, m.ULID.String())
 
@@ -245,13 +398,23 @@ func processDownsampling(ctx context.Context, logger log.Logger, bkt objstore.Bu
 
 	begin = time.Now()
 
-	err = block.Upload(ctx, logger, bkt, resdir)
+	err = block.Upload(audit.WithReason(ctx, "downsample"), logger, bkt, resdir)
 	if err != nil {
 		return errors.Wrapf(err, "upload downsampled block %s", id)
 	}
 
 	level.Info(logger).Log("msg", "uploaded block", "id", id, "duration", time.Since(begin))
 
+	if updateIndex {
+		newMeta, err := block.ReadMetaFile(resdir)
+		if err != nil {
+			return errors.Wrapf(err, "read meta of downsampled block %s", id)
+		}
+		if err := block.UpdateBucketIndex(ctx, logger, bkt, []block.Meta{*newMeta}, nil); err != nil {
+			return errors.Wrapf(err, "add downsampled block %s to bucket index", id)
+		}
+	}
+
 	begin = time.Now()
 
 	// It is not harmful if these fails.
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/improbable-eng/thanos/pkg/block"
+	"github.com/improbable-eng/thanos/pkg/compact/downsample"
+	"github.com/improbable-eng/thanos/pkg/testutil"
+	"github.com/oklog/ulid"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/prometheus/tsdb"
+)
+
+func TestUndersizedRuns(t *testing.T) {
+	var (
+		hour = int64(time.Hour / time.Millisecond)
+		// All blocks are 30 days old, except for the last one.
+		base = timestamp.FromTime(time.Now().Add(-30 * 24 * time.Hour))
+	)
+	newMeta := func(id uint64, res, mint, maxt int64, sources ...uint64) *block.Meta {
+		m := &block.Meta{BlockMeta: tsdb.BlockMeta{ULID: ulid.MustNew(id, nil), MinTime: base + mint, MaxTime: base + maxt}}
+		m.Thanos.Downsample.Resolution = res
+		if len(sources) == 0 {
+			sources = []uint64{id}
+		}
+		for _, s := range sources {
+			m.Compaction.Sources = append(m.Compaction.Sources, ulid.MustNew(s, nil))
+		}
+		return m
+	}
+	ids := func(runs [][]*block.Meta) (res [][]uint64) {
+		for _, r := range runs {
+			var ids []uint64
+			for _, m := range r {
+				ids = append(ids, m.ULID.Time())
+			}
+			res = append(res, ids)
+		}
+		return res
+	}
+
+	groups := map[string][]*block.Meta{
+		"raw": {
+			newMeta(1, 0, 0, 8*hour),
+			newMeta(2, 0, 8*hour, 16*hour),
+			newMeta(3, 0, 16*hour, 24*hour),
+			// Regularly sized block breaks the run.
+			newMeta(4, 0, 24*hour, 72*hour),
+			newMeta(5, 0, 72*hour, 80*hour),
+			newMeta(6, 0, 80*hour, 88*hour),
+			// Runs do not exceed the regular size.
+			newMeta(7, 0, 88*hour, 120*hour),
+			newMeta(8, 0, 120*hour, 128*hour),
+			// Already downsampled blocks are not compacted.
+			newMeta(9, 0, 128*hour, 136*hour),
+			// Too recent blocks are not compacted.
+			newMeta(10, 0, 30*24*hour, 30*24*hour+2*hour),
+			newMeta(11, 0, 30*24*hour+2*hour, 30*24*hour+4*hour),
+		},
+		"5m": {
+			newMeta(20, downsample.ResLevel1, 0, 24*hour, 30),
+			newMeta(21, downsample.ResLevel1, 24*hour, 48*hour, 31),
+			// Overlapping blocks are not compacted.
+			newMeta(22, downsample.ResLevel1, 40*hour, 60*hour, 32),
+		},
+	}
+	sources5m := downsampledShards{ulid.MustNew(9, nil): {{}}}
+	sources1h := downsampledShards{}
+
+	runs := undersizedRuns(groups, undersizedPolicy{minAge: 7 * 24 * time.Hour}, sources5m, sources1h)
+	testutil.Equals(t, [][]uint64{{20, 21}, {1, 2, 3}, {5, 6}, {7, 8}}, ids(runs))
+
+	// Disabled policy never compacts blocks.
+	testutil.Equals(t, 0, len(undersizedRuns(groups, undersizedPolicy{}, sources5m, sources1h)))
+}
+
+func TestDownsampledSources_Shards(t *testing.T) {
+	newMeta := func(id uint64, res int64, shard block.ThanosShardMeta, sources ...uint64) *block.Meta {
+		m := &block.Meta{BlockMeta: tsdb.BlockMeta{ULID: ulid.MustNew(id, nil)}}
+		m.Thanos.Downsample.Resolution = res
+		m.Thanos.Shard = shard
+		for _, s := range sources {
+			m.Compaction.Sources = append(m.Compaction.Sources, ulid.MustNew(s, nil))
+		}
+		return m
+	}
+	var (
+		shard0 = block.ThanosShardMeta{Index: 0, Count: 2}
+		shard1 = block.ThanosShardMeta{Index: 1, Count: 2}
+	)
+	// Both shards of block 1 share its sources, but only the first one was downsampled.
+	raw0 := newMeta(10, 0, shard0, 1, 2)
+	raw1 := newMeta(11, 0, shard1, 1, 2)
+	unsharded := newMeta(12, 0, block.ThanosShardMeta{}, 1, 2)
+
+	sources5m, _, err := downsampledSources([]*block.Meta{raw0, raw1, newMeta(20, downsample.ResLevel1, shard0, 1, 2)})
+	testutil.Ok(t, err)
+	testutil.Assert(t, !sources5m.missing(raw0), "expected shard 0 to be downsampled")
+	testutil.Assert(t, sources5m.missing(raw1), "expected shard 1 not to be downsampled")
+	testutil.Assert(t, sources5m.missing(unsharded), "expected unsharded block not to be downsampled")
+
+	// All shards together cover the unsharded block.
+	sources5m, _, err = downsampledSources([]*block.Meta{
+		newMeta(20, downsample.ResLevel1, shard0, 1, 2),
+		newMeta(21, downsample.ResLevel1, shard1, 1, 2),
+	})
+	testutil.Ok(t, err)
+	testutil.Assert(t, !sources5m.missing(unsharded), "expected unsharded block to be downsampled")
+
+	// An unsharded downsampled block covers all shards.
+	sources5m, _, err = downsampledSources([]*block.Meta{newMeta(20, downsample.ResLevel1, block.ThanosShardMeta{}, 1, 2)})
+	testutil.Ok(t, err)
+	testutil.Assert(t, !sources5m.missing(raw1), "expected shard 1 to be downsampled")
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"net"
@@ -11,6 +12,9 @@ import (
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 	"github.com/improbable-eng/thanos/pkg/cluster"
+	"github.com/improbable-eng/thanos/pkg/runutil"
+	"github.com/improbable-eng/thanos/pkg/usage"
+	"github.com/oklog/run"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/model"
@@ -115,6 +119,21 @@ func regCommonServerFlags(cmd *kingpin.CmdClause) (
 		}
 }
 
+func regGRPCClientFlags(cmd *kingpin.CmdClause) (
+	secure *bool,
+	cert *string,
+	key *string,
+	caCert *string,
+	serverName *string) {
+	secure = cmd.Flag("grpc-client-tls-secure", "Use TLS when talking to the gRPC server").Default("false").Bool()
+	cert = cmd.Flag("grpc-client-tls-cert", "TLS Certificates to use to identify this client to the server").Default("").String()
+	key = cmd.Flag("grpc-client-tls-key", "TLS Key for the client's certificate").Default("").String()
+	caCert = cmd.Flag("grpc-client-tls-ca", "TLS CA Certificates to use to verify gRPC servers").Default("").String()
+	serverName = cmd.Flag("grpc-client-server-name", "Server name to verify the hostname on the returned gRPC certificates. See https://tools.ietf.org/html/rfc4366#section-3.1").Default("").String()
+
+	return secure, cert, key, caCert, serverName
+}
+
 func regHTTPAddrFlag(cmd *kingpin.CmdClause) *string {
 	return cmd.Flag("http-address", "Listen host:port for HTTP endpoints.").Default("0.0.0.0:10902").String()
 }
@@ -168,3 +187,71 @@ func regCommonObjStoreFlags(cmd *kingpin.CmdClause, suffix string) *pathOrConten
 		content: bucketConf,
 	}
 }
+
+func regBucketIndexMaxStalenessFlag(cmd *kingpin.CmdClause) *model.Duration {
+	return modelDuration(cmd.Flag("bucket-index.max-staleness", "Maximum age of the bucket index maintained by the compactor before the bucket is listed instead. "+
+		"Blocks uploaded since the index was written are not seen while it is used. The compactor rewrites an unchanged index every 15m, "+
+		"so it should be larger than that. 0s disables the use of the index.").
+		Default("0s"))
+}
+
+type shipperReconcileConfig struct {
+	interval  *model.Duration
+	upload    *bool
+	retention *model.Duration
+}
+
+func regShipperReconcileFlags(cmd *kingpin.CmdClause) *shipperReconcileConfig {
+	interval := modelDuration(cmd.Flag("shipper.reconcile-interval", "Interval in which uploaded blocks are checked to be in the bucket, either themselves or as a source of a compacted block. "+
+		"Missing blocks are logged and counted in thanos_shipper_unreconciled_blocks. 0s disables the reconciliation.").
+		Default("0s"))
+
+	upload := cmd.Flag("shipper.reconcile-upload", "Upload blocks again that are missing in the bucket at two consecutive reconciliations.").
+		Default("false").Bool()
+
+	retention := modelDuration(cmd.Flag("shipper.reconcile-retention", "Retention of raw data in the bucket as configured for the compactor. "+
+		"Uploaded blocks older than it are not reconciled, so that blocks deleted by retention are not reported or uploaded again. 0d disables it.").
+		Default("0d"))
+
+	return &shipperReconcileConfig{
+		interval:  interval,
+		upload:    upload,
+		retention: retention,
+	}
+}
+
+type usageConfig struct {
+	maxClients     *int
+	reportInterval *model.Duration
+}
+
+func regCommonUsageFlags(cmd *kingpin.CmdClause) *usageConfig {
+	maxClients := cmd.Flag("usage.max-clients", "Maximum number of client identities whose usage is tracked separately. Usage of all further clients is accounted to the 'other' client.").
+		Default("100").Int()
+
+	reportInterval := modelDuration(cmd.Flag("usage.report-interval", "Interval in which the usage per client since the last report is logged. 0 disables the report.").
+		Default("0s"))
+
+	return &usageConfig{
+		maxClients:     maxClients,
+		reportInterval: reportInterval,
+	}
+}
+
+// tracker returns a new usage tracker of the given resources. The periodic usage report is added to the group if enabled.
+func (c *usageConfig) tracker(g *run.Group, logger log.Logger, reg *prometheus.Registry, subsystem string, resources ...usage.Resource) *usage.Tracker {
+	tracker := usage.NewTracker(logger, reg, subsystem, *c.maxClients, resources...)
+
+	if interval := time.Duration(*c.reportInterval); interval > 0 {
+		ctx, cancel := context.WithCancel(context.Background())
+		g.Add(func() error {
+			return runutil.Repeat(interval, ctx.Done(), func() error {
+				tracker.Report()
+				return nil
+			})
+		}, func(error) {
+			cancel()
+		})
+	}
+	return tracker
+}
@@ -78,6 +78,8 @@ func main() {
 	registerCompact(cmds, app, "compact")
 	registerBucket(cmds, app, "bucket")
 	registerDownsample(cmds, app, "downsample")
+	registerCheck(cmds, app, "check")
+	registerTools(cmds, app, "tools")
 
 	cmd, err := app.Parse(os.Args[1:])
 	if err != nil {
@@ -9,6 +9,7 @@ import (
 	"math"
 	"net"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/go-kit/kit/log"
@@ -25,10 +26,12 @@ import (
 	"github.com/improbable-eng/thanos/pkg/store/storepb"
 	"github.com/improbable-eng/thanos/pkg/tracing"
 	"github.com/improbable-eng/thanos/pkg/ui"
+	"github.com/improbable-eng/thanos/pkg/usage"
 	"github.com/oklog/run"
 	"github.com/opentracing/opentracing-go"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
 	"github.com/prometheus/common/route"
 	"github.com/prometheus/prometheus/discovery/file"
 	"github.com/prometheus/prometheus/discovery/targetgroup"
@@ -48,11 +51,7 @@ func registerQuery(m map[string]setupFunc, app *kingpin.Application, name string
 	httpAdvertiseAddr := cmd.Flag("http-advertise-address", "Explicit (external) host:port address to advertise for HTTP QueryAPI in gossip cluster. If empty, 'http-address' will be used.").
 		String()
 
-	secure := cmd.Flag("grpc-client-tls-secure", "Use TLS when talking to the gRPC server").Default("false").Bool()
-	cert := cmd.Flag("grpc-client-tls-cert", "TLS Certificates to use to identify this client to the server").Default("").String()
-	key := cmd.Flag("grpc-client-tls-key", "TLS Key for the client's certificate").Default("").String()
-	caCert := cmd.Flag("grpc-client-tls-ca", "TLS CA Certificates to use to verify gRPC servers").Default("").String()
-	serverName := cmd.Flag("grpc-client-server-name", "Server name to verify the hostname on the returned gRPC certificates. See https://tools.ietf.org/html/rfc4366#section-3.1").Default("").String()
+	secure, cert, key, caCert, serverName := regGRPCClientFlags(cmd)
 
 	queryTimeout := modelDuration(cmd.Flag("query.timeout", "Maximum time to process query by query node.").
 		Default("2m"))
@@ -78,9 +77,26 @@ func registerQuery(m map[string]setupFunc, app *kingpin.Application, name string
 	dnsSDInterval := modelDuration(cmd.Flag("store.sd-dns-interval", "Interval between DNS resolutions.").
 		Default("30s"))
 
+	storeLabels := cmd.Flag("store.label", "Label injected into all series and the labels of a store API server, replacing a label of the same name (repeatable). The store is given by its address as in --store or file SD files, so that the label applies to all store API servers discovered through it. This allows querying stores whose external labels collide with the ones of other stores.").
+		PlaceHolder("<store>=<name>=\"<value>\"").Strings()
+
+	fileSDLabels := cmd.Flag("store.sd-labels", "Inject the labels of targets and target groups in file SD files into their store API servers like --store.label.").
+		Default("false").Bool()
+
 	enableAutodownsampling := cmd.Flag("query.auto-downsampling", "Enable automatic adjustment (step / 5) to what source of data should be used in store gateways if no max_source_resolution param is specified. ").
 		Default("false").Bool()
 
+	enablePushdown := cmd.Flag("query.pushdown", "Enable evaluating whole queries on a single sidecar if it is the only store exposing data for all selectors of the query and the whole queried time range. Results are labeled with the sidecar's external labels.").
+		Default("false").Bool()
+
+	coalesceBufferSize := cmd.Flag("query.coalesce-buffer-size", "Maximum size of the series responses buffered for identical concurrent series requests, which then share a single request to the stores. Larger responses are not shared. 0 disables coalescing.").
+		Default("0B").Bytes()
+
+	clientHeader := cmd.Flag("usage.client-header", "HTTP header identifying the client of the query API for usage accounting. If not present, the subject of the client's TLS certificate is used.").
+		Default("X-Thanos-Client").String()
+
+	usageConf := regCommonUsageFlags(cmd)
+
 	m[name] = func(g *run.Group, logger log.Logger, reg *prometheus.Registry, tracer opentracing.Tracer, _ bool) error {
 		peer, err := newPeerFn(logger, reg, true, *httpAdvertiseAddr, true)
 		if err != nil {
@@ -90,6 +106,10 @@ func registerQuery(m map[string]setupFunc, app *kingpin.Application, name string
 		if err != nil {
 			return errors.Wrap(err, "parse federation labels")
 		}
+		injectedLabels, err := parseStoreLabels(*storeLabels)
+		if err != nil {
+			return errors.Wrap(err, "parse store labels")
+		}
 
 		lookupStores := map[string]struct{}{}
 		for _, s := range *stores {
@@ -131,12 +151,38 @@ func registerQuery(m map[string]setupFunc, app *kingpin.Application, name string
 			selectorLset,
 			*stores,
 			*enableAutodownsampling,
+			*enablePushdown,
+			int(*coalesceBufferSize),
+			*clientHeader,
+			usageConf,
 			fileSD,
 			time.Duration(*dnsSDInterval),
+			injectedLabels,
+			*fileSDLabels,
 		)
 	}
 }
 
+// parseStoreLabels parses labels to inject into stores given as <store>=<name>="<value>" by the store address.
+func parseStoreLabels(s []string) (map[string][]storepb.Label, error) {
+	res := map[string][]storepb.Label{}
+	for _, l := range s {
+		parts := strings.SplitN(l, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, errors.Errorf("unrecognized store label %q", l)
+		}
+		lset, err := parseFlagLabels(parts[1:])
+		if err != nil {
+			return nil, errors.Wrapf(err, "store %s", parts[0])
+		}
+		if lset[0].Name == "" || lset[0].Value == "" {
+			return nil, errors.Errorf("empty name or value of store label %q", l)
+		}
+		res[parts[0]] = store.InjectLabels(res[parts[0]], []storepb.Label{{Name: lset[0].Name, Value: lset[0].Value}})
+	}
+	return res, nil
+}
+
 func storeClientGRPCOpts(logger log.Logger, reg *prometheus.Registry, tracer opentracing.Tracer, secure bool, cert, key, caCert string, serverName string) ([]grpc.DialOption, error) {
 	grpcMets := grpc_prometheus.NewClientMetrics()
 	grpcMets.EnableClientHandlingTimeHistogram(
@@ -242,108 +288,28 @@ func runQuery(
 	selectorLset labels.Labels,
 	storeAddrs []string,
 	enableAutodownsampling bool,
+	enablePushdown bool,
+	coalesceBufferSize int,
+	clientHeader string,
+	usageConf *usageConfig,
 	fileSD *file.Discovery,
 	dnsSDInterval time.Duration,
+	storeLabels map[string][]storepb.Label,
+	fileSDLabels bool,
 ) error {
-	duplicatedStores := prometheus.NewCounter(prometheus.CounterOpts{
-		Name: "thanos_query_duplicated_store_address",
-		Help: "The number of times a duplicated store addresses is detected from the different configs in query",
-	})
-	storeAddrResolutionErrors := prometheus.NewCounter(prometheus.CounterOpts{
-		Name: "thanos_query_store_address_resolution_errors",
-		Help: "The number of times resolving an address of a store API has failed inside Thanos Query",
-	})
-	reg.MustRegister(duplicatedStores)
-	reg.MustRegister(storeAddrResolutionErrors)
-
 	dialOpts, err := storeClientGRPCOpts(logger, reg, tracer, secure, cert, key, caCert, serverName)
 	if err != nil {
 		return errors.Wrap(err, "building gRPC client")
 	}
 
-	fileSDCache := cache.New()
-	// DNS provider with default resolver.
-	dnsProvider := dns.NewProviderWithResolver(logger)
-
 	var (
-		stores = query.NewStoreSet(
-			logger,
-			reg,
-			func() (specs []query.StoreSpec) {
-				// Add store specs from gossip.
-				for id, ps := range peer.PeerStates(cluster.PeerTypesStoreAPIs()...) {
-					if ps.StoreAPIAddr == "" {
-						level.Error(logger).Log("msg", "Gossip found peer that propagates empty address, ignoring.", "lset", fmt.Sprintf("%v", ps.Metadata.Labels))
-						continue
-					}
-
-					specs = append(specs, &gossipSpec{id: id, addr: ps.StoreAPIAddr, peer: peer})
-				}
-
-				// Add DNS resolved addresses from static flags and file SD.
-				for _, addr := range dnsProvider.Addresses() {
-					specs = append(specs, query.NewGRPCStoreSpec(addr))
-				}
-
-				specs = removeDuplicateStoreSpecs(logger, duplicatedStores, specs)
-
-				return specs
-			},
-			dialOpts,
-		)
-		proxy = store.NewProxyStore(logger, func(context.Context) ([]store.Client, error) {
+		stores = runStoreSet(g, logger, reg, "query", peer, dialOpts, storeAddrs, fileSD, dnsSDInterval, storeLabels, fileSDLabels)
+		proxy  = store.NewProxyStore(logger, reg, func(context.Context) ([]store.Client, error) {
 			return stores.Get(), nil
-		}, selectorLset)
+		}, selectorLset, coalesceBufferSize)
 		queryableCreator = query.NewQueryableCreator(logger, proxy, replicaLabel)
 		engine           = promql.NewEngine(logger, reg, maxConcurrentQueries, queryTimeout)
 	)
-	// Periodically update the store set with the addresses we see in our cluster.
-	{
-		ctx, cancel := context.WithCancel(context.Background())
-		g.Add(func() error {
-			return runutil.Repeat(5*time.Second, ctx.Done(), func() error {
-				stores.Update(ctx)
-				return nil
-			})
-		}, func(error) {
-			cancel()
-			stores.Close()
-		})
-	}
-	// Run File Service Discovery and update the store set when the files are modified.
-	if fileSD != nil {
-		var fileSDUpdates chan []*targetgroup.Group
-		ctxRun, cancelRun := context.WithCancel(context.Background())
-
-		fileSDUpdates = make(chan []*targetgroup.Group)
-
-		g.Add(func() error {
-			fileSD.Run(ctxRun, fileSDUpdates)
-			return nil
-		}, func(error) {
-			cancelRun()
-		})
-
-		ctxUpdate, cancelUpdate := context.WithCancel(context.Background())
-		g.Add(func() error {
-			for {
-				select {
-				case update := <-fileSDUpdates:
-					// Discoverers sometimes send nil updates so need to check for it to avoid panics.
-					if update == nil {
-						continue
-					}
-					fileSDCache.Update(update)
-					stores.Update(ctxUpdate)
-				case <-ctxUpdate.Done():
-					return nil
-				}
-			}
-		}, func(error) {
-			cancelUpdate()
-			close(fileSDUpdates)
-		})
-	}
 	{
 		ctx, cancel := context.WithCancel(context.Background())
 		g.Add(func() error {
@@ -359,29 +325,19 @@ func runQuery(
 			peer.Close(5 * time.Second)
 		})
 	}
-	// Periodically update the addresses from static flags and file SD by resolving them using DNS SD if necessary.
-	{
-		ctx, cancel := context.WithCancel(context.Background())
-		g.Add(func() error {
-			return runutil.Repeat(dnsSDInterval, ctx.Done(), func() error {
-				addresses := append(fileSDCache.Addresses(), storeAddrs...)
-				if err := dnsProvider.Resolve(ctx, addresses); err != nil {
-					// Failure to resolve could be caused by a lookup timeout. We shouldn't fail because of that, so just log.
-					level.Error(logger).Log("msg", "failed to resolve addresses for storeAPIs", "err", err)
-					storeAddrResolutionErrors.Inc()
-				}
-				return nil
-			})
-		}, func(error) {
-			cancel()
-		})
-	}
 	// Start query API + UI HTTP server.
 	{
 		router := route.New()
 		ui.NewQueryUI(logger, nil).Register(router)
 
-		api := v1.NewAPI(logger, reg, engine, queryableCreator, enableAutodownsampling)
+		var pushdown *query.Pushdown
+		if enablePushdown {
+			pushdown = query.NewPushdown(logger, reg, stores.Get, replicaLabel, maxConcurrentQueries, queryTimeout)
+		}
+
+		usageTracker := usageConf.tracker(g, logger, reg, "query", usage.Queries, usage.Series, usage.Samples, usage.EvalWallSeconds)
+
+		api := v1.NewAPI(logger, reg, engine, queryableCreator, enableAutodownsampling, pushdown, usageTracker, clientHeader)
 		api.Register(router.WithPrefix("/api/v1"), tracer, logger)
 
 		router.Get("/-/healthy", func(w http.ResponseWriter, r *http.Request) {
@@ -437,6 +393,147 @@ func runQuery(
 	return nil
 }
 
+// runStoreSet returns a store set of the store APIs found through gossip, the given static addresses and file SD.
+// Static and file SD addresses are resolved through DNS if necessary. The set is kept up to date by actors added to the group.
+// The given labels by store address, and the labels of file SD targets if enabled, are injected into the stores.
+func runStoreSet(
+	g *run.Group,
+	logger log.Logger,
+	reg *prometheus.Registry,
+	component string,
+	peer *cluster.Peer,
+	dialOpts []grpc.DialOption,
+	storeAddrs []string,
+	fileSD *file.Discovery,
+	dnsSDInterval time.Duration,
+	storeLabels map[string][]storepb.Label,
+	fileSDLabels bool,
+) *query.StoreSet {
+	duplicatedStores := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: fmt.Sprintf("thanos_%s_duplicated_store_address", component),
+		Help: fmt.Sprintf("The number of times a duplicated store addresses is detected from the different configs in %s", component),
+	})
+	storeAddrResolutionErrors := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: fmt.Sprintf("thanos_%s_store_address_resolution_errors", component),
+		Help: fmt.Sprintf("The number of times resolving an address of a store API has failed inside Thanos %s", component),
+	})
+	reg.MustRegister(duplicatedStores)
+	reg.MustRegister(storeAddrResolutionErrors)
+
+	fileSDCache := cache.New()
+	// DNS provider with default resolver.
+	dnsProvider := dns.NewProviderWithResolver(logger)
+
+	// injectedLabels returns the labels injected into the stores of the address. Labels given by flags take
+	// precedence over the ones of file SD targets.
+	injectedLabels := func(addr string, sdLabels map[string]model.LabelSet) []storepb.Label {
+		var res []storepb.Label
+		for n, v := range sdLabels[addr] {
+			res = append(res, storepb.Label{Name: string(n), Value: string(v)})
+		}
+		return store.InjectLabels(res, storeLabels[addr])
+	}
+
+	stores := query.NewStoreSet(
+		logger,
+		reg,
+		func() (specs []query.StoreSpec) {
+			var sdLabels map[string]model.LabelSet
+			if fileSDLabels {
+				sdLabels = fileSDCache.AddressLabels()
+			}
+
+			// Add store specs from gossip.
+			for id, ps := range peer.PeerStates(cluster.PeerTypesStoreAPIs()...) {
+				if ps.StoreAPIAddr == "" {
+					level.Error(logger).Log("msg", "Gossip found peer that propagates empty address, ignoring.", "lset", fmt.Sprintf("%v", ps.Metadata.Labels))
+					continue
+				}
+
+				specs = append(specs, &gossipSpec{id: id, addr: ps.StoreAPIAddr, peer: peer, injected: injectedLabels(ps.StoreAPIAddr, nil)})
+			}
+
+			// Add DNS resolved addresses from static flags and file SD.
+			for addr, resolved := range dnsProvider.ResolvedAddresses() {
+				injected := injectedLabels(addr, sdLabels)
+				for _, r := range resolved {
+					specs = append(specs, query.NewGRPCStoreSpec(r, injected))
+				}
+			}
+
+			specs = removeDuplicateStoreSpecs(logger, duplicatedStores, specs)
+
+			return specs
+		},
+		dialOpts,
+	)
+	// Periodically update the store set with the addresses we see in our cluster.
+	{
+		ctx, cancel := context.WithCancel(context.Background())
+		g.Add(func() error {
+			return runutil.Repeat(5*time.Second, ctx.Done(), func() error {
+				stores.Update(ctx)
+				return nil
+			})
+		}, func(error) {
+			cancel()
+			stores.Close()
+		})
+	}
+	// Run File Service Discovery and update the store set when the files are modified.
+	if fileSD != nil {
+		var fileSDUpdates chan []*targetgroup.Group
+		ctxRun, cancelRun := context.WithCancel(context.Background())
+
+		fileSDUpdates = make(chan []*targetgroup.Group)
+
+		g.Add(func() error {
+			fileSD.Run(ctxRun, fileSDUpdates)
+			return nil
+		}, func(error) {
+			cancelRun()
+		})
+
+		ctxUpdate, cancelUpdate := context.WithCancel(context.Background())
+		g.Add(func() error {
+			for {
+				select {
+				case update := <-fileSDUpdates:
+					// Discoverers sometimes send nil updates so need to check for it to avoid panics.
+					if update == nil {
+						continue
+					}
+					fileSDCache.Update(update)
+					stores.Update(ctxUpdate)
+				case <-ctxUpdate.Done():
+					return nil
+				}
+			}
+		}, func(error) {
+			cancelUpdate()
+			close(fileSDUpdates)
+		})
+	}
+	// Periodically update the addresses from static flags and file SD by resolving them using DNS SD if necessary.
+	{
+		ctx, cancel := context.WithCancel(context.Background())
+		g.Add(func() error {
+			return runutil.Repeat(dnsSDInterval, ctx.Done(), func() error {
+				addresses := append(fileSDCache.Addresses(), storeAddrs...)
+				if err := dnsProvider.Resolve(ctx, addresses); err != nil {
+					// Failure to resolve could be caused by a lookup timeout. We shouldn't fail because of that, so just log.
+					level.Error(logger).Log("msg", "failed to resolve addresses for storeAPIs", "err", err)
+					storeAddrResolutionErrors.Inc()
+				}
+				return nil
+			})
+		}, func(error) {
+			cancel()
+		})
+	}
+	return stores
+}
+
 func removeDuplicateStoreSpecs(logger log.Logger, duplicatedStores prometheus.Counter, specs []query.StoreSpec) []query.StoreSpec {
 	set := make(map[string]query.StoreSpec)
 	for _, spec := range specs {
@@ -455,8 +552,9 @@ func removeDuplicateStoreSpecs(logger log.Logger, duplicatedStores prometheus.Co
 }
 
 type gossipSpec struct {
-	id   string
-	addr string
+	id       string
+	addr     string
+	injected []storepb.Label
 
 	peer *cluster.Peer
 }
@@ -465,11 +563,24 @@ func (s *gossipSpec) Addr() string {
 	return s.addr
 }
 
-// Metadata method for gossip store tries get current peer state.
-func (s *gossipSpec) Metadata(_ context.Context, _ storepb.StoreClient) (labels []storepb.Label, mint int64, maxt int64, err error) {
+func (s *gossipSpec) InjectedLabels() []storepb.Label {
+	return s.injected
+}
+
+// Metadata method for gossip store tries get current peer state. Metric names and supported features are not
+// gossiped, so those of the last metadata fetched from the store when it was added are kept. Filters of metric
+// names only cover data up to their max time, so they do not miss names as they grow old.
+func (s *gossipSpec) Metadata(_ context.Context, _ storepb.StoreClient, last *storepb.InfoResponse) (*storepb.InfoResponse, error) {
 	state, ok := s.peer.PeerState(s.id)
 	if !ok {
-		return nil, 0, 0, errors.Errorf("peer %s is no longer in gossip cluster", s.id)
+		return nil, errors.Errorf("peer %s is no longer in gossip cluster", s.id)
 	}
-	return state.Metadata.Labels, state.Metadata.MinTime, state.Metadata.MaxTime, nil
+	return &storepb.InfoResponse{
+		Labels:              state.Metadata.Labels,
+		MinTime:             state.Metadata.MinTime,
+		MaxTime:             state.Metadata.MaxTime,
+		MetricNames:         last.MetricNames,
+		SupportsMatcherSets: last.SupportsMatcherSets,
+		SupportsQuery:       last.SupportsQuery,
+	}, nil
 }
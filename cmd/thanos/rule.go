@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"math"
 	"math/rand"
@@ -27,6 +26,8 @@ import (
 	"github.com/improbable-eng/thanos/pkg/discovery/cache"
 	"github.com/improbable-eng/thanos/pkg/discovery/dns"
 	"github.com/improbable-eng/thanos/pkg/objstore/client"
+	"github.com/improbable-eng/thanos/pkg/query"
+	v1 "github.com/improbable-eng/thanos/pkg/query/api"
 	"github.com/improbable-eng/thanos/pkg/runutil"
 	"github.com/improbable-eng/thanos/pkg/shipper"
 	"github.com/improbable-eng/thanos/pkg/store"
@@ -37,7 +38,6 @@ import (
 	"github.com/opentracing/opentracing-go"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/common/model"
 	"github.com/prometheus/common/route"
 	"github.com/prometheus/prometheus/discovery/file"
 	"github.com/prometheus/prometheus/discovery/targetgroup"
@@ -77,8 +77,20 @@ func registerRule(m map[string]setupFunc, app *kingpin.Application, name string)
 
 	alertQueryURL := cmd.Flag("alert.query-url", "The external Thanos Query URL that would be set in all alerts 'Source' field").String()
 
+	alertDeliveryHistory := cmd.Flag("alert.delivery-history", "Number of most recently sent alerts whose delivery status to Alertmanagers is shown on the notifications page and API.").
+		Default("1000").Int()
+
+	alertFlushTimeout := modelDuration(cmd.Flag("alert.flush-timeout", "Maximum time to send queued alerts to Alertmanagers on shutdown. Firing alerts are sent as resolved when their rules are removed on reload.").
+		Default("10s"))
+
+	alertResolveOnShutdown := cmd.Flag("alert.resolve-on-shutdown", "Send all firing alerts as resolved on shutdown. Only enable it for rulers that are removed for good, "+
+		"as alerts of restarted rulers fire again and their notifications flap.").
+		Default("false").Bool()
+
 	objStoreConfig := regCommonObjStoreFlags(cmd, "")
 
+	reconcileConf := regShipperReconcileFlags(cmd)
+
 	queries := cmd.Flag("query", "Addresses of statically configured query API servers (repeatable). The scheme may be prefixed with 'dns+' or 'dnssrv+' to detect query API servers through respective DNS lookups.").
 		PlaceHolder("<query>").Strings()
 
@@ -91,6 +103,35 @@ func registerRule(m map[string]setupFunc, app *kingpin.Application, name string)
 	dnsSDInterval := modelDuration(cmd.Flag("query.sd-dns-interval", "Interval between DNS resolutions.").
 		Default("30s"))
 
+	embeddedQuery := cmd.Flag("query.embedded", "Evaluate rules with an embedded query engine directly against store APIs found through gossip, --store and --store.sd-files instead of sending them to query nodes.").
+		Default("false").Bool()
+
+	queryTimeout := modelDuration(cmd.Flag("query.timeout", "Maximum time to evaluate a rule query with the embedded query engine.").
+		Default("2m"))
+
+	maxConcurrentQueries := cmd.Flag("query.max-concurrent", "Maximum number of rule queries evaluated concurrently by the embedded query engine.").
+		Default("20").Int()
+
+	replicaLabel := cmd.Flag("query.replica-label", "Label to treat as a replica indicator along which data is deduplicated by the embedded query engine.").
+		String()
+
+	partialResponse := cmd.Flag("query.partial-response", "Evaluate rules with the embedded query engine even if some store APIs fail to respond. Otherwise the evaluation fails.").
+		Default("false").Bool()
+
+	grpcClientSecure, grpcClientCert, grpcClientKey, grpcClientCA, grpcClientServerName := regGRPCClientFlags(cmd)
+
+	stores := cmd.Flag("store", "Addresses of statically configured store API servers for the embedded query engine (repeatable). The scheme may be prefixed with 'dns+' or 'dnssrv+' to detect store API servers through respective DNS lookups.").
+		PlaceHolder("<store>").Strings()
+
+	storeFileSDFiles := cmd.Flag("store.sd-files", "Path to files that contain addresses of store API servers for the embedded query engine. The path can be a glob pattern (repeatable).").
+		PlaceHolder("<path>").Strings()
+
+	storeFileSDInterval := modelDuration(cmd.Flag("store.sd-interval", "Refresh interval to re-read store file SD files. It is used as a resync fallback.").
+		Default("5m"))
+
+	storeDNSSDInterval := modelDuration(cmd.Flag("store.sd-dns-interval", "Interval between DNS resolutions of store API servers.").
+		Default("30s"))
+
 	m[name] = func(g *run.Group, logger log.Logger, reg *prometheus.Registry, tracer opentracing.Tracer, _ bool) error {
 		lset, err := parseFlagLabels(*labelStrs)
 		if err != nil {
@@ -131,6 +172,39 @@ func registerRule(m map[string]setupFunc, app *kingpin.Application, name string)
 			fileSD = file.NewDiscovery(conf, logger)
 		}
 
+		var (
+			dialOpts    []grpc.DialOption
+			storeFileSD *file.Discovery
+		)
+		if *embeddedQuery {
+			if len(*queries) > 0 || len(*fileSDFiles) > 0 {
+				return errors.New("--query and --query.sd-files cannot be used with --query.embedded")
+			}
+			lookupStores := map[string]struct{}{}
+			for _, s := range *stores {
+				if _, ok := lookupStores[s]; ok {
+					return errors.Errorf("Address %s is duplicated for --store flag.", s)
+				}
+
+				lookupStores[s] = struct{}{}
+			}
+
+			dialOpts, err = storeClientGRPCOpts(logger, reg, tracer, *grpcClientSecure, *grpcClientCert, *grpcClientKey, *grpcClientCA, *grpcClientServerName)
+			if err != nil {
+				return errors.Wrap(err, "building gRPC client")
+			}
+
+			if len(*storeFileSDFiles) > 0 {
+				conf := &file.SDConfig{
+					Files:           *storeFileSDFiles,
+					RefreshInterval: *storeFileSDInterval,
+				}
+				storeFileSD = file.NewDiscovery(conf, logger)
+			}
+		} else if len(*stores) > 0 || len(*storeFileSDFiles) > 0 {
+			return errors.New("--store and --store.sd-files require --query.embedded")
+		}
+
 		return runRule(g,
 			logger,
 			reg,
@@ -147,12 +221,25 @@ func registerRule(m map[string]setupFunc, app *kingpin.Application, name string)
 			*ruleFiles,
 			peer,
 			objStoreConfig,
+			reconcileConf,
 			tsdbOpts,
 			name,
 			alertQueryURL,
+			*alertDeliveryHistory,
+			time.Duration(*alertFlushTimeout),
+			*alertResolveOnShutdown,
 			*queries,
 			fileSD,
 			time.Duration(*dnsSDInterval),
+			*embeddedQuery,
+			time.Duration(*queryTimeout),
+			*maxConcurrentQueries,
+			*replicaLabel,
+			*partialResponse,
+			dialOpts,
+			*stores,
+			storeFileSD,
+			time.Duration(*storeDNSSDInterval),
 		)
 	}
 }
@@ -176,12 +263,25 @@ func runRule(
 	ruleFiles []string,
 	peer *cluster.Peer,
 	objStoreConfig *pathOrContent,
+	reconcileConf *shipperReconcileConfig,
 	tsdbOpts *tsdb.Options,
 	component string,
 	alertQueryURL *url.URL,
+	alertDeliveryHistory int,
+	alertFlushTimeout time.Duration,
+	alertResolveOnShutdown bool,
 	queryAddrs []string,
 	fileSD *file.Discovery,
 	dnsSDInterval time.Duration,
+	embeddedQuery bool,
+	queryTimeout time.Duration,
+	maxConcurrentQueries int,
+	replicaLabel string,
+	partialResponse bool,
+	dialOpts []grpc.DialOption,
+	storeAddrs []string,
+	storeFileSD *file.Discovery,
+	storeDNSSDInterval time.Duration,
 ) error {
 	configSuccess := prometheus.NewGauge(prometheus.GaugeOpts{
 		Name: "thanos_config_last_reload_successful",
@@ -244,7 +344,7 @@ func runRule(
 
 	// Hit the HTTP query API of query peers in randomized order until we get a result
 	// back or the context get canceled.
-	queryFn := func(ctx context.Context, q string, t time.Time) (promql.Vector, error) {
+	var queryFn rules.QueryFunc = func(ctx context.Context, q string, t time.Time) (promql.Vector, error) {
 		var addrs []string
 
 		// Add addresses from gossip.
@@ -274,12 +374,30 @@ func runRule(
 		}
 		return nil, errors.Errorf("no query peer reachable")
 	}
+	if embeddedQuery {
+		stores := runStoreSet(g, logger, reg, "rule", peer, dialOpts, storeAddrs, storeFileSD, storeDNSSDInterval, nil, false)
+		proxy := store.NewProxyStore(logger, reg, func(context.Context) ([]store.Client, error) {
+			return stores.Get(), nil
+		}, nil, 0)
+
+		queryFn = embeddedQueryFunc(
+			logger,
+			promql.NewEngine(logger, reg, maxConcurrentQueries, queryTimeout),
+			query.NewQueryableCreator(logger, proxy, replicaLabel),
+			replicaLabel != "",
+			partialResponse,
+		)
+	}
 
 	// Run rule evaluation and alert notifications.
 	var (
 		alertmgrs = newAlertmanagerSet(alertmgrURLs, nil)
 		alertQ    = alert.NewQueue(logger, reg, 10000, 100, labelsTSDBToProm(lset))
+		alertDlvs = alert.NewDeliveryStatuses(alertDeliveryHistory)
+		firing    = newFiringAlerts()
 		mgr       *rules.Manager
+		// Closed once the rule manager stopped and, if enabled, pushed firing alerts as resolved.
+		alertsResolved = make(chan struct{})
 	)
 	{
 		ctx, cancel := context.WithCancel(context.Background())
@@ -303,6 +421,7 @@ func runRule(
 				}
 				res = append(res, a)
 			}
+			firing.update(res)
 			alertQ.Push(res)
 
 			return nil
@@ -317,9 +436,17 @@ func runRule(
 			ExternalURL: nil,
 		})
 		g.Add(func() error {
+			defer close(alertsResolved)
+
 			mgr.Run()
 			<-ctx.Done()
 			mgr.Stop()
+
+			// Resolve all firing alerts so that Alertmanagers do not keep them until they time out. Alerts of rulers that
+			// are only restarted would fire again right away, so it is only done on demand.
+			if alertResolveOnShutdown {
+				alertQ.Push(firing.resolve(nil, time.Now()))
+			}
 			return nil
 		}, func(error) {
 			cancel()
@@ -352,7 +479,7 @@ func runRule(
 		})
 	}
 	{
-		sdr := alert.NewSender(logger, reg, alertmgrs.get, nil)
+		sdr := alert.NewSender(logger, reg, alertmgrs.get, nil, alertDlvs)
 		ctx, cancel := context.WithCancel(context.Background())
 
 		g.Add(func() error {
@@ -364,6 +491,7 @@ func runRule(
 
 				select {
 				case <-ctx.Done():
+					flushAlerts(logger, sdr, alertQ, alertsResolved, alertFlushTimeout)
 					return ctx.Err()
 				default:
 				}
@@ -457,6 +585,12 @@ func runRule(
 				configSuccess.Set(1)
 				configSuccessTime.Set(float64(time.Now().UnixNano()) / 1e9)
 
+				// Alerts of removed rules are not evaluated anymore and would never be sent as resolved.
+				if resolved := firing.resolve(activeAlerts(mgr), time.Now()); len(resolved) > 0 {
+					level.Info(logger).Log("msg", "resolving alerts of removed rules", "numAlerts", len(resolved))
+					alertQ.Push(resolved)
+				}
+
 				rulesLoaded.Reset()
 				for _, group := range mgr.RuleGroups() {
 					rulesLoaded.WithLabelValues(group.File(), group.Name()).Set(float64(len(group.Rules())))
@@ -535,7 +669,7 @@ func runRule(
 			reload <- struct{}{}
 		})
 
-		ui.NewRuleUI(logger, mgr, alertQueryURL.String()).Register(router)
+		ui.NewRuleUI(logger, mgr, alertQueryURL.String(), alertQ, alertDlvs).Register(router)
 
 		mux := http.NewServeMux()
 		registerMetrics(mux, reg)
@@ -581,7 +715,8 @@ func runRule(
 			}
 		}()
 
-		s := shipper.New(logger, nil, dataDir, bkt, func() labels.Labels { return lset }, block.RulerSource)
+		s := shipper.New(logger, reg, dataDir, bkt, func() labels.Labels { return lset }, block.RulerSource)
+		reconcile := shipperReconciler(logger, s, reconcileConf)
 
 		ctx, cancel := context.WithCancel(context.Background())
 
@@ -590,6 +725,7 @@ func runRule(
 
 			return runutil.Repeat(30*time.Second, ctx.Done(), func() error {
 				s.Sync(ctx)
+				reconcile(ctx)
 
 				minTime, _, err := s.Timestamps()
 				if err != nil {
@@ -628,6 +764,8 @@ func queryPrometheusInstant(ctx context.Context, logger log.Logger, addr, query
 	defer span.Finish()
 
 	req = req.WithContext(ctx)
+	// Decoding JSON responses is expensive for large results, so prefer the binary encoding of the query API.
+	req.Header.Set("Accept", v1.ContentTypeProtobuf+", "+v1.ContentTypeJSON+";q=0.9")
 
 	client := &http.Client{
 		Transport: tracing.HTTPTripperware(logger, http.DefaultTransport),
@@ -640,31 +778,9 @@ func queryPrometheusInstant(ctx context.Context, logger log.Logger, addr, query
 
 	// Always try to decode a vector. Scalar rules won't work for now and arguably
 	// have no relevant use case.
-	var m struct {
-		Data struct {
-			Result model.Vector `json:"result"`
-		} `json:"data"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&m); err != nil {
-		return nil, err
-	}
-	vec := make(promql.Vector, 0, len(m.Data.Result))
-
-	for _, e := range m.Data.Result {
-		lset := make(promlabels.Labels, 0, len(e.Metric))
-
-		for k, v := range e.Metric {
-			lset = append(lset, promlabels.Label{
-				Name:  string(k),
-				Value: string(v),
-			})
-		}
-		sort.Sort(lset)
-
-		vec = append(vec, promql.Sample{
-			Metric: lset,
-			Point:  promql.Point{T: int64(e.Timestamp), V: float64(e.Value)},
-		})
+	vec, _, err := v1.DecodeVector(resp.Header.Get("Content-Type"), resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "decode query response")
 	}
 	return vec, nil
 }
@@ -676,6 +792,89 @@ type alertmanagerSet struct {
 	current  []*url.URL
 }
 
+// firingAlerts tracks the alerts that were sent as firing and have not been resolved yet.
+type firingAlerts struct {
+	mtx    sync.Mutex
+	alerts map[uint64]*alert.Alert
+}
+
+func newFiringAlerts() *firingAlerts {
+	return &firingAlerts{alerts: map[uint64]*alert.Alert{}}
+}
+
+// update tracks the given firing alerts and stops tracking the given resolved ones.
+func (f *firingAlerts) update(alerts []*alert.Alert) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	now := time.Now()
+	for _, a := range alerts {
+		if a.ResolvedAt(now) {
+			delete(f.alerts, a.Hash())
+			continue
+		}
+		// Copy the alert as the queue attaches external labels to pushed alerts.
+		c := *a
+		f.alerts[a.Hash()] = &c
+	}
+}
+
+// resolve stops tracking all alerts except those with label hashes in keep and returns them resolved at t.
+func (f *firingAlerts) resolve(keep map[uint64]struct{}, t time.Time) []*alert.Alert {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	var res []*alert.Alert
+	for h, a := range f.alerts {
+		if _, ok := keep[h]; ok {
+			continue
+		}
+		delete(f.alerts, h)
+
+		a.EndsAt = t
+		res = append(res, a)
+	}
+	return res
+}
+
+// activeAlerts returns the label hashes of the pending and firing alerts of all alerting rules of the manager.
+func activeAlerts(mgr *rules.Manager) map[uint64]struct{} {
+	res := map[uint64]struct{}{}
+	for _, r := range mgr.AlertingRules() {
+		for _, a := range r.ActiveAlerts() {
+			res[a.Labels.Hash()] = struct{}{}
+		}
+	}
+	return res
+}
+
+// flushAlerts sends the queued alerts, including any alerts resolved on shutdown, until the queue
+// is empty or the timeout expires.
+func flushAlerts(logger log.Logger, sdr *alert.Sender, alertQ *alert.Queue, resolved <-chan struct{}, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	select {
+	case <-resolved:
+	case <-ctx.Done():
+		level.Warn(logger).Log("msg", "timed out waiting for rule manager to resolve alerts", "timeout", timeout)
+		return
+	}
+	for {
+		alerts := alertQ.TryPop()
+		if len(alerts) == 0 {
+			return
+		}
+		if err := sdr.Send(ctx, alerts); err != nil {
+			level.Warn(logger).Log("msg", "sending alerts on shutdown failed", "err", err)
+		}
+		if ctx.Err() != nil {
+			level.Warn(logger).Log("msg", "timed out sending alerts on shutdown", "numDropped", alertQ.Len())
+			return
+		}
+	}
+}
+
 func newAlertmanagerSet(addrs []string, resolver *net.Resolver) *alertmanagerSet {
 	return &alertmanagerSet{
 		resolver: dns.NewResolver(resolver),
@@ -744,6 +943,34 @@ func (s *alertmanagerSet) update(ctx context.Context) error {
 	return nil
 }
 
+// embeddedQueryFunc returns a function evaluating rule queries with the given engine against the store APIs of the queryable.
+// Partial errors of store APIs fail the evaluation unless partial responses are enabled, in which case they are logged.
+func embeddedQueryFunc(logger log.Logger, engine *promql.Engine, queryableCreator query.QueryableCreator, deduplicate, partialResponse bool) rules.QueryFunc {
+	return func(ctx context.Context, q string, t time.Time) (promql.Vector, error) {
+		var (
+			mtx         sync.Mutex
+			partialErrs []error
+		)
+		queryable := queryableCreator(deduplicate, 0, false, func(err error) {
+			mtx.Lock()
+			partialErrs = append(partialErrs, err)
+			mtx.Unlock()
+		})
+
+		vec, err := rules.EngineQueryFunc(engine, queryable)(ctx, q, t)
+		if err != nil {
+			return nil, err
+		}
+		for _, err := range partialErrs {
+			if !partialResponse {
+				return nil, errors.Wrap(err, "partial response")
+			}
+			level.Warn(logger).Log("msg", "partial response for rule query", "query", q, "err", err)
+		}
+		return vec, nil
+	}
+}
+
 func parseFlagLabels(s []string) (labels.Labels, error) {
 	var lset labels.Labels
 	for _, l := range s {
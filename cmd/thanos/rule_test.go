@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/improbable-eng/thanos/pkg/alert"
+	"github.com/improbable-eng/thanos/pkg/query"
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+	"github.com/improbable-eng/thanos/pkg/testutil"
+	"github.com/pkg/errors"
+	promlabels "github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/tsdb/chunkenc"
+)
+
+type testStoreServer struct {
+	// This field just exist to pseudo-implement the unused methods of the interface.
+	storepb.StoreServer
+
+	resps []*storepb.SeriesResponse
+}
+
+func (s *testStoreServer) Series(_ *storepb.SeriesRequest, srv storepb.Store_SeriesServer) error {
+	for _, resp := range s.resps {
+		if err := srv.Send(resp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestEmbeddedQueryFunc(t *testing.T) {
+	c := chunkenc.NewXORChunk()
+	a, err := c.Appender()
+	testutil.Ok(t, err)
+	a.Append(1000, 1)
+	a.Append(2000, 2)
+
+	series := func(replica string) *storepb.SeriesResponse {
+		return storepb.NewSeriesResponse(&storepb.Series{
+			Labels: []storepb.Label{{Name: "__name__", Value: "up"}, {Name: "replica", Value: replica}},
+			Chunks: []storepb.AggrChunk{{MinTime: 1000, MaxTime: 2000, Raw: &storepb.Chunk{Type: storepb.Chunk_XOR, Data: c.Bytes()}}},
+		})
+	}
+	srv := &testStoreServer{resps: []*storepb.SeriesResponse{series("a"), series("b")}}
+
+	var (
+		engine           = promql.NewEngine(nil, nil, 1, time.Minute)
+		queryableCreator = query.NewQueryableCreator(nil, srv, "replica")
+		ts               = time.Unix(2, 0)
+	)
+
+	vec, err := embeddedQueryFunc(log.NewNopLogger(), engine, queryableCreator, false, false)(context.Background(), "up", ts)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 2, len(vec))
+
+	// Replicas are deduplicated.
+	vec, err = embeddedQueryFunc(log.NewNopLogger(), engine, queryableCreator, true, false)(context.Background(), "up", ts)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 1, len(vec))
+	testutil.Equals(t, promql.Point{T: 2000, V: 2}, vec[0].Point)
+
+	// Partial errors fail the evaluation unless partial responses are enabled.
+	srv.resps = append(srv.resps, storepb.NewWarnSeriesResponse(errors.New("store unavailable")))
+
+	_, err = embeddedQueryFunc(log.NewNopLogger(), engine, queryableCreator, true, false)(context.Background(), "up", ts)
+	testutil.NotOk(t, err)
+
+	vec, err = embeddedQueryFunc(log.NewNopLogger(), engine, queryableCreator, true, true)(context.Background(), "up", ts)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 1, len(vec))
+}
+
+func TestFiringAlerts(t *testing.T) {
+	var (
+		f   = newFiringAlerts()
+		now = time.Now()
+		a1  = &alert.Alert{Labels: promlabels.FromStrings("alertname", "a1"), GeneratorURL: "http://query/graph"}
+		a2  = &alert.Alert{Labels: promlabels.FromStrings("alertname", "a2")}
+		a3  = &alert.Alert{Labels: promlabels.FromStrings("alertname", "a3")}
+	)
+	f.update([]*alert.Alert{a1, a2, a3})
+	// Alerts resolved by their rules are not tracked anymore.
+	f.update([]*alert.Alert{{Labels: a3.Labels, EndsAt: now.Add(-time.Minute)}})
+
+	resolved := f.resolve(map[uint64]struct{}{a2.Hash(): {}}, now)
+	testutil.Equals(t, 1, len(resolved))
+	testutil.Equals(t, a1.Labels, resolved[0].Labels)
+	testutil.Equals(t, a1.GeneratorURL, resolved[0].GeneratorURL)
+	testutil.Equals(t, now, resolved[0].EndsAt)
+	testutil.Assert(t, a1.EndsAt.IsZero(), "tracked alert must be a copy")
+
+	resolved = f.resolve(nil, now)
+	testutil.Equals(t, 1, len(resolved))
+	testutil.Equals(t, a2.Labels, resolved[0].Labels)
+
+	testutil.Equals(t, 0, len(f.resolve(nil, now)))
+}
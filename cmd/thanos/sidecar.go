@@ -40,6 +40,18 @@ func registerSidecar(m map[string]setupFunc, app *kingpin.Application, name stri
 	promURL := cmd.Flag("prometheus.url", "URL at which to reach Prometheus's API. For better performance use local network.").
 		Default("http://localhost:9090").URL()
 
+	remoteReadMaxConcurrent := cmd.Flag("prometheus.remote-read.max-concurrent", "Maximum number of remote reads and pushed down queries sent to Prometheus concurrently. Further requests wait for a free slot. 0 means no limit.").
+		Default("20").Int()
+
+	remoteReadMaxWait := modelDuration(cmd.Flag("prometheus.remote-read.max-wait", "Maximum time a request waits for a free remote read slot before it is rejected. 0 means no limit.").
+		Default("1m"))
+
+	remoteReadMaxRange := modelDuration(cmd.Flag("prometheus.remote-read.max-range", "Maximum time range of a single remote read against Prometheus. Series requests over longer time ranges are split into several remote reads, pushed down queries selecting longer time ranges fail. 0 means no limit.").
+		Default("0s"))
+
+	remoteReadMaxSamples := cmd.Flag("prometheus.remote-read.max-samples", "Maximum number of samples a single series request may read from Prometheus or a pushed down query may return. Requests exceeding it fail. 0 means no limit.").
+		Default("0").Int()
+
 	dataDir := cmd.Flag("tsdb.path", "Data directory of TSDB.").
 		Default("./data").String()
 
@@ -53,6 +65,8 @@ func registerSidecar(m map[string]setupFunc, app *kingpin.Application, name stri
 
 	objStoreConfig := regCommonObjStoreFlags(cmd, "")
 
+	reconcileConf := regShipperReconcileFlags(cmd)
+
 	m[name] = func(g *run.Group, logger log.Logger, reg *prometheus.Registry, tracer opentracing.Tracer, _ bool) error {
 		rl := reloader.New(
 			log.With(logger, "component", "reloader"),
@@ -76,8 +90,13 @@ func registerSidecar(m map[string]setupFunc, app *kingpin.Application, name stri
 			*clientCA,
 			*httpBindAddr,
 			*promURL,
+			*remoteReadMaxConcurrent,
+			time.Duration(*remoteReadMaxWait),
+			time.Duration(*remoteReadMaxRange),
+			*remoteReadMaxSamples,
 			*dataDir,
 			objStoreConfig,
+			reconcileConf,
 			peer,
 			rl,
 			name,
@@ -96,8 +115,13 @@ func runSidecar(
 	clientCA string,
 	httpBindAddr string,
 	promURL *url.URL,
+	remoteReadMaxConcurrent int,
+	remoteReadMaxWait time.Duration,
+	remoteReadMaxRange time.Duration,
+	remoteReadMaxSamples int,
 	dataDir string,
 	objStoreConfig *pathOrContent,
+	reconcileConf *shipperReconcileConfig,
 	peer *cluster.Peer,
 	reloader *reloader.Reloader,
 	component string,
@@ -204,7 +228,8 @@ func runSidecar(
 		var client http.Client
 
 		promStore, err := store.NewPrometheusStore(
-			logger, &client, promURL, metadata.Labels, metadata.Timestamps)
+			logger, reg, &client, promURL, metadata.Labels, metadata.Timestamps,
+			remoteReadMaxConcurrent, remoteReadMaxWait, remoteReadMaxRange, remoteReadMaxSamples)
 		if err != nil {
 			return errors.Wrap(err, "create Prometheus store")
 		}
@@ -215,6 +240,7 @@ func runSidecar(
 		}
 		s := grpc.NewServer(opts...)
 		storepb.RegisterStoreServer(s, promStore)
+		storepb.RegisterQueryServer(s, promStore)
 
 		g.Add(func() error {
 			level.Info(logger).Log("msg", "Listening for StoreAPI gRPC", "address", grpcBindAddr)
@@ -252,7 +278,8 @@ func runSidecar(
 			}
 		}()
 
-		s := shipper.New(logger, nil, dataDir, bkt, metadata.Labels, block.SidecarSource)
+		s := shipper.New(logger, reg, dataDir, bkt, metadata.Labels, block.SidecarSource)
+		reconcile := shipperReconciler(logger, s, reconcileConf)
 		ctx, cancel := context.WithCancel(context.Background())
 
 		g.Add(func() error {
@@ -260,6 +287,7 @@ func runSidecar(
 
 			return runutil.Repeat(30*time.Second, ctx.Done(), func() error {
 				s.Sync(ctx)
+				reconcile(ctx)
 
 				minTime, _, err := s.Timestamps()
 				if err != nil {
@@ -281,6 +309,25 @@ func runSidecar(
 	return nil
 }
 
+// shipperReconciler returns a function that reconciles the uploaded blocks of the shipper against the bucket
+// if the configured interval passed since the last reconciliation. Like the shipper, it is not concurrency-safe.
+func shipperReconciler(logger log.Logger, s *shipper.Shipper, conf *shipperReconcileConfig) func(context.Context) {
+	var (
+		interval = time.Duration(*conf.interval)
+		last     time.Time
+	)
+	return func(ctx context.Context) {
+		if interval <= 0 || time.Since(last) < interval {
+			return
+		}
+		last = time.Now()
+
+		if err := s.Reconcile(ctx, *conf.upload, time.Duration(*conf.retention)); err != nil {
+			level.Warn(logger).Log("msg", "reconciling uploaded blocks failed", "err", err)
+		}
+	}
+}
+
 type metadata struct {
 	promURL *url.URL
 
@@ -13,6 +13,7 @@ import (
 	"github.com/improbable-eng/thanos/pkg/runutil"
 	"github.com/improbable-eng/thanos/pkg/store"
 	"github.com/improbable-eng/thanos/pkg/store/storepb"
+	"github.com/improbable-eng/thanos/pkg/usage"
 	"github.com/oklog/run"
 	"github.com/opentracing/opentracing-go"
 	"github.com/pkg/errors"
@@ -41,6 +42,13 @@ func registerStore(m map[string]setupFunc, app *kingpin.Application, name string
 	syncInterval := cmd.Flag("sync-block-duration", "Repeat interval for syncing the blocks between local and remote view.").
 		Default("3m").Duration()
 
+	usageConf := regCommonUsageFlags(cmd)
+
+	indexMaxStaleness := regBucketIndexMaxStalenessFlag(cmd)
+
+	resolutionFallback := cmd.Flag("store.resolution-fallback", "Serve time ranges without data of the requested maximum resolution from blocks of lower resolutions for all requests, e.g. when raw data was deleted by retention. Requests can also ask for it individually.").
+		Default("false").Bool()
+
 	m[name] = func(g *run.Group, logger log.Logger, reg *prometheus.Registry, tracer opentracing.Tracer, debugLogging bool) error {
 		peer, err := newPeerFn(logger, reg, false, "", false)
 		if err != nil {
@@ -63,6 +71,9 @@ func registerStore(m map[string]setupFunc, app *kingpin.Application, name string
 			name,
 			debugLogging,
 			*syncInterval,
+			usageConf,
+			time.Duration(*indexMaxStaleness),
+			*resolutionFallback,
 		)
 	}
 }
@@ -86,6 +97,9 @@ func runStore(
 	component string,
 	verbose bool,
 	syncInterval time.Duration,
+	usageConf *usageConfig,
+	indexMaxStaleness time.Duration,
+	resolutionFallback bool,
 ) error {
 	{
 		bucketConfig, err := objStoreConfig.Content()
@@ -113,6 +127,9 @@ func runStore(
 			indexCacheSizeBytes,
 			chunkPoolSizeBytes,
 			verbose,
+			indexMaxStaleness,
+			usageConf.tracker(g, logger, reg, "bucket_store", usage.SeriesRequests, usage.FetchedBytes, usage.Chunks),
+			resolutionFallback,
 		)
 		if err != nil {
 			return errors.Wrap(err, "create object storage store")
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/go-kit/kit/log"
+	"github.com/improbable-eng/thanos/pkg/block"
+	"github.com/improbable-eng/thanos/pkg/compact/downsample"
+	"github.com/improbable-eng/thanos/pkg/runutil"
+	"github.com/oklog/run"
+	"github.com/opentracing/opentracing-go"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	promlabels "github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/tsdb"
+	"github.com/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/tsdb/chunks"
+	"github.com/prometheus/tsdb/index"
+	"github.com/prometheus/tsdb/labels"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+func registerTools(m map[string]setupFunc, app *kingpin.Application, name string) {
+	cmd := app.Command(name, "tools for inspecting Thanos data locally")
+
+	blockCmd := cmd.Command("block", "inspect a local block directory, e.g. one downloaded from the bucket")
+
+	meta := blockCmd.Command("meta", "print the meta.json of the block")
+	metaDir := meta.Arg("block-dir", "Block directory.").Required().ExistingDir()
+	m[name+" block meta"] = func(g *run.Group, _ log.Logger, _ *prometheus.Registry, _ opentracing.Tracer, _ bool) error {
+		// Dummy actor to immediately kill the group after the run function returns.
+		g.Add(func() error { return nil }, func(error) {})
+
+		return printBlockMeta(os.Stdout, *metaDir)
+	}
+
+	series := blockCmd.Command("series", "list the series of the block matching a selector with the number and time range of their chunks")
+	seriesDir := series.Arg("block-dir", "Block directory.").Required().ExistingDir()
+	seriesSelector := series.Flag("selector", "Series selector, e.g. 'up{job=\"prometheus\"}'. All series are listed if empty.").
+		Short('s').Default("").String()
+	m[name+" block series"] = func(g *run.Group, logger log.Logger, _ *prometheus.Registry, _ opentracing.Tracer, _ bool) error {
+		// Dummy actor to immediately kill the group after the run function returns.
+		g.Add(func() error { return nil }, func(error) {})
+
+		return printBlockSeries(os.Stdout, logger, *seriesDir, *seriesSelector, false)
+	}
+
+	dump := blockCmd.Command("dump", "print the samples of the series of the block matching a selector per chunk. "+
+		"Chunks of downsampled blocks are printed per aggregate")
+	dumpDir := dump.Arg("block-dir", "Block directory.").Required().ExistingDir()
+	dumpSelector := dump.Flag("selector", "Series selector, e.g. 'up{job=\"prometheus\"}'. All series are dumped if empty.").
+		Short('s').Default("").String()
+	m[name+" block dump"] = func(g *run.Group, logger log.Logger, _ *prometheus.Registry, _ opentracing.Tracer, _ bool) error {
+		// Dummy actor to immediately kill the group after the run function returns.
+		g.Add(func() error { return nil }, func(error) {})
+
+		return printBlockSeries(os.Stdout, logger, *dumpDir, *dumpSelector, true)
+	}
+
+	indexStats := blockCmd.Command("index-stats", "print statistics and issues of the block index as checked by the bucket verifier")
+	indexStatsDir := indexStats.Arg("block-dir", "Block directory.").Required().ExistingDir()
+	m[name+" block index-stats"] = func(g *run.Group, logger log.Logger, _ *prometheus.Registry, _ opentracing.Tracer, _ bool) error {
+		// Dummy actor to immediately kill the group after the run function returns.
+		g.Add(func() error { return nil }, func(error) {})
+
+		return printBlockIndexStats(os.Stdout, logger, *indexStatsDir)
+	}
+}
+
+// printBlockMeta prints the indented meta.json of the block in dir.
+func printBlockMeta(w io.Writer, dir string) error {
+	m, err := block.ReadMetaFile(dir)
+	if err != nil {
+		return errors.Wrap(err, "read meta")
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(m)
+}
+
+// printBlockSeries prints the series of the block in dir matching the selector with their chunks.
+// If samples is true, the samples of every chunk are printed as well.
+func printBlockSeries(w io.Writer, logger log.Logger, dir string, selector string, samples bool) (err error) {
+	var matchers []*promlabels.Matcher
+	if selector != "" {
+		if matchers, err = promql.ParseMetricSelector(selector); err != nil {
+			return errors.Wrap(err, "parse selector")
+		}
+	}
+
+	// The pool decodes aggregate chunks of downsampled blocks as well as raw chunks.
+	b, err := tsdb.OpenBlock(dir, downsample.NewPool())
+	if err != nil {
+		return errors.Wrap(err, "open block")
+	}
+	defer runutil.CloseWithErrCapture(logger, &err, b, "block")
+
+	ir, err := b.Index()
+	if err != nil {
+		return errors.Wrap(err, "open index")
+	}
+	defer runutil.CloseWithErrCapture(logger, &err, ir, "index reader")
+
+	cr, err := b.Chunks()
+	if err != nil {
+		return errors.Wrap(err, "open chunks")
+	}
+	defer runutil.CloseWithErrCapture(logger, &err, cr, "chunk reader")
+
+	p, err := ir.Postings(index.AllPostingsKey())
+	if err != nil {
+		return errors.Wrap(err, "get all postings")
+	}
+	var (
+		lset labels.Labels
+		chks []chunks.Meta
+	)
+	for p.Next() {
+		if err := ir.Series(p.At(), &lset, &chks); err != nil {
+			return errors.Wrap(err, "read series")
+		}
+		if !matchSeries(matchers, lset) {
+			continue
+		}
+		if len(chks) == 0 {
+			fmt.Fprintf(w, "%s chunks=0\n", lset)
+			continue
+		}
+		fmt.Fprintf(w, "%s chunks=%d mint=%d maxt=%d\n", lset, len(chks), chks[0].MinTime, chks[len(chks)-1].MaxTime)
+
+		if !samples {
+			continue
+		}
+		for i, cm := range chks {
+			c, err := cr.Chunk(cm.Ref)
+			if err != nil {
+				return errors.Wrapf(err, "read chunk %d of series %s", i, lset)
+			}
+			if err := printChunk(w, i, cm, c); err != nil {
+				return errors.Wrapf(err, "print chunk %d of series %s", i, lset)
+			}
+		}
+	}
+	if p.Err() != nil {
+		return errors.Wrap(p.Err(), "walk postings")
+	}
+	return nil
+}
+
+// printChunk prints the samples of the chunk. Aggregate chunks are printed per aggregate they contain.
+func printChunk(w io.Writer, i int, cm chunks.Meta, c chunkenc.Chunk) error {
+	if c.Encoding() != downsample.ChunkEncAggr {
+		fmt.Fprintf(w, "\tchunk %d: mint=%d maxt=%d encoding=%s samples=%d\n", i, cm.MinTime, cm.MaxTime, c.Encoding(), c.NumSamples())
+		return printSamples(w, "\t\t", c.Iterator())
+	}
+
+	fmt.Fprintf(w, "\tchunk %d: mint=%d maxt=%d encoding=aggr\n", i, cm.MinTime, cm.MaxTime)
+
+	ac := downsample.AggrChunk(c.Bytes())
+	for _, at := range []downsample.AggrType{
+		downsample.AggrCount, downsample.AggrSum, downsample.AggrMin, downsample.AggrMax, downsample.AggrCounter,
+	} {
+		sc, err := ac.Get(at)
+		if err == downsample.ErrAggrNotExist {
+			continue
+		}
+		if err != nil {
+			return errors.Wrapf(err, "get aggregate %s", at)
+		}
+		fmt.Fprintf(w, "\t\t%s: samples=%d\n", at, sc.NumSamples())
+		if err := printSamples(w, "\t\t\t", sc.Iterator()); err != nil {
+			return errors.Wrapf(err, "aggregate %s", at)
+		}
+	}
+	return nil
+}
+
+func printSamples(w io.Writer, indent string, it chunkenc.Iterator) error {
+	for it.Next() {
+		t, v := it.At()
+		fmt.Fprintf(w, "%s%d %g\n", indent, t, v)
+	}
+	return errors.Wrap(it.Err(), "iterate samples")
+}
+
+func matchSeries(matchers []*promlabels.Matcher, lset labels.Labels) bool {
+	for _, m := range matchers {
+		if !m.Matches(lset.Get(m.Name)) {
+			return false
+		}
+	}
+	return true
+}
+
+// printBlockIndexStats prints the statistics the bucket verifier gathers about the index of the block in dir
+// followed by the issues they indicate.
+func printBlockIndexStats(w io.Writer, logger log.Logger, dir string) error {
+	m, err := block.ReadMetaFile(dir)
+	if err != nil {
+		return errors.Wrap(err, "read meta")
+	}
+	stats, err := block.GatherIndexIssueStats(logger, filepath.Join(dir, block.IndexFilename), m.MinTime, m.MaxTime)
+	if err != nil {
+		return errors.Wrap(err, "gather index issue stats")
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 1, ' ', 0)
+	for _, s := range []struct {
+		name string
+		val  int
+	}{
+		{"Total series", stats.TotalSeries},
+		{"Out of order series", stats.OutOfOrderSeries},
+		{"Out of order chunks", stats.OutOfOrderChunks},
+		{"Duplicated chunks", stats.DuplicatedChunks},
+		{"Chunks outside of block range", stats.OutsideChunks},
+		{"Chunks completely outside of block range", stats.CompleteOutsideChunks},
+		{"Chunks outside of block range due to issue 347", stats.Issue347OutsideChunks},
+	} {
+		fmt.Fprintf(tw, "%s:\t%d\n", s.name, s.val)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if err := stats.AnyErr(); err != nil {
+		fmt.Fprintf(w, "Issues: %s\n", err)
+		return nil
+	}
+	fmt.Fprintln(w, "Issues: none")
+	return nil
+}
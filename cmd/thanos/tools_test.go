@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/improbable-eng/thanos/pkg/block"
+	"github.com/improbable-eng/thanos/pkg/compact/downsample"
+	"github.com/improbable-eng/thanos/pkg/testutil"
+	"github.com/prometheus/tsdb"
+	"github.com/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/tsdb/labels"
+)
+
+func TestToolsBlock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tools-block")
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, os.RemoveAll(dir)) }()
+
+	logger := log.NewNopLogger()
+
+	id, err := testutil.CreateBlock(dir, []labels.Labels{
+		labels.FromStrings("a", "1"),
+		labels.FromStrings("a", "2"),
+	}, 100, 0, 3600*1000, labels.FromStrings("ext", "1"), 0)
+	testutil.Ok(t, err)
+	bdir := filepath.Join(dir, id.String())
+
+	var buf bytes.Buffer
+	testutil.Ok(t, printBlockMeta(&buf, bdir))
+	var m block.Meta
+	testutil.Ok(t, json.Unmarshal(buf.Bytes(), &m))
+	exp, err := block.ReadMetaFile(bdir)
+	testutil.Ok(t, err)
+	testutil.Equals(t, *exp, m)
+
+	buf.Reset()
+	testutil.Ok(t, printBlockSeries(&buf, logger, bdir, "", false))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	testutil.Equals(t, 2, len(lines))
+	testutil.Assert(t, strings.HasPrefix(lines[0], `{a="1"} chunks=1 mint=0 `), "unexpected series %q", lines[0])
+	testutil.Assert(t, strings.HasPrefix(lines[1], `{a="2"} chunks=1 mint=0 `), "unexpected series %q", lines[1])
+
+	buf.Reset()
+	testutil.Ok(t, printBlockSeries(&buf, logger, bdir, `{a="2"}`, true))
+	lines = strings.Split(strings.TrimSpace(buf.String()), "\n")
+	// Series, chunk and sample lines.
+	testutil.Equals(t, 1+1+100, len(lines))
+	testutil.Assert(t, strings.HasPrefix(lines[1], "\tchunk 0: mint=0 "), "unexpected chunk %q", lines[1])
+	testutil.Assert(t, strings.HasPrefix(lines[2], "\t\t0 "), "unexpected sample %q", lines[2])
+
+	testutil.NotOk(t, printBlockSeries(&buf, logger, bdir, `{a=~"("}`, false))
+
+	buf.Reset()
+	testutil.Ok(t, printBlockIndexStats(&buf, logger, bdir))
+	testutil.Assert(t, strings.Contains(buf.String(), "Total series:"), "missing total series in %q", buf.String())
+	testutil.Assert(t, strings.HasSuffix(buf.String(), "Issues: none\n"), "unexpected issues in %q", buf.String())
+
+	// Aggregate chunks of downsampled blocks are printed per aggregate.
+	b, err := tsdb.OpenBlock(bdir, chunkenc.NewPool())
+	testutil.Ok(t, err)
+	did, err := downsample.Downsample(logger, exp, b, dir, downsample.ResLevel1)
+	testutil.Ok(t, err)
+	testutil.Ok(t, b.Close())
+
+	buf.Reset()
+	testutil.Ok(t, printBlockSeries(&buf, logger, filepath.Join(dir, did.String()), `{a="1"}`, true))
+	out := buf.String()
+	testutil.Assert(t, strings.Contains(out, "encoding=aggr\n"), "missing aggregate chunk in %q", out)
+	for _, at := range []string{"count", "sum", "min", "max", "counter"} {
+		testutil.Assert(t, strings.Contains(out, "\t\t"+at+": samples="), "missing aggregate %s in %q", at, out)
+	}
+}
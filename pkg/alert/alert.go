@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"net/url"
 	"path"
+	"sort"
 	"sync"
 	"time"
 
@@ -86,6 +87,8 @@ type Queue struct {
 	mtx   sync.Mutex
 	queue []*Alert
 	morec chan struct{}
+	// Number of alerts dropped since the queue was created.
+	numDropped int
 
 	pushed  prometheus.Counter
 	popped  prometheus.Counter
@@ -147,6 +150,13 @@ func (q *Queue) Cap() int {
 	return q.capacity
 }
 
+// Dropped returns the number of alerts dropped from the queue since it was created.
+func (q *Queue) Dropped() int {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	return q.numDropped
+}
+
 // Pop takes a batch of alerts from the front of the queue. The batch size is limited
 // according to the queues maxBatchSize limit.
 // It blocks until elements are available or a termination signal is send on termc.
@@ -157,6 +167,12 @@ func (q *Queue) Pop(termc <-chan struct{}) []*Alert {
 	case <-q.morec:
 	}
 
+	return q.TryPop()
+}
+
+// TryPop takes a batch of alerts from the front of the queue like Pop but does not block.
+// It returns an empty batch if the queue is empty.
+func (q *Queue) TryPop() []*Alert {
 	q.mtx.Lock()
 	defer q.mtx.Unlock()
 
@@ -197,6 +213,7 @@ func (q *Queue) Push(alerts []*Alert) {
 			"msg", "Alert batch larger than queue capacity, dropping alerts",
 			"numDropped", d)
 		q.dropped.Add(float64(d))
+		q.numDropped += d
 	}
 
 	// If the queue is full, remove the oldest alerts in favor
@@ -208,6 +225,7 @@ func (q *Queue) Push(alerts []*Alert) {
 			"msg", "Alert notification queue full, dropping alerts",
 			"numDropped", d)
 		q.dropped.Add(float64(d))
+		q.numDropped += d
 	}
 
 	q.queue = append(q.queue, alerts...)
@@ -223,6 +241,7 @@ type Sender struct {
 	logger        log.Logger
 	alertmanagers func() []*url.URL
 	doReq         func(req *http.Request) (*http.Response, error)
+	statuses      *DeliveryStatuses
 
 	sent    *prometheus.CounterVec
 	dropped *prometheus.CounterVec
@@ -231,11 +250,13 @@ type Sender struct {
 
 // NewSender returns a new sender. On each call to Send the entire alert batch is sent
 // to each Alertmanager returned by the getter function.
+// The delivery status of sent alerts is recorded in the given statuses unless they are nil.
 func NewSender(
 	logger log.Logger,
 	reg prometheus.Registerer,
 	alertmanagers func() []*url.URL,
 	doReq func(req *http.Request) (*http.Response, error),
+	statuses *DeliveryStatuses,
 ) *Sender {
 	if doReq == nil {
 		doReq = http.DefaultClient.Do
@@ -247,6 +268,7 @@ func NewSender(
 		logger:        logger,
 		alertmanagers: alertmanagers,
 		doReq:         doReq,
+		statuses:      statuses,
 
 		sent: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Name: "thanos_alert_sender_alerts_sent_total",
@@ -279,9 +301,16 @@ func (s *Sender) Send(ctx context.Context, alerts []*Alert) error {
 		return errors.Wrap(err, "encode alerts")
 	}
 
-	var g errgroup.Group
+	var (
+		g     errgroup.Group
+		start = time.Now()
+		ams   = s.alertmanagers()
 
-	for _, u := range s.alertmanagers() {
+		mtx      sync.Mutex
+		accepted []string
+	)
+	for _, u := range ams {
+		u := u
 		amURL := *u
 		sendCtx, cancel := context.WithCancel(ctx)
 
@@ -302,13 +331,24 @@ func (s *Sender) Send(ctx context.Context, alerts []*Alert) error {
 			}
 			s.sent.WithLabelValues(u.Host).Add(float64(len(alerts)))
 			s.latency.WithLabelValues(u.Host).Observe(time.Since(start).Seconds())
+
+			mtx.Lock()
+			accepted = append(accepted, u.Host)
+			mtx.Unlock()
 			return nil
 		})
 	}
-	if err := g.Wait(); err != nil {
-		return errors.Wrap(err, "send alerts")
+	err = g.Wait()
+
+	if s.statuses != nil {
+		statusErr := err
+		if len(ams) == 0 {
+			statusErr = errors.New("no Alertmanager to send to")
+		}
+		sort.Strings(accepted)
+		s.statuses.update(alerts, start, accepted, statusErr)
 	}
-	return nil
+	return errors.Wrap(err, "send alerts")
 }
 
 func (s *Sender) sendOne(ctx context.Context, url string, b []byte) error {
@@ -0,0 +1,18 @@
+package alert
+
+import (
+	"testing"
+
+	"github.com/improbable-eng/thanos/pkg/testutil"
+)
+
+func TestQueue_TryPop(t *testing.T) {
+	q := NewQueue(nil, nil, 10, 2, nil)
+	testutil.Equals(t, 0, len(q.TryPop()))
+
+	q.Push([]*Alert{{}, {}, {}})
+	testutil.Equals(t, 2, len(q.Pop(nil)))
+	// The remaining alert can be taken without another push.
+	testutil.Equals(t, 1, len(q.TryPop()))
+	testutil.Equals(t, 0, q.Len())
+}
@@ -0,0 +1,87 @@
+package alert
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+)
+
+// DeliveryStatus is the status of the delivery of an alert to Alertmanagers.
+type DeliveryStatus struct {
+	Labels   labels.Labels `json:"labels"`
+	Resolved bool          `json:"resolved"`
+	// Number of times the alert was sent.
+	Sends    int       `json:"sends"`
+	LastSend time.Time `json:"lastSend"`
+	// Alertmanagers that accepted the alert when it was last sent.
+	Alertmanagers []string `json:"alertmanagers"`
+	// Error of the last send. It is empty if all Alertmanagers accepted the alert.
+	LastError string `json:"lastError,omitempty"`
+}
+
+// DeliveryStatuses keeps the delivery status of a limited number of the most recently sent alerts.
+type DeliveryStatuses struct {
+	capacity int
+
+	mtx sync.Mutex
+	// Elements of statuses by the hash of the alert labels.
+	byHash map[uint64]*list.Element
+	// Statuses ordered by their last send, most recent first.
+	statuses *list.List
+}
+
+// NewDeliveryStatuses returns delivery statuses that keep the status of at most capacity alerts.
+func NewDeliveryStatuses(capacity int) *DeliveryStatuses {
+	return &DeliveryStatuses{
+		capacity: capacity,
+		byHash:   map[uint64]*list.Element{},
+		statuses: list.New(),
+	}
+}
+
+// update records that the alerts were sent at the given time, were accepted by the given Alertmanagers and
+// failed with the given error, if any. The statuses of the least recently sent alerts are dropped to stay within capacity.
+func (s *DeliveryStatuses) update(alerts []*Alert, t time.Time, accepted []string, err error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	var errMsg string
+	if err != nil {
+		errMsg = err.Error()
+	}
+	for _, a := range alerts {
+		h := a.Hash()
+		e, ok := s.byHash[h]
+		if ok {
+			s.statuses.MoveToFront(e)
+		} else {
+			e = s.statuses.PushFront(&DeliveryStatus{Labels: a.Labels.Copy()})
+			s.byHash[h] = e
+		}
+		st := e.Value.(*DeliveryStatus)
+		st.Resolved = a.ResolvedAt(t)
+		st.Sends++
+		st.LastSend = t
+		st.Alertmanagers = accepted
+		st.LastError = errMsg
+	}
+	for s.statuses.Len() > s.capacity {
+		e := s.statuses.Back()
+		delete(s.byHash, e.Value.(*DeliveryStatus).Labels.Hash())
+		s.statuses.Remove(e)
+	}
+}
+
+// Get returns the delivery statuses, most recently sent alerts first.
+func (s *DeliveryStatuses) Get() []DeliveryStatus {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	res := make([]DeliveryStatus, 0, s.statuses.Len())
+	for e := s.statuses.Front(); e != nil; e = e.Next() {
+		res = append(res, *e.Value.(*DeliveryStatus))
+	}
+	return res
+}
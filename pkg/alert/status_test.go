@@ -0,0 +1,88 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/improbable-eng/thanos/pkg/testutil"
+	"github.com/prometheus/prometheus/pkg/labels"
+)
+
+func TestSender_DeliveryStatuses(t *testing.T) {
+	var (
+		statuses = NewDeliveryStatuses(2)
+		failing  = map[string]bool{}
+	)
+	ams := []*url.URL{{Scheme: "http", Host: "am1:9093"}, {Scheme: "http", Host: "am2:9093"}}
+
+	s := NewSender(nil, nil, func() []*url.URL { return ams }, func(req *http.Request) (*http.Response, error) {
+		status := http.StatusOK
+		if failing[req.URL.Host] {
+			status = http.StatusInternalServerError
+		}
+		return &http.Response{
+			StatusCode: status,
+			Status:     http.StatusText(status),
+			Body:       ioutil.NopCloser(bytes.NewReader(nil)),
+		}, nil
+	}, statuses)
+
+	a1 := &Alert{Labels: labels.FromStrings("alertname", "a1")}
+	a2 := &Alert{Labels: labels.FromStrings("alertname", "a2")}
+	a3 := &Alert{Labels: labels.FromStrings("alertname", "a3"), EndsAt: time.Now().Add(-time.Minute)}
+
+	testutil.Ok(t, s.Send(context.Background(), []*Alert{a1, a2}))
+
+	failing["am2:9093"] = true
+	testutil.NotOk(t, s.Send(context.Background(), []*Alert{a1}))
+
+	got := statuses.Get()
+	testutil.Equals(t, 2, len(got))
+	testutil.Equals(t, a1.Labels, got[0].Labels)
+	testutil.Equals(t, 2, got[0].Sends)
+	testutil.Equals(t, []string{"am1:9093"}, got[0].Alertmanagers)
+	testutil.Assert(t, got[0].LastError != "", "expected error of last send")
+	testutil.Equals(t, a2.Labels, got[1].Labels)
+	testutil.Equals(t, 1, got[1].Sends)
+	testutil.Equals(t, []string{"am1:9093", "am2:9093"}, got[1].Alertmanagers)
+	testutil.Equals(t, "", got[1].LastError)
+
+	// The least recently sent alert is dropped once the capacity is exceeded.
+	failing = map[string]bool{}
+	testutil.Ok(t, s.Send(context.Background(), []*Alert{a3}))
+
+	got = statuses.Get()
+	testutil.Equals(t, 2, len(got))
+	testutil.Equals(t, a3.Labels, got[0].Labels)
+	testutil.Assert(t, got[0].Resolved, "expected resolved alert")
+	testutil.Equals(t, a1.Labels, got[1].Labels)
+
+	// Alerts are not delivered if there are no Alertmanagers.
+	ams = nil
+	testutil.Ok(t, s.Send(context.Background(), []*Alert{a2}))
+
+	got = statuses.Get()
+	testutil.Equals(t, a2.Labels, got[0].Labels)
+	testutil.Equals(t, 0, len(got[0].Alertmanagers))
+	testutil.Assert(t, got[0].LastError != "", "expected error without Alertmanagers")
+}
+
+func TestQueue_Dropped(t *testing.T) {
+	q := NewQueue(nil, nil, 3, 10, nil)
+
+	q.Push([]*Alert{{}, {}})
+	testutil.Equals(t, 0, q.Dropped())
+
+	q.Push([]*Alert{{}, {}})
+	testutil.Equals(t, 1, q.Dropped())
+	testutil.Equals(t, 3, q.Len())
+
+	q.Push([]*Alert{{}, {}, {}, {}})
+	testutil.Equals(t, 5, q.Dropped())
+	testutil.Equals(t, 3, q.Len())
+}
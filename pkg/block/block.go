@@ -14,6 +14,7 @@ import (
 
 	"github.com/go-kit/kit/log"
 	"github.com/improbable-eng/thanos/pkg/objstore"
+	"github.com/improbable-eng/thanos/pkg/objstore/audit"
 	"github.com/improbable-eng/thanos/pkg/runutil"
 	"github.com/oklog/ulid"
 	"github.com/pkg/errors"
@@ -42,6 +43,7 @@ const (
 	CompactorRepairSource SourceType = "compactor.repair"
 	RulerSource           SourceType = "ruler"
 	BucketRepairSource    SourceType = "bucket.repair"
+	BucketSplitSource     SourceType = "bucket.split"
 	TestSource            SourceType = "test"
 )
 
@@ -62,12 +64,22 @@ type ThanosMeta struct {
 
 	// Source is a real upload source of the block.
 	Source SourceType `json:"source"`
+
+	// Shard is the series shard of the block. Blocks without shard contain all series of their origin.
+	Shard ThanosShardMeta `json:"shard"`
 }
 
 type ThanosDownsampleMeta struct {
 	Resolution int64 `json:"resolution"`
 }
 
+// ThanosShardMeta describes which series of its origin a block contains. A block of shard Index out of Count
+// contains series whose labels hash to Index modulo Count. A Count of zero denotes an unsharded block.
+type ThanosShardMeta struct {
+	Index uint64 `json:"index"`
+	Count uint64 `json:"count"`
+}
+
 // WriteMetaFile writes the given meta into <dir>/meta.json.
 func WriteMetaFile(logger log.Logger, dir string, meta *Meta) error {
 	// Make any changes to the file appear atomic.
@@ -184,25 +196,25 @@ func Upload(ctx context.Context, logger log.Logger, bkt objstore.Bucket, bdir st
 	}
 
 	if err := objstore.UploadDir(ctx, logger, bkt, path.Join(bdir, ChunksDirname), path.Join(id.String(), ChunksDirname)); err != nil {
-		return cleanUp(bkt, id, errors.Wrap(err, "upload chunks"))
+		return cleanUp(ctx, bkt, id, errors.Wrap(err, "upload chunks"))
 	}
 
 	if err := objstore.UploadFile(ctx, logger, bkt, path.Join(bdir, IndexFilename), path.Join(id.String(), IndexFilename)); err != nil {
-		return cleanUp(bkt, id, errors.Wrap(err, "upload index"))
+		return cleanUp(ctx, bkt, id, errors.Wrap(err, "upload index"))
 	}
 
 	// Meta.json always need to be uploaded as a last item. This will allow to assume block directories without meta file
 	// to be pending uploads.
 	if err := objstore.UploadFile(ctx, logger, bkt, path.Join(bdir, MetaFilename), path.Join(id.String(), MetaFilename)); err != nil {
-		return cleanUp(bkt, id, errors.Wrap(err, "upload meta file"))
+		return cleanUp(ctx, bkt, id, errors.Wrap(err, "upload meta file"))
 	}
 
 	return nil
 }
 
-func cleanUp(bkt objstore.Bucket, id ulid.ULID, err error) error {
+func cleanUp(ctx context.Context, bkt objstore.Bucket, id ulid.ULID, err error) error {
 	// Cleanup the dir with an uncancelable context.
-	cleanErr := Delete(context.Background(), bkt, id)
+	cleanErr := Delete(audit.WithReason(context.Background(), audit.Reason(ctx)), bkt, id)
 	if cleanErr != nil {
 		return errors.Wrapf(err, "failed to clean block after upload issue. Partial block in system. Err: %s", err.Error())
 	}
@@ -216,7 +228,7 @@ func Delete(ctx context.Context, bucket objstore.Bucket, id ulid.ULID) error {
 }
 
 // DownloadMeta downloads only meta file from bucket by block ID.
-func DownloadMeta(ctx context.Context, logger log.Logger, bkt objstore.Bucket, id ulid.ULID) (Meta, error) {
+func DownloadMeta(ctx context.Context, logger log.Logger, bkt objstore.BucketReader, id ulid.ULID) (Meta, error) {
 	rc, err := bkt.Get(ctx, path.Join(id.String(), MetaFilename))
 	if err != nil {
 		return Meta{}, errors.Wrapf(err, "meta.json bkt get for %s", id.String())
@@ -0,0 +1,225 @@
+package block
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/improbable-eng/thanos/pkg/objstore"
+	"github.com/improbable-eng/thanos/pkg/runutil"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+)
+
+// BucketIndexFilename is the name of the bucket index object in the root of the bucket.
+const BucketIndexFilename = "bucket-index.json.gz"
+
+// ErrBucketIndexNotFound is returned if the bucket has no index.
+var ErrBucketIndexNotFound = errors.New("bucket index not found")
+
+// BucketIndex lists the metas of all blocks in a bucket, so that readers do not have to list the bucket and
+// fetch the meta of every block. It is maintained by the compactor. Updates of the index are not atomic,
+// so no other component must write it.
+type BucketIndex struct {
+	Version int `json:"version"`
+
+	// SyncTime is the time in milliseconds at which the bucket was listed last. Blocks uploaded after it,
+	// e.g. by sidecars, are missing from the index. Changes made by the compactor are reflected immediately.
+	SyncTime int64 `json:"sync_time"`
+
+	Blocks []Meta `json:"blocks"`
+
+	// DeletionMarks lists blocks that were deleted or are being deleted. Readers must not use them,
+	// even if they are still present in the bucket.
+	DeletionMarks []DeletionMark `json:"deletion_marks"`
+}
+
+// DeletionMark marks a block as deleted.
+type DeletionMark struct {
+	ID ulid.ULID `json:"id"`
+	// DeletionTime is the time in milliseconds at which the block was marked.
+	DeletionTime int64 `json:"deletion_time"`
+}
+
+// Stale returns whether the bucket was listed longer ago than the given duration.
+func (i *BucketIndex) Stale(maxStaleness time.Duration) bool {
+	return time.Since(timestamp.Time(i.SyncTime)) > maxStaleness
+}
+
+// Deleted returns whether the block is marked as deleted.
+func (i *BucketIndex) Deleted(id ulid.ULID) bool {
+	for _, m := range i.DeletionMarks {
+		if m.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Equal returns whether both indexes list the same blocks and deletion marks, regardless of their sync times.
+// Metas of blocks never change, so blocks are compared by their IDs.
+func (i *BucketIndex) Equal(o *BucketIndex) bool {
+	if len(i.Blocks) != len(o.Blocks) || len(i.DeletionMarks) != len(o.DeletionMarks) {
+		return false
+	}
+	for j := range i.Blocks {
+		if i.Blocks[j].ULID != o.Blocks[j].ULID {
+			return false
+		}
+	}
+	for j := range i.DeletionMarks {
+		if i.DeletionMarks[j].ID != o.DeletionMarks[j].ID {
+			return false
+		}
+	}
+	return true
+}
+
+// Update adds the given blocks to the index and marks the deleted blocks.
+func (i *BucketIndex) Update(added []Meta, deleted []ulid.ULID) {
+	del := map[ulid.ULID]struct{}{}
+	for _, id := range deleted {
+		del[id] = struct{}{}
+	}
+	for _, m := range added {
+		del[m.ULID] = struct{}{}
+	}
+
+	blocks := i.Blocks[:0]
+	for _, m := range i.Blocks {
+		if _, ok := del[m.ULID]; !ok {
+			blocks = append(blocks, m)
+		}
+	}
+	i.Blocks = append(blocks, added...)
+	sort.Slice(i.Blocks, func(a, b int) bool { return i.Blocks[a].ULID.Compare(i.Blocks[b].ULID) < 0 })
+
+	now := timestamp.FromTime(time.Now())
+	for _, id := range deleted {
+		if !i.Deleted(id) {
+			i.DeletionMarks = append(i.DeletionMarks, DeletionMark{ID: id, DeletionTime: now})
+		}
+	}
+}
+
+// ReadBucketIndex reads the index of the bucket. It returns ErrBucketIndexNotFound if the bucket has no index.
+func ReadBucketIndex(ctx context.Context, logger log.Logger, bkt objstore.BucketReader) (*BucketIndex, error) {
+	rc, err := bkt.Get(ctx, BucketIndexFilename)
+	if err != nil {
+		if bkt.IsObjNotFoundErr(err) {
+			return nil, ErrBucketIndexNotFound
+		}
+		return nil, errors.Wrap(err, "get bucket index")
+	}
+	defer runutil.CloseWithLogOnErr(logger, rc, "bucket index reader")
+
+	gr, err := gzip.NewReader(rc)
+	if err != nil {
+		return nil, errors.Wrap(err, "create gzip reader")
+	}
+	var idx BucketIndex
+	if err := json.NewDecoder(gr).Decode(&idx); err != nil {
+		return nil, errors.Wrap(err, "decode bucket index")
+	}
+	if idx.Version != 1 {
+		return nil, errors.Errorf("unexpected bucket index version %d", idx.Version)
+	}
+	return &idx, nil
+}
+
+// WriteBucketIndex replaces the index of the bucket. The index object is replaced at once, so readers
+// never see partial updates.
+func WriteBucketIndex(ctx context.Context, bkt objstore.Bucket, idx *BucketIndex) error {
+	idx.Version = 1
+
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(gw).Encode(idx); err != nil {
+		return errors.Wrap(err, "encode bucket index")
+	}
+	if err := gw.Close(); err != nil {
+		return errors.Wrap(err, "compress bucket index")
+	}
+	return errors.Wrap(bkt.Upload(ctx, BucketIndexFilename, &buf), "upload bucket index")
+}
+
+// UpdateBucketIndex adds the given blocks to the index and marks the deleted blocks in it. Blocks must be added after
+// they were uploaded and marked before they are deleted. It does nothing if the bucket has no index yet.
+// The index is read and written back without any locking, so it must only be called by the compactor.
+func UpdateBucketIndex(ctx context.Context, logger log.Logger, bkt objstore.Bucket, added []Meta, deleted []ulid.ULID) error {
+	idx, err := ReadBucketIndex(ctx, logger, bkt)
+	if err == ErrBucketIndexNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	idx.Update(added, deleted)
+
+	return WriteBucketIndex(ctx, bkt, idx)
+}
+
+// IterBlocks calls f for all blocks in the bucket. If the bucket has an index that was synced within the given
+// staleness, the blocks are read from it and f is passed their metas. Otherwise the bucket is listed, skipping blocks
+// that are marked as deleted in the index, and f is passed nil metas. A staleness of zero disables the use of the index.
+func IterBlocks(ctx context.Context, logger log.Logger, bkt objstore.BucketReader, maxStaleness time.Duration, f func(id ulid.ULID, m *Meta) error) error {
+	var idx *BucketIndex
+	if maxStaleness > 0 {
+		var err error
+		idx, err = ReadBucketIndex(ctx, logger, bkt)
+		switch {
+		case err == ErrBucketIndexNotFound:
+			level.Debug(logger).Log("msg", "bucket has no index, listing the bucket")
+		case err != nil:
+			level.Warn(logger).Log("msg", "reading bucket index failed, listing the bucket", "err", err)
+		case idx.Stale(maxStaleness):
+			level.Warn(logger).Log("msg", "bucket index is stale, listing the bucket", "syncTime", timestamp.Time(idx.SyncTime))
+		default:
+			for i := range idx.Blocks {
+				if err := f(idx.Blocks[i].ULID, &idx.Blocks[i]); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+
+	return bkt.Iter(ctx, "", func(name string) error {
+		id, ok := IsBlockDir(name)
+		if !ok {
+			return nil
+		}
+		if idx != nil && idx.Deleted(id) {
+			return nil
+		}
+		return f(id, nil)
+	})
+}
+
+// BucketMetas returns the metas of all blocks in the bucket. They are read from the bucket index if it was synced
+// within the given staleness and downloaded otherwise.
+func BucketMetas(ctx context.Context, logger log.Logger, bkt objstore.BucketReader, maxStaleness time.Duration) ([]*Meta, error) {
+	var metas []*Meta
+
+	err := IterBlocks(ctx, logger, bkt, maxStaleness, func(id ulid.ULID, m *Meta) error {
+		if m == nil {
+			meta, err := DownloadMeta(ctx, logger, bkt, id)
+			if err != nil {
+				return err
+			}
+			m = &meta
+		}
+		metas = append(metas, m)
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "retrieve bucket block metas")
+	}
+	return metas, nil
+}
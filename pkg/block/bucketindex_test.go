@@ -0,0 +1,111 @@
+package block
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"path"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/improbable-eng/thanos/pkg/objstore/inmem"
+	"github.com/oklog/ulid"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/prometheus/tsdb"
+)
+
+func TestBucketIndex(t *testing.T) {
+	ctx := context.Background()
+	logger := log.NewNopLogger()
+	bkt := inmem.NewBucket()
+
+	var metas []Meta
+	for i := 0; i < 3; i++ {
+		m := Meta{BlockMeta: tsdb.BlockMeta{ULID: ulid.MustNew(uint64(i+1), nil), Version: 1}}
+		metas = append(metas, m)
+
+		b, err := json.Marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := bkt.Upload(ctx, path.Join(m.ULID.String(), MetaFilename), bytes.NewReader(b)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ids := func(metas []*Meta) (res []ulid.ULID) {
+		for _, m := range metas {
+			res = append(res, m.ULID)
+		}
+		return res
+	}
+
+	if _, err := ReadBucketIndex(ctx, logger, bkt); err != ErrBucketIndexNotFound {
+		t.Fatalf("expected missing index, got %v", err)
+	}
+	// Updates are ignored until the compactor wrote the first index.
+	if err := UpdateBucketIndex(ctx, logger, bkt, nil, []ulid.ULID{metas[0].ULID}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadBucketIndex(ctx, logger, bkt); err != ErrBucketIndexNotFound {
+		t.Fatalf("expected missing index, got %v", err)
+	}
+
+	// Without an index, the bucket is listed.
+	res, err := BucketMetas(ctx, logger, bkt, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := []ulid.ULID{metas[0].ULID, metas[1].ULID, metas[2].ULID}; !reflect.DeepEqual(exp, ids(res)) {
+		t.Fatalf("expected %v, got %v", exp, ids(res))
+	}
+
+	// The index does not know about the third block yet.
+	if err := WriteBucketIndex(ctx, bkt, &BucketIndex{SyncTime: timestamp.FromTime(time.Now()), Blocks: metas[:2]}); err != nil {
+		t.Fatal(err)
+	}
+	if err := UpdateBucketIndex(ctx, logger, bkt, nil, []ulid.ULID{metas[0].ULID}); err != nil {
+		t.Fatal(err)
+	}
+	idx, err := ReadBucketIndex(ctx, logger, bkt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !idx.Deleted(metas[0].ULID) || idx.Deleted(metas[1].ULID) {
+		t.Fatalf("unexpected deletion marks %v", idx.DeletionMarks)
+	}
+
+	res, err = BucketMetas(ctx, logger, bkt, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := []ulid.ULID{metas[1].ULID}; !reflect.DeepEqual(exp, ids(res)) {
+		t.Fatalf("expected %v, got %v", exp, ids(res))
+	}
+
+	// Marked blocks are skipped when the bucket is listed because the index is stale. A disabled index is not read at all.
+	time.Sleep(10 * time.Millisecond)
+	for _, staleness := range []time.Duration{time.Millisecond, 0} {
+		res, err = BucketMetas(ctx, logger, bkt, staleness)
+		if err != nil {
+			t.Fatal(err)
+		}
+		exp := []ulid.ULID{metas[1].ULID, metas[2].ULID}
+		if staleness == 0 {
+			exp = []ulid.ULID{metas[0].ULID, metas[1].ULID, metas[2].ULID}
+		}
+		if !reflect.DeepEqual(exp, ids(res)) {
+			t.Fatalf("staleness %s: expected %v, got %v", staleness, exp, ids(res))
+		}
+	}
+
+	// Added blocks replace existing entries and are kept in order.
+	idx.Update([]Meta{metas[2], metas[1]}, nil)
+	if len(idx.Blocks) != 2 || idx.Blocks[0].ULID != metas[1].ULID || idx.Blocks[1].ULID != metas[2].ULID {
+		t.Fatalf("unexpected blocks %v", idx.Blocks)
+	}
+	if len(idx.DeletionMarks) != 1 {
+		t.Fatalf("unexpected deletion marks %v", idx.DeletionMarks)
+	}
+}
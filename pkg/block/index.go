@@ -402,7 +402,7 @@ func Repair(logger log.Logger, dir string, id ulid.ULID, source SourceType, igno
 	resmeta.Stats = tsdb.BlockStats{} // reset stats
 	resmeta.Thanos.Source = source    // update source
 
-	if err := rewrite(indexr, chunkr, indexw, chunkw, &resmeta, ignoreChkFns); err != nil {
+	if err := rewrite(indexr, chunkr, indexw, chunkw, &resmeta, ignoreChkFns, nil); err != nil {
 		return resid, errors.Wrap(err, "rewrite block")
 	}
 	if err := WriteMetaFile(logger, resdir, &resmeta); err != nil {
@@ -489,20 +489,59 @@ OUTER:
 	return repl, nil
 }
 
+// seriesRewriteFn returns the chunks of the given series that should be written into the rewritten block.
+// Returning no chunks drops the series.
+type seriesRewriteFn func(lset labels.Labels, chks []chunks.Meta) ([]chunks.Meta, error)
+
 // rewrite writes all data from the readers back into the writers while cleaning
-// up mis-ordered and duplicated chunks.
+// up mis-ordered and duplicated chunks. If seriesFn is not nil, it is applied to every series
+// after chunk sanitization.
 func rewrite(
 	indexr tsdb.IndexReader, chunkr tsdb.ChunkReader,
 	indexw tsdb.IndexWriter, chunkw tsdb.ChunkWriter,
 	meta *Meta,
 	ignoreChkFns []ignoreFnType,
+	seriesFn seriesRewriteFn,
+) error {
+	return rewriteAll(indexr, chunkr, meta.MinTime, meta.MaxTime, ignoreChkFns, &rewriteOutput{
+		indexw:   indexw,
+		chunkw:   chunkw,
+		meta:     meta,
+		seriesFn: seriesFn,
+	})
+}
+
+// rewriteOutput is a block written from the series of another block.
+type rewriteOutput struct {
+	indexw   tsdb.IndexWriter
+	chunkw   tsdb.ChunkWriter
+	meta     *Meta
+	seriesFn seriesRewriteFn
+
+	postings *index.MemPostings
+	values   map[string]stringset
+	i        uint64
+}
+
+// rewriteAll writes the data from the readers into all outputs in a single pass over the series. Chunks are
+// sanitized once for the time range [mint, maxt] of the read block, before the seriesFn of each output is applied.
+func rewriteAll(
+	indexr tsdb.IndexReader, chunkr tsdb.ChunkReader,
+	mint, maxt int64,
+	ignoreChkFns []ignoreFnType,
+	outs ...*rewriteOutput,
 ) error {
 	symbols, err := indexr.Symbols()
 	if err != nil {
 		return err
 	}
-	if err := indexw.AddSymbols(symbols); err != nil {
-		return err
+	for _, out := range outs {
+		if err := out.indexw.AddSymbols(symbols); err != nil {
+			return err
+		}
+		// We fully rebuild the postings list index from merged series.
+		out.postings = index.NewMemPostings()
+		out.values = map[string]stringset{}
 	}
 
 	all, err := indexr.Postings(index.AllPostingsKey())
@@ -511,13 +550,6 @@ func rewrite(
 	}
 	all = indexr.SortedPostings(all)
 
-	// We fully rebuild the postings list index from merged series.
-	var (
-		postings = index.NewMemPostings()
-		values   = map[string]stringset{}
-		i        = uint64(0)
-	)
-
 	var lset labels.Labels
 	var chks []chunks.Meta
 
@@ -534,44 +566,69 @@ func rewrite(
 			}
 		}
 
-		chks, err := sanitizeChunkSequence(chks, meta.MinTime, meta.MaxTime, ignoreChkFns)
+		chks, err := sanitizeChunkSequence(chks, mint, maxt, ignoreChkFns)
 		if err != nil {
 			return err
 		}
 
-		if len(chks) == 0 {
-			continue
+		for _, out := range outs {
+			if err := out.addSeries(lset, chks); err != nil {
+				return err
+			}
 		}
-
-		if err := chunkw.WriteChunks(chks...); err != nil {
-			return errors.Wrap(err, "write chunks")
+	}
+	if all.Err() != nil {
+		return errors.Wrap(all.Err(), "iterate series")
+	}
+	for _, out := range outs {
+		if err := writeLabelIndicesAndPostings(out.indexw, out.values, out.postings); err != nil {
+			return err
 		}
-		if err := indexw.AddSeries(i, lset, chks...); err != nil {
-			return errors.Wrap(err, "add series")
+	}
+	return nil
+}
+
+func (out *rewriteOutput) addSeries(lset labels.Labels, chks []chunks.Meta) (err error) {
+	if out.seriesFn != nil {
+		chks, err = out.seriesFn(lset, chks)
+		if err != nil {
+			return errors.Wrapf(err, "rewrite series %s", lset)
 		}
+	}
 
-		meta.Stats.NumChunks += uint64(len(chks))
-		meta.Stats.NumSeries++
+	if len(chks) == 0 {
+		return nil
+	}
 
-		for _, chk := range chks {
-			meta.Stats.NumSamples += uint64(chk.Chunk.NumSamples())
-		}
+	if err := out.chunkw.WriteChunks(chks...); err != nil {
+		return errors.Wrap(err, "write chunks")
+	}
+	if err := out.indexw.AddSeries(out.i, lset, chks...); err != nil {
+		return errors.Wrap(err, "add series")
+	}
 
-		for _, l := range lset {
-			valset, ok := values[l.Name]
-			if !ok {
-				valset = stringset{}
-				values[l.Name] = valset
-			}
-			valset.set(l.Value)
-		}
-		postings.Add(i, lset)
-		i++
+	out.meta.Stats.NumChunks += uint64(len(chks))
+	out.meta.Stats.NumSeries++
+
+	for _, chk := range chks {
+		out.meta.Stats.NumSamples += uint64(chk.Chunk.NumSamples())
 	}
-	if all.Err() != nil {
-		return errors.Wrap(all.Err(), "iterate series")
+
+	for _, l := range lset {
+		valset, ok := out.values[l.Name]
+		if !ok {
+			valset = stringset{}
+			out.values[l.Name] = valset
+		}
+		valset.set(l.Value)
 	}
+	out.postings.Add(out.i, lset)
+	out.i++
+	return nil
+}
 
+// writeLabelIndicesAndPostings writes the label indices and postings lists of all written series.
+func writeLabelIndicesAndPostings(indexw tsdb.IndexWriter, values map[string]stringset, postings *index.MemPostings) error {
 	s := make([]string, 0, 256)
 	for n, v := range values {
 		s = s[:0]
@@ -0,0 +1,306 @@
+package block
+
+import (
+	"io"
+	"math/rand"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/improbable-eng/thanos/pkg/runutil"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/tsdb"
+	"github.com/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/tsdb/chunks"
+	"github.com/prometheus/tsdb/index"
+	"github.com/prometheus/tsdb/labels"
+)
+
+// maxSamplesPerChunk is the number of samples after which merged chunks are cut. It matches the
+// number of samples TSDB head aims for in a single chunk.
+const maxSamplesPerChunk = 120
+
+// MergeOverlapping merges the blocks in the given block directories, which are allowed to overlap in time,
+// into a new block created in dir. Overlapping chunks of the same series are decoded and their samples
+// merged. Only one sample is kept for duplicated timestamps.
+// Only raw blocks can be merged.
+func MergeOverlapping(logger log.Logger, dir string, pool chunkenc.Pool, bdirs ...string) (resid ulid.ULID, err error) {
+	if len(bdirs) == 0 {
+		return resid, errors.New("no blocks to merge")
+	}
+
+	entropy := rand.New(rand.NewSource(time.Now().UnixNano()))
+	resid = ulid.MustNew(ulid.Now(), entropy)
+
+	var (
+		sets    []*seriesCursor
+		symbols = map[string]struct{}{}
+		resmeta = Meta{Version: 1}
+		sources = map[ulid.ULID]struct{}{}
+		closers []io.Closer
+	)
+	defer func() {
+		// Close in reverse order, as blocks wait for their readers to be closed.
+		for i := len(closers) - 1; i >= 0; i-- {
+			runutil.CloseWithErrCapture(logger, &err, closers[i], "merge block reader")
+		}
+	}()
+
+	for i, bdir := range bdirs {
+		meta, err := ReadMetaFile(bdir)
+		if err != nil {
+			return resid, errors.Wrapf(err, "read meta file of %s", bdir)
+		}
+		if meta.Thanos.Downsample.Resolution > 0 {
+			return resid, errors.Errorf("cannot merge downsampled block %s", meta.ULID)
+		}
+		if i == 0 {
+			resmeta.MinTime, resmeta.MaxTime = meta.MinTime, meta.MaxTime
+			resmeta.Thanos = meta.Thanos
+		}
+		if meta.MinTime < resmeta.MinTime {
+			resmeta.MinTime = meta.MinTime
+		}
+		if meta.MaxTime > resmeta.MaxTime {
+			resmeta.MaxTime = meta.MaxTime
+		}
+		if meta.Compaction.Level > resmeta.Compaction.Level {
+			resmeta.Compaction.Level = meta.Compaction.Level
+		}
+		for _, s := range meta.Compaction.Sources {
+			sources[s] = struct{}{}
+		}
+
+		b, err := tsdb.OpenBlock(bdir, pool)
+		if err != nil {
+			return resid, errors.Wrapf(err, "open block %s", bdir)
+		}
+		closers = append(closers, b)
+
+		indexr, err := b.Index()
+		if err != nil {
+			return resid, errors.Wrapf(err, "open index of %s", bdir)
+		}
+		closers = append(closers, indexr)
+
+		chunkr, err := b.Chunks()
+		if err != nil {
+			return resid, errors.Wrapf(err, "open chunks of %s", bdir)
+		}
+		closers = append(closers, chunkr)
+
+		syms, err := indexr.Symbols()
+		if err != nil {
+			return resid, errors.Wrapf(err, "read symbols of %s", bdir)
+		}
+		for s := range syms {
+			symbols[s] = struct{}{}
+		}
+
+		all, err := indexr.Postings(index.AllPostingsKey())
+		if err != nil {
+			return resid, errors.Wrapf(err, "read postings of %s", bdir)
+		}
+		sets = append(sets, &seriesCursor{indexr: indexr, chunkr: chunkr, p: indexr.SortedPostings(all)})
+	}
+
+	resmeta.ULID = resid
+	resmeta.Compaction.Level++
+	for s := range sources {
+		resmeta.Compaction.Sources = append(resmeta.Compaction.Sources, s)
+	}
+	sort.Slice(resmeta.Compaction.Sources, func(i, j int) bool {
+		return resmeta.Compaction.Sources[i].Compare(resmeta.Compaction.Sources[j]) < 0
+	})
+
+	resdir := filepath.Join(dir, resid.String())
+
+	if err := func() (err error) {
+		chunkw, err := chunks.NewWriter(filepath.Join(resdir, ChunksDirname))
+		if err != nil {
+			return errors.Wrap(err, "open chunk writer")
+		}
+		defer runutil.CloseWithErrCapture(logger, &err, chunkw, "merge chunk writer")
+
+		indexw, err := index.NewWriter(filepath.Join(resdir, IndexFilename))
+		if err != nil {
+			return errors.Wrap(err, "open index writer")
+		}
+		defer runutil.CloseWithErrCapture(logger, &err, indexw, "merge index writer")
+
+		return errors.Wrap(merge(sets, symbols, indexw, chunkw, &resmeta), "merge blocks")
+	}(); err != nil {
+		return resid, err
+	}
+	if err := WriteMetaFile(logger, resdir, &resmeta); err != nil {
+		return resid, err
+	}
+	return resid, nil
+}
+
+// seriesCursor iterates over all series of a single block in label order.
+type seriesCursor struct {
+	indexr tsdb.IndexReader
+	chunkr tsdb.ChunkReader
+	p      index.Postings
+
+	lset labels.Labels
+	chks []chunks.Meta
+	ok   bool
+}
+
+func (c *seriesCursor) next() error {
+	c.ok = c.p.Next()
+	if !c.ok {
+		return c.p.Err()
+	}
+	if err := c.indexr.Series(c.p.At(), &c.lset, &c.chks); err != nil {
+		return err
+	}
+	for i, chk := range c.chks {
+		var err error
+		c.chks[i].Chunk, err = c.chunkr.Chunk(chk.Ref)
+		if err != nil {
+			return errors.Wrapf(err, "read chunk of series %s", c.lset)
+		}
+	}
+	return nil
+}
+
+// merge writes all series from the given cursors into the writers. Series with equal label sets
+// are merged into a single one.
+func merge(sets []*seriesCursor, symbols map[string]struct{}, indexw tsdb.IndexWriter, chunkw tsdb.ChunkWriter, meta *Meta) error {
+	if err := indexw.AddSymbols(symbols); err != nil {
+		return err
+	}
+	for _, s := range sets {
+		if err := s.next(); err != nil {
+			return errors.Wrap(err, "iterate series")
+		}
+	}
+
+	var (
+		postings = index.NewMemPostings()
+		values   = map[string]stringset{}
+		i        = uint64(0)
+	)
+	for {
+		// Find the smallest label set among all cursors.
+		var lset labels.Labels
+		for _, s := range sets {
+			if s.ok && (lset == nil || labels.Compare(s.lset, lset) < 0) {
+				lset = s.lset
+			}
+		}
+		if lset == nil {
+			break
+		}
+		lset = append(labels.Labels(nil), lset...)
+
+		var chks []chunks.Meta
+		for _, s := range sets {
+			if !s.ok || labels.Compare(s.lset, lset) != 0 {
+				continue
+			}
+			chks = append(chks, s.chks...)
+			if err := s.next(); err != nil {
+				return errors.Wrap(err, "iterate series")
+			}
+		}
+
+		chks, err := mergeChunks(chks)
+		if err != nil {
+			return errors.Wrapf(err, "merge chunks of series %s", lset)
+		}
+
+		if err := chunkw.WriteChunks(chks...); err != nil {
+			return errors.Wrap(err, "write chunks")
+		}
+		if err := indexw.AddSeries(i, lset, chks...); err != nil {
+			return errors.Wrap(err, "add series")
+		}
+
+		meta.Stats.NumChunks += uint64(len(chks))
+		meta.Stats.NumSeries++
+
+		for _, chk := range chks {
+			meta.Stats.NumSamples += uint64(chk.Chunk.NumSamples())
+		}
+
+		for _, l := range lset {
+			valset, ok := values[l.Name]
+			if !ok {
+				valset = stringset{}
+				values[l.Name] = valset
+			}
+			valset.set(l.Value)
+		}
+		postings.Add(i, lset)
+		i++
+	}
+	return writeLabelIndicesAndPostings(indexw, values, postings)
+}
+
+// mergeChunks returns the given chunks ordered by time. If any of them overlap, all samples
+// are re-encoded into new chunks, dropping samples with duplicated timestamps.
+func mergeChunks(chks []chunks.Meta) ([]chunks.Meta, error) {
+	sort.SliceStable(chks, func(i, j int) bool {
+		return chks[i].MinTime < chks[j].MinTime
+	})
+
+	overlap := false
+	for i := 1; i < len(chks); i++ {
+		if chks[i].MinTime <= chks[i-1].MaxTime {
+			overlap = true
+			break
+		}
+	}
+	if !overlap {
+		return chks, nil
+	}
+
+	type sample struct {
+		t int64
+		v float64
+	}
+	var samples []sample
+	for _, c := range chks {
+		if c.Chunk.Encoding() != chunkenc.EncXOR {
+			return nil, errors.Errorf("cannot merge chunk with encoding %s", c.Chunk.Encoding())
+		}
+		it := c.Chunk.Iterator()
+		for it.Next() {
+			t, v := it.At()
+			samples = append(samples, sample{t: t, v: v})
+		}
+		if it.Err() != nil {
+			return nil, errors.Wrap(it.Err(), "iterate chunk")
+		}
+	}
+	sort.SliceStable(samples, func(i, j int) bool {
+		return samples[i].t < samples[j].t
+	})
+
+	var (
+		res []chunks.Meta
+		app chunkenc.Appender
+		err error
+	)
+	for i, s := range samples {
+		if i > 0 && s.t == samples[i-1].t {
+			continue
+		}
+		if app == nil || res[len(res)-1].Chunk.NumSamples() >= maxSamplesPerChunk {
+			c := chunkenc.NewXORChunk()
+			if app, err = c.Appender(); err != nil {
+				return nil, err
+			}
+			res = append(res, chunks.Meta{MinTime: s.t, Chunk: c})
+		}
+		app.Append(s.t, s.v)
+		res[len(res)-1].MaxTime = s.t
+	}
+	return res, nil
+}
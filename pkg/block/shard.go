@@ -0,0 +1,39 @@
+package block
+
+import (
+	"fmt"
+
+	"github.com/prometheus/tsdb/labels"
+)
+
+// ShardOf returns the shard of the series with the given labels out of count shards.
+// External labels are not part of the series labels of a block and thus do not affect the shard.
+func ShardOf(lset labels.Labels, count uint64) uint64 {
+	return lset.Hash() % count
+}
+
+// Sharded returns whether the block contains only a shard of the series of its origin.
+func (s ThanosShardMeta) Sharded() bool {
+	return s.Count > 0
+}
+
+// Contains returns whether a series with the given labels belongs to the shard.
+func (s ThanosShardMeta) Contains(lset labels.Labels) bool {
+	return !s.Sharded() || ShardOf(lset, s.Count) == s.Index
+}
+
+// Covers returns whether all series of shard o belong to the shard. This is the case if o is a subdivision
+// of the shard, i.e. its count is a multiple of the count of the shard and its index is congruent to it.
+func (s ThanosShardMeta) Covers(o ThanosShardMeta) bool {
+	if !s.Sharded() {
+		return true
+	}
+	return o.Sharded() && o.Count%s.Count == 0 && o.Index%s.Count == s.Index
+}
+
+func (s ThanosShardMeta) String() string {
+	if !s.Sharded() {
+		return "unsharded"
+	}
+	return fmt.Sprintf("%d/%d", s.Index, s.Count)
+}
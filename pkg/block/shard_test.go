@@ -0,0 +1,40 @@
+package block
+
+import (
+	"testing"
+
+	"github.com/prometheus/tsdb/labels"
+)
+
+func TestThanosShardMeta_Covers(t *testing.T) {
+	for _, tcase := range []struct {
+		a, b ThanosShardMeta
+		exp  bool
+	}{
+		{a: ThanosShardMeta{}, b: ThanosShardMeta{}, exp: true},
+		{a: ThanosShardMeta{}, b: ThanosShardMeta{Index: 1, Count: 4}, exp: true},
+		{a: ThanosShardMeta{Index: 1, Count: 4}, b: ThanosShardMeta{}, exp: false},
+		{a: ThanosShardMeta{Index: 1, Count: 4}, b: ThanosShardMeta{Index: 1, Count: 4}, exp: true},
+		{a: ThanosShardMeta{Index: 1, Count: 4}, b: ThanosShardMeta{Index: 2, Count: 4}, exp: false},
+		// Series of shard 1/2 hash to 1 or 3 modulo 4.
+		{a: ThanosShardMeta{Index: 1, Count: 2}, b: ThanosShardMeta{Index: 3, Count: 4}, exp: true},
+		{a: ThanosShardMeta{Index: 3, Count: 4}, b: ThanosShardMeta{Index: 1, Count: 2}, exp: false},
+		{a: ThanosShardMeta{Index: 1, Count: 2}, b: ThanosShardMeta{Index: 2, Count: 4}, exp: false},
+		{a: ThanosShardMeta{Index: 1, Count: 3}, b: ThanosShardMeta{Index: 1, Count: 4}, exp: false},
+	} {
+		if res := tcase.a.Covers(tcase.b); res != tcase.exp {
+			t.Errorf("expected %s covering %s to be %v, got %v", tcase.a, tcase.b, tcase.exp, res)
+		}
+	}
+
+	lset := labels.FromStrings("a", "1")
+	if !(ThanosShardMeta{}).Contains(lset) {
+		t.Errorf("unsharded block must contain all series")
+	}
+	if !(ThanosShardMeta{Index: ShardOf(lset, 4), Count: 4}).Contains(lset) {
+		t.Errorf("shard must contain series")
+	}
+	if (ThanosShardMeta{Index: (ShardOf(lset, 4) + 1) % 4, Count: 4}).Contains(lset) {
+		t.Errorf("shard must not contain series")
+	}
+}
@@ -0,0 +1,321 @@
+package block
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/improbable-eng/thanos/pkg/runutil"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/tsdb"
+	"github.com/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/tsdb/chunks"
+	"github.com/prometheus/tsdb/index"
+	"github.com/prometheus/tsdb/labels"
+)
+
+// splitOutput describes a single block created while splitting a block.
+type splitOutput struct {
+	meta     Meta
+	seriesFn seriesRewriteFn
+}
+
+// SplitByTime opens the block with given id in dir and splits it into new blocks covering
+// consecutive time ranges of the given duration, aligned to multiples of it.
+// Chunks crossing range boundaries are re-encoded. Only raw blocks can be split by time.
+// It returns the IDs of all non-empty created blocks.
+func SplitByTime(logger log.Logger, dir string, id ulid.ULID, pool chunkenc.Pool, source SourceType, rng time.Duration) ([]ulid.ULID, error) {
+	meta, err := ReadMetaFile(filepath.Join(dir, id.String()))
+	if err != nil {
+		return nil, errors.Wrap(err, "read meta file")
+	}
+	if meta.Thanos.Downsample.Resolution > 0 {
+		return nil, errors.New("cannot split downsampled block by time")
+	}
+	step := int64(rng / time.Millisecond)
+	if step <= 0 {
+		return nil, errors.Errorf("invalid split range %s", rng)
+	}
+
+	var (
+		outputs []splitOutput
+		entropy = rand.New(rand.NewSource(time.Now().UnixNano()))
+	)
+	for start := meta.MinTime - meta.MinTime%step; start < meta.MaxTime; start += step {
+		mint, maxt := start, start+step
+		if mint < meta.MinTime {
+			mint = meta.MinTime
+		}
+		if maxt > meta.MaxTime {
+			maxt = meta.MaxTime
+		}
+
+		out := newSplitMeta(*meta, entropy, source)
+		out.MinTime, out.MaxTime = mint, maxt
+
+		outputs = append(outputs, splitOutput{
+			meta: out,
+			seriesFn: func(_ labels.Labels, chks []chunks.Meta) ([]chunks.Meta, error) {
+				return trimChunks(chks, mint, maxt)
+			},
+		})
+	}
+	return split(logger, dir, id, pool, outputs)
+}
+
+// SplitByLabel opens the block with given id in dir and splits it into one new block per value
+// of the given label name. If promote is true, the label value is added to the external labels of the new blocks,
+// so that they do not overlap with each other. Otherwise all new blocks keep the external labels of the block and
+// overlap in time. Series without the label are written into a block with the external labels of the block.
+// It returns the IDs of all non-empty created blocks.
+func SplitByLabel(logger log.Logger, dir string, id ulid.ULID, pool chunkenc.Pool, source SourceType, name string, promote bool) ([]ulid.ULID, error) {
+	bdir := filepath.Join(dir, id.String())
+
+	meta, err := ReadMetaFile(bdir)
+	if err != nil {
+		return nil, errors.Wrap(err, "read meta file")
+	}
+	if _, ok := meta.Thanos.Labels[name]; ok {
+		return nil, errors.Errorf("label %s is already an external label of the block", name)
+	}
+
+	values, err := labelValues(logger, bdir, pool, name)
+	if err != nil {
+		return nil, err
+	}
+	// Collect all series not having the label at all.
+	values = append(values, "")
+
+	var (
+		outputs []splitOutput
+		entropy = rand.New(rand.NewSource(time.Now().UnixNano()))
+	)
+	for _, v := range values {
+		value := v
+
+		out := newSplitMeta(*meta, entropy, source)
+		if promote && value != "" {
+			out.Thanos.Labels = make(map[string]string, len(meta.Thanos.Labels)+1)
+			for k, v := range meta.Thanos.Labels {
+				out.Thanos.Labels[k] = v
+			}
+			out.Thanos.Labels[name] = value
+		}
+
+		outputs = append(outputs, splitOutput{
+			meta: out,
+			seriesFn: func(lset labels.Labels, chks []chunks.Meta) ([]chunks.Meta, error) {
+				if lset.Get(name) != value {
+					return nil, nil
+				}
+				return chks, nil
+			},
+		})
+	}
+	return split(logger, dir, id, pool, outputs)
+}
+
+// SplitByShard opens the block with given id in dir and partitions its series into the given number of
+// new blocks by the hash of their labels. Unlike other splits, the new blocks keep the compaction sources
+// and level of the block, as all of them together replace it. The block must not be sharded already.
+// It returns the IDs of all non-empty created blocks.
+func SplitByShard(logger log.Logger, dir string, id ulid.ULID, pool chunkenc.Pool, source SourceType, count uint64) ([]ulid.ULID, error) {
+	meta, err := ReadMetaFile(filepath.Join(dir, id.String()))
+	if err != nil {
+		return nil, errors.Wrap(err, "read meta file")
+	}
+	if meta.Thanos.Shard.Sharded() {
+		return nil, errors.Errorf("block is already sharded as %s", meta.Thanos.Shard)
+	}
+	if count < 2 {
+		return nil, errors.Errorf("invalid shard count %d", count)
+	}
+
+	var (
+		outputs []splitOutput
+		entropy = rand.New(rand.NewSource(time.Now().UnixNano()))
+	)
+	for i := uint64(0); i < count; i++ {
+		out := *meta
+		out.ULID = ulid.MustNew(ulid.Now(), entropy)
+		out.Stats = tsdb.BlockStats{}
+		out.Thanos.Source = source
+		out.Thanos.Shard = ThanosShardMeta{Index: i, Count: count}
+
+		shard := out.Thanos.Shard
+		outputs = append(outputs, splitOutput{
+			meta: out,
+			seriesFn: func(lset labels.Labels, chks []chunks.Meta) ([]chunks.Meta, error) {
+				if !shard.Contains(lset) {
+					return nil, nil
+				}
+				return chks, nil
+			},
+		})
+	}
+	return split(logger, dir, id, pool, outputs)
+}
+
+// newSplitMeta returns a meta for a new block created from the given one.
+// Every split block becomes a compaction source of its own, so garbage collection does not consider
+// sibling blocks as duplicates of each other. The sources of the given block are kept as well to record
+// the lineage of the data, e.g. for the reconciliation of uploaded blocks.
+func newSplitMeta(meta Meta, entropy *rand.Rand, source SourceType) Meta {
+	id := ulid.MustNew(ulid.Now(), entropy)
+
+	meta.ULID = id
+	meta.Stats = tsdb.BlockStats{}
+	meta.Compaction.Sources = append([]ulid.ULID{id}, meta.Compaction.Sources...)
+	meta.Thanos.Source = source
+	return meta
+}
+
+func labelValues(logger log.Logger, bdir string, pool chunkenc.Pool, name string) (_ []string, err error) {
+	b, err := tsdb.OpenBlock(bdir, pool)
+	if err != nil {
+		return nil, errors.Wrap(err, "open block")
+	}
+	defer runutil.CloseWithErrCapture(logger, &err, b, "split block reader")
+
+	indexr, err := b.Index()
+	if err != nil {
+		return nil, errors.Wrap(err, "open index")
+	}
+	defer runutil.CloseWithErrCapture(logger, &err, indexr, "split index reader")
+
+	tpls, err := indexr.LabelValues(name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get label values of %s", name)
+	}
+	vals := make([]string, 0, tpls.Len())
+	for i := 0; i < tpls.Len(); i++ {
+		v, err := tpls.At(i)
+		if err != nil {
+			return nil, errors.Wrap(err, "get label value")
+		}
+		vals = append(vals, v[0])
+	}
+	return vals, nil
+}
+
+// split writes a new block for each of the given outputs into dir in a single pass over the block with the given id.
+// Outputs that end up without any series are removed.
+func split(logger log.Logger, dir string, id ulid.ULID, pool chunkenc.Pool, outputs []splitOutput) (ids []ulid.ULID, err error) {
+	b, err := tsdb.OpenBlock(filepath.Join(dir, id.String()), pool)
+	if err != nil {
+		return nil, errors.Wrap(err, "open block")
+	}
+	defer runutil.CloseWithErrCapture(logger, &err, b, "split block reader")
+
+	indexr, err := b.Index()
+	if err != nil {
+		return nil, errors.Wrap(err, "open index")
+	}
+	defer runutil.CloseWithErrCapture(logger, &err, indexr, "split index reader")
+
+	chunkr, err := b.Chunks()
+	if err != nil {
+		return nil, errors.Wrap(err, "open chunks")
+	}
+	defer runutil.CloseWithErrCapture(logger, &err, chunkr, "split chunk reader")
+
+	if err := writeSplitBlocks(logger, indexr, chunkr, dir, b.Meta(), outputs); err != nil {
+		return nil, err
+	}
+
+	for _, out := range outputs {
+		resdir := filepath.Join(dir, out.meta.ULID.String())
+
+		if out.meta.Stats.NumSeries == 0 {
+			if err := os.RemoveAll(resdir); err != nil {
+				return nil, errors.Wrapf(err, "remove empty split block %s", out.meta.ULID)
+			}
+			continue
+		}
+		if err := WriteMetaFile(logger, resdir, &out.meta); err != nil {
+			return nil, errors.Wrapf(err, "write meta of split block %s", out.meta.ULID)
+		}
+		ids = append(ids, out.meta.ULID)
+	}
+	return ids, nil
+}
+
+// writeSplitBlocks writes the index and chunks of all outputs. The stats of their metas are updated accordingly.
+func writeSplitBlocks(logger log.Logger, indexr tsdb.IndexReader, chunkr tsdb.ChunkReader, dir string, meta tsdb.BlockMeta, outputs []splitOutput) (err error) {
+	outs := make([]*rewriteOutput, 0, len(outputs))
+	for i := range outputs {
+		resdir := filepath.Join(dir, outputs[i].meta.ULID.String())
+
+		var (
+			chunkw tsdb.ChunkWriter
+			indexw tsdb.IndexWriter
+		)
+		chunkw, err = chunks.NewWriter(filepath.Join(resdir, ChunksDirname))
+		if err != nil {
+			return errors.Wrapf(err, "open chunk writer of split block %s", outputs[i].meta.ULID)
+		}
+		defer runutil.CloseWithErrCapture(logger, &err, chunkw, "split chunk writer")
+
+		indexw, err = index.NewWriter(filepath.Join(resdir, IndexFilename))
+		if err != nil {
+			return errors.Wrapf(err, "open index writer of split block %s", outputs[i].meta.ULID)
+		}
+		defer runutil.CloseWithErrCapture(logger, &err, indexw, "split index writer")
+
+		outs = append(outs, &rewriteOutput{
+			indexw:   indexw,
+			chunkw:   chunkw,
+			meta:     &outputs[i].meta,
+			seriesFn: outputs[i].seriesFn,
+		})
+	}
+	return errors.Wrap(rewriteAll(indexr, chunkr, meta.MinTime, meta.MaxTime, nil, outs...), "rewrite block")
+}
+
+// trimChunks returns chunks with samples within [mint, maxt) only. Chunks partially
+// outside of the range are re-encoded.
+func trimChunks(chks []chunks.Meta, mint, maxt int64) ([]chunks.Meta, error) {
+	var res []chunks.Meta
+	for _, c := range chks {
+		if c.MaxTime < mint || c.MinTime >= maxt {
+			continue
+		}
+		if c.MinTime >= mint && c.MaxTime < maxt {
+			res = append(res, c)
+			continue
+		}
+		if c.Chunk.Encoding() != chunkenc.EncXOR {
+			return nil, errors.Errorf("cannot trim chunk with encoding %s", c.Chunk.Encoding())
+		}
+
+		nc := chunkenc.NewXORChunk()
+		app, err := nc.Appender()
+		if err != nil {
+			return nil, err
+		}
+		tc := chunks.Meta{MinTime: -1, Chunk: nc}
+
+		it := c.Chunk.Iterator()
+		for it.Next() {
+			t, v := it.At()
+			if t < mint || t >= maxt {
+				continue
+			}
+			if tc.MinTime == -1 {
+				tc.MinTime = t
+			}
+			tc.MaxTime = t
+			app.Append(t, v)
+		}
+		if it.Err() != nil {
+			return nil, errors.Wrap(it.Err(), "iterate chunk")
+		}
+		if nc.NumSamples() > 0 {
+			res = append(res, tc)
+		}
+	}
+	return res, nil
+}
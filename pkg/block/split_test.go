@@ -0,0 +1,191 @@
+package block
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/oklog/ulid"
+	"github.com/prometheus/tsdb"
+	"github.com/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/tsdb/chunks"
+	"github.com/prometheus/tsdb/labels"
+)
+
+func TestTrimChunks(t *testing.T) {
+	newChunk := func(mint, maxt int64) chunks.Meta {
+		c := chunkenc.NewXORChunk()
+		app, err := c.Appender()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for ts := mint; ts <= maxt; ts += 10 {
+			app.Append(ts, float64(ts))
+		}
+		return chunks.Meta{MinTime: mint, MaxTime: maxt, Chunk: c}
+	}
+	chks := []chunks.Meta{newChunk(0, 90), newChunk(100, 190), newChunk(200, 290)}
+
+	for _, tc := range []struct {
+		name       string
+		mint, maxt int64
+		exp        [][2]int64
+		expSamples int
+	}{
+		{name: "all", mint: 0, maxt: 300, exp: [][2]int64{{0, 90}, {100, 190}, {200, 290}}, expSamples: 30},
+		{name: "none", mint: 300, maxt: 400},
+		{name: "aligned", mint: 100, maxt: 200, exp: [][2]int64{{100, 190}}, expSamples: 10},
+		{name: "straddling", mint: 50, maxt: 150, exp: [][2]int64{{50, 90}, {100, 140}}, expSamples: 10},
+		{name: "within single chunk", mint: 215, maxt: 245, exp: [][2]int64{{220, 240}}, expSamples: 3},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := trimChunks(chks, tc.mint, tc.maxt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(res) != len(tc.exp) {
+				t.Fatalf("expected %d chunks, got %d", len(tc.exp), len(res))
+			}
+
+			samples := 0
+			for i, c := range res {
+				if c.MinTime != tc.exp[i][0] || c.MaxTime != tc.exp[i][1] {
+					t.Fatalf("chunk %d: expected range %v, got [%d %d]", i, tc.exp[i], c.MinTime, c.MaxTime)
+				}
+				it := c.Chunk.Iterator()
+				for it.Next() {
+					if ts, _ := it.At(); ts < tc.mint || ts >= tc.maxt {
+						t.Fatalf("chunk %d: sample %d outside of [%d, %d)", i, ts, tc.mint, tc.maxt)
+					}
+					samples++
+				}
+			}
+			if samples != tc.expSamples {
+				t.Fatalf("expected %d samples, got %d", tc.expSamples, samples)
+			}
+		})
+	}
+}
+
+func TestSplit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test-split")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	logger := log.NewNopLogger()
+	id := createTestBlock(t, dir, []labels.Labels{
+		labels.FromStrings("a", "1", "tenant", "x"),
+		labels.FromStrings("a", "2", "tenant", "y"),
+		labels.FromStrings("a", "3"),
+	}, 0, 100)
+
+	// Splitting by an external label would not separate anything.
+	if _, err := SplitByLabel(logger, dir, id, chunkenc.NewPool(), TestSource, "ext", true); err == nil {
+		t.Fatal("expected error splitting by external label")
+	}
+
+	ids, err := SplitByLabel(logger, dir, id, chunkenc.NewPool(), TestSource, "tenant", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tenants := map[string]bool{}
+	for _, sid := range ids {
+		m := readSplitMeta(t, dir, sid, id)
+		if m.Stats.NumSeries != 1 || m.Stats.NumSamples != 100 {
+			t.Fatalf("block %s: expected 1 series with 100 samples, got %d with %d", sid, m.Stats.NumSeries, m.Stats.NumSamples)
+		}
+		tenants[m.Thanos.Labels["tenant"]] = true
+	}
+	// Series without the label keep the external labels of the block only.
+	if len(ids) != 3 || !tenants["x"] || !tenants["y"] || !tenants[""] {
+		t.Fatalf("unexpected tenants of split blocks: %v", tenants)
+	}
+
+	// Without promotion all blocks keep the external labels of the source block.
+	ids, err = SplitByLabel(logger, dir, id, chunkenc.NewPool(), TestSource, "tenant", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 3 {
+		t.Fatalf("expected 3 blocks, got %d", len(ids))
+	}
+	for _, sid := range ids {
+		m := readSplitMeta(t, dir, sid, id)
+		if exp := map[string]string{"ext": "1"}; !reflect.DeepEqual(exp, m.Thanos.Labels) {
+			t.Fatalf("block %s: expected external labels %v, got %v", sid, exp, m.Thanos.Labels)
+		}
+	}
+
+	ids, err = SplitByTime(logger, dir, id, chunkenc.NewPool(), TestSource, 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 2 {
+		t.Fatalf("expected 2 blocks, got %d", len(ids))
+	}
+	for i, sid := range ids {
+		m := readSplitMeta(t, dir, sid, id)
+		if m.MinTime != int64(i)*50 || m.MaxTime != int64(i+1)*50 {
+			t.Fatalf("block %s: unexpected time range [%d, %d)", sid, m.MinTime, m.MaxTime)
+		}
+		if m.Stats.NumSeries != 3 || m.Stats.NumSamples != 150 {
+			t.Fatalf("block %s: expected 3 series with 150 samples, got %d with %d", sid, m.Stats.NumSeries, m.Stats.NumSamples)
+		}
+	}
+}
+
+// readSplitMeta reads the meta of the split block and checks that it is a compaction source of its own and keeps
+// the sources of the block it was split from.
+func readSplitMeta(t *testing.T, dir string, id, parent ulid.ULID) *Meta {
+	m, err := ReadMetaFile(filepath.Join(dir, id.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := []ulid.ULID{id, parent}; !reflect.DeepEqual(exp, m.Compaction.Sources) {
+		t.Fatalf("block %s: expected sources %v, got %v", id, exp, m.Compaction.Sources)
+	}
+	return m
+}
+
+// createTestBlock writes a block with a sample every millisecond in [mint, maxt) for each series.
+func createTestBlock(t *testing.T, dir string, series []labels.Labels, mint, maxt int64) ulid.ULID {
+	h, err := tsdb.NewHead(nil, nil, tsdb.NopWAL(), 10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	app := h.Appender()
+	for ts := mint; ts < maxt; ts++ {
+		for _, lset := range series {
+			if _, err := app.Add(lset, ts, float64(ts)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := app.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := tsdb.NewLeveledCompactor(nil, log.NewNopLogger(), []int64{maxt - mint}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := c.Write(dir, h, mint, maxt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := InjectThanosMeta(log.NewNopLogger(), filepath.Join(dir, id.String()), ThanosMeta{
+		Labels: map[string]string{"ext": "1"},
+		Source: TestSource,
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
@@ -16,9 +16,11 @@ import (
 	"github.com/improbable-eng/thanos/pkg/block"
 	"github.com/improbable-eng/thanos/pkg/compact/downsample"
 	"github.com/improbable-eng/thanos/pkg/objstore"
+	"github.com/improbable-eng/thanos/pkg/objstore/audit"
 	"github.com/oklog/ulid"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/pkg/timestamp"
 	"github.com/prometheus/tsdb"
 	"github.com/prometheus/tsdb/labels"
 )
@@ -31,6 +33,9 @@ const (
 	ResolutionLevel1h  = ResolutionLevel(downsample.ResLevel2)
 )
 
+// bucketIndexRefreshInterval is the maximum age of an unchanged bucket index before it is rewritten by a sync.
+const bucketIndexRefreshInterval = 15 * time.Minute
+
 // Syncer syncronizes block metas from a bucket into a local directory.
 // It sorts them into compaction groups based on equal label sets.
 type Syncer struct {
@@ -41,6 +46,11 @@ type Syncer struct {
 	mtx       sync.Mutex
 	blocks    map[ulid.ULID]*block.Meta
 	metrics   *syncerMetrics
+
+	// metas holds the metas of all blocks in the bucket, including those that are too fresh to be compacted.
+	metas map[ulid.ULID]*block.Meta
+	// marked holds blocks that are marked as deleted in the bucket index but are still present in the bucket.
+	marked map[ulid.ULID]struct{}
 }
 
 type syncerMetrics struct {
@@ -131,6 +141,8 @@ func NewSyncer(logger log.Logger, reg prometheus.Registerer, bkt objstore.Bucket
 		reg:       reg,
 		syncDelay: syncDelay,
 		blocks:    map[ulid.ULID]*block.Meta{},
+		metas:     map[ulid.ULID]*block.Meta{},
+		marked:    map[ulid.ULID]struct{}{},
 		bkt:       bkt,
 		metrics:   newSyncerMetrics(reg),
 	}, nil
@@ -155,27 +167,55 @@ func (c *Syncer) SyncMetas(ctx context.Context) error {
 }
 
 func (c *Syncer) syncMetas(ctx context.Context) error {
+	// The bucket index provides metas of blocks that are not cached yet, e.g. after a restart or compaction,
+	// without downloading them.
+	idx, err := block.ReadBucketIndex(ctx, c.logger, c.bkt)
+	if err != nil {
+		if err != block.ErrBucketIndexNotFound {
+			level.Warn(c.logger).Log("msg", "reading bucket index failed, rebuilding it", "err", err)
+		}
+		idx = &block.BucketIndex{}
+	}
+	indexed := make(map[ulid.ULID]*block.Meta, len(idx.Blocks))
+	for i := range idx.Blocks {
+		indexed[idx.Blocks[i].ULID] = &idx.Blocks[i]
+	}
+
+	syncTime := time.Now()
 	// Read back all block metas so we can detect deleted blocks.
-	remote := map[ulid.ULID]struct{}{}
+	remote := map[ulid.ULID]*block.Meta{}
+	marked := map[ulid.ULID]struct{}{}
 
-	err := c.bkt.Iter(ctx, "", func(name string) error {
+	err = c.bkt.Iter(ctx, "", func(name string) error {
 		id, ok := block.IsBlockDir(name)
 		if !ok {
 			return nil
 		}
 
-		remote[id] = struct{}{}
+		// Blocks marked as deleted are leftovers of deletions that failed or were interrupted.
+		if idx.Deleted(id) {
+			marked[id] = struct{}{}
+			return nil
+		}
 
 		// Check if we already have this block cached locally.
-		if _, ok := c.blocks[id]; ok {
-			return nil
+		meta, ok := c.metas[id]
+		if !ok {
+			meta, ok = indexed[id]
 		}
+		if !ok {
+			level.Debug(c.logger).Log("msg", "download meta", "block", id)
 
-		level.Debug(c.logger).Log("msg", "download meta", "block", id)
+			m, err := block.DownloadMeta(ctx, c.logger, c.bkt, id)
+			if err != nil {
+				return errors.Wrapf(err, "downloading meta.json for %s", id)
+			}
+			meta = &m
+		}
+		remote[id] = meta
 
-		meta, err := block.DownloadMeta(ctx, c.logger, c.bkt, id)
-		if err != nil {
-			return errors.Wrapf(err, "downloading meta.json for %s", id)
+		if _, ok := c.blocks[id]; ok {
+			return nil
 		}
 
 		// ULIDs contain a millisecond timestamp. We do not consider blocks that have been created too recently to
@@ -193,8 +233,7 @@ func (c *Syncer) syncMetas(ctx context.Context) error {
 			return nil
 		}
 
-		remote[id] = struct{}{}
-		c.blocks[id] = &meta
+		c.blocks[id] = meta
 
 		return nil
 	})
@@ -208,18 +247,42 @@ func (c *Syncer) syncMetas(ctx context.Context) error {
 			delete(c.blocks, id)
 		}
 	}
-
+	c.metas = remote
+	c.marked = marked
+
+	// Replace the bucket index with the result of the listing. Deletion marks are kept as long as
+	// their blocks are present in the bucket. An unchanged index is only rewritten to refresh its sync time
+	// once it is older than the refresh interval.
+	newIdx := &block.BucketIndex{SyncTime: timestamp.FromTime(syncTime)}
+	for _, m := range remote {
+		newIdx.Blocks = append(newIdx.Blocks, *m)
+	}
+	sort.Slice(newIdx.Blocks, func(i, j int) bool { return newIdx.Blocks[i].ULID.Compare(newIdx.Blocks[j].ULID) < 0 })
+	for _, m := range idx.DeletionMarks {
+		if _, ok := marked[m.ID]; ok {
+			newIdx.DeletionMarks = append(newIdx.DeletionMarks, m)
+		}
+	}
+	if newIdx.Equal(idx) && !idx.Stale(bucketIndexRefreshInterval) {
+		return nil
+	}
+	if err := block.WriteBucketIndex(ctx, c.bkt, newIdx); err != nil {
+		return retry(errors.Wrap(err, "write bucket index"))
+	}
 	return nil
 }
 
 // GroupKey returns a unique identifier for the group the block belongs to. It considers
-// the downsampling resolution and the block's labels.
+// the downsampling resolution, the block's labels and its series shard.
 func GroupKey(meta block.Meta) string {
-	return groupKey(meta.Thanos.Downsample.Resolution, labels.FromMap(meta.Thanos.Labels))
+	return groupKey(meta.Thanos.Downsample.Resolution, labels.FromMap(meta.Thanos.Labels), meta.Thanos.Shard)
 }
 
-func groupKey(res int64, lbls labels.Labels) string {
-	return fmt.Sprintf("%d@%s", res, lbls)
+func groupKey(res int64, lbls labels.Labels, shard block.ThanosShardMeta) string {
+	if !shard.Sharded() {
+		return fmt.Sprintf("%d@%s", res, lbls)
+	}
+	return fmt.Sprintf("%d@%s@shard-%d-of-%d", res, lbls, shard.Index, shard.Count)
 }
 
 // Groups returns the compaction groups for all blocks currently known to the syncer.
@@ -237,9 +300,11 @@ func (c *Syncer) Groups() (res []*Group, err error) {
 				c.bkt,
 				labels.FromMap(m.Thanos.Labels),
 				m.Thanos.Downsample.Resolution,
+				m.Thanos.Shard,
 				c.metrics.compactions.WithLabelValues(GroupKey(*m)),
 				c.metrics.compactionFailures.WithLabelValues(GroupKey(*m)),
 				c.metrics.garbageCollectedBlocks,
+				true,
 			)
 			if err != nil {
 				return nil, errors.Wrap(err, "create compaction group")
@@ -286,7 +351,9 @@ func (c *Syncer) GarbageCollect(ctx context.Context) error {
 func (c *Syncer) GarbageBlocks(resolution int64) (ids []ulid.ULID, err error) {
 	// Map each block to its highest priority parent. Initial blocks have themselves
 	// in their source section, i.e. are their own parent.
-	parents := map[ulid.ULID]ulid.ULID{}
+	// Blocks of different series shards sharing a source are no duplicates of each other, so parents
+	// are determined for each shard of a source separately.
+	parents := map[gcKey]ulid.ULID{}
 
 	for id, meta := range c.blocks {
 
@@ -297,10 +364,12 @@ func (c *Syncer) GarbageBlocks(resolution int64) (ids []ulid.ULID, err error) {
 
 		// For each source block we contain, check whether we are the highest priority parent block.
 		for _, sid := range meta.Compaction.Sources {
-			pid, ok := parents[sid]
+			key := gcKey{source: sid, shard: meta.Thanos.Shard}
+
+			pid, ok := parents[key]
 			// No parents for the source block so far.
 			if !ok {
-				parents[sid] = id
+				parents[key] = id
 				continue
 			}
 			pmeta, ok := c.blocks[pid]
@@ -317,7 +386,7 @@ func (c *Syncer) GarbageBlocks(resolution int64) (ids []ulid.ULID, err error) {
 			level, plevel := meta.Compaction.Level, pmeta.Compaction.Level
 
 			if level > plevel || (level == plevel && id.Compare(pid) > 0) {
-				parents[sid] = id
+				parents[key] = id
 			}
 		}
 	}
@@ -328,13 +397,16 @@ func (c *Syncer) GarbageBlocks(resolution int64) (ids []ulid.ULID, err error) {
 	for _, pid := range parents {
 		topParents[pid] = struct{}{}
 	}
+	shards := c.shardCoverage(parents)
 
 	for id, meta := range c.blocks {
 		// Skip any block that has a different resolution.
 		if meta.Thanos.Downsample.Resolution != resolution {
 			continue
 		}
-		if _, ok := topParents[id]; ok {
+		// Unsharded blocks are garbage as well once all their sources are covered by a higher level
+		// set of shards, e.g. if the compactor was interrupted after uploading the shards of a compaction.
+		if _, ok := topParents[id]; ok && !shards.covers(meta) {
 			continue
 		}
 
@@ -343,11 +415,83 @@ func (c *Syncer) GarbageBlocks(resolution int64) (ids []ulid.ULID, err error) {
 	return ids, nil
 }
 
+// gcKey identifies a shard of a source block.
+type gcKey struct {
+	source ulid.ULID
+	shard  block.ThanosShardMeta
+}
+
+// shardCoverage holds for each source block and shard count the number of shards and their
+// lowest compaction level among the highest priority parents of the source.
+type shardCoverage map[ulid.ULID]map[uint64]*shardSetLevel
+
+type shardSetLevel struct {
+	shards   uint64
+	minLevel int
+}
+
+func (c *Syncer) shardCoverage(parents map[gcKey]ulid.ULID) shardCoverage {
+	res := shardCoverage{}
+	for key, pid := range parents {
+		if !key.shard.Sharded() {
+			continue
+		}
+		counts, ok := res[key.source]
+		if !ok {
+			counts = map[uint64]*shardSetLevel{}
+			res[key.source] = counts
+		}
+		set, ok := counts[key.shard.Count]
+		if !ok {
+			set = &shardSetLevel{minLevel: c.blocks[pid].Compaction.Level}
+			counts[key.shard.Count] = set
+		}
+		set.shards++
+		if l := c.blocks[pid].Compaction.Level; l < set.minLevel {
+			set.minLevel = l
+		}
+	}
+	return res
+}
+
+// covers returns whether all sources of the unsharded block are covered by a complete set of shards
+// of a higher compaction level.
+func (s shardCoverage) covers(meta *block.Meta) bool {
+	if meta.Thanos.Shard.Sharded() {
+		return false
+	}
+	for _, sid := range meta.Compaction.Sources {
+		covered := false
+		for count, set := range s[sid] {
+			if set.shards == count && set.minLevel > meta.Compaction.Level {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			return false
+		}
+	}
+	return len(meta.Compaction.Sources) > 0
+}
+
 func (c *Syncer) garbageCollect(ctx context.Context, resolution int64) error {
 	garbageIds, err := c.GarbageBlocks(resolution)
 	if err != nil {
 		return err
 	}
+	if len(garbageIds) > 0 {
+		// Mark the blocks in the bucket index first, so that readers stop using them before they are incomplete.
+		if err := block.UpdateBucketIndex(ctx, c.logger, c.bkt, nil, garbageIds); err != nil {
+			return retry(errors.Wrap(err, "mark blocks as deleted in bucket index"))
+		}
+	}
+	// Retry deletions of marked blocks that failed or were interrupted before. They are resolution independent,
+	// so they are deleted in the first round.
+	for id := range c.marked {
+		garbageIds = append(garbageIds, id)
+		delete(c.marked, id)
+	}
 
 	for _, id := range garbageIds {
 		if ctx.Err() != nil {
@@ -355,7 +499,7 @@ func (c *Syncer) garbageCollect(ctx context.Context, resolution int64) error {
 		}
 
 		// Spawn a new context so we always delete a block in full on shutdown.
-		delCtx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		delCtx, cancel := context.WithTimeout(audit.WithReason(context.Background(), "gc"), 5*time.Minute)
 
 		level.Info(c.logger).Log("msg", "deleting outdated block", "block", id)
 
@@ -380,11 +524,22 @@ type Group struct {
 	bkt                         objstore.Bucket
 	labels                      labels.Labels
 	resolution                  int64
+	shard                       block.ThanosShardMeta
 	mtx                         sync.Mutex
 	blocks                      map[ulid.ULID]*block.Meta
 	compactions                 prometheus.Counter
 	compactionFailures          prometheus.Counter
 	groupGarbageCollectedBlocks prometheus.Counter
+
+	// allowOverlaps disables the overlap check of input blocks. It is only set for compactions
+	// that are able to merge overlapping blocks.
+	allowOverlaps bool
+	// outputShards is the number of series shards the output of an unsharded group is written as.
+	// Output is not sharded if it is less than two.
+	outputShards uint64
+	// updateIndex enables updates of the bucket index. It is only set for groups of the compactor,
+	// which must be the only writer of the index.
+	updateIndex bool
 }
 
 // newGroup returns a new compaction group.
@@ -393,9 +548,11 @@ func newGroup(
 	bkt objstore.Bucket,
 	lset labels.Labels,
 	resolution int64,
+	shard block.ThanosShardMeta,
 	compactions prometheus.Counter,
 	compactionFailures prometheus.Counter,
 	groupGarbageCollectedBlocks prometheus.Counter,
+	updateIndex bool,
 ) (*Group, error) {
 	if logger == nil {
 		logger = log.NewNopLogger()
@@ -405,17 +562,19 @@ func newGroup(
 		bkt:                         bkt,
 		labels:                      lset,
 		resolution:                  resolution,
+		shard:                       shard,
 		blocks:                      map[ulid.ULID]*block.Meta{},
 		compactions:                 compactions,
 		compactionFailures:          compactionFailures,
 		groupGarbageCollectedBlocks: groupGarbageCollectedBlocks,
+		updateIndex:                 updateIndex,
 	}
 	return g, nil
 }
 
 // Key returns an identifier for the group.
 func (cg *Group) Key() string {
-	return groupKey(cg.resolution, cg.labels)
+	return groupKey(cg.resolution, cg.labels, cg.shard)
 }
 
 // Add the block with the given meta to the group.
@@ -429,6 +588,9 @@ func (cg *Group) Add(meta *block.Meta) error {
 	if cg.resolution != meta.Thanos.Downsample.Resolution {
 		return errors.New("block and group resolution do not match")
 	}
+	if cg.shard != meta.Thanos.Shard {
+		return errors.New("block and group shard do not match")
+	}
 	cg.blocks[meta.ULID] = meta
 	return nil
 }
@@ -457,8 +619,14 @@ func (cg *Group) Resolution() int64 {
 	return cg.resolution
 }
 
+// Shard returns the common series shard of blocks in the group.
+func (cg *Group) Shard() block.ThanosShardMeta {
+	return cg.shard
+}
+
 // Compact plans and runs a single compaction against the group. The compacted result
-// is uploaded into the bucket the blocks were retrieved from.
+// is uploaded into the bucket the blocks were retrieved from. If the result is written as
+// series shards, the ID of the first shard is returned.
 func (cg *Group) Compact(ctx context.Context, dir string, comp tsdb.Compactor) (ulid.ULID, error) {
 	subDir := filepath.Join(dir, cg.Key())
 
@@ -580,6 +748,7 @@ func RepairIssue347(ctx context.Context, logger log.Logger, bkt objstore.Bucket,
 	}
 
 	level.Info(logger).Log("msg", "Repairing block broken by https://github.com/prometheus/tsdb/issues/347", "id", ie.id, "err", issue347Err)
+	ctx = audit.WithReason(ctx, "repair issue 347")
 
 	tmpdir, err := ioutil.TempDir("", fmt.Sprintf("repair-issue-347-id-%s-", ie.id))
 	if err != nil {
@@ -617,10 +786,18 @@ func RepairIssue347(ctx context.Context, logger log.Logger, bkt objstore.Bucket,
 		return retry(errors.Wrapf(err, "upload of %s failed", resid))
 	}
 
+	resmeta, err := block.ReadMetaFile(filepath.Join(tmpdir, resid.String()))
+	if err != nil {
+		return errors.Wrapf(err, "read meta of repaired block %s", resid)
+	}
+	if err := block.UpdateBucketIndex(ctx, logger, bkt, []block.Meta{*resmeta}, []ulid.ULID{ie.id}); err != nil {
+		return retry(errors.Wrap(err, "update bucket index"))
+	}
+
 	level.Info(logger).Log("msg", "deleting broken block", "id", ie.id)
 
 	// Spawn a new context so we always delete a block in full on shutdown.
-	delCtx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	delCtx, cancel := context.WithTimeout(audit.WithReason(context.Background(), audit.Reason(ctx)), 5*time.Minute)
 	defer cancel()
 
 	// TODO(bplotka): Issue with this will introduce overlap that will halt compactor. Automate that (fix duplicate overlaps caused by this).
@@ -636,8 +813,10 @@ func (cg *Group) compact(ctx context.Context, dir string, comp tsdb.Compactor) (
 	defer cg.mtx.Unlock()
 
 	// Check for overlapped blocks.
-	if err := cg.areBlocksOverlapping(nil); err != nil {
-		return compID, halt(errors.Wrap(err, "pre compaction overlap check"))
+	if !cg.allowOverlaps {
+		if err := cg.areBlocksOverlapping(nil); err != nil {
+			return compID, halt(errors.Wrap(err, "pre compaction overlap check"))
+		}
 	}
 
 	// Planning a compaction works purely based on the meta.json files in our future group's dir.
@@ -664,7 +843,9 @@ func (cg *Group) compact(ctx context.Context, dir string, comp tsdb.Compactor) (
 
 	// Due to #183 we verify that none of the blocks in the plan have overlapping sources.
 	// This is one potential source of how we could end up with duplicated chunks.
-	uniqueSources := map[ulid.ULID]struct{}{}
+	// Blocks split by time share the sources of the block they were split from, but cannot contain duplicated
+	// chunks as their time ranges do not overlap.
+	uniqueSources := map[ulid.ULID]*block.Meta{}
 
 	// Once we have a plan we need to download the actual data.
 	begin := time.Now()
@@ -680,10 +861,10 @@ func (cg *Group) compact(ctx context.Context, dir string, comp tsdb.Compactor) (
 		}
 
 		for _, s := range meta.Compaction.Sources {
-			if _, ok := uniqueSources[s]; ok {
+			if o, ok := uniqueSources[s]; ok && o.MinTime < meta.MaxTime && meta.MinTime < o.MaxTime {
 				return compID, halt(errors.Errorf("overlapping sources detected for plan %v", plan))
 			}
-			uniqueSources[s] = struct{}{}
+			uniqueSources[s] = meta
 		}
 
 		id, err := ulid.Parse(filepath.Base(pdir))
@@ -731,12 +912,14 @@ func (cg *Group) compact(ctx context.Context, dir string, comp tsdb.Compactor) (
 		Labels:     cg.labels.Map(),
 		Downsample: block.ThanosDownsampleMeta{Resolution: cg.resolution},
 		Source:     block.CompactorSource,
+		Shard:      cg.shard,
 	}, nil)
 	if err != nil {
 		return compID, errors.Wrapf(err, "failed to finalize the block %s", bdir)
 	}
 
-	if err = os.Remove(filepath.Join(bdir, "tombstones")); err != nil {
+	// Merged overlapping blocks are written without tombstones.
+	if err = os.Remove(filepath.Join(bdir, "tombstones")); err != nil && !os.IsNotExist(err) {
 		return compID, errors.Wrap(err, "remove tombstones")
 	}
 
@@ -750,12 +933,61 @@ func (cg *Group) compact(ctx context.Context, dir string, comp tsdb.Compactor) (
 		return compID, halt(errors.Wrapf(err, "resulted compacted block %s overlaps with something", bdir))
 	}
 
-	begin = time.Now()
+	resultDirs := []string{bdir}
+	if !cg.shard.Sharded() && cg.outputShards > 1 {
+		// The shards keep the sources of the compacted block. Subsequent compactions happen within the
+		// group of each shard, which keeps the shards aligned.
+		ids, err := block.SplitByShard(cg.logger, dir, compID, downsample.NewPool(), block.CompactorSource, cg.outputShards)
+		if err != nil {
+			return compID, errors.Wrapf(err, "shard compacted block %s", compID)
+		}
+		if err := os.RemoveAll(bdir); err != nil {
+			return compID, errors.Wrapf(err, "remove sharded block dir %s", compID)
+		}
+		level.Debug(cg.logger).Log("msg", "sharded compacted block", "block", compID, "shards", len(ids))
 
-	if err := block.Upload(ctx, cg.logger, cg.bkt, bdir); err != nil {
-		return compID, retry(errors.Wrapf(err, "upload of %s failed", compID))
+		resultDirs = resultDirs[:0]
+		for _, id := range ids {
+			resultDirs = append(resultDirs, filepath.Join(dir, id.String()))
+		}
+		if len(ids) > 0 {
+			compID = ids[0]
+		}
+	}
+
+	if audit.Reason(ctx) == "" {
+		ctx = audit.WithReason(ctx, "compaction")
+	}
+	for _, rdir := range resultDirs {
+		begin = time.Now()
+
+		if err := block.Upload(ctx, cg.logger, cg.bkt, rdir); err != nil {
+			return compID, retry(errors.Wrapf(err, "upload of %s failed", filepath.Base(rdir)))
+		}
+		level.Debug(cg.logger).Log("msg", "uploaded block", "result_block", filepath.Base(rdir), "duration", time.Since(begin))
+	}
+
+	resultMetas := make([]block.Meta, 0, len(resultDirs))
+	planIDs := make([]ulid.ULID, 0, len(plan))
+	for _, rdir := range resultDirs {
+		m, err := block.ReadMetaFile(rdir)
+		if err != nil {
+			return compID, errors.Wrapf(err, "read meta of %s", filepath.Base(rdir))
+		}
+		resultMetas = append(resultMetas, *m)
+	}
+	for _, b := range plan {
+		id, err := ulid.Parse(filepath.Base(b))
+		if err != nil {
+			return compID, errors.Wrapf(err, "plan dir %s", b)
+		}
+		planIDs = append(planIDs, id)
+	}
+	if cg.updateIndex {
+		if err := block.UpdateBucketIndex(ctx, cg.logger, cg.bkt, resultMetas, planIDs); err != nil {
+			return compID, retry(errors.Wrap(err, "update bucket index"))
+		}
 	}
-	level.Debug(cg.logger).Log("msg", "uploaded block", "result_block", compID, "duration", time.Since(begin))
 
 	// Delete the blocks we just compacted from the group and bucket so they do not get included
 	// into the next planning cycle.
@@ -771,7 +1003,7 @@ func (cg *Group) compact(ctx context.Context, dir string, comp tsdb.Compactor) (
 		}
 
 		// Spawn a new context so we always delete a block in full on shutdown.
-		delCtx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		delCtx, cancel := context.WithTimeout(audit.WithReason(context.Background(), audit.Reason(ctx)), 5*time.Minute)
 		level.Info(cg.logger).Log("msg", "deleting compacted block", "old_block", id, "result_block", compID)
 		err = block.Delete(delCtx, cg.bkt, id)
 		cancel()
@@ -779,35 +1011,54 @@ func (cg *Group) compact(ctx context.Context, dir string, comp tsdb.Compactor) (
 			return compID, retry(errors.Wrapf(err, "delete old block %s from bucket ", id))
 		}
 		cg.groupGarbageCollectedBlocks.Inc()
+		delete(cg.blocks, id)
 	}
 
+	// Replace the compacted blocks with the result, so that the group can be compacted further within
+	// the same iteration. Series shards belong to groups of their own.
+	for i := range resultMetas {
+		if GroupKey(resultMetas[i]) == cg.Key() {
+			cg.blocks[resultMetas[i].ULID] = &resultMetas[i]
+		}
+	}
 	return compID, nil
 }
 
 // BucketCompactor compacts blocks in a bucket.
 type BucketCompactor struct {
-	logger     log.Logger
-	sy         *Syncer
-	comp       tsdb.Compactor
-	compactDir string
-	bkt        objstore.Bucket
+	logger        log.Logger
+	sy            *Syncer
+	comp          tsdb.Compactor
+	compactDir    string
+	bkt           objstore.Bucket
+	sched         *Scheduler
+	shards        uint64
+	maxIterations int
 }
 
-// NewBucketCompactor creates a new bucket compactor.
-func NewBucketCompactor(logger log.Logger, sy *Syncer, comp tsdb.Compactor, compactDir string, bkt objstore.Bucket) *BucketCompactor {
+// NewBucketCompactor creates a new bucket compactor. Groups are compacted in the order and with the number of
+// steps per iteration given by the scheduler, or ordered by their key with one step each if it is nil.
+// If shards is greater than one, the output of compacting unsharded blocks is written as that many blocks
+// partitioned by the hash of series labels. Sharded blocks are only compacted with blocks of the same shard.
+// If maxIterations is positive, Compact returns after that many iterations even if work is left, so that
+// downsampling is not delayed by a large compaction backlog.
+func NewBucketCompactor(logger log.Logger, sy *Syncer, comp tsdb.Compactor, compactDir string, bkt objstore.Bucket, sched *Scheduler, shards uint64, maxIterations int) *BucketCompactor {
 	return &BucketCompactor{
-		logger:     logger,
-		sy:         sy,
-		comp:       comp,
-		compactDir: compactDir,
-		bkt:        bkt,
+		logger:        logger,
+		sy:            sy,
+		comp:          comp,
+		compactDir:    compactDir,
+		bkt:           bkt,
+		sched:         sched,
+		shards:        shards,
+		maxIterations: maxIterations,
 	}
 }
 
 // Compact runs compaction over bucket.
 func (c *BucketCompactor) Compact(ctx context.Context) error {
-	// Loop over bucket and compact until there's no work left.
-	for {
+	// Loop over bucket and compact until there's no work left or the maximum number of iterations is reached.
+	for i := 0; c.maxIterations <= 0 || i < c.maxIterations; i++ {
 		// Clean up the compaction temporary directory at the beginning of every compaction loop.
 		if err := os.RemoveAll(c.compactDir); err != nil {
 			return errors.Wrap(err, "clean up the compaction temporary directory")
@@ -830,28 +1081,47 @@ func (c *BucketCompactor) Compact(ctx context.Context) error {
 			return errors.Wrap(err, "build compaction groups")
 		}
 		done := true
-		for _, g := range groups {
-			id, err := g.Compact(ctx, c.compactDir, c.comp)
-			if err == nil {
-				// If the returned ID has a zero value, the group had no blocks to be compacted.
-				// We keep going through the outer loop until no group has any work left.
-				if id != (ulid.ULID{}) {
-					done = false
-				}
-				continue
+		for _, g := range c.sched.Schedule(groups) {
+			g.mtx.Lock()
+			g.outputShards = c.shards
+			g.mtx.Unlock()
+
+			progress, err := c.compactGroup(ctx, g, c.sched.Steps(g))
+			if err != nil {
+				return errors.Wrap(err, "compaction")
+			}
+			// We keep going through the outer loop until no group has any work left.
+			if progress {
+				done = false
 			}
+		}
+		if done {
+			return nil
+		}
+	}
+	level.Info(c.logger).Log("msg", "maximum number of compaction iterations reached, continuing with the remaining work later", "iterations", c.maxIterations)
+	return nil
+}
 
+// compactGroup runs up to the given number of compactions against the group and reports whether any of them
+// made progress.
+func (c *BucketCompactor) compactGroup(ctx context.Context, g *Group, steps int) (progress bool, err error) {
+	for i := 0; i < steps; i++ {
+		id, err := g.Compact(ctx, c.compactDir, c.comp)
+		if err != nil {
 			if IsIssue347Error(err) {
 				if err := RepairIssue347(ctx, c.logger, c.bkt, err); err == nil {
-					done = false
-					continue
+					// The repaired block is only part of the group after the next sync.
+					return true, nil
 				}
 			}
-			return errors.Wrap(err, "compaction")
+			return progress, err
 		}
-		if done {
-			break
+		// If the returned ID has a zero value, the group had no blocks to be compacted.
+		if id == (ulid.ULID{}) {
+			return progress, nil
 		}
+		progress = true
 	}
-	return nil
+	return progress, nil
 }
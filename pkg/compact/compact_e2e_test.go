@@ -139,7 +139,9 @@ func TestSyncer_GarbageCollect_e2e(t *testing.T) {
 
 		var rem []ulid.ULID
 		err = bkt.Iter(ctx, "", func(n string) error {
-			rem = append(rem, ulid.MustParse(n[:len(n)-1]))
+			if id, ok := block.IsBlockDir(n); ok {
+				rem = append(rem, id)
+			}
 			return nil
 		})
 		testutil.Ok(t, err)
@@ -161,6 +163,86 @@ func TestSyncer_GarbageCollect_e2e(t *testing.T) {
 		testutil.Equals(t, []ulid.ULID{metas[9].ULID, m3.ULID}, groups[0].IDs())
 		testutil.Equals(t, "1000@{}", groups[1].Key())
 		testutil.Equals(t, []ulid.ULID{m4.ULID}, groups[1].IDs())
+
+		// The bucket index lists the remaining blocks only.
+		idx, err := block.ReadBucketIndex(ctx, log.NewNopLogger(), bkt)
+		testutil.Ok(t, err)
+		var indexed []ulid.ULID
+		for _, m := range idx.Blocks {
+			indexed = append(indexed, m.ULID)
+		}
+		testutil.Equals(t, rem, indexed)
+		testutil.Equals(t, 0, len(idx.DeletionMarks))
+	})
+}
+
+func TestSyncer_BucketIndex_e2e(t *testing.T) {
+	objtesting.ForeachStore(t, func(t testing.TB, bkt objstore.Bucket) {
+		ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
+		defer cancel()
+
+		var ids []ulid.ULID
+		for i := 0; i < 3; i++ {
+			var m block.Meta
+			m.Version = 1
+			m.ULID = ulid.MustNew(uint64(i+1), nil)
+			m.Compaction.Sources = []ulid.ULID{m.ULID}
+			ids = append(ids, m.ULID)
+
+			var buf bytes.Buffer
+			testutil.Ok(t, json.NewEncoder(&buf).Encode(&m))
+			testutil.Ok(t, bkt.Upload(ctx, path.Join(m.ULID.String(), block.MetaFilename), &buf))
+		}
+
+		sy, err := NewSyncer(nil, nil, bkt, 0)
+		testutil.Ok(t, err)
+		testutil.Ok(t, sy.SyncMetas(ctx))
+
+		idx, err := block.ReadBucketIndex(ctx, log.NewNopLogger(), bkt)
+		testutil.Ok(t, err)
+		testutil.Equals(t, 3, len(idx.Blocks))
+
+		// An unchanged index is not rewritten.
+		testutil.Ok(t, sy.SyncMetas(ctx))
+		unchanged, err := block.ReadBucketIndex(ctx, log.NewNopLogger(), bkt)
+		testutil.Ok(t, err)
+		testutil.Equals(t, idx.SyncTime, unchanged.SyncTime)
+
+		// Simulate a deletion that was interrupted after the block was marked.
+		testutil.Ok(t, block.UpdateBucketIndex(ctx, log.NewNopLogger(), bkt, nil, ids[:1]))
+
+		// A new syncer relies on the index for metas and skips the marked block.
+		sy, err = NewSyncer(nil, nil, bkt, 0)
+		testutil.Ok(t, err)
+		testutil.Ok(t, sy.SyncMetas(ctx))
+
+		groups, err := sy.Groups()
+		testutil.Ok(t, err)
+		testutil.Equals(t, 1, len(groups))
+		testutil.Equals(t, ids[1:], groups[0].IDs())
+
+		idx, err = block.ReadBucketIndex(ctx, log.NewNopLogger(), bkt)
+		testutil.Ok(t, err)
+		testutil.Equals(t, 2, len(idx.Blocks))
+		testutil.Assert(t, idx.Deleted(ids[0]), "marked block must stay marked while it is present")
+
+		// Garbage collection completes the deletion, after which the mark is dropped.
+		testutil.Ok(t, sy.GarbageCollect(ctx))
+		testutil.Ok(t, sy.SyncMetas(ctx))
+
+		idx, err = block.ReadBucketIndex(ctx, log.NewNopLogger(), bkt)
+		testutil.Ok(t, err)
+		testutil.Equals(t, 0, len(idx.DeletionMarks))
+		testutil.Equals(t, 2, len(idx.Blocks))
+
+		var present []ulid.ULID
+		testutil.Ok(t, bkt.Iter(ctx, "", func(n string) error {
+			if id, ok := block.IsBlockDir(n); ok {
+				present = append(present, id)
+			}
+			return nil
+		}))
+		testutil.Equals(t, ids[1:], present)
 	})
 }
 
@@ -238,9 +320,11 @@ func TestGroup_Compact_e2e(t *testing.T) {
 			bkt,
 			extLset,
 			124,
+			block.ThanosShardMeta{},
 			metrics.compactions.WithLabelValues(""),
 			metrics.compactionFailures.WithLabelValues(""),
 			metrics.garbageCollectedBlocks,
+			true,
 		)
 		testutil.Ok(t, err)
 
@@ -277,6 +361,18 @@ func TestGroup_Compact_e2e(t *testing.T) {
 		testutil.Assert(t, extLset.Equals(labels.FromMap(meta.Thanos.Labels)), "ext labels does not match")
 		testutil.Equals(t, int64(124), meta.Thanos.Downsample.Resolution)
 
+		// The group holds the result instead of the compacted blocks, so it can be compacted further right away.
+		ids := map[ulid.ULID]struct{}{}
+		for _, id := range g.IDs() {
+			ids[id] = struct{}{}
+		}
+		_, ok := ids[id]
+		testutil.Assert(t, ok, "expected compacted block in group")
+		for _, source := range meta.Compaction.Sources {
+			_, ok := ids[source]
+			testutil.Assert(t, !ok, "expected source %s not to be in group", source)
+		}
+
 		// Check object storage. All blocks that were included in new compacted one should be removed.
 		err = bkt.Iter(ctx, "", func(n string) error {
 			id, ok := block.IsBlockDir(n)
@@ -1,9 +1,12 @@
 package compact
 
 import (
+	"sort"
 	"testing"
 
+	"github.com/improbable-eng/thanos/pkg/block"
 	"github.com/improbable-eng/thanos/pkg/testutil"
+	"github.com/oklog/ulid"
 	"github.com/pkg/errors"
 )
 
@@ -37,3 +40,45 @@ func TestRetryError(t *testing.T) {
 	err = errors.Wrap(retry(errors.Wrap(halt(errors.New("test")), "something")), "something2")
 	testutil.Assert(t, IsHaltError(err), "not a halt error. Retry should not hide halt error")
 }
+
+func TestSyncer_GarbageBlocks_Shards(t *testing.T) {
+	newMeta := func(id uint64, level int, shard block.ThanosShardMeta, sources ...uint64) *block.Meta {
+		m := &block.Meta{}
+		m.ULID = ulid.MustNew(id, nil)
+		m.Compaction.Level = level
+		for _, s := range sources {
+			m.Compaction.Sources = append(m.Compaction.Sources, ulid.MustNew(s, nil))
+		}
+		m.Thanos.Shard = shard
+		return m
+	}
+	garbage := func(metas ...*block.Meta) (res []uint64) {
+		sy := &Syncer{blocks: map[ulid.ULID]*block.Meta{}}
+		for _, m := range metas {
+			sy.blocks[m.ULID] = m
+		}
+		ids, err := sy.GarbageBlocks(0)
+		testutil.Ok(t, err)
+		for _, id := range ids {
+			res = append(res, id.Time())
+		}
+		sort.Slice(res, func(i, j int) bool { return res[i] < res[j] })
+		return res
+	}
+	var (
+		s1 = newMeta(1, 1, block.ThanosShardMeta{}, 1)
+		s2 = newMeta(2, 1, block.ThanosShardMeta{}, 2)
+		// Shards of the compaction of both source blocks.
+		p0 = newMeta(10, 2, block.ThanosShardMeta{Index: 0, Count: 2}, 1, 2)
+		p1 = newMeta(11, 2, block.ThanosShardMeta{Index: 1, Count: 2}, 1, 2)
+		// Compaction of shard 0 with a block of the same shard.
+		q0 = newMeta(12, 3, block.ThanosShardMeta{Index: 0, Count: 2}, 1, 2, 3)
+	)
+
+	// Shards are no duplicates of each other and replace the source blocks once all of them exist.
+	testutil.Equals(t, []uint64{1, 2}, garbage(s1, s2, p0, p1))
+	// An incomplete set of shards does not replace the source blocks.
+	testutil.Equals(t, []uint64(nil), garbage(s1, s2, p0))
+	// Higher level blocks replace lower level blocks of the same shard only.
+	testutil.Equals(t, []uint64{1, 2, 10}, garbage(s1, s2, p0, p1, q0))
+}
@@ -464,6 +464,11 @@ func downsampleAggr(chks []*AggrChunk, buf *[]sample, mint, maxt, inRes, outRes
 		res       = make([]chunks.Meta, 0, numChunks)
 		batchSize = len(chks) / numChunks
 	)
+	// Small blocks may have fewer chunks than targeted. As aggregates are only downsampled along
+	// chunk boundaries, every output chunk then contains a single input chunk.
+	if batchSize == 0 {
+		batchSize = 1
+	}
 
 	for len(chks) > 0 {
 		j := batchSize
@@ -0,0 +1,154 @@
+package compact
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+
+	"github.com/go-kit/kit/log"
+	"github.com/improbable-eng/thanos/pkg/block"
+	"github.com/improbable-eng/thanos/pkg/objstore"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/tsdb"
+	"github.com/prometheus/tsdb/labels"
+)
+
+// NewGroupForBlocks returns a compaction group consisting of the blocks with the given IDs only.
+// It returns an error if the blocks do not share the same labels, downsampling resolution and series shard.
+// The bucket index is only updated with the results of compactions if updateIndex is set, which only the
+// compactor may do, as it must be the only writer of the index.
+func NewGroupForBlocks(ctx context.Context, logger log.Logger, bkt objstore.Bucket, ids []ulid.ULID, updateIndex bool) (*Group, error) {
+	if len(ids) == 0 {
+		return nil, errors.New("no blocks specified")
+	}
+
+	var g *Group
+	for _, id := range ids {
+		meta, err := block.DownloadMeta(ctx, logger, bkt, id)
+		if err != nil {
+			return nil, errors.Wrapf(err, "download meta of block %s", id)
+		}
+		if g == nil {
+			g, err = newGroup(
+				log.With(logger, "compactionGroup", GroupKey(meta)),
+				bkt,
+				labels.FromMap(meta.Thanos.Labels),
+				meta.Thanos.Downsample.Resolution,
+				meta.Thanos.Shard,
+				prometheus.NewCounter(prometheus.CounterOpts{Name: "thanos_compact_group_compactions_total"}),
+				prometheus.NewCounter(prometheus.CounterOpts{Name: "thanos_compact_group_compactions_failures_total"}),
+				prometheus.NewCounter(prometheus.CounterOpts{Name: "thanos_compact_garbage_collected_blocks_total"}),
+				updateIndex,
+			)
+			if err != nil {
+				return nil, errors.Wrap(err, "create compaction group")
+			}
+		}
+		if err := g.Add(&meta); err != nil {
+			return nil, errors.Wrapf(err, "add block %s with labels %v, resolution %d and shard %s to group %s",
+				id, meta.Thanos.Labels, meta.Thanos.Downsample.Resolution, meta.Thanos.Shard, g.Key())
+		}
+	}
+	return g, nil
+}
+
+// Metas returns the metas of all blocks in the group sorted by their min time.
+func (cg *Group) Metas() (metas []*block.Meta) {
+	cg.mtx.Lock()
+	defer cg.mtx.Unlock()
+
+	for _, m := range cg.blocks {
+		metas = append(metas, m)
+	}
+	sort.Slice(metas, func(i, j int) bool {
+		return metas[i].MinTime < metas[j].MinTime
+	})
+	return metas
+}
+
+// Overlaps returns an error describing overlapping blocks in the group, if there are any.
+func (cg *Group) Overlaps() error {
+	cg.mtx.Lock()
+	defer cg.mtx.Unlock()
+
+	return cg.areBlocksOverlapping(nil)
+}
+
+// CompactAll compacts all blocks of the group into a single block, regardless of the plan of the given
+// compactor. The result is uploaded into the bucket and the compacted blocks are deleted from it.
+// If mergeOverlapping is true, overlapping raw blocks are merged sample by sample instead of halting.
+func (cg *Group) CompactAll(ctx context.Context, dir string, comp tsdb.Compactor, mergeOverlapping bool) (ulid.ULID, error) {
+	cg.mtx.Lock()
+	cg.allowOverlaps = mergeOverlapping
+	cg.mtx.Unlock()
+
+	return cg.Compact(ctx, dir, &allBlocksCompactor{
+		Compactor:        comp,
+		logger:           cg.logger,
+		mergeOverlapping: mergeOverlapping,
+	})
+}
+
+// allBlocksCompactor is a tsdb.Compactor that plans all blocks of the directory for compaction.
+type allBlocksCompactor struct {
+	tsdb.Compactor
+
+	logger           log.Logger
+	mergeOverlapping bool
+}
+
+// Plan returns all block directories in dir sorted by their min time.
+func (c *allBlocksCompactor) Plan(dir string) ([]string, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var metas []*block.Meta
+	for _, f := range files {
+		if _, ok := block.IsBlockDir(f.Name()); !ok || !f.IsDir() {
+			continue
+		}
+		meta, err := block.ReadMetaFile(filepath.Join(dir, f.Name()))
+		if err != nil {
+			return nil, errors.Wrapf(err, "read meta of %s", f.Name())
+		}
+		metas = append(metas, meta)
+	}
+	if len(metas) < 2 {
+		return nil, nil
+	}
+	sort.Slice(metas, func(i, j int) bool {
+		return metas[i].MinTime < metas[j].MinTime
+	})
+
+	plan := make([]string, 0, len(metas))
+	for _, m := range metas {
+		plan = append(plan, filepath.Join(dir, m.ULID.String()))
+	}
+	return plan, nil
+}
+
+// Compact compacts the given block directories. Overlapping blocks are merged if enabled,
+// as the underlying compactor is not able to handle them.
+func (c *allBlocksCompactor) Compact(dest string, dirs ...string) (ulid.ULID, error) {
+	if !c.mergeOverlapping {
+		return c.Compactor.Compact(dest, dirs...)
+	}
+
+	var metas []tsdb.BlockMeta
+	for _, d := range dirs {
+		meta, err := block.ReadMetaFile(d)
+		if err != nil {
+			return ulid.ULID{}, errors.Wrapf(err, "read meta of %s", d)
+		}
+		metas = append(metas, meta.BlockMeta)
+	}
+	if len(tsdb.OverlappingBlocks(metas)) == 0 {
+		return c.Compactor.Compact(dest, dirs...)
+	}
+	return block.MergeOverlapping(c.logger, dest, nil, dirs...)
+}
@@ -0,0 +1,186 @@
+package compact
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/improbable-eng/thanos/pkg/block"
+	"github.com/improbable-eng/thanos/pkg/objstore/inmem"
+	"github.com/improbable-eng/thanos/pkg/testutil"
+	"github.com/oklog/ulid"
+	"github.com/prometheus/tsdb"
+	"github.com/prometheus/tsdb/labels"
+)
+
+func TestGroup_CompactAll(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	dir, err := ioutil.TempDir("", "compact-all-test")
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, os.RemoveAll(dir)) }()
+
+	series := []labels.Labels{
+		{{Name: "a", Value: "1"}},
+		{{Name: "a", Value: "2"}},
+	}
+	extLset := labels.Labels{{Name: "e1", Value: "1"}}
+
+	comp, err := tsdb.NewLeveledCompactor(nil, log.NewNopLogger(), []int64{1000, 3000}, nil)
+	testutil.Ok(t, err)
+
+	for _, tcase := range []struct {
+		name             string
+		ranges           [][2]int64
+		overlapping      bool
+		mergeOverlapping bool
+		expErr           bool
+	}{
+		{name: "consecutive blocks", ranges: [][2]int64{{0, 1000}, {1000, 2000}, {2000, 3000}}},
+		{name: "overlapping blocks without merge", ranges: [][2]int64{{0, 1000}, {500, 1500}}, overlapping: true, expErr: true},
+		{name: "overlapping blocks with merge", ranges: [][2]int64{{0, 1000}, {500, 1500}, {1500, 2000}}, overlapping: true, mergeOverlapping: true},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			bkt := inmem.NewBucket()
+
+			var ids []ulid.ULID
+			for _, r := range tcase.ranges {
+				id, err := testutil.CreateBlock(dir, series, 10, r[0], r[1], extLset, 0)
+				testutil.Ok(t, err)
+				testutil.Ok(t, block.Upload(ctx, log.NewNopLogger(), bkt, filepath.Join(dir, id.String())))
+				ids = append(ids, id)
+			}
+
+			g, err := NewGroupForBlocks(ctx, log.NewNopLogger(), bkt, ids, false)
+			testutil.Ok(t, err)
+			testutil.Equals(t, len(ids), len(g.Metas()))
+			testutil.Equals(t, tcase.overlapping, g.Overlaps() != nil)
+
+			compID, err := g.CompactAll(ctx, filepath.Join(dir, "compact"), comp, tcase.mergeOverlapping)
+			if tcase.expErr {
+				testutil.NotOk(t, err)
+				testutil.Assert(t, IsHaltError(err), "expected halt error, got %v", err)
+				return
+			}
+			testutil.Ok(t, err)
+
+			// Only the compacted block remains in the bucket.
+			var blocks []ulid.ULID
+			testutil.Ok(t, bkt.Iter(ctx, "", func(name string) error {
+				if id, ok := block.IsBlockDir(name); ok {
+					blocks = append(blocks, id)
+				}
+				return nil
+			}))
+			testutil.Equals(t, []ulid.ULID{compID}, blocks)
+
+			meta, err := block.DownloadMeta(ctx, log.NewNopLogger(), bkt, compID)
+			testutil.Ok(t, err)
+			testutil.Equals(t, tcase.ranges[0][0], meta.MinTime)
+			testutil.Equals(t, tcase.ranges[len(tcase.ranges)-1][1], meta.MaxTime)
+			testutil.Equals(t, uint64(len(series)), meta.Stats.NumSeries)
+			testutil.Equals(t, uint64(len(series)*10*len(tcase.ranges)), meta.Stats.NumSamples)
+			testutil.Equals(t, len(ids), len(meta.Compaction.Sources))
+			testutil.Equals(t, extLset.Map(), meta.Thanos.Labels)
+		})
+	}
+}
+
+func TestNewGroupForBlocks_MismatchedLabels(t *testing.T) {
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", "compact-all-test")
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, os.RemoveAll(dir)) }()
+
+	bkt := inmem.NewBucket()
+	series := []labels.Labels{{{Name: "a", Value: "1"}}}
+
+	var ids []ulid.ULID
+	for _, lset := range []labels.Labels{
+		{{Name: "e1", Value: "1"}},
+		{{Name: "e1", Value: "2"}},
+	} {
+		id, err := testutil.CreateBlock(dir, series, 10, 0, 1000, lset, 0)
+		testutil.Ok(t, err)
+		testutil.Ok(t, block.Upload(ctx, log.NewNopLogger(), bkt, filepath.Join(dir, id.String())))
+		ids = append(ids, id)
+	}
+
+	_, err = NewGroupForBlocks(ctx, log.NewNopLogger(), bkt, ids, false)
+	testutil.NotOk(t, err)
+}
+
+func TestBucketCompactor_Shards(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	dir, err := ioutil.TempDir("", "compact-shards-test")
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, os.RemoveAll(dir)) }()
+
+	var series []labels.Labels
+	for i := 0; i < 20; i++ {
+		series = append(series, labels.FromStrings("a", strconv.Itoa(i)))
+	}
+	extLset := labels.Labels{{Name: "e1", Value: "1"}}
+	bkt := inmem.NewBucket()
+
+	// The planner never compacts the most recent block.
+	var ids []ulid.ULID
+	for _, r := range [][2]int64{{0, 1000}, {1000, 2000}, {2000, 3000}, {3000, 4000}} {
+		id, err := testutil.CreateBlock(dir, series, 10, r[0], r[1], extLset, 0)
+		testutil.Ok(t, err)
+		testutil.Ok(t, block.Upload(ctx, log.NewNopLogger(), bkt, filepath.Join(dir, id.String())))
+		ids = append(ids, id)
+	}
+
+	comp, err := tsdb.NewLeveledCompactor(nil, log.NewNopLogger(), []int64{1000, 3000}, nil)
+	testutil.Ok(t, err)
+	sy, err := NewSyncer(nil, nil, bkt, 0)
+	testutil.Ok(t, err)
+
+	testutil.Ok(t, NewBucketCompactor(log.NewNopLogger(), sy, comp, filepath.Join(dir, "compact"), bkt, nil, 4, 0).Compact(ctx))
+
+	// The blocks were compacted into shards, which keep their sources and the series of each shard only.
+	var numSeries uint64
+	shards := map[uint64]struct{}{}
+	testutil.Ok(t, bkt.Iter(ctx, "", func(name string) error {
+		id, ok := block.IsBlockDir(name)
+		if !ok {
+			return nil
+		}
+		meta, err := block.DownloadMeta(ctx, log.NewNopLogger(), bkt, id)
+		testutil.Ok(t, err)
+		if id == ids[3] {
+			testutil.Assert(t, !meta.Thanos.Shard.Sharded(), "most recent block must not be sharded")
+			return nil
+		}
+
+		testutil.Equals(t, uint64(4), meta.Thanos.Shard.Count)
+		testutil.Equals(t, int64(0), meta.MinTime)
+		testutil.Equals(t, int64(3000), meta.MaxTime)
+		testutil.Equals(t, ids[:3], meta.Compaction.Sources)
+		testutil.Equals(t, extLset.Map(), meta.Thanos.Labels)
+
+		shards[meta.Thanos.Shard.Index] = struct{}{}
+		numSeries += meta.Stats.NumSeries
+		return nil
+	}))
+	testutil.Equals(t, 4, len(shards))
+	testutil.Equals(t, uint64(len(series)), numSeries)
+
+	// Sharded blocks form groups of their own.
+	testutil.Ok(t, sy.SyncMetas(ctx))
+	groups, err := sy.Groups()
+	testutil.Ok(t, err)
+	testutil.Equals(t, 5, len(groups))
+	testutil.Equals(t, `0@{e1="1"}`, groups[0].Key())
+	testutil.Equals(t, `0@{e1="1"}@shard-0-of-4`, groups[1].Key())
+}
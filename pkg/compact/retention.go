@@ -8,39 +8,49 @@ import (
 	"github.com/go-kit/kit/log/level"
 	"github.com/improbable-eng/thanos/pkg/block"
 	"github.com/improbable-eng/thanos/pkg/objstore"
+	"github.com/improbable-eng/thanos/pkg/objstore/audit"
+	"github.com/oklog/ulid"
 	"github.com/pkg/errors"
 )
 
 // Apply removes blocks depending on the specified retentionByResolution based on blocks MaxTime.
 // A value of 0 disables the retention for its resolution.
-func ApplyRetentionPolicyByResolution(ctx context.Context, logger log.Logger, bkt objstore.Bucket, retentionByResolution map[ResolutionLevel]time.Duration) error {
+// Block metas are read from the bucket index if it was synced within maxStaleness.
+func ApplyRetentionPolicyByResolution(ctx context.Context, logger log.Logger, bkt objstore.Bucket, retentionByResolution map[ResolutionLevel]time.Duration, maxStaleness time.Duration) error {
 	level.Info(logger).Log("msg", "start optional retention")
-	if err := bkt.Iter(ctx, "", func(name string) error {
-		id, ok := block.IsBlockDir(name)
-		if !ok {
-			return nil
-		}
-		m, err := block.DownloadMeta(ctx, logger, bkt, id)
-		if err != nil {
-			return errors.Wrap(err, "download metadata")
-		}
+	ctx = audit.WithReason(ctx, "retention")
+
+	metas, err := block.BucketMetas(ctx, logger, bkt, maxStaleness)
+	if err != nil {
+		return errors.Wrap(err, "retention")
+	}
 
+	var expired []ulid.ULID
+	for _, m := range metas {
 		retentionDuration := retentionByResolution[ResolutionLevel(m.Thanos.Downsample.Resolution)]
 		if retentionDuration.Seconds() == 0 {
-			return nil
+			continue
 		}
 
 		maxTime := time.Unix(m.MaxTime/1000, 0)
 		if time.Now().After(maxTime.Add(retentionDuration)) {
-			level.Info(logger).Log("msg", "deleting block", "id", id, "maxTime", maxTime.String())
-			if err := block.Delete(ctx, bkt, id); err != nil {
-				return errors.Wrap(err, "delete block")
-			}
+			level.Info(logger).Log("msg", "block expired", "id", m.ULID, "maxTime", maxTime.String())
+			expired = append(expired, m.ULID)
 		}
-
+	}
+	if len(expired) == 0 {
+		level.Info(logger).Log("msg", "optional retention apply done")
 		return nil
-	}); err != nil {
-		return errors.Wrap(err, "retention")
+	}
+
+	if err := block.UpdateBucketIndex(ctx, logger, bkt, nil, expired); err != nil {
+		return errors.Wrap(err, "mark expired blocks as deleted in bucket index")
+	}
+	for _, id := range expired {
+		level.Info(logger).Log("msg", "deleting block", "id", id)
+		if err := block.Delete(ctx, bkt, id); err != nil {
+			return errors.Wrap(err, "delete block")
+		}
 	}
 
 	level.Info(logger).Log("msg", "optional retention apply done")
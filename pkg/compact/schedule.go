@@ -0,0 +1,191 @@
+package compact
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	promlabels "github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/tsdb/labels"
+)
+
+// Priority determines the order in which compaction groups are processed.
+type Priority string
+
+const (
+	// PriorityNone processes groups ordered by their key.
+	PriorityNone Priority = "none"
+	// PriorityMostBehind processes groups with the most blocks awaiting compaction first.
+	PriorityMostBehind Priority = "most-behind"
+	// PriorityNewest processes groups with the newest blocks awaiting compaction first.
+	PriorityNewest Priority = "newest"
+)
+
+// Priorities are all supported priorities.
+var Priorities = []string{string(PriorityNone), string(PriorityMostBehind), string(PriorityNewest)}
+
+// GroupWeight is the weight of all compaction groups whose labels match the matchers.
+type GroupWeight struct {
+	Matchers []*promlabels.Matcher
+	Weight   float64
+}
+
+// ParseGroupWeight parses a group weight in the format <selector>=<weight>, e.g. {env="prod"}=10.
+func ParseGroupWeight(s string) (GroupWeight, error) {
+	i := strings.LastIndex(s, "=")
+	if i < 0 {
+		return GroupWeight{}, errors.Errorf("invalid group weight %q, expected <selector>=<weight>", s)
+	}
+	ms, err := promql.ParseMetricSelector(s[:i])
+	if err != nil {
+		return GroupWeight{}, errors.Wrapf(err, "parse selector of group weight %q", s)
+	}
+	w, err := strconv.ParseFloat(s[i+1:], 64)
+	if err != nil {
+		return GroupWeight{}, errors.Wrapf(err, "parse weight of group weight %q", s)
+	}
+	return GroupWeight{Matchers: ms, Weight: w}, nil
+}
+
+func (w GroupWeight) matches(lset labels.Labels) bool {
+	for _, m := range w.Matchers {
+		if !m.Matches(lset.Get(m.Name)) {
+			return false
+		}
+	}
+	return true
+}
+
+// Scheduler orders compaction groups by their weight first and by the configured priority second. Groups are
+// compacted up to their weight rounded up times per iteration, so that groups of higher weight catch up faster.
+// It exposes the compaction backlog of each group as metrics.
+type Scheduler struct {
+	priority Priority
+	weights  []GroupWeight
+	maxRange int64
+
+	backlogBlocks    *prometheus.GaugeVec
+	backlogNewest    *prometheus.GaugeVec
+	groupWeight      *prometheus.GaugeVec
+	schedulePosition *prometheus.GaugeVec
+}
+
+// NewScheduler returns a new scheduler. Blocks spanning less than maxRange are considered to await compaction.
+// The weight of a group is the one of the first matching group weight or 1 if none matches.
+func NewScheduler(reg prometheus.Registerer, priority Priority, weights []GroupWeight, maxRange int64) *Scheduler {
+	s := &Scheduler{
+		priority: priority,
+		weights:  weights,
+		maxRange: maxRange,
+		backlogBlocks: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "thanos_compact_group_backlog_blocks",
+			Help: "Number of blocks of the group not yet compacted to the maximum compaction range.",
+		}, []string{"group"}),
+		backlogNewest: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "thanos_compact_group_backlog_newest_block_timestamp_seconds",
+			Help: "Max time of the newest block of the group not yet compacted to the maximum compaction range.",
+		}, []string{"group"}),
+		groupWeight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "thanos_compact_group_weight",
+			Help: "Configured scheduling weight of the group.",
+		}, []string{"group"}),
+		schedulePosition: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "thanos_compact_group_schedule_position",
+			Help: "Position of the group in the last compaction iteration. Lower positions are compacted first.",
+		}, []string{"group"}),
+	}
+	if reg != nil {
+		reg.MustRegister(s.backlogBlocks, s.backlogNewest, s.groupWeight, s.schedulePosition)
+	}
+	return s
+}
+
+// groupBacklog is the compaction backlog of a group.
+type groupBacklog struct {
+	group  *Group
+	weight float64
+	blocks int
+	newest int64
+}
+
+func (s *Scheduler) weight(g *Group) float64 {
+	for _, w := range s.weights {
+		if w.matches(g.Labels()) {
+			return w.Weight
+		}
+	}
+	return 1
+}
+
+// Steps returns the maximum number of compactions of the group per iteration. It is the weight of the group
+// rounded up, but at least one.
+func (s *Scheduler) Steps(g *Group) int {
+	if s == nil {
+		return 1
+	}
+	if w := math.Ceil(s.weight(g)); w > 1 {
+		return int(w)
+	}
+	return 1
+}
+
+func (s *Scheduler) backlog(g *Group) groupBacklog {
+	b := groupBacklog{group: g, weight: s.weight(g)}
+	for _, m := range g.Metas() {
+		if m.MaxTime-m.MinTime >= s.maxRange {
+			continue
+		}
+		b.blocks++
+		if m.MaxTime > b.newest {
+			b.newest = m.MaxTime
+		}
+	}
+	return b
+}
+
+// Schedule returns the groups in the order they should be compacted in and updates the backlog metrics.
+func (s *Scheduler) Schedule(groups []*Group) []*Group {
+	if s == nil {
+		return groups
+	}
+	backlogs := make([]groupBacklog, 0, len(groups))
+	for _, g := range groups {
+		backlogs = append(backlogs, s.backlog(g))
+	}
+
+	sort.SliceStable(backlogs, func(i, j int) bool {
+		bi, bj := backlogs[i], backlogs[j]
+		if bi.weight != bj.weight {
+			return bi.weight > bj.weight
+		}
+		switch s.priority {
+		case PriorityMostBehind:
+			return bi.blocks > bj.blocks
+		case PriorityNewest:
+			return bi.newest > bj.newest
+		}
+		return false
+	})
+
+	// Groups may vanish, e.g. after retention was applied.
+	s.backlogBlocks.Reset()
+	s.backlogNewest.Reset()
+	s.groupWeight.Reset()
+	s.schedulePosition.Reset()
+
+	res := make([]*Group, 0, len(backlogs))
+	for i, b := range backlogs {
+		key := b.group.Key()
+		s.backlogBlocks.WithLabelValues(key).Set(float64(b.blocks))
+		s.backlogNewest.WithLabelValues(key).Set(float64(b.newest) / 1000)
+		s.groupWeight.WithLabelValues(key).Set(b.weight)
+		s.schedulePosition.WithLabelValues(key).Set(float64(i))
+
+		res = append(res, b.group)
+	}
+	return res
+}
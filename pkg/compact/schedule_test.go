@@ -0,0 +1,99 @@
+package compact
+
+import (
+	"testing"
+
+	"github.com/improbable-eng/thanos/pkg/block"
+	"github.com/improbable-eng/thanos/pkg/testutil"
+	"github.com/oklog/ulid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/tsdb"
+	"github.com/prometheus/tsdb/labels"
+)
+
+func TestParseGroupWeight(t *testing.T) {
+	w, err := ParseGroupWeight(`{env="prod",cluster=~"eu-.*"}=10`)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 10.0, w.Weight)
+	testutil.Assert(t, w.matches(labels.FromStrings("env", "prod", "cluster", "eu-1")), "expected labels to match")
+	testutil.Assert(t, !w.matches(labels.FromStrings("env", "prod", "cluster", "us-1")), "expected labels not to match")
+
+	for _, s := range []string{`{env="prod"}`, `{env="prod"}=high`, `{env=}=1`} {
+		_, err := ParseGroupWeight(s)
+		testutil.NotOk(t, err)
+	}
+}
+
+func TestScheduler_Schedule(t *testing.T) {
+	// Group a has the most blocks awaiting compaction, group c the newest ones.
+	// Blocks of group b are all compacted to the maximum range already.
+	groups := []*Group{
+		testGroup(t, labels.FromStrings("cluster", "a"), [][2]int64{{0, 20}, {20, 40}, {40, 60}}),
+		testGroup(t, labels.FromStrings("cluster", "b"), [][2]int64{{0, 100}}),
+		testGroup(t, labels.FromStrings("cluster", "c"), [][2]int64{{100, 120}, {120, 140}}),
+	}
+	keys := func(groups []*Group) (res []string) {
+		for _, g := range groups {
+			res = append(res, g.Labels().Get("cluster"))
+		}
+		return res
+	}
+
+	var sched *Scheduler
+	testutil.Equals(t, []string{"a", "b", "c"}, keys(sched.Schedule(groups)))
+
+	for _, tcase := range []struct {
+		priority Priority
+		weights  []string
+		exp      []string
+	}{
+		{priority: PriorityNone, exp: []string{"a", "b", "c"}},
+		{priority: PriorityMostBehind, exp: []string{"a", "c", "b"}},
+		{priority: PriorityNewest, exp: []string{"c", "a", "b"}},
+		{priority: PriorityMostBehind, weights: []string{`{cluster="b"}=2`}, exp: []string{"b", "a", "c"}},
+		{priority: PriorityNewest, weights: []string{`{cluster=~"a|b"}=0.5`}, exp: []string{"c", "a", "b"}},
+	} {
+		var weights []GroupWeight
+		for _, s := range tcase.weights {
+			w, err := ParseGroupWeight(s)
+			testutil.Ok(t, err)
+			weights = append(weights, w)
+		}
+		sched := NewScheduler(prometheus.NewRegistry(), tcase.priority, weights, 100)
+		testutil.Equals(t, tcase.exp, keys(sched.Schedule(groups)))
+	}
+}
+
+func TestScheduler_Steps(t *testing.T) {
+	var weights []GroupWeight
+	for _, s := range []string{`{cluster="a"}=3`, `{cluster="b"}=2.5`, `{cluster="c"}=0.5`} {
+		w, err := ParseGroupWeight(s)
+		testutil.Ok(t, err)
+		weights = append(weights, w)
+	}
+	sched := NewScheduler(nil, PriorityNone, weights, 100)
+
+	for cluster, exp := range map[string]int{"a": 3, "b": 3, "c": 1, "d": 1} {
+		g := testGroup(t, labels.FromStrings("cluster", cluster), nil)
+		testutil.Equals(t, exp, sched.Steps(g))
+	}
+	var nilSched *Scheduler
+	testutil.Equals(t, 1, nilSched.Steps(testGroup(t, labels.FromStrings("cluster", "a"), nil)))
+}
+
+func testGroup(t *testing.T, lset labels.Labels, ranges [][2]int64) *Group {
+	g, err := newGroup(nil, nil, lset, 0, block.ThanosShardMeta{},
+		prometheus.NewCounter(prometheus.CounterOpts{}),
+		prometheus.NewCounter(prometheus.CounterOpts{}),
+		prometheus.NewCounter(prometheus.CounterOpts{}),
+		false,
+	)
+	testutil.Ok(t, err)
+
+	for i, r := range ranges {
+		m := &block.Meta{BlockMeta: tsdb.BlockMeta{ULID: ulid.MustNew(uint64(i), nil), MinTime: r[0], MaxTime: r[1]}}
+		m.Thanos.Labels = lset.Map()
+		testutil.Ok(t, g.Add(m))
+	}
+	return g
+}
@@ -1,6 +1,7 @@
 package cache
 
 import (
+	"strings"
 	"sync"
 
 	"github.com/prometheus/common/model"
@@ -48,3 +49,26 @@ func (c *Cache) Addresses() []string {
 	}
 	return addresses
 }
+
+// AddressLabels returns the labels of all the addresses from all target groups present in the Cache.
+// Labels of a target override the labels of its group. Labels with the reserved "__" prefix are omitted.
+func (c *Cache) AddressLabels() map[string]model.LabelSet {
+	c.Lock()
+	defer c.Unlock()
+	res := make(map[string]model.LabelSet)
+	for _, group := range c.tgs {
+		for _, target := range group.Targets {
+			lset := model.LabelSet{}
+			for _, ls := range []model.LabelSet{group.Labels, target} {
+				for n, v := range ls {
+					if strings.HasPrefix(string(n), model.ReservedLabelPrefix) {
+						continue
+					}
+					lset[n] = v
+				}
+			}
+			res[string(target[model.AddressLabel])] = lset
+		}
+	}
+	return res
+}
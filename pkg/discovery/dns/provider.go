@@ -106,6 +106,17 @@ func (p *Provider) Addresses() []string {
 	return result
 }
 
+// ResolvedAddresses returns the latest addresses present in the Provider by the address they were resolved from.
+func (p *Provider) ResolvedAddresses() map[string][]string {
+	p.Lock()
+	defer p.Unlock()
+	result := make(map[string][]string, len(p.resolved))
+	for addr, addrs := range p.resolved {
+		result[addr] = append([]string(nil), addrs...)
+	}
+	return result
+}
+
 func contains(slice []string, str string) bool {
 	for _, s := range slice {
 		if str == s {
@@ -0,0 +1,368 @@
+// Package audit implements an objstore.Bucket decorator that records every mutating operation
+// (Upload and Delete) made against the bucket, so it is possible to tell later on which component
+// and for what reason an object was created or removed.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"math/rand"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/improbable-eng/thanos/pkg/objstore"
+	"github.com/improbable-eng/thanos/pkg/runutil"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// DefaultPrefix is the bucket directory audit records are written to if none is specified.
+	DefaultPrefix = "audit"
+
+	// maxBufferedRecords is the number of records kept in memory before they are flushed as a new object.
+	maxBufferedRecords = 100
+	// maxPendingRecords is the number of records kept in memory while flushes fail. The oldest records are dropped
+	// beyond it.
+	maxPendingRecords = 10000
+	// flushInterval is the maximum age of buffered records before they are flushed as a new object.
+	flushInterval = 1 * time.Minute
+	// flushTimeout is the timeout of uploading a new object.
+	flushTimeout = 1 * time.Minute
+)
+
+// Op is a type of audited bucket operation.
+type Op string
+
+const (
+	OpUpload Op = "upload"
+	OpDelete Op = "delete"
+)
+
+// Record describes a single mutating operation against the bucket.
+type Record struct {
+	Time      time.Time `json:"time"`
+	Op        Op        `json:"op"`
+	Name      string    `json:"name"`
+	Component string    `json:"component"`
+	Hostname  string    `json:"hostname"`
+	Reason    string    `json:"reason,omitempty"`
+	Err       string    `json:"err,omitempty"`
+}
+
+// Config configures where audit records are written to. Records are written to the
+// bucket if Prefix is set and to the local file if File is set. Both can be used at the same time.
+type Config struct {
+	// Prefix is a bucket directory under which records are stored as append-only JSON-lines objects.
+	Prefix string `yaml:"prefix"`
+	// File is a path to a local file to which records are appended in JSON-lines format.
+	File string `yaml:"file"`
+}
+
+// Enabled returns true if any audit destination is configured.
+func (c Config) Enabled() bool {
+	return c.Prefix != "" || c.File != ""
+}
+
+type reasonKey struct{}
+
+// WithReason returns a context that attaches the given reason to all audited operations
+// made with it, e.g "retention", "gc" or "repair index_issue".
+func WithReason(ctx context.Context, reason string) context.Context {
+	return context.WithValue(ctx, reasonKey{}, reason)
+}
+
+// Reason returns the reason attached to the context, or an empty string if there is none.
+func Reason(ctx context.Context) string {
+	r, _ := ctx.Value(reasonKey{}).(string)
+	return r
+}
+
+// Bucket is an objstore.Bucket that records all mutating operations.
+type Bucket struct {
+	objstore.Bucket
+
+	logger    log.Logger
+	component string
+	hostname  string
+	prefix    string
+
+	mtx  sync.Mutex
+	file *os.File
+	buf  []Record
+
+	// flushMtx serializes flushes, so that objects are uploaded in the order of their records.
+	flushMtx sync.Mutex
+	entropy  *rand.Rand
+
+	flushc chan struct{}
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	droppedRecords prometheus.Counter
+}
+
+// NewBucket returns a Bucket that audits all Upload and Delete operations made against bkt.
+// Records of the bucket destination are buffered and flushed in the background periodically, once enough of them
+// are buffered and on Close.
+func NewBucket(logger log.Logger, reg prometheus.Registerer, bkt objstore.Bucket, conf Config, component string) (*Bucket, error) {
+	if !conf.Enabled() {
+		return nil, errors.New("no audit destination configured")
+	}
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, errors.Wrap(err, "get hostname")
+	}
+
+	b := &Bucket{
+		Bucket:    bkt,
+		logger:    logger,
+		component: component,
+		hostname:  hostname,
+		prefix:    strings.TrimSuffix(conf.Prefix, objstore.DirDelim),
+		entropy:   rand.New(rand.NewSource(time.Now().UnixNano())),
+		flushc:    make(chan struct{}, 1),
+		done:      make(chan struct{}),
+		droppedRecords: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "thanos_objstore_audit_dropped_records_total",
+			Help:        "Total number of audit records dropped because they could not be flushed into the bucket.",
+			ConstLabels: prometheus.Labels{"bucket": bkt.Name()},
+		}),
+	}
+	if reg != nil {
+		reg.MustRegister(b.droppedRecords)
+	}
+	if conf.File != "" {
+		b.file, err = os.OpenFile(conf.File, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+		if err != nil {
+			return nil, errors.Wrapf(err, "open audit file %s", conf.File)
+		}
+	}
+
+	// Flushes are independent of the operations that recorded, so they have their own context.
+	ctx, cancel := context.WithCancel(context.Background())
+	b.cancel = cancel
+	if b.prefix == "" {
+		close(b.done)
+		return b, nil
+	}
+	go b.runFlusher(ctx)
+	return b, nil
+}
+
+// runFlusher flushes buffered records periodically and whenever enough of them are buffered until the context
+// is canceled.
+func (b *Bucket) runFlusher(ctx context.Context) {
+	defer close(b.done)
+
+	t := time.NewTicker(flushInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		case <-b.flushc:
+		}
+		fctx, cancel := context.WithTimeout(ctx, flushTimeout)
+		if err := b.Flush(fctx); err != nil {
+			level.Warn(b.logger).Log("msg", "failed to flush audit records", "err", err)
+		}
+		cancel()
+	}
+}
+
+// Upload the contents of the reader as an object into the bucket and records the operation.
+func (b *Bucket) Upload(ctx context.Context, name string, r io.Reader) error {
+	err := b.Bucket.Upload(ctx, name, r)
+	b.record(ctx, OpUpload, name, err)
+	return err
+}
+
+// Delete removes the object with the given name and records the operation.
+func (b *Bucket) Delete(ctx context.Context, name string) error {
+	err := b.Bucket.Delete(ctx, name)
+	b.record(ctx, OpDelete, name, err)
+	return err
+}
+
+// Flush writes all buffered records into the bucket. Records are kept for the next flush if it fails.
+func (b *Bucket) Flush(ctx context.Context) error {
+	b.flushMtx.Lock()
+	defer b.flushMtx.Unlock()
+
+	b.mtx.Lock()
+	recs := b.buf
+	b.buf = nil
+	b.mtx.Unlock()
+
+	if len(recs) == 0 {
+		return nil
+	}
+	if err := b.upload(ctx, recs); err != nil {
+		// Keep the records in front of those recorded in the meantime.
+		b.mtx.Lock()
+		b.buf = append(recs, b.buf...)
+		b.dropExcessLocked()
+		b.mtx.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Close stops the background flusher, flushes all buffered records and closes the underlying bucket.
+func (b *Bucket) Close() error {
+	b.cancel()
+	<-b.done
+
+	// Use an uncancelable context to not lose records on shutdown.
+	ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+	defer cancel()
+
+	if err := b.Flush(ctx); err != nil {
+		level.Warn(b.logger).Log("msg", "failed to flush audit records", "err", err)
+	}
+	if b.file != nil {
+		runutil.CloseWithLogOnErr(b.logger, b.file, "audit file")
+	}
+	return b.Bucket.Close()
+}
+
+func (b *Bucket) record(ctx context.Context, op Op, name string, err error) {
+	rec := Record{
+		Time:      time.Now().UTC(),
+		Op:        op,
+		Name:      name,
+		Component: b.component,
+		Hostname:  b.hostname,
+		Reason:    Reason(ctx),
+	}
+	if err != nil {
+		rec.Err = err.Error()
+	}
+
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	if b.file != nil {
+		if err := json.NewEncoder(b.file).Encode(&rec); err != nil {
+			level.Warn(b.logger).Log("msg", "failed to write audit record to file", "name", name, "err", err)
+		}
+	}
+	if b.prefix == "" {
+		return
+	}
+
+	b.buf = append(b.buf, rec)
+	b.dropExcessLocked()
+	if len(b.buf) < maxBufferedRecords {
+		return
+	}
+	// Wake up the flusher unless it is already about to flush.
+	select {
+	case b.flushc <- struct{}{}:
+	default:
+	}
+}
+
+// dropExcessLocked drops the oldest buffered records beyond maxPendingRecords. The caller must hold the lock.
+func (b *Bucket) dropExcessLocked() {
+	n := len(b.buf) - maxPendingRecords
+	if n <= 0 {
+		return
+	}
+	b.buf = append(b.buf[:0], b.buf[n:]...)
+	b.droppedRecords.Add(float64(n))
+	level.Warn(b.logger).Log("msg", "dropped audit records that could not be flushed", "dropped", n)
+}
+
+// upload uploads the records as a new object. Object names are ULIDs, so they sort by time of the flush.
+func (b *Bucket) upload(ctx context.Context, recs []Record) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for i := range recs {
+		if err := enc.Encode(&recs[i]); err != nil {
+			return errors.Wrap(err, "encode audit record")
+		}
+	}
+
+	id := ulid.MustNew(ulid.Now(), b.entropy)
+	name := path.Join(b.prefix, id.String()+".jsonl")
+
+	// Audit objects are written to the underlying bucket directly, so they are not audited themselves.
+	return errors.Wrapf(b.Bucket.Upload(ctx, name, &buf), "upload audit object %s", name)
+}
+
+// Read calls f for each audit record stored under the prefix directory of the bucket, in the order they were flushed.
+func Read(ctx context.Context, logger log.Logger, bkt objstore.BucketReader, prefix string, f func(Record) error) error {
+	var names []string
+	if err := bkt.Iter(ctx, prefix, func(name string) error {
+		if strings.HasSuffix(name, objstore.DirDelim) {
+			return nil
+		}
+		names = append(names, name)
+		return nil
+	}); err != nil {
+		return errors.Wrap(err, "list audit objects")
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := readObject(ctx, logger, bkt, name, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readObject(ctx context.Context, logger log.Logger, bkt objstore.BucketReader, name string, f func(Record) error) error {
+	rc, err := bkt.Get(ctx, name)
+	if err != nil {
+		return errors.Wrapf(err, "get audit object %s", name)
+	}
+	defer runutil.CloseWithLogOnErr(logger, rc, "audit object reader")
+
+	return errors.Wrapf(decode(rc, f), "decode audit object %s", name)
+}
+
+// ReadFile calls f for each audit record stored in the given local file.
+func ReadFile(logger log.Logger, fn string, f func(Record) error) error {
+	r, err := os.Open(fn)
+	if err != nil {
+		return errors.Wrap(err, "open audit file")
+	}
+	defer runutil.CloseWithLogOnErr(logger, r, "audit file reader")
+
+	return errors.Wrapf(decode(r, f), "decode audit file %s", fn)
+}
+
+func decode(r io.Reader, f func(Record) error) error {
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		if len(bytes.TrimSpace(s.Bytes())) == 0 {
+			continue
+		}
+		var rec Record
+		if err := json.Unmarshal(s.Bytes(), &rec); err != nil {
+			return err
+		}
+		if err := f(rec); err != nil {
+			return err
+		}
+	}
+	return s.Err()
+}
@@ -0,0 +1,158 @@
+package audit_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/improbable-eng/thanos/pkg/objstore"
+	"github.com/improbable-eng/thanos/pkg/objstore/audit"
+	"github.com/improbable-eng/thanos/pkg/objstore/inmem"
+	"github.com/improbable-eng/thanos/pkg/testutil"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestBucket_RecordsMutatingOperations(t *testing.T) {
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", "audit-test")
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, os.RemoveAll(dir)) }()
+
+	inner := inmem.NewBucket()
+	bkt, err := audit.NewBucket(log.NewNopLogger(), nil, inner, audit.Config{Prefix: "audit", File: filepath.Join(dir, "audit.jsonl")}, "test")
+	testutil.Ok(t, err)
+
+	testutil.Ok(t, bkt.Upload(audit.WithReason(ctx, "upload reason"), "id1/meta.json", bytes.NewReader([]byte("{}"))))
+	testutil.Ok(t, bkt.Delete(audit.WithReason(ctx, "gc"), "id1/meta.json"))
+
+	// Reads are not recorded.
+	_, err = bkt.Exists(ctx, "id1/meta.json")
+	testutil.Ok(t, err)
+
+	// Nothing is flushed into the bucket until close.
+	var recs []audit.Record
+	testutil.Ok(t, audit.Read(ctx, nil, inner, "audit", func(r audit.Record) error {
+		recs = append(recs, r)
+		return nil
+	}))
+	testutil.Equals(t, 0, len(recs))
+
+	testutil.Ok(t, bkt.Close())
+
+	// Audit objects themselves are not audited.
+	for name := range inner.Objects() {
+		testutil.Assert(t, strings.HasPrefix(name, "audit/"), "unexpected object %s", name)
+	}
+
+	check := func(recs []audit.Record) {
+		testutil.Equals(t, 2, len(recs))
+
+		testutil.Equals(t, audit.OpUpload, recs[0].Op)
+		testutil.Equals(t, "id1/meta.json", recs[0].Name)
+		testutil.Equals(t, "upload reason", recs[0].Reason)
+		testutil.Equals(t, "test", recs[0].Component)
+
+		testutil.Equals(t, audit.OpDelete, recs[1].Op)
+		testutil.Equals(t, "gc", recs[1].Reason)
+	}
+
+	testutil.Ok(t, audit.Read(ctx, nil, inner, "audit", func(r audit.Record) error {
+		recs = append(recs, r)
+		return nil
+	}))
+	check(recs)
+
+	recs = recs[:0]
+	testutil.Ok(t, audit.ReadFile(nil, filepath.Join(dir, "audit.jsonl"), func(r audit.Record) error {
+		recs = append(recs, r)
+		return nil
+	}))
+	check(recs)
+}
+
+func TestNewBucket_NoDestination(t *testing.T) {
+	_, err := audit.NewBucket(log.NewNopLogger(), nil, inmem.NewBucket(), audit.Config{}, "test")
+	testutil.NotOk(t, err)
+}
+
+// auditFailingBucket fails uploads of audit objects while fail is set.
+type auditFailingBucket struct {
+	objstore.Bucket
+
+	mtx  sync.Mutex
+	fail bool
+}
+
+func (b *auditFailingBucket) setFail(fail bool) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.fail = fail
+}
+
+func (b *auditFailingBucket) Upload(ctx context.Context, name string, r io.Reader) error {
+	b.mtx.Lock()
+	fail := b.fail
+	b.mtx.Unlock()
+
+	if fail && strings.HasPrefix(name, "audit/") {
+		return errors.New("upload failed")
+	}
+	return b.Bucket.Upload(ctx, name, r)
+}
+
+func TestBucket_DropsRecordsWhileFlushesFail(t *testing.T) {
+	ctx := context.Background()
+
+	inner := &auditFailingBucket{Bucket: inmem.NewBucket(), fail: true}
+	reg := prometheus.NewRegistry()
+	bkt, err := audit.NewBucket(log.NewNopLogger(), reg, inner, audit.Config{Prefix: "audit"}, "test")
+	testutil.Ok(t, err)
+
+	// Buffered records trigger background flushes that fail, so records pile up until the oldest are dropped.
+	const dropped = 50
+	for i := 0; i < 10000+dropped; i++ {
+		testutil.Ok(t, bkt.Upload(ctx, fmt.Sprintf("obj%d", i), bytes.NewReader(nil)))
+	}
+	// Once a flush failed after all operations, the oldest records are dropped.
+	testutil.NotOk(t, bkt.Flush(ctx))
+	inner.setFail(false)
+	testutil.Ok(t, bkt.Close())
+
+	var recs []audit.Record
+	testutil.Ok(t, audit.Read(ctx, nil, inner, "audit", func(r audit.Record) error {
+		recs = append(recs, r)
+		return nil
+	}))
+	testutil.Equals(t, 10000, len(recs))
+	testutil.Equals(t, fmt.Sprintf("obj%d", dropped), recs[0].Name)
+	testutil.Equals(t, fmt.Sprintf("obj%d", 10000+dropped-1), recs[len(recs)-1].Name)
+
+	mfs, err := reg.Gather()
+	testutil.Ok(t, err)
+	testutil.Equals(t, 1, len(mfs))
+	testutil.Equals(t, float64(dropped), mfs[0].GetMetric()[0].GetCounter().GetValue())
+
+	// Audited buckets of a single process, e.g. source and backup bucket, register their metrics side by side.
+	backup, err := audit.NewBucket(log.NewNopLogger(), reg, namedBucket{Bucket: inmem.NewBucket(), name: "backup"}, audit.Config{Prefix: "audit"}, "test")
+	testutil.Ok(t, err)
+	testutil.Ok(t, backup.Close())
+}
+
+type namedBucket struct {
+	objstore.Bucket
+	name string
+}
+
+func (b namedBucket) Name() string {
+	return b.name
+}
@@ -13,6 +13,7 @@ import (
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 	"github.com/improbable-eng/thanos/pkg/objstore"
+	"github.com/improbable-eng/thanos/pkg/secret"
 	"github.com/pkg/errors"
 	yaml "gopkg.in/yaml.v2"
 )
@@ -27,9 +28,10 @@ const (
 
 // Config Azure storage configuration.
 type Config struct {
-	StorageAccountName string `yaml:"storage_account"`
-	StorageAccountKey  string `yaml:"storage_account_key"`
-	ContainerName      string `yaml:"container"`
+	StorageAccountName    string `yaml:"storage_account"`
+	StorageAccountKey     string `yaml:"storage_account_key"`
+	StorageAccountKeyFile string `yaml:"storage_account_key_file"`
+	ContainerName         string `yaml:"container"`
 }
 
 // Bucket implements the store.Bucket interface against Azure APIs.
@@ -48,6 +50,25 @@ func (conf *Config) validate() error {
 	return nil
 }
 
+// ValidateConfig checks the bucket configuration strictly without reading secrets or connecting to Azure.
+func ValidateConfig(azureConfig []byte) error {
+	var conf Config
+	if err := yaml.UnmarshalStrict(azureConfig, &conf); err != nil {
+		return err
+	}
+	if conf.StorageAccountKey == "" {
+		// The key is read from the file when creating the client.
+		conf.StorageAccountKey = conf.StorageAccountKeyFile
+	}
+	if err := conf.validate(); err != nil {
+		return err
+	}
+	if conf.ContainerName == "" {
+		return errors.New("missing Azure storage container name")
+	}
+	return nil
+}
+
 // NewBucket returns a new Bucket using the provided Azure config.
 func NewBucket(logger log.Logger, azureConfig []byte, component string) (*Bucket, error) {
 	level.Debug(logger).Log("msg", "creating new Azure bucket connection", "component", component)
@@ -57,6 +78,12 @@ func NewBucket(logger log.Logger, azureConfig []byte, component string) (*Bucket
 		return nil, err
 	}
 
+	key, err := secret.ReadFile(conf.StorageAccountKey, conf.StorageAccountKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	conf.StorageAccountKey, conf.StorageAccountKeyFile = key, ""
+
 	if err := conf.validate(); err != nil {
 		return nil, err
 	}
@@ -8,10 +8,12 @@ import (
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 	"github.com/improbable-eng/thanos/pkg/objstore"
+	"github.com/improbable-eng/thanos/pkg/objstore/audit"
 	"github.com/improbable-eng/thanos/pkg/objstore/azure"
 	"github.com/improbable-eng/thanos/pkg/objstore/gcs"
 	"github.com/improbable-eng/thanos/pkg/objstore/s3"
 	"github.com/improbable-eng/thanos/pkg/objstore/swift"
+	"github.com/improbable-eng/thanos/pkg/secret"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	"gopkg.in/yaml.v2"
@@ -29,12 +31,73 @@ const (
 type BucketConfig struct {
 	Type   objProvider `yaml:"type"`
 	Config interface{} `yaml:"config"`
+	// Audit optionally enables recording of all mutating operations made against the bucket.
+	Audit audit.Config `yaml:"audit"`
 }
 
 var ErrNotFound = errors.New("not found bucket")
 
+// provider validates configurations of an object storage provider and creates its clients.
+type provider struct {
+	validate  func(conf []byte) error
+	newBucket func(logger log.Logger, conf []byte, component string) (objstore.Bucket, error)
+}
+
+// providers holds all supported providers, so that every provider that clients can be created for is validated as well.
+var providers = map[objProvider]provider{
+	GCS: {
+		validate: gcs.ValidateConfig,
+		newBucket: func(logger log.Logger, conf []byte, component string) (objstore.Bucket, error) {
+			return gcs.NewBucket(context.Background(), logger, conf, component)
+		},
+	},
+	S3: {
+		validate: s3.ValidateConfig,
+		newBucket: func(logger log.Logger, conf []byte, component string) (objstore.Bucket, error) {
+			return s3.NewBucket(logger, conf, component)
+		},
+	},
+	AZURE: {
+		validate: azure.ValidateConfig,
+		newBucket: func(logger log.Logger, conf []byte, component string) (objstore.Bucket, error) {
+			return azure.NewBucket(logger, conf, component)
+		},
+	},
+	SWIFT: {
+		validate: swift.ValidateConfig,
+		newBucket: func(logger log.Logger, conf []byte, _ string) (objstore.Bucket, error) {
+			return swift.NewContainer(logger, conf)
+		},
+	},
+}
+
+// ValidateConfig strictly checks the bucket configuration, including the configuration of the provider, without creating a client.
+// References to secrets are not resolved, so that configurations can be checked without access to them.
+func ValidateConfig(confContentYaml []byte) error {
+	if len(confContentYaml) == 0 {
+		return ErrNotFound
+	}
+
+	bucketConf := &BucketConfig{}
+	if err := yaml.UnmarshalStrict(confContentYaml, bucketConf); err != nil {
+		return errors.Wrap(err, "parsing config YAML file")
+	}
+
+	config, err := yaml.Marshal(bucketConf.Config)
+	if err != nil {
+		return errors.Wrap(err, "marshal content of bucket configuration")
+	}
+
+	p, ok := providers[objProvider(strings.ToUpper(string(bucketConf.Type)))]
+	if !ok {
+		return errors.Errorf("bucket with type %s is not supported", bucketConf.Type)
+	}
+	return errors.Wrapf(p.validate(config), "invalid %s configuration", bucketConf.Type)
+}
+
 // NewBucket initializes and returns new object storage clients.
-// NOTE: confContentYaml can contain secrets.
+// NOTE: confContentYaml can contain secrets. References to secrets, e.g. $(file:/path/to/key) or $(env:SECRET_KEY),
+// in string values of the provider configuration are resolved before the client is created.
 func NewBucket(logger log.Logger, confContentYaml []byte, reg *prometheus.Registry, component string) (objstore.Bucket, error) {
 	level.Info(logger).Log("msg", "loading bucket configuration")
 	if len(confContentYaml) == 0 {
@@ -45,27 +108,37 @@ func NewBucket(logger log.Logger, confContentYaml []byte, reg *prometheus.Regist
 	if err := yaml.UnmarshalStrict(confContentYaml, bucketConf); err != nil {
 		return nil, errors.Wrap(err, "parsing config YAML file")
 	}
+	if redacted, err := secret.RedactYAML(confContentYaml); err == nil {
+		level.Debug(logger).Log("msg", "bucket configuration", "config", string(redacted))
+	}
 
-	config, err := yaml.Marshal(bucketConf.Config)
+	conf, err := secret.ExpandValues(bucketConf.Config)
+	if err != nil {
+		return nil, errors.Wrap(err, "resolve secrets of bucket configuration")
+	}
+
+	config, err := yaml.Marshal(conf)
 	if err != nil {
 		return nil, errors.Wrap(err, "marshal content of bucket configuration")
 	}
 
-	var bucket objstore.Bucket
-	switch strings.ToUpper(string(bucketConf.Type)) {
-	case string(GCS):
-		bucket, err = gcs.NewBucket(context.Background(), logger, config, component)
-	case string(S3):
-		bucket, err = s3.NewBucket(logger, config, component)
-	case string(AZURE):
-		bucket, err = azure.NewBucket(logger, config, component)
-	case string(SWIFT):
-		bucket, err = swift.NewContainer(logger, config)
-	default:
+	p, ok := providers[objProvider(strings.ToUpper(string(bucketConf.Type)))]
+	if !ok {
 		return nil, errors.Errorf("bucket with type %s is not supported", bucketConf.Type)
 	}
+	bucket, err := p.newBucket(logger, config, component)
 	if err != nil {
 		return nil, errors.Wrap(err, fmt.Sprintf("create %s client", bucketConf.Type))
 	}
-	return objstore.BucketWithMetrics(bucket.Name(), bucket, reg), nil
+	bucket = objstore.BucketWithMetrics(bucket.Name(), bucket, reg)
+
+	if bucketConf.Audit.Enabled() {
+		level.Info(logger).Log("msg", "audit of mutating bucket operations enabled", "prefix", bucketConf.Audit.Prefix, "file", bucketConf.Audit.File)
+		abkt, err := audit.NewBucket(logger, reg, bucket, bucketConf.Audit, component)
+		if err != nil {
+			return nil, errors.Wrap(err, "create audit bucket")
+		}
+		return abkt, nil
+	}
+	return bucket, nil
 }
@@ -1,11 +1,13 @@
 package client
 
 import (
+	"os"
 	"testing"
 
 	"github.com/improbable-eng/thanos/pkg/testutil"
 
 	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 const unknownTypeConfig = `type: UNKNOWN
@@ -31,3 +33,47 @@ func TestNewBucketNoConfig(t *testing.T) {
 	testutil.NotOk(t, err)
 	testutil.Assert(t, err == ErrNotFound, "it should error with not found")
 }
+
+const secretRefConfig = `type: S3
+config:
+  bucket: test-bucket
+  endpoint: localhost:9000
+  access_key: key-id
+  secret_key: $(env:THANOS_CLIENT_TEST_SECRET_KEY)`
+
+func TestNewBucketSecretRef(t *testing.T) {
+	_, err := NewBucket(log.NewNopLogger(), []byte(secretRefConfig), nil, "bkt-client-test")
+	testutil.NotOk(t, err)
+
+	testutil.Ok(t, os.Setenv("THANOS_CLIENT_TEST_SECRET_KEY", "secret"))
+	defer func() { testutil.Ok(t, os.Unsetenv("THANOS_CLIENT_TEST_SECRET_KEY")) }()
+
+	_, err = NewBucket(log.NewNopLogger(), []byte(secretRefConfig), prometheus.NewRegistry(), "bkt-client-test")
+	testutil.Ok(t, err)
+}
+
+func TestValidateConfig(t *testing.T) {
+	testutil.Assert(t, ValidateConfig(nil) == ErrNotFound, "it should error with not found")
+
+	for _, tcase := range []struct {
+		conf string
+		ok   bool
+	}{
+		{conf: secretRefConfig, ok: true},
+		{conf: "type: GCS\nconfig:\n  bucket: test-bucket", ok: true},
+		{conf: "type: GCS\nconfig:\n  bucket: test-bucket\naudit:\n  prefix: audit", ok: true},
+		{conf: "type: AZURE\nconfig:\n  storage_account: acc\n  storage_account_key_file: /path/to/key\n  container: c", ok: true},
+		{conf: "type: SWIFT\nconfig:\n  auth_url: http://localhost\n  container_name: c", ok: true},
+		{conf: unknownTypeConfig},
+		{conf: blankGCSConfig},
+		// Unknown fields are rejected.
+		{conf: "type: GCS\nconfig:\n  bucket: test-bucket\n  region: eu"},
+		{conf: "type: GCS\nconfig:\n  bucket: test-bucket\nregion: eu"},
+		// Secret keys without access keys are rejected.
+		{conf: "type: S3\nconfig:\n  bucket: test-bucket\n  endpoint: localhost:9000\n  secret_key_file: /path/to/key"},
+		{conf: "type: AZURE\nconfig:\n  storage_account: acc\n  storage_account_key: key"},
+	} {
+		err := ValidateConfig([]byte(tcase.conf))
+		testutil.Assert(t, (err == nil) == tcase.ok, "unexpected validation result %v for config %q", err, tcase.conf)
+	}
+}
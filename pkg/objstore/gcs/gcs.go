@@ -38,6 +38,18 @@ type Bucket struct {
 	closer io.Closer
 }
 
+// ValidateConfig checks the bucket configuration strictly without connecting to GCS.
+func ValidateConfig(conf []byte) error {
+	var gc gcsConfig
+	if err := yaml.UnmarshalStrict(conf, &gc); err != nil {
+		return err
+	}
+	if gc.Bucket == "" {
+		return errors.New("missing Google Cloud Storage bucket name for stored blocks")
+	}
+	return nil
+}
+
 // NewBucket returns a new Bucket against the given bucket handle.
 func NewBucket(ctx context.Context, logger log.Logger, conf []byte, component string) (*Bucket, error) {
 	var gc gcsConfig
@@ -18,6 +18,7 @@ import (
 	"github.com/go-kit/kit/log"
 	"github.com/improbable-eng/thanos/pkg/objstore"
 	"github.com/improbable-eng/thanos/pkg/runutil"
+	"github.com/improbable-eng/thanos/pkg/secret"
 	"github.com/minio/minio-go"
 	"github.com/minio/minio-go/pkg/credentials"
 	"github.com/minio/minio-go/pkg/encrypt"
@@ -39,6 +40,7 @@ type Config struct {
 	SignatureV2   bool       `yaml:"signature_version2"`
 	SSEEncryption bool       `yaml:"encrypt_sse"`
 	SecretKey     string     `yaml:"secret_key"`
+	SecretKeyFile string     `yaml:"secret_key_file"`
 	HTTPConfig    HTTPConfig `yaml:"http_config"`
 }
 
@@ -65,6 +67,22 @@ func ParseConfig(conf []byte) (Config, error) {
 	return config, nil
 }
 
+// ValidateConfig checks the bucket configuration strictly without reading secrets or connecting to s3.
+func ValidateConfig(conf []byte) error {
+	var config Config
+	if err := yaml.UnmarshalStrict(conf, &config); err != nil {
+		return err
+	}
+	if config.Bucket == "" {
+		return errors.New("missing s3 bucket name")
+	}
+	if config.SecretKey == "" {
+		// The secret key is read from the file when creating the client.
+		config.SecretKey = config.SecretKeyFile
+	}
+	return Validate(config)
+}
+
 // NewBucket returns a new Bucket using the provided s3 config values.
 func NewBucket(logger log.Logger, conf []byte, component string) (*Bucket, error) {
 	config, err := ParseConfig(conf)
@@ -79,6 +97,12 @@ func NewBucket(logger log.Logger, conf []byte, component string) (*Bucket, error
 func NewBucketWithConfig(logger log.Logger, config Config, component string) (*Bucket, error) {
 	var chain []credentials.Provider
 
+	secretKey, err := secret.ReadFile(config.SecretKey, config.SecretKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	config.SecretKey, config.SecretKeyFile = secretKey, ""
+
 	if err := Validate(config); err != nil {
 		return nil, err
 	}
@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"github.com/improbable-eng/thanos/pkg/objstore"
+	"github.com/improbable-eng/thanos/pkg/secret"
 
 	"github.com/go-kit/kit/log"
 	"github.com/gophercloud/gophercloud"
@@ -31,6 +32,7 @@ type swiftConfig struct {
 	Username      string `yaml:"username,omitempty"`
 	UserId        string `yaml:"user_id,omitempty"`
 	Password      string `yaml:"password"`
+	PasswordFile  string `yaml:"password_file,omitempty"`
 	DomainId      string `yaml:"domain_id,omitempty"`
 	DomainName    string `yaml:"domain_name,omitempty"`
 	TenantID      string `yaml:"tenant_id,omitempty"`
@@ -45,17 +47,37 @@ type Container struct {
 	name   string
 }
 
+// ValidateConfig checks the container configuration strictly without reading secrets or connecting to Swift.
+func ValidateConfig(conf []byte) error {
+	var sc swiftConfig
+	if err := yaml.UnmarshalStrict(conf, &sc); err != nil {
+		return err
+	}
+	if sc.AuthUrl == "" {
+		return errors.New("missing Swift auth URL")
+	}
+	if sc.ContainerName == "" {
+		return errors.New("missing Swift container name")
+	}
+	return nil
+}
+
 func NewContainer(logger log.Logger, conf []byte) (*Container, error) {
 	var sc swiftConfig
 	if err := yaml.Unmarshal(conf, &sc); err != nil {
 		return nil, err
 	}
 
+	password, err := secret.ReadFile(sc.Password, sc.PasswordFile)
+	if err != nil {
+		return nil, err
+	}
+
 	authOpts := gophercloud.AuthOptions{
 		IdentityEndpoint: sc.AuthUrl,
 		Username:         sc.Username,
 		UserID:           sc.UserId,
-		Password:         sc.Password,
+		Password:         password,
 		DomainID:         sc.DomainId,
 		DomainName:       sc.DomainName,
 		TenantID:         sc.TenantID,
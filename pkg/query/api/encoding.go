@@ -0,0 +1,174 @@
+package v1
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/improbable-eng/thanos/pkg/query/api/apipb"
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql"
+)
+
+const (
+	// ContentTypeJSON is the content type of JSON encoded responses, which all clients understand.
+	ContentTypeJSON = "application/json"
+	// ContentTypeProtobuf is the content type of responses encoded as apipb.Response. Clients request it
+	// through the Accept header. Only vector, matrix and series results are encoded as protobuf,
+	// all other responses, including errors, are always encoded as JSON.
+	ContentTypeProtobuf = "application/x-protobuf"
+)
+
+// acceptsProtobuf returns whether the request accepts protobuf encoded responses.
+func acceptsProtobuf(r *http.Request) bool {
+	for _, accept := range r.Header["Accept"] {
+		for _, v := range strings.Split(accept, ",") {
+			mt, params, err := mime.ParseMediaType(strings.TrimSpace(v))
+			if err != nil || mt != ContentTypeProtobuf {
+				continue
+			}
+			if q, ok := params["q"]; ok && strings.Trim(q, "0.") == "" {
+				continue
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// protobufResponse returns the protobuf encoding of the response data. It returns false if the data
+// has no protobuf encoding.
+func protobufResponse(data interface{}, warnings []string) (*apipb.Response, bool) {
+	resp := &apipb.Response{Warnings: warnings}
+
+	switch d := data.(type) {
+	case *queryData:
+		switch v := d.Result.(type) {
+		case promql.Vector:
+			vec := &apipb.Vector{Samples: make([]apipb.Sample, 0, len(v))}
+			for _, s := range v {
+				vec.Samples = append(vec.Samples, apipb.Sample{
+					Labels: protobufLabels(s.Metric),
+					Point:  apipb.Point{Timestamp: s.T, Value: s.V},
+				})
+			}
+			resp.Data = &apipb.Response_Vector{Vector: vec}
+		case promql.Matrix:
+			mat := &apipb.Matrix{Series: make([]apipb.Series, 0, len(v))}
+			for _, s := range v {
+				series := apipb.Series{
+					Labels: protobufLabels(s.Metric),
+					Points: make([]apipb.Point, 0, len(s.Points)),
+				}
+				for _, p := range s.Points {
+					series.Points = append(series.Points, apipb.Point{Timestamp: p.T, Value: p.V})
+				}
+				mat.Series = append(mat.Series, series)
+			}
+			resp.Data = &apipb.Response_Matrix{Matrix: mat}
+		default:
+			return nil, false
+		}
+	case []labels.Labels:
+		sets := &apipb.LabelSets{LabelSets: make([]apipb.LabelSet, 0, len(d))}
+		for _, lset := range d {
+			sets.LabelSets = append(sets.LabelSets, apipb.LabelSet{Labels: protobufLabels(lset)})
+		}
+		resp.Data = &apipb.Response_Series{Series: sets}
+	default:
+		return nil, false
+	}
+	return resp, true
+}
+
+func protobufLabels(lset labels.Labels) []apipb.Label {
+	res := make([]apipb.Label, 0, len(lset))
+	for _, l := range lset {
+		res = append(res, apipb.Label{Name: l.Name, Value: l.Value})
+	}
+	return res
+}
+
+func promLabels(lset []apipb.Label) labels.Labels {
+	res := make(labels.Labels, 0, len(lset))
+	for _, l := range lset {
+		res = append(res, labels.Label{Name: l.Name, Value: l.Value})
+	}
+	return res
+}
+
+// DecodeVector decodes the instant vector result of a query API response encoded according to the given content type.
+// Responses without a content type are decoded as JSON. It returns the warnings of the response along with the vector.
+func DecodeVector(contentType string, r io.Reader) (promql.Vector, []string, error) {
+	var mt string
+	if contentType != "" {
+		var err error
+		mt, _, err = mime.ParseMediaType(contentType)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "parse content type %q", contentType)
+		}
+	}
+
+	if mt == ContentTypeProtobuf {
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "read response")
+		}
+		var resp apipb.Response
+		if err := resp.Unmarshal(b); err != nil {
+			return nil, nil, errors.Wrap(err, "unmarshal protobuf response")
+		}
+		v, ok := resp.Data.(*apipb.Response_Vector)
+		if !ok {
+			return nil, nil, errors.Errorf("unexpected result %T, expected vector", resp.Data)
+		}
+		vec := make(promql.Vector, 0, len(v.Vector.Samples))
+		for _, s := range v.Vector.Samples {
+			vec = append(vec, promql.Sample{
+				Metric: promLabels(s.Labels),
+				Point:  promql.Point{T: s.Point.Timestamp, V: s.Point.Value},
+			})
+		}
+		return vec, resp.Warnings, nil
+	}
+
+	var resp struct {
+		Status    status `json:"status"`
+		ErrorType string `json:"errorType"`
+		Error     string `json:"error"`
+		Data      struct {
+			ResultType string       `json:"resultType"`
+			Result     model.Vector `json:"result"`
+		} `json:"data"`
+		Warnings []string `json:"warnings"`
+	}
+	if err := json.NewDecoder(r).Decode(&resp); err != nil {
+		return nil, nil, errors.Wrap(err, "decode JSON response")
+	}
+	if resp.Status == statusError {
+		return nil, nil, errors.Errorf("query failed with %s error: %s", resp.ErrorType, resp.Error)
+	}
+	if resp.Data.ResultType != string(promql.ValueTypeVector) {
+		return nil, nil, errors.Errorf("unexpected result type %q, expected vector", resp.Data.ResultType)
+	}
+	vec := make(promql.Vector, 0, len(resp.Data.Result))
+	for _, e := range resp.Data.Result {
+		lset := make(labels.Labels, 0, len(e.Metric))
+		for k, v := range e.Metric {
+			lset = append(lset, labels.Label{Name: string(k), Value: string(v)})
+		}
+		sort.Sort(lset)
+
+		vec = append(vec, promql.Sample{
+			Metric: lset,
+			Point:  promql.Point{T: int64(e.Timestamp), V: float64(e.Value)},
+		})
+	}
+	return vec, resp.Warnings, nil
+}
@@ -0,0 +1,192 @@
+package v1
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/improbable-eng/thanos/pkg/query/api/apipb"
+	"github.com/improbable-eng/thanos/pkg/testutil"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql"
+)
+
+func TestAcceptsProtobuf(t *testing.T) {
+	for _, tcase := range []struct {
+		accept string
+		exp    bool
+	}{
+		{accept: "", exp: false},
+		{accept: "application/json", exp: false},
+		{accept: "application/x-protobuf", exp: true},
+		{accept: "application/json, application/x-protobuf;q=0.5", exp: true},
+		{accept: "application/x-protobuf;q=0, application/json", exp: false},
+		{accept: "application/x-protobuf;q=0.0", exp: false},
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		if tcase.accept != "" {
+			r.Header.Set("Accept", tcase.accept)
+		}
+		testutil.Equals(t, tcase.exp, acceptsProtobuf(r))
+	}
+}
+
+func TestRespond_Protobuf(t *testing.T) {
+	var (
+		vec = promql.Vector{
+			{Metric: labels.FromStrings("__name__", "a", "b", "c"), Point: promql.Point{T: 1000, V: 1}},
+			{Metric: labels.FromStrings("__name__", "a", "b", "d"), Point: promql.Point{T: 1000, V: 2}},
+		}
+		mat = promql.Matrix{
+			{Metric: labels.FromStrings("__name__", "a"), Points: []promql.Point{{T: 1000, V: 1}, {T: 2000, V: 2}}},
+		}
+		series = []labels.Labels{labels.FromStrings("__name__", "a", "b", "c")}
+	)
+
+	for _, tcase := range []struct {
+		data    interface{}
+		exp     *apipb.Response
+		expJSON bool
+	}{
+		{
+			data: &queryData{ResultType: promql.ValueTypeVector, Result: vec},
+			exp: &apipb.Response{
+				Warnings: []string{"warning"},
+				Data: &apipb.Response_Vector{Vector: &apipb.Vector{Samples: []apipb.Sample{
+					{Labels: []apipb.Label{{Name: "__name__", Value: "a"}, {Name: "b", Value: "c"}}, Point: apipb.Point{Timestamp: 1000, Value: 1}},
+					{Labels: []apipb.Label{{Name: "__name__", Value: "a"}, {Name: "b", Value: "d"}}, Point: apipb.Point{Timestamp: 1000, Value: 2}},
+				}}},
+			},
+		},
+		{
+			data: &queryData{ResultType: promql.ValueTypeMatrix, Result: mat},
+			exp: &apipb.Response{
+				Warnings: []string{"warning"},
+				Data: &apipb.Response_Matrix{Matrix: &apipb.Matrix{Series: []apipb.Series{
+					{Labels: []apipb.Label{{Name: "__name__", Value: "a"}}, Points: []apipb.Point{{Timestamp: 1000, Value: 1}, {Timestamp: 2000, Value: 2}}},
+				}}},
+			},
+		},
+		{
+			data: series,
+			exp: &apipb.Response{
+				Warnings: []string{"warning"},
+				Data: &apipb.Response_Series{Series: &apipb.LabelSets{LabelSets: []apipb.LabelSet{
+					{Labels: []apipb.Label{{Name: "__name__", Value: "a"}, {Name: "b", Value: "c"}}},
+				}}},
+			},
+		},
+		// Scalars and label values have no protobuf encoding.
+		{data: &queryData{ResultType: promql.ValueTypeScalar, Result: promql.Scalar{T: 1000, V: 1}}, expJSON: true},
+		{data: []string{"a", "b"}, expJSON: true},
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept", ContentTypeProtobuf)
+		w := httptest.NewRecorder()
+
+		respond(w, r, tcase.data, []error{fmt.Errorf("warning")})
+		testutil.Equals(t, http.StatusOK, w.Code)
+
+		if tcase.expJSON {
+			testutil.Equals(t, ContentTypeJSON, w.Header().Get("Content-Type"))
+			continue
+		}
+		testutil.Equals(t, ContentTypeProtobuf, w.Header().Get("Content-Type"))
+
+		var resp apipb.Response
+		testutil.Ok(t, resp.Unmarshal(w.Body.Bytes()))
+		testutil.Equals(t, tcase.exp, &resp)
+	}
+}
+
+func TestDecodeVector(t *testing.T) {
+	vec := promql.Vector{
+		{Metric: labels.FromStrings("__name__", "a", "b", "c"), Point: promql.Point{T: 1000, V: 1}},
+		{Metric: labels.FromStrings("__name__", "a", "b", "d"), Point: promql.Point{T: 2000, V: 2.5}},
+	}
+
+	for _, accept := range []string{ContentTypeJSON, ContentTypeProtobuf} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+
+		respond(w, r, &queryData{ResultType: promql.ValueTypeVector, Result: vec}, []error{fmt.Errorf("warning")})
+		testutil.Equals(t, accept, w.Header().Get("Content-Type"))
+
+		res, warnings, err := DecodeVector(w.Header().Get("Content-Type"), w.Body)
+		testutil.Ok(t, err)
+		testutil.Equals(t, vec, res)
+		testutil.Equals(t, []string{"warning"}, warnings)
+	}
+
+	// Responses without a content type, e.g. of older queriers or proxies dropping it, are decoded as JSON.
+	r := httptest.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	respond(w, r, &queryData{ResultType: promql.ValueTypeVector, Result: vec}, nil)
+	res, _, err := DecodeVector("", w.Body)
+	testutil.Ok(t, err)
+	testutil.Equals(t, vec, res)
+
+	// Other result types and errors are not decoded.
+	r = httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Accept", ContentTypeProtobuf)
+	w = httptest.NewRecorder()
+	respond(w, r, &queryData{ResultType: promql.ValueTypeMatrix, Result: promql.Matrix{}}, nil)
+	_, _, err = DecodeVector(w.Header().Get("Content-Type"), w.Body)
+	testutil.NotOk(t, err)
+
+	w = httptest.NewRecorder()
+	respondError(w, &apiError{errorBadData, fmt.Errorf("bad query")}, nil)
+	_, _, err = DecodeVector(w.Header().Get("Content-Type"), w.Body)
+	testutil.NotOk(t, err)
+}
+
+// BenchmarkRespond compares the encoding time and size of a large range query result in both encodings.
+func BenchmarkRespond(b *testing.B) {
+	var mat promql.Matrix
+	for i := 0; i < 100; i++ {
+		lset := labels.FromStrings(
+			"__name__", "my_test_metric_name",
+			"instance", fmt.Sprintf("abcdefghijklmnopqrstuvxyz-%d", i),
+			"job", "test-test",
+			"method", "ABCD",
+			"status", "199",
+		)
+		var points []promql.Point
+		for j := 0; j < 1000; j++ {
+			points = append(points, promql.Point{T: int64(j * 10000), V: rand.Float64()})
+		}
+		mat = append(mat, promql.Series{Metric: lset, Points: points})
+	}
+	data := &queryData{ResultType: promql.ValueTypeMatrix, Result: mat}
+
+	for _, accept := range []string{ContentTypeJSON, ContentTypeProtobuf} {
+		b.Run(accept, func(b *testing.B) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set("Accept", accept)
+
+			var buf bytes.Buffer
+			respond(&bufferResponseWriter{Buffer: &buf, header: http.Header{}}, r, data, nil)
+			b.Logf("response size: %d bytes", buf.Len())
+			b.SetBytes(int64(buf.Len()))
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				buf.Reset()
+				respond(&bufferResponseWriter{Buffer: &buf, header: http.Header{}}, r, data, nil)
+			}
+		})
+	}
+}
+
+type bufferResponseWriter struct {
+	*bytes.Buffer
+	header http.Header
+}
+
+func (w *bufferResponseWriter) Header() http.Header { return w.header }
+func (w *bufferResponseWriter) WriteHeader(int)     {}
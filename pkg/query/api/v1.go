@@ -32,6 +32,7 @@ import (
 	"github.com/improbable-eng/thanos/pkg/query"
 	"github.com/improbable-eng/thanos/pkg/runutil"
 	"github.com/improbable-eng/thanos/pkg/tracing"
+	"github.com/improbable-eng/thanos/pkg/usage"
 	"github.com/opentracing/opentracing-go"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
@@ -92,7 +93,7 @@ func setCORS(w http.ResponseWriter) {
 	}
 }
 
-type apiFunc func(r *http.Request) (interface{}, []error, *apiError)
+type apiFunc func(r *http.Request) (interface{}, []error, *apiError, func())
 
 // API can register a set of endpoints in a router and handle
 // them using the provided storage and query engine.
@@ -100,6 +101,9 @@ type API struct {
 	logger          log.Logger
 	queryableCreate query.QueryableCreator
 	queryEngine     *promql.Engine
+	pushdown        *query.Pushdown
+	usage           *usage.Tracker
+	clientHeader    string
 
 	instantQueryDuration   prometheus.Histogram
 	rangeQueryDuration     prometheus.Histogram
@@ -114,6 +118,9 @@ func NewAPI(
 	qe *promql.Engine,
 	c query.QueryableCreator,
 	enableAutodownsampling bool,
+	pushdown *query.Pushdown,
+	usageTracker *usage.Tracker,
+	clientHeader string,
 ) *API {
 	instantQueryDuration := prometheus.NewHistogram(prometheus.HistogramOpts{
 		Name: "thanos_query_api_instant_query_duration_seconds",
@@ -138,6 +145,9 @@ func NewAPI(
 		logger:                 logger,
 		queryEngine:            qe,
 		queryableCreate:        c,
+		pushdown:               pushdown,
+		usage:                  usageTracker,
+		clientHeader:           clientHeader,
 		instantQueryDuration:   instantQueryDuration,
 		rangeQueryDuration:     rangeQueryDuration,
 		enableAutodownsampling: enableAutodownsampling,
@@ -150,10 +160,16 @@ func (api *API) Register(r *route.Router, tracer opentracing.Tracer, logger log.
 	instr := func(name string, f apiFunc) http.HandlerFunc {
 		hf := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			setCORS(w)
-			if data, warnings, err := f(r); err != nil {
+			r = r.WithContext(usage.WithClient(r.Context(), usage.ClientFromHTTP(r, api.clientHeader)))
+			data, warnings, err, finalizer := f(r)
+			if finalizer != nil {
+				// Results may be backed by pooled memory of the query engine until they are written.
+				defer finalizer()
+			}
+			if err != nil {
 				respondError(w, err, data)
 			} else if data != nil {
-				respond(w, data, warnings)
+				respond(w, r, data, warnings)
 			} else {
 				w.WriteHeader(http.StatusNoContent)
 			}
@@ -177,17 +193,17 @@ type queryData struct {
 	Warnings   []error          `json:"warnings,omitempty"`
 }
 
-func (api *API) options(r *http.Request) (interface{}, []error, *apiError) {
-	return nil, nil, nil
+func (api *API) options(r *http.Request) (interface{}, []error, *apiError, func()) {
+	return nil, nil, nil, nil
 }
 
-func (api *API) query(r *http.Request) (interface{}, []error, *apiError) {
+func (api *API) query(r *http.Request) (interface{}, []error, *apiError, func()) {
 	var ts time.Time
 	if t := r.FormValue("time"); t != "" {
 		var err error
 		ts, err = parseTime(t)
 		if err != nil {
-			return nil, nil, &apiError{errorBadData, err}
+			return nil, nil, &apiError{errorBadData, err}, nil
 		}
 	} else {
 		ts = api.now()
@@ -198,7 +214,7 @@ func (api *API) query(r *http.Request) (interface{}, []error, *apiError) {
 		var cancel context.CancelFunc
 		timeout, err := parseDuration(to)
 		if err != nil {
-			return nil, nil, &apiError{errorBadData, err}
+			return nil, nil, &apiError{errorBadData, err}, nil
 		}
 
 		ctx, cancel = context.WithTimeout(ctx, timeout)
@@ -221,62 +237,172 @@ func (api *API) query(r *http.Request) (interface{}, []error, *apiError) {
 		var err error
 		enableDeduplication, err = strconv.ParseBool(dedup)
 		if err != nil {
-			return nil, nil, &apiError{errorBadData, errors.Wrap(err, "'dedup' parameter")}
+			return nil, nil, &apiError{errorBadData, errors.Wrap(err, "'dedup' parameter")}, nil
 		}
 	}
 
+	resolutionFallback, apiErr := parseResolutionFallback(r)
+	if apiErr != nil {
+		return nil, nil, apiErr, nil
+	}
+
 	// We are starting promQL tracing span here, because we have no control over promQL code.
 	span, ctx := tracing.StartSpan(r.Context(), "promql_instant_query")
 	defer span.Finish()
 
-	begin := api.now()
-	qry, err := api.queryEngine.NewInstantQuery(api.queryableCreate(enableDeduplication, 0, partialErrReporter), r.FormValue("query"), ts)
-	if err != nil {
-		return nil, nil, &apiError{errorBadData, err}
-	}
+	var (
+		begin = api.now()
+		val   promql.Value
+	)
+	// Queries are accounted however they end, including failures and timeouts.
+	defer func() { api.accountUsage(ctx, val, time.Since(begin)) }()
 
-	res := qry.Exec(ctx)
+	var (
+		res         *promql.Result
+		resWarnings []error
+		pushedDown  bool
+		release     = func() {}
+	)
+	if api.pushdown != nil {
+		res, resWarnings, pushedDown = api.pushdown.Exec(ctx, r.FormValue("query"), ts, ts, 0, enableDeduplication)
+	}
+	if !pushedDown {
+		var (
+			qry promql.Query
+			err error
+		)
+		qry, res, resWarnings, err = execForResolution(ctx, func(qs string) (promql.Query, error) {
+			// Only warnings of the last evaluation are returned.
+			warnmtx.Lock()
+			warnings = nil
+			warnmtx.Unlock()
+			return api.queryEngine.NewInstantQuery(api.queryableCreate(enableDeduplication, 0, resolutionFallback, partialErrReporter), qs, ts)
+		}, r.FormValue("query"), 0)
+		if err != nil {
+			return nil, nil, &apiError{errorBadData, err}, nil
+		}
+		release = qry.Close
+	}
 	if res.Err != nil {
 		switch res.Err.(type) {
 		case promql.ErrQueryCanceled:
-			return nil, nil, &apiError{errorCanceled, res.Err}
+			return nil, nil, &apiError{errorCanceled, res.Err}, release
 		case promql.ErrQueryTimeout:
-			return nil, nil, &apiError{errorTimeout, res.Err}
+			return nil, nil, &apiError{errorTimeout, res.Err}, release
 		case promql.ErrStorage:
-			return nil, nil, &apiError{errorInternal, res.Err}
+			return nil, nil, &apiError{errorInternal, res.Err}, release
 		}
-		return nil, nil, &apiError{errorExec, res.Err}
+		return nil, nil, &apiError{errorExec, res.Err}, release
 	}
 	api.instantQueryDuration.Observe(time.Since(begin).Seconds())
+	val = res.Value
 
 	return &queryData{
 		ResultType: res.Value.Type(),
 		Result:     res.Value,
-	}, warnings, nil
+	}, append(warnings, resWarnings...), nil, release
+}
+
+// parseResolutionFallback parses the parameter that allows filling gaps of the requested resolution with
+// coarser resolutions.
+func parseResolutionFallback(r *http.Request) (bool, *apiError) {
+	val := r.FormValue("resolution_fallback")
+	if val == "" {
+		return false, nil
+	}
+	fallback, err := strconv.ParseBool(val)
+	if err != nil {
+		return false, &apiError{errorBadData, errors.Wrap(err, "'resolution_fallback' parameter")}
+	}
+	return fallback, nil
+}
+
+// execForResolution creates the query and evaluates it against data of up to the given maximum source resolution.
+// Downsampled data is too sparse for PromQL's default lookback delta and for short ranges. The query is adjusted for
+// the resolution stores serve for the maximum source resolution before it is evaluated, and lookback deltas are extended
+// per series to the resolution of their data. The query is only evaluated again if the resolution of the data actually
+// served requires other adjustments, e.g. if only raw data or coarser data of a resolution fallback was served.
+// It returns the evaluated query, which has to be closed once its result is not used anymore, its result and the
+// warnings about its adjustments.
+func execForResolution(
+	ctx context.Context,
+	newQuery func(qs string) (promql.Query, error),
+	qs string,
+	maxSourceResolution time.Duration,
+) (promql.Query, *promql.Result, []error, error) {
+	expected := query.ResolutionLevel(maxSourceResolution)
+
+	adjusted, warnings := query.AdjustForResolution(qs, expected)
+	qry, err := newQuery(adjusted)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	resCtx := query.WithResolution(ctx, expected)
+	result := qry.Exec(resCtx)
+	if result.Err != nil {
+		return qry, result, nil, nil
+	}
+
+	served := query.ServedResolution(resCtx)
+	servedAdjusted, servedWarnings := query.AdjustForResolution(qs, served)
+	// Series were selected for the expected resolution, so they were served with a lookback delta of their
+	// resolution unless coarser data was served.
+	if servedAdjusted == adjusted && served <= expected {
+		return qry, result, warnings, nil
+	}
+	qry.Close()
+
+	qry, err = newQuery(servedAdjusted)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return qry, qry.Exec(query.WithResolution(ctx, served)), servedWarnings, nil
+}
+
+// accountUsage accounts the executed query, the size of its result and the wall-clock time spent evaluating it
+// to the client of the context. Failed queries have no result.
+func (api *API) accountUsage(ctx context.Context, val promql.Value, evalTime time.Duration) {
+	var series, samples int
+	switch v := val.(type) {
+	case promql.Vector:
+		series, samples = len(v), len(v)
+	case promql.Matrix:
+		series = len(v)
+		for _, s := range v {
+			samples += len(s.Points)
+		}
+	case promql.Scalar, promql.String:
+		samples = 1
+	}
+
+	api.usage.Add(ctx, usage.Queries, 1)
+	api.usage.Add(ctx, usage.Series, float64(series))
+	api.usage.Add(ctx, usage.Samples, float64(samples))
+	api.usage.Add(ctx, usage.EvalWallSeconds, evalTime.Seconds())
 }
 
-func (api *API) queryRange(r *http.Request) (interface{}, []error, *apiError) {
+func (api *API) queryRange(r *http.Request) (interface{}, []error, *apiError, func()) {
 	start, err := parseTime(r.FormValue("start"))
 	if err != nil {
-		return nil, nil, &apiError{errorBadData, err}
+		return nil, nil, &apiError{errorBadData, err}, nil
 	}
 	end, err := parseTime(r.FormValue("end"))
 	if err != nil {
-		return nil, nil, &apiError{errorBadData, err}
+		return nil, nil, &apiError{errorBadData, err}, nil
 	}
 	if end.Before(start) {
 		err := errors.New("end timestamp must not be before start time")
-		return nil, nil, &apiError{errorBadData, err}
+		return nil, nil, &apiError{errorBadData, err}, nil
 	}
 
 	step, err := parseDuration(r.FormValue("step"))
 	if err != nil {
-		return nil, nil, &apiError{errorBadData, errors.Wrap(err, "param step")}
+		return nil, nil, &apiError{errorBadData, errors.Wrap(err, "param step")}, nil
 	}
 
 	if step <= 0 {
 		err := errors.New("zero or negative query resolution step widths are not accepted. Try a positive integer")
-		return nil, nil, &apiError{errorBadData, err}
+		return nil, nil, &apiError{errorBadData, err}, nil
 	}
 
 	maxSourceResolution := 0 * time.Second
@@ -287,27 +413,27 @@ func (api *API) queryRange(r *http.Request) (interface{}, []error, *apiError) {
 	if val := r.FormValue("max_source_resolution"); val != "" {
 		maxSourceResolution, err = parseDuration(val)
 		if err != nil {
-			return nil, nil, &apiError{errorBadData, errors.Wrap(err, "param max_source_resolution")}
+			return nil, nil, &apiError{errorBadData, errors.Wrap(err, "param max_source_resolution")}, nil
 		}
 	}
 
 	if maxSourceResolution < 0 {
 		err := errors.New("negative query max source resolution is not accepted. Try a positive integer")
-		return nil, nil, &apiError{errorBadData, err}
+		return nil, nil, &apiError{errorBadData, err}, nil
 	}
 
 	// For safety, limit the number of returned points per timeseries.
 	// This is sufficient for 60s resolution for a week or 1h resolution for a year.
 	if end.Sub(start)/step > 11000 {
 		err := errors.New("exceeded maximum resolution of 11,000 points per timeseries. Try decreasing the query resolution (?step=XX)")
-		return nil, nil, &apiError{errorBadData, err}
+		return nil, nil, &apiError{errorBadData, err}, nil
 	}
 	ctx := r.Context()
 	if to := r.FormValue("timeout"); to != "" {
 		var cancel context.CancelFunc
 		timeout, err := parseDuration(to)
 		if err != nil {
-			return nil, nil, &apiError{errorBadData, err}
+			return nil, nil, &apiError{errorBadData, err}, nil
 		}
 
 		ctx, cancel = context.WithTimeout(ctx, timeout)
@@ -330,50 +456,82 @@ func (api *API) queryRange(r *http.Request) (interface{}, []error, *apiError) {
 		var err error
 		enableDeduplication, err = strconv.ParseBool(dedup)
 		if err != nil {
-			return nil, nil, &apiError{errorBadData, errors.Wrap(err, "'dedup' parameter")}
+			return nil, nil, &apiError{errorBadData, errors.Wrap(err, "'dedup' parameter")}, nil
 		}
 	}
 
+	resolutionFallback, apiErr := parseResolutionFallback(r)
+	if apiErr != nil {
+		return nil, nil, apiErr, nil
+	}
+
 	// We are starting promQL tracing span here, because we have no control over promQL code.
 	span, ctx := tracing.StartSpan(r.Context(), "promql_range_query")
 	defer span.Finish()
 
-	begin := api.now()
-	qry, err := api.queryEngine.NewRangeQuery(
-		api.queryableCreate(enableDeduplication, maxSourceResolution, partialErrReporter),
-		r.FormValue("query"),
-		start,
-		end,
-		step,
+	var (
+		begin = api.now()
+		val   promql.Value
 	)
-	if err != nil {
-		return nil, nil, &apiError{errorBadData, err}
-	}
+	// Queries are accounted however they end, including failures and timeouts.
+	defer func() { api.accountUsage(ctx, val, time.Since(begin)) }()
 
-	res := qry.Exec(ctx)
+	var (
+		res         *promql.Result
+		resWarnings []error
+		pushedDown  bool
+		release     = func() {}
+	)
+	if api.pushdown != nil {
+		res, resWarnings, pushedDown = api.pushdown.Exec(ctx, r.FormValue("query"), start, end, step, enableDeduplication)
+	}
+	if !pushedDown {
+		var (
+			qry promql.Query
+			err error
+		)
+		qry, res, resWarnings, err = execForResolution(ctx, func(qs string) (promql.Query, error) {
+			// Only warnings of the last evaluation are returned.
+			warnmtx.Lock()
+			warnings = nil
+			warnmtx.Unlock()
+			return api.queryEngine.NewRangeQuery(
+				api.queryableCreate(enableDeduplication, maxSourceResolution, resolutionFallback, partialErrReporter),
+				qs,
+				start,
+				end,
+				step,
+			)
+		}, r.FormValue("query"), maxSourceResolution)
+		if err != nil {
+			return nil, nil, &apiError{errorBadData, err}, nil
+		}
+		release = qry.Close
+	}
 	if res.Err != nil {
 		switch res.Err.(type) {
 		case promql.ErrQueryCanceled:
-			return nil, nil, &apiError{errorCanceled, res.Err}
+			return nil, nil, &apiError{errorCanceled, res.Err}, release
 		case promql.ErrQueryTimeout:
-			return nil, nil, &apiError{errorTimeout, res.Err}
+			return nil, nil, &apiError{errorTimeout, res.Err}, release
 		}
-		return nil, nil, &apiError{errorExec, res.Err}
+		return nil, nil, &apiError{errorExec, res.Err}, release
 	}
 	api.rangeQueryDuration.Observe(time.Since(begin).Seconds())
+	val = res.Value
 
 	return &queryData{
 		ResultType: res.Value.Type(),
 		Result:     res.Value,
-	}, warnings, nil
+	}, append(warnings, resWarnings...), nil, release
 }
 
-func (api *API) labelValues(r *http.Request) (interface{}, []error, *apiError) {
+func (api *API) labelValues(r *http.Request) (interface{}, []error, *apiError, func()) {
 	ctx := r.Context()
 	name := route.Param(ctx, "name")
 
 	if !model.LabelNameRE.MatchString(name) {
-		return nil, nil, &apiError{errorBadData, fmt.Errorf("invalid label name: %q", name)}
+		return nil, nil, &apiError{errorBadData, fmt.Errorf("invalid label name: %q", name)}, nil
 	}
 
 	var (
@@ -386,9 +544,9 @@ func (api *API) labelValues(r *http.Request) (interface{}, []error, *apiError) {
 		warnmtx.Unlock()
 	}
 
-	q, err := api.queryableCreate(true, 0, partialErrReporter).Querier(ctx, math.MinInt64, math.MaxInt64)
+	q, err := api.queryableCreate(true, 0, false, partialErrReporter).Querier(ctx, math.MinInt64, math.MaxInt64)
 	if err != nil {
-		return nil, nil, &apiError{errorExec, err}
+		return nil, nil, &apiError{errorExec, err}, nil
 	}
 	defer runutil.CloseWithLogOnErr(api.logger, q, "queryable labelValues")
 
@@ -396,10 +554,10 @@ func (api *API) labelValues(r *http.Request) (interface{}, []error, *apiError) {
 
 	vals, err := q.LabelValues(name)
 	if err != nil {
-		return nil, nil, &apiError{errorExec, err}
+		return nil, nil, &apiError{errorExec, err}, nil
 	}
 
-	return vals, warnings, nil
+	return vals, warnings, nil, nil
 }
 
 var (
@@ -407,13 +565,13 @@ var (
 	maxTime = time.Unix(math.MaxInt64/1000-62135596801, 999999999)
 )
 
-func (api *API) series(r *http.Request) (interface{}, []error, *apiError) {
+func (api *API) series(r *http.Request) (interface{}, []error, *apiError, func()) {
 	if err := r.ParseForm(); err != nil {
-		return nil, nil, &apiError{errorInternal, errors.Wrap(err, "parse form")}
+		return nil, nil, &apiError{errorInternal, errors.Wrap(err, "parse form")}, nil
 	}
 
 	if len(r.Form["match[]"]) == 0 {
-		return nil, nil, &apiError{errorBadData, fmt.Errorf("no match[] parameter provided")}
+		return nil, nil, &apiError{errorBadData, fmt.Errorf("no match[] parameter provided")}, nil
 	}
 
 	var start time.Time
@@ -421,7 +579,7 @@ func (api *API) series(r *http.Request) (interface{}, []error, *apiError) {
 		var err error
 		start, err = parseTime(t)
 		if err != nil {
-			return nil, nil, &apiError{errorBadData, err}
+			return nil, nil, &apiError{errorBadData, err}, nil
 		}
 	} else {
 		start = minTime
@@ -432,7 +590,7 @@ func (api *API) series(r *http.Request) (interface{}, []error, *apiError) {
 		var err error
 		end, err = parseTime(t)
 		if err != nil {
-			return nil, nil, &apiError{errorBadData, err}
+			return nil, nil, &apiError{errorBadData, err}, nil
 		}
 	} else {
 		end = maxTime
@@ -442,7 +600,7 @@ func (api *API) series(r *http.Request) (interface{}, []error, *apiError) {
 	for _, s := range r.Form["match[]"] {
 		matchers, err := promql.ParseMetricSelector(s)
 		if err != nil {
-			return nil, nil, &apiError{errorBadData, err}
+			return nil, nil, &apiError{errorBadData, err}, nil
 		}
 		matcherSets = append(matcherSets, matchers)
 	}
@@ -463,53 +621,84 @@ func (api *API) series(r *http.Request) (interface{}, []error, *apiError) {
 		var err error
 		enableDeduplication, err = strconv.ParseBool(dedup)
 		if err != nil {
-			return nil, nil, &apiError{errorBadData, errors.Wrap(err, "'dedup' parameter")}
+			return nil, nil, &apiError{errorBadData, errors.Wrap(err, "'dedup' parameter")}, nil
 		}
 	}
 
-	q, err := api.queryableCreate(enableDeduplication, 0, partialErrReporter).Querier(r.Context(), timestamp.FromTime(start), timestamp.FromTime(end))
+	resolutionFallback, apiErr := parseResolutionFallback(r)
+	if apiErr != nil {
+		return nil, nil, apiErr, nil
+	}
+
+	q, err := api.queryableCreate(enableDeduplication, 0, resolutionFallback, partialErrReporter).Querier(r.Context(), timestamp.FromTime(start), timestamp.FromTime(end))
 	if err != nil {
-		return nil, nil, &apiError{errorExec, err}
+		return nil, nil, &apiError{errorExec, err}, nil
 	}
 	defer runutil.CloseWithLogOnErr(api.logger, q, "queryable series")
 
-	var sets []storage.SeriesSet
-	for _, mset := range matcherSets {
-		s, err := q.Select(&storage.SelectParams{}, mset...)
+	var set storage.SeriesSet
+	if mq, ok := q.(query.MultiSelecter); ok {
+		// Select the series of all matcher sets at once, so that stores read their data only once.
+		set, err = mq.SelectMulti(&storage.SelectParams{}, matcherSets...)
 		if err != nil {
-			return nil, nil, &apiError{errorExec, err}
+			return nil, nil, &apiError{errorExec, err}, nil
+		}
+	} else {
+		var sets []storage.SeriesSet
+		for _, mset := range matcherSets {
+			s, err := q.Select(&storage.SelectParams{}, mset...)
+			if err != nil {
+				return nil, nil, &apiError{errorExec, err}, nil
+			}
+			sets = append(sets, s)
 		}
-		sets = append(sets, s)
+		set = storage.NewMergeSeriesSet(sets)
 	}
-
-	set := storage.NewMergeSeriesSet(sets)
 	metrics := []labels.Labels{}
 	for set.Next() {
 		metrics = append(metrics, set.At().Labels())
 	}
 	if set.Err() != nil {
-		return nil, nil, &apiError{errorExec, set.Err()}
+		return nil, nil, &apiError{errorExec, set.Err()}, nil
 	}
 
-	return metrics, warnings, nil
+	return metrics, warnings, nil, nil
 }
 
-func respond(w http.ResponseWriter, data interface{}, warnings []error) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-
-	resp := &response{
-		Status: statusSuccess,
-		Data:   data,
-	}
+// respond writes the data as protobuf if the request accepts it and the data has a protobuf encoding,
+// and as JSON otherwise.
+func respond(w http.ResponseWriter, r *http.Request, data interface{}, warnings []error) {
+	var warns []string
 	for _, warn := range warnings {
-		resp.Warnings = append(resp.Warnings, warn.Error())
+		warns = append(warns, warn.Error())
 	}
-	_ = json.NewEncoder(w).Encode(resp)
+
+	if acceptsProtobuf(r) {
+		if resp, ok := protobufResponse(data, warns); ok {
+			b, err := resp.Marshal()
+			if err != nil {
+				respondError(w, &apiError{errorInternal, errors.Wrap(err, "marshal protobuf response")}, nil)
+				return
+			}
+			w.Header().Set("Content-Type", ContentTypeProtobuf)
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write(b)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", ContentTypeJSON)
+	w.WriteHeader(http.StatusOK)
+
+	_ = json.NewEncoder(w).Encode(&response{
+		Status:   statusSuccess,
+		Data:     data,
+		Warnings: warns,
+	})
 }
 
 func respondError(w http.ResponseWriter, apiErr *apiError, data interface{}) {
-	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Type", ContentTypeJSON)
 
 	var code int
 	switch apiErr.typ {
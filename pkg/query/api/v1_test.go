@@ -23,25 +23,35 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strconv"
 	"testing"
 	"time"
 
 	"github.com/prometheus/common/route"
 
 	"github.com/go-kit/kit/log"
+	"github.com/improbable-eng/thanos/pkg/block"
+	"github.com/improbable-eng/thanos/pkg/compact/downsample"
+	"github.com/improbable-eng/thanos/pkg/objstore/inmem"
 	"github.com/improbable-eng/thanos/pkg/query"
+	"github.com/improbable-eng/thanos/pkg/store"
 	"github.com/improbable-eng/thanos/pkg/testutil"
+	"github.com/improbable-eng/thanos/pkg/usage"
 	"github.com/opentracing/opentracing-go"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/prometheus/pkg/labels"
 	"github.com/prometheus/prometheus/pkg/timestamp"
 	"github.com/prometheus/prometheus/promql"
 	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/tsdb"
+	tsdblabels "github.com/prometheus/tsdb/labels"
 )
 
 func testQueryableCreator(queryable storage.Queryable) query.QueryableCreator {
-	return func(_ bool, _ time.Duration, _ query.PartialErrReporter) storage.Queryable {
+	return func(_ bool, _ time.Duration, _ bool, _ query.PartialErrReporter) storage.Queryable {
 		return queryable
 	}
 }
@@ -261,6 +271,18 @@ func TestEndpoints(t *testing.T) {
 			},
 			errType: errorBadData,
 		},
+		// Bad resolution_fallback parameter.
+		{
+			endpoint: api.queryRange,
+			query: url.Values{
+				"query":               []string{"time()"},
+				"start":               []string{"0"},
+				"end":                 []string{"2"},
+				"step":                []string{"1"},
+				"resolution_fallback": []string{"sdfsf-range"},
+			},
+			errType: errorBadData,
+		},
 		{
 			endpoint: api.labelValues,
 			params: map[string]string{
@@ -420,7 +442,10 @@ func TestEndpoints(t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
-		resp, _, apiErr := test.endpoint(req.WithContext(ctx))
+		resp, _, apiErr, release := test.endpoint(req.WithContext(ctx))
+		if release != nil {
+			defer release()
+		}
 		if apiErr != nil {
 			if test.errType == errorNone {
 				t.Fatalf("Unexpected error: %s", apiErr)
@@ -441,7 +466,7 @@ func TestEndpoints(t *testing.T) {
 
 func TestRespondSuccess(t *testing.T) {
 	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		respond(w, "test", nil)
+		respond(w, r, "test", nil)
 	}))
 	defer s.Close()
 
@@ -683,3 +708,143 @@ func BenchmarkQueryResultEncoding(b *testing.B) {
 	testutil.Ok(b, err)
 	fmt.Println(len(c))
 }
+
+func TestEndpoints_ResolutionFallback(t *testing.T) {
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", "test-resolution-fallback")
+	testutil.Ok(t, err)
+	defer os.RemoveAll(dir)
+
+	// Only data downsampled to a resolution of 1h is available in the bucket.
+	var (
+		hour = int64(time.Hour / time.Millisecond)
+		bkt  = inmem.NewBucket()
+	)
+	rawID, err := testutil.CreateBlock(dir, []tsdblabels.Labels{tsdblabels.FromStrings("__name__", "x")}, 719, 0, 12*hour, tsdblabels.FromStrings("ext", "1"), 0)
+	testutil.Ok(t, err)
+
+	bdir := filepath.Join(dir, rawID.String())
+	meta, err := block.ReadMetaFile(bdir)
+	testutil.Ok(t, err)
+	b, err := tsdb.OpenBlock(bdir, nil)
+	testutil.Ok(t, err)
+	id, err := downsample.Downsample(log.NewNopLogger(), meta, b, dir, downsample.ResLevel2)
+	testutil.Ok(t, err)
+	testutil.Ok(t, b.Close())
+	testutil.Ok(t, block.Upload(ctx, log.NewNopLogger(), bkt, filepath.Join(dir, id.String())))
+
+	bs, err := store.NewBucketStore(nil, nil, bkt, filepath.Join(dir, "store"), 100*1024*1024, 0, false, 0, nil, false)
+	testutil.Ok(t, err)
+	testutil.Ok(t, bs.SyncBlocks(ctx))
+
+	api := &API{
+		queryableCreate: query.NewQueryableCreator(nil, bs, "replica"),
+		queryEngine:     promql.NewEngine(nil, nil, 1, time.Minute),
+
+		instantQueryDuration: prometheus.NewHistogram(prometheus.HistogramOpts{}),
+		rangeQueryDuration:   prometheus.NewHistogram(prometheus.HistogramOpts{}),
+
+		now: time.Now,
+	}
+
+	// Samples of 1h resolution are more than the default lookback delta and the range of 5m apart
+	// at 11h30m, so results require the lookback delta and ranges to be extended to the served resolution.
+	for _, qs := range []string{`x`, `rate(x[5m])`} {
+		for _, fallback := range []bool{false, true} {
+			r := httptest.NewRequest("GET", "http://example.com/?"+url.Values{
+				"query":               []string{qs},
+				"time":                []string{"41400"},
+				"resolution_fallback": []string{strconv.FormatBool(fallback)},
+			}.Encode(), nil)
+
+			resp, warnings, apiErr, release := api.query(r)
+			testutil.Assert(t, apiErr == nil, "unexpected error: %v", apiErr)
+			defer release()
+
+			vec := resp.(*queryData).Result.(promql.Vector)
+			if !fallback {
+				testutil.Equals(t, 0, len(vec))
+				continue
+			}
+			testutil.Equals(t, 1, len(vec))
+			testutil.Equals(t, "1", vec[0].Metric.Get("ext"))
+			testutil.Assert(t, len(warnings) > 0, "expected warnings about fallback and adjustments")
+		}
+	}
+
+	// Queries served at the expected resolution are adjusted before and evaluated only once.
+	evals := 0
+	qry, res, warnings, err := execForResolution(ctx, func(qs string) (promql.Query, error) {
+		evals++
+		return api.queryEngine.NewRangeQuery(api.queryableCreate(false, time.Hour, false, func(error) {}), qs, time.Unix(3600, 0), time.Unix(39600, 0), time.Hour)
+	}, `rate(x[5m])`, time.Hour)
+	testutil.Ok(t, err)
+	testutil.Ok(t, res.Err)
+	testutil.Equals(t, 1, evals)
+	testutil.Assert(t, len(warnings) > 0, "expected warnings about adjustments")
+	testutil.Equals(t, 1, len(res.Value.(promql.Matrix)))
+	qry.Close()
+
+	// Queries are not adjusted if raw data is served, even if a coarser maximum source resolution is requested.
+	testutil.Ok(t, block.Upload(ctx, log.NewNopLogger(), bkt, bdir))
+	testutil.Ok(t, bs.SyncBlocks(ctx))
+
+	r := httptest.NewRequest("GET", "http://example.com/?"+url.Values{
+		"query":                 []string{`rate(x[5m])`},
+		"start":                 []string{"3600"},
+		"end":                   []string{"39600"},
+		"step":                  []string{"3600"},
+		"max_source_resolution": []string{"12m"},
+	}.Encode(), nil)
+
+	resp, warnings, apiErr, release := api.queryRange(r)
+	testutil.Assert(t, apiErr == nil, "unexpected error: %v", apiErr)
+	defer release()
+	testutil.Equals(t, 0, len(warnings))
+
+	mat := resp.(*queryData).Result.(promql.Matrix)
+	testutil.Equals(t, 1, len(mat))
+	testutil.Equals(t, 11, len(mat[0].Points))
+}
+
+func TestEndpoints_Usage(t *testing.T) {
+	suite, err := promql.NewTest(t, `
+		load 1m
+			test_metric1{foo="bar"} 0+100x100
+			test_metric1{foo="boo"} 1+0x100
+	`)
+	testutil.Ok(t, err)
+	defer suite.Close()
+	testutil.Ok(t, suite.Run())
+
+	reg := prometheus.NewRegistry()
+	api := &API{
+		queryableCreate: testQueryableCreator(suite.Storage()),
+		queryEngine:     suite.QueryEngine(),
+		usage:           usage.NewTracker(nil, reg, "query", 10, usage.Queries, usage.Series, usage.EvalWallSeconds),
+
+		instantQueryDuration: prometheus.NewHistogram(prometheus.HistogramOpts{}),
+		rangeQueryDuration:   prometheus.NewHistogram(prometheus.HistogramOpts{}),
+
+		now: time.Now,
+	}
+
+	// Successful queries and queries failing during evaluation are accounted.
+	for _, q := range []string{`test_metric1`, `label_replace(test_metric1, "foo", "x", "foo", ".*")`} {
+		r := httptest.NewRequest("GET", "http://example.com/?"+url.Values{"query": []string{q}, "time": []string{"60"}}.Encode(), nil)
+		_, _, apiErr, release := api.query(r)
+		testutil.Equals(t, q != `test_metric1`, apiErr != nil)
+		release()
+	}
+
+	mfs, err := reg.Gather()
+	testutil.Ok(t, err)
+	values := map[string]float64{}
+	for _, mf := range mfs {
+		values[mf.GetName()] = mf.GetMetric()[0].GetCounter().GetValue()
+	}
+	testutil.Equals(t, 2.0, values["thanos_query_usage_queries_total"])
+	testutil.Equals(t, 2.0, values["thanos_query_usage_series_total"])
+	testutil.Assert(t, values["thanos_query_usage_eval_wall_seconds_total"] > 0, "expected evaluation time to be accounted")
+}
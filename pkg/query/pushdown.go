@@ -0,0 +1,256 @@
+package query
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/improbable-eng/thanos/pkg/store"
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+	"github.com/improbable-eng/thanos/pkg/tracing"
+	"github.com/improbable-eng/thanos/pkg/usage"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/prometheus/prometheus/promql"
+)
+
+// Pushdown evaluates whole PromQL queries on a single store if that store is the only one exposing data
+// for all selectors of the query, e.g. a sidecar of a Prometheus. This avoids fetching all raw series
+// from the store just to evaluate the query on the querier.
+type Pushdown struct {
+	logger       log.Logger
+	stores       func() []store.Client
+	replicaLabel string
+	gate         chan struct{}
+	timeout      time.Duration
+
+	queries  prometheus.Counter
+	failures prometheus.Counter
+}
+
+// NewPushdown returns a new Pushdown that plans queries against the given stores.
+// If deduplication is enabled for a query, the replicaLabel is removed from its result.
+// Like queries evaluated by the querier's engine, at most maxConcurrent queries are pushed down at a time and
+// they fail after the given timeout.
+func NewPushdown(
+	logger log.Logger,
+	reg *prometheus.Registry,
+	stores func() []store.Client,
+	replicaLabel string,
+	maxConcurrent int,
+	timeout time.Duration,
+) *Pushdown {
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+	p := &Pushdown{
+		logger:       logger,
+		stores:       stores,
+		replicaLabel: replicaLabel,
+		gate:         make(chan struct{}, maxConcurrent),
+		timeout:      timeout,
+		queries: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "thanos_query_pushdown_queries_total",
+			Help: "Total number of queries evaluated by a single store.",
+		}),
+		failures: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "thanos_query_pushdown_failures_total",
+			Help: "Total number of queries that failed to be evaluated by a single store and were evaluated by the querier instead.",
+		}),
+	}
+	if reg != nil {
+		reg.MustRegister(p.queries, p.failures)
+	}
+	return p
+}
+
+// Exec evaluates the query on a single store if possible. A step of zero denotes an instant query.
+// It returns false if the query cannot be pushed down and has to be evaluated by the querier instead.
+// Queries that time out or are canceled are not evaluated again, their result holds the respective PromQL error.
+func (p *Pushdown) Exec(ctx context.Context, qs string, start, end time.Time, step time.Duration, deduplicate bool) (*promql.Result, []error, bool) {
+	expr, err := promql.ParseExpr(qs)
+	if err != nil {
+		return nil, nil, false
+	}
+	switch expr.Type() {
+	case promql.ValueTypeVector:
+	case promql.ValueTypeMatrix:
+		if step > 0 {
+			return nil, nil, false
+		}
+	default:
+		return nil, nil, false
+	}
+
+	st, err := p.plan(expr, timestamp.FromTime(start), timestamp.FromTime(end))
+	if err != nil {
+		level.Debug(p.logger).Log("msg", "failed to plan query pushdown", "query", qs, "err", err)
+		return nil, nil, false
+	}
+	if st == nil {
+		return nil, nil, false
+	}
+	qc, ok := st.(storepb.QueryClient)
+	if !ok {
+		return nil, nil, false
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	select {
+	case p.gate <- struct{}{}:
+		defer func() { <-p.gate }()
+	case <-ctx.Done():
+		return &promql.Result{Err: contextErr(ctx.Err(), "query queue")}, nil, true
+	}
+
+	span, ctx := tracing.StartSpan(ctx, "query_pushdown")
+	defer span.Finish()
+
+	resp, err := qc.Query(usage.OutgoingContext(ctx), &storepb.QueryRequest{
+		Query: qs,
+		Start: timestamp.FromTime(start),
+		End:   timestamp.FromTime(end),
+		Step:  int64(step / time.Millisecond),
+	})
+	if ctx.Err() != nil {
+		return &promql.Result{Err: contextErr(ctx.Err(), "query pushdown")}, nil, true
+	}
+	if err != nil {
+		p.failures.Inc()
+		level.Debug(p.logger).Log("msg", "query pushdown failed, evaluating on querier", "store", st, "query", qs, "err", err)
+		return nil, nil, false
+	}
+	p.queries.Inc()
+
+	var warnings []error
+	for _, w := range resp.Warnings {
+		warnings = append(warnings, errors.New(w))
+	}
+
+	var dropLabel string
+	if deduplicate {
+		dropLabel = p.replicaLabel
+	}
+	if step == 0 && expr.Type() == promql.ValueTypeVector {
+		vec := make(promql.Vector, 0, len(resp.Series))
+		for _, s := range resp.Series {
+			for _, smpl := range s.Samples {
+				vec = append(vec, promql.Sample{
+					Metric: promLabels(s.Labels, dropLabel),
+					Point:  promql.Point{T: smpl.Timestamp, V: smpl.Value},
+				})
+			}
+		}
+		return &promql.Result{Value: vec}, warnings, true
+	}
+
+	mat := make(promql.Matrix, 0, len(resp.Series))
+	for _, s := range resp.Series {
+		series := promql.Series{
+			Metric: promLabels(s.Labels, dropLabel),
+			Points: make([]promql.Point, 0, len(s.Samples)),
+		}
+		for _, smpl := range s.Samples {
+			series.Points = append(series.Points, promql.Point{T: smpl.Timestamp, V: smpl.Value})
+		}
+		mat = append(mat, series)
+	}
+	sort.Sort(mat)
+	return &promql.Result{Value: mat}, warnings, true
+}
+
+// contextErr translates the error of a done context into the PromQL error for the given environment.
+func contextErr(err error, env string) error {
+	if err == context.DeadlineExceeded {
+		return promql.ErrQueryTimeout(env)
+	}
+	return promql.ErrQueryCanceled(env)
+}
+
+// plan returns the only store that exposes data for all selectors of the expression within the queried time range
+// and covers that time range entirely. It returns nil if there is no such store, the store does not support queries
+// or labels are injected into its series.
+func (p *Pushdown) plan(expr promql.Expr, start, end int64) (store.Client, error) {
+	var (
+		stores     = p.stores()
+		res        store.Client
+		mint, maxt = start, end
+		err        error
+	)
+	promql.Inspect(expr, func(node promql.Node, _ []promql.Node) error {
+		var (
+			ms     []*labels.Matcher
+			offset time.Duration
+			rng    = promql.LookbackDelta
+		)
+		switch n := node.(type) {
+		case *promql.VectorSelector:
+			ms, offset = n.LabelMatchers, n.Offset
+		case *promql.MatrixSelector:
+			ms, offset, rng = n.LabelMatchers, n.Offset, n.Range
+		default:
+			return nil
+		}
+
+		sms, serr := translateMatchers(ms...)
+		if serr != nil {
+			err = serr
+			return err
+		}
+		smint := start - int64((offset+rng)/time.Millisecond)
+		smaxt := end - int64(offset/time.Millisecond)
+
+		matching, serr := store.MatchingStores(stores, smint, smaxt, sms...)
+		if serr != nil {
+			err = serr
+			return err
+		}
+		if len(matching) != 1 || (res != nil && res != matching[0]) {
+			err = errors.Errorf("selector %s matches %d stores", node, len(matching))
+			return err
+		}
+		res = matching[0]
+
+		if smint < mint {
+			mint = smint
+		}
+		if smaxt > maxt {
+			maxt = smaxt
+		}
+		return nil
+	})
+	if err != nil || res == nil {
+		return nil, err
+	}
+	if !res.SupportsQuery() {
+		return nil, errors.Errorf("store %s does not support queries", res)
+	}
+	// The store would neither understand matchers of injected labels nor add them to the result.
+	if len(res.InjectedLabels()) > 0 {
+		return nil, errors.Errorf("store %s has injected labels", res)
+	}
+
+	smint, smaxt := res.TimeRange()
+	if smint > mint || smaxt < maxt {
+		return nil, errors.Errorf("store %s does not cover time range %d-%d", res, mint, maxt)
+	}
+	return res, nil
+}
+
+// promLabels converts the given labels into Prometheus labels without the label with the given name.
+func promLabels(lset []storepb.Label, drop string) labels.Labels {
+	res := make(labels.Labels, 0, len(lset))
+	for _, l := range lset {
+		if l.Name == drop {
+			continue
+		}
+		res = append(res, labels.Label{Name: l.Name, Value: l.Value})
+	}
+	return res
+}
@@ -0,0 +1,142 @@
+package query
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/improbable-eng/thanos/pkg/store"
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+	"github.com/improbable-eng/thanos/pkg/testutil"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql"
+	"google.golang.org/grpc"
+)
+
+type queryClient struct {
+	storepb.StoreClient
+
+	labels     []storepb.Label
+	injected   []storepb.Label
+	mint, maxt int64
+	query      bool
+
+	reqs []*storepb.QueryRequest
+	resp *storepb.QueryResponse
+}
+
+func (c *queryClient) Labels() []storepb.Label                { return c.labels }
+func (c *queryClient) TimeRange() (int64, int64)              { return c.mint, c.maxt }
+func (c *queryClient) MetricNames() *storepb.MetricNameFilter { return nil }
+func (c *queryClient) SupportsMatcherSets() bool              { return true }
+func (c *queryClient) SupportsQuery() bool                    { return c.query }
+func (c *queryClient) InjectedLabels() []storepb.Label        { return c.injected }
+func (c *queryClient) String() string                         { return "query client" }
+func (c *queryClient) Query(_ context.Context, r *storepb.QueryRequest, _ ...grpc.CallOption) (*storepb.QueryResponse, error) {
+	c.reqs = append(c.reqs, r)
+	return c.resp, nil
+}
+
+func TestPushdown_Exec(t *testing.T) {
+	sidecar := &queryClient{
+		labels: []storepb.Label{{Name: "cluster", Value: "a"}, {Name: "replica", Value: "1"}},
+		mint:   time.Unix(1000, 0).UnixNano() / int64(time.Millisecond),
+		maxt:   math.MaxInt64,
+		query:  true,
+		resp: &storepb.QueryResponse{Series: []storepb.QuerySeries{{
+			Labels:  []storepb.Label{{Name: "cluster", Value: "a"}, {Name: "replica", Value: "1"}},
+			Samples: []storepb.Sample{{Timestamp: 3000000, Value: 1}, {Timestamp: 3060000, Value: 2}},
+		}}},
+	}
+	other := &queryClient{
+		labels: []storepb.Label{{Name: "cluster", Value: "b"}},
+		mint:   0,
+		maxt:   math.MaxInt64,
+	}
+	gateway := &queryClient{
+		labels: []storepb.Label{{Name: "cluster", Value: "a"}},
+		mint:   0,
+		maxt:   time.Unix(1000, 0).UnixNano() / int64(time.Millisecond),
+	}
+	p := NewPushdown(nil, nil, func() []store.Client {
+		return []store.Client{sidecar, other, gateway}
+	}, "replica", 1, time.Minute)
+
+	ctx := context.Background()
+	start, end := time.Unix(3000, 0), time.Unix(3060, 0)
+
+	for _, tcase := range []struct {
+		query string
+		start time.Time
+		end   time.Time
+		step  time.Duration
+		ok    bool
+	}{
+		{query: `up{cluster="a"}`, start: start, step: time.Minute, ok: true},
+		{query: `rate(up{cluster=~"a|c"}[5m])`, start: start, step: time.Minute, ok: true},
+		// Selector matches multiple stores.
+		{query: `up`, start: start, step: time.Minute},
+		// Selectors matching different stores.
+		{query: `up{cluster="a"} / up{cluster="b"}`, start: start, step: time.Minute},
+		// Older data is exposed by another store as well.
+		{query: `up{cluster="a"}`, start: time.Unix(900, 0), step: time.Minute},
+		{query: `rate(up{cluster="a"}[1h])`, start: start, step: time.Minute},
+		// The only store exposing older data does not support queries.
+		{query: `up{cluster="a"}`, start: time.Unix(400, 0), end: time.Unix(500, 0), step: time.Minute},
+		// Scalar results are not pushed down.
+		{query: `scalar(up{cluster="a"})`, start: start, step: time.Minute},
+		{query: `1`, start: start, step: time.Minute},
+	} {
+		t.Run(tcase.query, func(t *testing.T) {
+			sidecar.reqs = nil
+			if tcase.end.IsZero() {
+				tcase.end = end
+			}
+
+			_, _, ok := p.Exec(ctx, tcase.query, tcase.start, tcase.end, tcase.step, false)
+			testutil.Equals(t, tcase.ok, ok)
+			if !ok {
+				testutil.Equals(t, 0, len(sidecar.reqs))
+				return
+			}
+			testutil.Equals(t, []*storepb.QueryRequest{{
+				Query: tcase.query,
+				Start: 3000000,
+				End:   3060000,
+				Step:  60000,
+			}}, sidecar.reqs)
+		})
+	}
+
+	res, _, ok := p.Exec(ctx, `up{cluster="a"}`, start, end, time.Minute, true)
+	testutil.Assert(t, ok, "expected query to be pushed down")
+	testutil.Ok(t, res.Err)
+	testutil.Equals(t, promql.Matrix{{
+		Metric: labels.FromStrings("cluster", "a"),
+		Points: []promql.Point{{T: 3000000, V: 1}, {T: 3060000, V: 2}},
+	}}, res.Value)
+
+	res, _, ok = p.Exec(ctx, `up{cluster="a"}`, start, start, 0, false)
+	testutil.Assert(t, ok, "expected query to be pushed down")
+	testutil.Ok(t, res.Err)
+	testutil.Equals(t, promql.Vector{
+		{Metric: labels.FromStrings("cluster", "a", "replica", "1"), Point: promql.Point{T: 3000000, V: 1}},
+		{Metric: labels.FromStrings("cluster", "a", "replica", "1"), Point: promql.Point{T: 3060000, V: 2}},
+	}, res.Value)
+
+	// Queries waiting for a free slot time out like queries of the engine and are not evaluated again.
+	p.timeout = 10 * time.Millisecond
+	p.gate <- struct{}{}
+	res, _, ok = p.Exec(ctx, `up{cluster="a"}`, start, end, time.Minute, false)
+	<-p.gate
+	testutil.Assert(t, ok, "expected timed out query not to be evaluated again")
+	_, isTimeout := res.Err.(promql.ErrQueryTimeout)
+	testutil.Assert(t, isTimeout, "expected timeout error, got %v", res.Err)
+	p.timeout = time.Minute
+
+	// Stores with injected labels do not know about them, so queries are not pushed down to them.
+	sidecar.injected = []storepb.Label{{Name: "cluster", Value: "a"}}
+	_, _, ok = p.Exec(ctx, `up{cluster="a"}`, start, end, time.Minute, false)
+	testutil.Assert(t, !ok, "expected query not to be pushed down")
+}
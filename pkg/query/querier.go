@@ -11,7 +11,9 @@ import (
 	"github.com/improbable-eng/thanos/pkg/store/storepb"
 	"github.com/improbable-eng/thanos/pkg/tracing"
 	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql"
 	"github.com/prometheus/prometheus/storage"
 )
 
@@ -22,18 +24,20 @@ type PartialErrReporter func(error)
 
 // QueryableCreator returns implementation of promql.Queryable that fetches data from the proxy store API endpoints.
 // If deduplication is enabled, all data retrieved from it will be deduplicated along the replicaLabel by default.
-// maxSourceResolution controls downsampling resolution that is allowed.
-type QueryableCreator func(deduplicate bool, maxSourceResolution time.Duration, p PartialErrReporter) storage.Queryable
+// maxSourceResolution controls downsampling resolution that is allowed. If resolutionFallback is enabled, stores
+// may serve data of lower resolutions where none of the allowed resolutions is available.
+type QueryableCreator func(deduplicate bool, maxSourceResolution time.Duration, resolutionFallback bool, p PartialErrReporter) storage.Queryable
 
 // NewQueryableCreator creates QueryableCreator.
 func NewQueryableCreator(logger log.Logger, proxy storepb.StoreServer, replicaLabel string) QueryableCreator {
-	return func(deduplicate bool, maxSourceResolution time.Duration, p PartialErrReporter) storage.Queryable {
+	return func(deduplicate bool, maxSourceResolution time.Duration, resolutionFallback bool, p PartialErrReporter) storage.Queryable {
 		return &queryable{
 			logger:              logger,
 			replicaLabel:        replicaLabel,
 			proxy:               proxy,
 			deduplicate:         deduplicate,
 			maxSourceResolution: maxSourceResolution,
+			resolutionFallback:  resolutionFallback,
 			partialErrReport:    p,
 		}
 	}
@@ -46,11 +50,12 @@ type queryable struct {
 	deduplicate         bool
 	partialErrReport    PartialErrReporter
 	maxSourceResolution time.Duration
+	resolutionFallback  bool
 }
 
 // Querier returns a new storage querier against the underlying proxy store API.
 func (q *queryable) Querier(ctx context.Context, mint, maxt int64) (storage.Querier, error) {
-	return newQuerier(ctx, q.logger, mint, maxt, q.replicaLabel, q.proxy, q.deduplicate, int64(q.maxSourceResolution/time.Millisecond), q.partialErrReport), nil
+	return newQuerier(ctx, q.logger, mint, maxt, q.replicaLabel, q.proxy, q.deduplicate, int64(q.maxSourceResolution/time.Millisecond), q.resolutionFallback, q.partialErrReport), nil
 }
 
 type querier struct {
@@ -63,6 +68,7 @@ type querier struct {
 	deduplicate         bool
 	partialErrReport    PartialErrReporter
 	maxSourceResolution int64
+	resolutionFallback  bool
 }
 
 // newQuerier creates implementation of storage.Querier that fetches data from the proxy
@@ -75,6 +81,7 @@ func newQuerier(
 	proxy storepb.StoreServer,
 	deduplicate bool,
 	maxSourceResolution int64,
+	resolutionFallback bool,
 	partialErrReport PartialErrReporter,
 ) *querier {
 	if logger == nil {
@@ -94,6 +101,7 @@ func newQuerier(
 		proxy:               proxy,
 		deduplicate:         deduplicate,
 		maxSourceResolution: maxSourceResolution,
+		resolutionFallback:  resolutionFallback,
 		partialErrReport:    partialErrReport,
 	}
 }
@@ -161,25 +169,62 @@ func aggrsFromFunc(f string) ([]storepb.Aggr, resAggr) {
 	return []storepb.Aggr{storepb.Aggr_COUNT, storepb.Aggr_SUM}, resAggrAvg
 }
 
+// MultiSelecter selects series matching any of several matcher sets with a single request to the underlying stores.
+type MultiSelecter interface {
+	SelectMulti(params *storage.SelectParams, matcherSets ...[]*labels.Matcher) (storage.SeriesSet, error)
+}
+
 func (q *querier) Select(params *storage.SelectParams, ms ...*labels.Matcher) (storage.SeriesSet, error) {
+	return q.SelectMulti(params, ms)
+}
+
+// SelectMulti returns the union of series matching any of the given matcher sets. Stores resolve all sets at once,
+// which avoids reading the same data for each set.
+func (q *querier) SelectMulti(params *storage.SelectParams, matcherSets ...[]*labels.Matcher) (storage.SeriesSet, error) {
 	span, ctx := tracing.StartSpan(q.ctx, "querier_select")
 	defer span.Finish()
 
-	sms, err := translateMatchers(ms...)
-	if err != nil {
-		return nil, errors.Wrap(err, "convert matchers")
+	var (
+		smsSets  [][]storepb.LabelMatcher
+		lookback bool
+	)
+	for _, ms := range matcherSets {
+		ms, marked := stripLookbackMatcher(ms)
+		lookback = lookback || marked
+
+		sms, err := translateMatchers(ms...)
+		if err != nil {
+			return nil, errors.Wrap(err, "convert matchers")
+		}
+		smsSets = append(smsSets, sms)
 	}
 
 	queryAggrs, resAggr := aggrsFromFunc(params.Func)
 
-	resp := &seriesServer{ctx: ctx}
-	if err := q.proxy.Series(&storepb.SeriesRequest{
-		MinTime:             q.mint,
+	mint := q.mint
+	if lookback {
+		// Samples of downsampled series are up to one resolution apart, so for an extended lookback delta
+		// series are selected from one resolution before the queried range.
+		lookbackWindow := int64(ResolutionLevel(time.Duration(q.maxSourceResolution)*time.Millisecond) / time.Millisecond)
+		if res := expectedResolution(q.ctx); res > lookbackWindow {
+			lookbackWindow = res
+		}
+		if lookbackWindow > int64(promql.LookbackDelta/time.Millisecond) {
+			mint -= lookbackWindow
+		}
+	}
+
+	req := &storepb.SeriesRequest{
+		MinTime:             mint,
 		MaxTime:             q.maxt,
-		Matchers:            sms,
 		MaxResolutionWindow: q.maxSourceResolution,
 		Aggregates:          queryAggrs,
-	}, resp); err != nil {
+		ResolutionFallback:  q.resolutionFallback,
+	}
+	req.SetMatcherSets(smsSets...)
+
+	resp := &seriesServer{ctx: ctx}
+	if err := q.proxy.Series(req, resp); err != nil {
 		return nil, errors.Wrap(err, "proxy Series()")
 	}
 
@@ -187,14 +232,26 @@ func (q *querier) Select(params *storage.SelectParams, ms ...*labels.Matcher) (s
 		q.partialErrReport(errors.New(w))
 	}
 
+	var res int64
+	for _, s := range resp.seriesSet {
+		if r := chunksResolution(s.Chunks); r > res {
+			res = r
+		}
+	}
+	recordServedResolution(q.ctx, res)
+	if lookback && res > int64(promql.LookbackDelta/time.Millisecond) {
+		q.partialErrReport(errors.Errorf("lookback delta extended from %s to the resolution of the served data up to %s",
+			model.Duration(promql.LookbackDelta), model.Duration(time.Duration(res)*time.Millisecond)))
+	}
+
 	if !q.isDedupEnabled() {
 		// Return data without any deduplication.
-		return promSeriesSet{
-			mint: q.mint,
+		return q.withLookback(promSeriesSet{
+			mint: mint,
 			maxt: q.maxt,
 			set:  newStoreSeriesSet(resp.seriesSet),
 			aggr: resAggr,
-		}, nil
+		}, lookback), nil
 	}
 
 	// TODO(fabxc): this could potentially pushed further down into the store API
@@ -202,7 +259,7 @@ func (q *querier) Select(params *storage.SelectParams, ms ...*labels.Matcher) (s
 	sortDedupLabels(resp.seriesSet, q.replicaLabel)
 
 	set := promSeriesSet{
-		mint: q.mint,
+		mint: mint,
 		maxt: q.maxt,
 		set:  newStoreSeriesSet(resp.seriesSet),
 		aggr: resAggr,
@@ -211,7 +268,15 @@ func (q *querier) Select(params *storage.SelectParams, ms ...*labels.Matcher) (s
 	// The merged series set assembles all potentially-overlapping time ranges
 	// of the same series into a single one. The series are ordered so that equal series
 	// from different replicas are sequential. We can now deduplicate those.
-	return newDedupSeriesSet(set, q.replicaLabel), nil
+	return q.withLookback(newDedupSeriesSet(set, q.replicaLabel), lookback), nil
+}
+
+// withLookback extends the lookback delta of the set's series to the resolution of their data if requested.
+func (q *querier) withLookback(set storage.SeriesSet, lookback bool) storage.SeriesSet {
+	if !lookback {
+		return set
+	}
+	return newLookbackSeriesSet(set)
 }
 
 // sortDedupLabels resorts the set so that the same series with different replica
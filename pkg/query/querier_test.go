@@ -33,7 +33,7 @@ func TestQuerier_Series(t *testing.T) {
 
 	// Querier clamps the range to [1,300], which should drop some samples of the result above.
 	// The store API allows endpoints to send more data then initially requested.
-	q := newQuerier(context.Background(), nil, 1, 300, "", testProxy, false, 0, nil)
+	q := newQuerier(context.Background(), nil, 1, 300, "", testProxy, false, 0, false, nil)
 	defer func() { testutil.Ok(t, q.Close()) }()
 
 	res, err := q.Select(&storage.SelectParams{})
@@ -74,6 +74,43 @@ func TestQuerier_Series(t *testing.T) {
 	testutil.Equals(t, len(expected), i)
 }
 
+func TestQuerier_SelectMulti(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	testProxy := &storeServer{}
+	q := newQuerier(context.Background(), nil, 1, 300, "", testProxy, false, 0, false, nil)
+	defer func() { testutil.Ok(t, q.Close()) }()
+
+	ma, err := labels.NewMatcher(labels.MatchEqual, "a", "a")
+	testutil.Ok(t, err)
+	mb, err := labels.NewMatcher(labels.MatchRegexp, "b", "b|c")
+	testutil.Ok(t, err)
+
+	_, err = q.SelectMulti(&storage.SelectParams{}, []*labels.Matcher{ma}, []*labels.Matcher{mb})
+	testutil.Ok(t, err)
+	_, err = q.Select(&storage.SelectParams{}, ma)
+	testutil.Ok(t, err)
+
+	// Several matcher sets are sent at once. A single one is sent as matchers to be understood by all stores.
+	testutil.Equals(t, []*storepb.SeriesRequest{
+		{
+			MinTime: 1,
+			MaxTime: 300,
+			MatcherSets: []storepb.LabelMatchers{
+				{Matchers: []storepb.LabelMatcher{{Type: storepb.LabelMatcher_EQ, Name: "a", Value: "a"}}},
+				{Matchers: []storepb.LabelMatcher{{Type: storepb.LabelMatcher_RE, Name: "b", Value: "b|c"}}},
+			},
+			Aggregates: []storepb.Aggr{storepb.Aggr_COUNT, storepb.Aggr_SUM},
+		},
+		{
+			MinTime:    1,
+			MaxTime:    300,
+			Matchers:   []storepb.LabelMatcher{{Type: storepb.LabelMatcher_EQ, Name: "a", Value: "a"}},
+			Aggregates: []storepb.Aggr{storepb.Aggr_COUNT, storepb.Aggr_SUM},
+		},
+	}, testProxy.reqs)
+}
+
 func TestSortReplicaLabel(t *testing.T) {
 	defer leaktest.CheckTimeout(t, 10*time.Second)()
 
@@ -370,9 +407,11 @@ type storeServer struct {
 	storepb.StoreServer
 
 	resps []*storepb.SeriesResponse
+	reqs  []*storepb.SeriesRequest
 }
 
 func (s *storeServer) Series(r *storepb.SeriesRequest, srv storepb.Store_SeriesServer) error {
+	s.reqs = append(s.reqs, r)
 	for _, resp := range s.resps {
 		err := srv.Send(resp)
 		if err != nil {
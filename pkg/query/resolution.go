@@ -0,0 +1,272 @@
+package query
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/improbable-eng/thanos/pkg/compact/downsample"
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/value"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/storage"
+)
+
+// lookbackLabel marks instant vector selectors whose series have to be served with a lookback delta
+// extended to the resolution of their data. Its matcher matches series without the label, so
+// marked selectors keep their meaning in PromQL. The querier strips the matcher before selecting series.
+const lookbackLabel = "__thanos_lookback__"
+
+// AdjustForResolution rewrites the query so that it yields results when evaluated against data downsampled
+// to the given resolution. Range vectors shorter than twice the resolution may contain less than two samples,
+// which most range functions require, so they are extended to twice the resolution and a warning is returned
+// for each of them. Instant vector selectors are marked to be served with a lookback delta of the resolution
+// of the data actually served if it exceeds the default lookback delta. Queries that cannot be parsed are
+// returned as they are.
+func AdjustForResolution(qs string, res time.Duration) (string, []error) {
+	expr, err := promql.ParseExpr(qs)
+	if err != nil {
+		return qs, nil
+	}
+
+	var (
+		warnings []error
+		minRange = 2 * res
+	)
+	promql.Inspect(expr, func(node promql.Node, _ []promql.Node) error {
+		switch n := node.(type) {
+		case *promql.MatrixSelector:
+			if n.Range >= minRange {
+				return nil
+			}
+			warnings = append(warnings, errors.Errorf("range of %s extended to %s to cover data of resolution %s",
+				n, model.Duration(minRange), model.Duration(res)))
+			n.Range = minRange
+		case *promql.VectorSelector:
+			for _, m := range n.LabelMatchers {
+				if m.Name == lookbackLabel {
+					return nil
+				}
+			}
+			n.LabelMatchers = append(n.LabelMatchers, &labels.Matcher{Type: labels.MatchEqual, Name: lookbackLabel})
+		}
+		return nil
+	})
+	return expr.String(), warnings
+}
+
+// ResolutionLevel returns the coarsest downsampling resolution that does not exceed the maximum source resolution.
+// Stores serve data of that resolution unless they fall back to coarser data.
+func ResolutionLevel(maxSourceResolution time.Duration) time.Duration {
+	res := int64(maxSourceResolution / time.Millisecond)
+	for _, l := range []int64{downsample.ResLevel2, downsample.ResLevel1} {
+		if res >= l {
+			return time.Duration(l) * time.Millisecond
+		}
+	}
+	return 0
+}
+
+type resolutionKey struct{}
+
+// resolution holds the resolution of the data queriers of a query expect and records the coarsest resolution
+// of the data they serve.
+type resolution struct {
+	expected int64
+
+	mtx    sync.Mutex
+	served int64
+}
+
+// WithResolution returns a context in which queriers expect data of up to the given resolution and record
+// the coarsest resolution of the data they serve. Series of instant vector selectors marked by AdjustForResolution
+// are selected from up to the expected resolution before the queried range, so that their samples are found
+// within the extended lookback delta.
+func WithResolution(ctx context.Context, res time.Duration) context.Context {
+	return context.WithValue(ctx, resolutionKey{}, &resolution{expected: int64(res / time.Millisecond)})
+}
+
+// ServedResolution returns the coarsest resolution of the data served by queriers of the context.
+// It is zero if only raw data was served or the context does not record it.
+func ServedResolution(ctx context.Context) time.Duration {
+	r, ok := ctx.Value(resolutionKey{}).(*resolution)
+	if !ok {
+		return 0
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return time.Duration(r.served) * time.Millisecond
+}
+
+func expectedResolution(ctx context.Context) int64 {
+	r, ok := ctx.Value(resolutionKey{}).(*resolution)
+	if !ok {
+		return 0
+	}
+	return r.expected
+}
+
+func recordServedResolution(ctx context.Context, res int64) {
+	r, ok := ctx.Value(resolutionKey{}).(*resolution)
+	if !ok {
+		return
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if res > r.served {
+		r.served = res
+	}
+}
+
+// chunksResolution returns the coarsest resolution of the chunks.
+func chunksResolution(chks []storepb.AggrChunk) (res int64) {
+	for _, c := range chks {
+		if c.Resolution > res {
+			res = c.Resolution
+		}
+	}
+	return res
+}
+
+// seriesResolution returns the coarsest resolution of the data of the series.
+func seriesResolution(s storage.Series) (res int64) {
+	switch s := s.(type) {
+	case *chunkSeries:
+		return chunksResolution(s.chunks)
+	case *dedupSeries:
+		for _, r := range s.replicas {
+			if rr := seriesResolution(r); rr > res {
+				res = rr
+			}
+		}
+	case seriesWithLabels:
+		return seriesResolution(s.Series)
+	}
+	return res
+}
+
+// stripLookbackMatcher removes the lookback marker from the matchers and reports whether it was present.
+func stripLookbackMatcher(ms []*labels.Matcher) ([]*labels.Matcher, bool) {
+	for i, m := range ms {
+		if m.Name != lookbackLabel {
+			continue
+		}
+		res := make([]*labels.Matcher, 0, len(ms)-1)
+		res = append(res, ms[:i]...)
+		return append(res, ms[i+1:]...), true
+	}
+	return ms, false
+}
+
+// lookbackSeriesSet serves each series of the underlying set with a lookback delta of the resolution of its data
+// if it exceeds the default lookback delta.
+type lookbackSeriesSet struct {
+	storage.SeriesSet
+	delta int64
+}
+
+func newLookbackSeriesSet(set storage.SeriesSet) storage.SeriesSet {
+	return &lookbackSeriesSet{
+		SeriesSet: set,
+		delta:     int64(promql.LookbackDelta / time.Millisecond),
+	}
+}
+
+func (s *lookbackSeriesSet) At() storage.Series {
+	series := s.SeriesSet.At()
+	res := seriesResolution(series)
+	if res <= s.delta {
+		return series
+	}
+	return &lookbackSeries{Series: series, delta: s.delta, lookback: res}
+}
+
+type lookbackSeries struct {
+	storage.Series
+	delta, lookback int64
+}
+
+func (s *lookbackSeries) Iterator() storage.SeriesIterator {
+	return newLookbackSeriesIterator(s.Series.Iterator(), s.delta, s.lookback)
+}
+
+// lookbackSeriesIterator repeats every sample of the underlying iterator at intervals of the PromQL lookback
+// delta until the next sample or until the extended lookback delta has passed. PromQL thereby finds each sample
+// for all evaluation timestamps within the extended lookback delta after it. Stale markers are not repeated.
+type lookbackSeriesIterator struct {
+	it              storage.SeriesIterator
+	delta, lookback int64
+
+	started bool
+	ok      bool
+	t       int64
+	v       float64
+	// Timestamp of the underlying sample the current sample repeats.
+	src int64
+
+	peekOk bool
+	peekT  int64
+	peekV  float64
+}
+
+func newLookbackSeriesIterator(it storage.SeriesIterator, delta, lookback int64) *lookbackSeriesIterator {
+	return &lookbackSeriesIterator{it: it, delta: delta, lookback: lookback}
+}
+
+func (it *lookbackSeriesIterator) peek() {
+	it.peekOk = it.it.Next()
+	if it.peekOk {
+		it.peekT, it.peekV = it.it.At()
+	}
+}
+
+func (it *lookbackSeriesIterator) Next() bool {
+	it.ok = it.next()
+	return it.ok
+}
+
+func (it *lookbackSeriesIterator) next() bool {
+	if !it.started {
+		it.started = true
+		it.peek()
+	} else if !value.IsStaleNaN(it.v) {
+		// Repeat the current sample one lookback delta later, but not beyond the extended lookback delta.
+		t := it.t + it.delta
+		if last := it.src + it.lookback - it.delta; t > last {
+			t = last
+		}
+		if t > it.t && (!it.peekOk || t < it.peekT) {
+			it.t = t
+			return true
+		}
+	}
+	if !it.peekOk {
+		return false
+	}
+	it.t, it.v, it.src = it.peekT, it.peekV, it.peekT
+	it.peek()
+	return true
+}
+
+func (it *lookbackSeriesIterator) Seek(t int64) bool {
+	if it.started && (!it.ok || it.t >= t) {
+		return it.ok
+	}
+	for it.Next() {
+		if it.t >= t {
+			return true
+		}
+	}
+	return false
+}
+
+func (it *lookbackSeriesIterator) At() (int64, float64) {
+	return it.t, it.v
+}
+
+func (it *lookbackSeriesIterator) Err() error {
+	return it.it.Err()
+}
@@ -0,0 +1,189 @@
+package query
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+	"github.com/improbable-eng/thanos/pkg/testutil"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/value"
+	"github.com/prometheus/prometheus/promql"
+)
+
+func TestAdjustForResolution(t *testing.T) {
+	for _, tcase := range []struct {
+		query    string
+		res      time.Duration
+		exp      string
+		warnings int
+	}{
+		{query: `rate(x[5m])`, res: 0, exp: `rate(x[5m])`},
+		{query: `rate(x[5m])`, res: 1 * time.Minute, exp: `rate(x[5m])`},
+		{query: `rate(x[5m])`, res: 5 * time.Minute, exp: `rate(x[10m])`, warnings: 1},
+		{query: `x + y`, res: 0, exp: `x{__thanos_lookback__=""} + y{__thanos_lookback__=""}`},
+		{query: `x{__thanos_lookback__=""}`, res: 0, exp: `x{__thanos_lookback__=""}`},
+		{query: `rate(x[1h]) / y{a="b"}`, res: time.Hour, exp: `rate(x[2h]) / y{__thanos_lookback__="",a="b"}`, warnings: 1},
+		{query: `invalid(`, res: time.Hour, exp: `invalid(`},
+	} {
+		qs, warnings := AdjustForResolution(tcase.query, tcase.res)
+		testutil.Equals(t, tcase.exp, qs)
+		testutil.Equals(t, tcase.warnings, len(warnings))
+	}
+}
+
+func TestLookbackSeriesIterator(t *testing.T) {
+	it := newLookbackSeriesIterator(&listSeriesIterator{
+		l: []sample{{0, 1}, {12, 2}, {14, 3}, {100, math.Float64frombits(value.StaleNaN)}, {200, 4}},
+		i: -1,
+	}, 5, 12)
+
+	res := expandSeries(t, it)
+	for i := range res {
+		// NaN is never equal to itself.
+		if value.IsStaleNaN(res[i].v) {
+			res[i].v = -1
+		}
+	}
+	testutil.Equals(t, []sample{
+		{0, 1}, {5, 1}, {7, 1},
+		{12, 2},
+		{14, 3}, {19, 3}, {21, 3},
+		{100, -1},
+		{200, 4}, {205, 4}, {207, 4},
+	}, res)
+
+	it = newLookbackSeriesIterator(&listSeriesIterator{l: []sample{{0, 1}, {12, 2}}, i: -1}, 5, 12)
+	testutil.Assert(t, it.Seek(6), "expected sample")
+	ts, v := it.At()
+	testutil.Equals(t, sample{7, 1}, sample{ts, v})
+	testutil.Assert(t, it.Seek(3), "expected sample")
+	ts, v = it.At()
+	testutil.Equals(t, sample{7, 1}, sample{ts, v})
+	// The last sample is repeated as well.
+	testutil.Assert(t, it.Seek(13), "expected sample")
+	ts, v = it.At()
+	testutil.Equals(t, sample{17, 2}, sample{ts, v})
+	testutil.Assert(t, !it.Seek(25), "expected no sample")
+}
+
+// listSeriesIterator iterates over the samples of the list. Unlike SampleIterator, it does not report a sample past
+// the end of the list.
+type listSeriesIterator struct {
+	l []sample
+	i int
+}
+
+func (it *listSeriesIterator) Next() bool {
+	if it.i < len(it.l) {
+		it.i++
+	}
+	return it.i < len(it.l)
+}
+
+func (it *listSeriesIterator) Seek(t int64) bool {
+	if it.i < 0 {
+		it.i = 0
+	}
+	for ; it.i < len(it.l); it.i++ {
+		if it.l[it.i].t >= t {
+			return true
+		}
+	}
+	return false
+}
+
+func (it *listSeriesIterator) At() (int64, float64) {
+	return it.l[it.i].t, it.l[it.i].v
+}
+
+func (it *listSeriesIterator) Err() error {
+	return nil
+}
+
+func TestResolutionLevel(t *testing.T) {
+	for _, tcase := range []struct {
+		maxSourceResolution, exp time.Duration
+	}{
+		{maxSourceResolution: 0, exp: 0},
+		{maxSourceResolution: 4 * time.Minute, exp: 0},
+		{maxSourceResolution: 5 * time.Minute, exp: 5 * time.Minute},
+		{maxSourceResolution: 12 * time.Minute, exp: 5 * time.Minute},
+		{maxSourceResolution: time.Hour, exp: time.Hour},
+		{maxSourceResolution: 24 * time.Hour, exp: time.Hour},
+	} {
+		testutil.Equals(t, tcase.exp, ResolutionLevel(tcase.maxSourceResolution))
+	}
+}
+
+func TestAdjustForResolution_Engine(t *testing.T) {
+	var (
+		hour = int64(time.Hour / time.Millisecond)
+		// Series with a sample per hour as in data downsampled to a resolution of 1h.
+		x      = storeSeriesResponse(t, labels.FromStrings("__name__", "x"), []sample{{0, 1}, {hour, 2}, {2 * hour, 3}, {3 * hour, 4}})
+		proxy  = &storeServer{resps: []*storepb.SeriesResponse{x}}
+		engine = promql.NewEngine(nil, nil, 1, time.Minute)
+		start  = time.Unix(0, 0)
+		end    = start.Add(3 * time.Hour)
+		res    = time.Hour
+	)
+	x.GetSeries().Chunks[0].Resolution = hour
+	queryable := NewQueryableCreator(nil, proxy, "")(false, res, false, nil)
+
+	for _, tcase := range []struct {
+		query  string
+		metric labels.Labels
+		exp    []promql.Point
+	}{
+		{
+			query:  `x`,
+			metric: labels.FromStrings("__name__", "x"),
+			exp: []promql.Point{
+				{T: 0, V: 1}, {T: hour / 2, V: 1}, {T: hour, V: 2}, {T: 3 * hour / 2, V: 2},
+				{T: 2 * hour, V: 3}, {T: 5 * hour / 2, V: 3}, {T: 3 * hour, V: 4},
+			},
+		},
+		{
+			query:  `increase(x[30m])`,
+			metric: labels.Labels{},
+			// Ranges of 2h contain at least two samples from the first hour on.
+			exp: []promql.Point{
+				{T: hour, V: 2}, {T: 3 * hour / 2, V: 2}, {T: 2 * hour, V: 2}, {T: 5 * hour / 2, V: 2}, {T: 3 * hour, V: 2},
+			},
+		},
+	} {
+		// Without adjustments, steps between samples return nothing.
+		qry, err := engine.NewRangeQuery(queryable, tcase.query, start, end, 30*time.Minute)
+		testutil.Ok(t, err)
+		r := qry.Exec(context.Background())
+		testutil.Ok(t, r.Err)
+		mat, err := r.Matrix()
+		testutil.Ok(t, err)
+		testutil.Assert(t, len(mat) == 0 || len(mat[0].Points) < len(tcase.exp), "expected sparse result without adjustments")
+
+		qs, _ := AdjustForResolution(tcase.query, res)
+		qry, err = engine.NewRangeQuery(queryable, qs, start, end, 30*time.Minute)
+		testutil.Ok(t, err)
+		r = qry.Exec(context.Background())
+		testutil.Ok(t, r.Err)
+		mat, err = r.Matrix()
+		testutil.Ok(t, err)
+		testutil.Equals(t, 1, len(mat))
+		testutil.Equals(t, tcase.metric, mat[0].Metric)
+		testutil.Equals(t, tcase.exp, mat[0].Points)
+	}
+
+	// The lookback delta of raw series is not extended.
+	x.GetSeries().Chunks[0].Resolution = 0
+	qs, _ := AdjustForResolution(`x`, 0)
+	qry, err := engine.NewRangeQuery(queryable, qs, start, end, 30*time.Minute)
+	testutil.Ok(t, err)
+	r := qry.Exec(context.Background())
+	testutil.Ok(t, r.Err)
+	mat, err := r.Matrix()
+	testutil.Ok(t, err)
+	testutil.Equals(t, 1, len(mat))
+	testutil.Equals(t, []promql.Point{{T: 0, V: 1}, {T: hour, V: 2}, {T: 2 * hour, V: 3}, {T: 3 * hour, V: 4}}, mat[0].Points)
+}
@@ -21,22 +21,27 @@ import (
 type StoreSpec interface {
 	// Addr returns StoreAPI Address for the store spec. It is used as ID for store.
 	Addr() string
-	// Metadata returns current labels and min, max ranges for store.
-	// It can change for every call for this method.
+	// Metadata returns current labels, min, max ranges, the filter of metric names and the supported features of store.
+	// A nil filter means that the store may hold any metric. It can change for every call for this method.
+	// The last metadata of the store allows to reuse parts that did not change.
 	// If metadata call fails we assume that store is no longer accessible and we should not use it.
 	// NOTE: It is implementation responsibility to retry until context timeout, but a caller responsibility to manage
 	// given store connection.
-	Metadata(ctx context.Context, client storepb.StoreClient) (labels []storepb.Label, mint int64, maxt int64, err error)
+	Metadata(ctx context.Context, client storepb.StoreClient, last *storepb.InfoResponse) (*storepb.InfoResponse, error)
+	// InjectedLabels returns labels that are added to all series and the labels of the store, replacing labels of the
+	// same name. The store set checks the uniqueness of the labels of stores including them.
+	InjectedLabels() []storepb.Label
 }
 
 type grpcStoreSpec struct {
-	addr string
+	addr     string
+	injected []storepb.Label
 }
 
-// NewGRPCStoreSpec creates store pure gRPC spec.
+// NewGRPCStoreSpec creates store pure gRPC spec with the given labels injected into the store.
 // It uses Info gRPC call to get Metadata.
-func NewGRPCStoreSpec(addr string) StoreSpec {
-	return &grpcStoreSpec{addr: addr}
+func NewGRPCStoreSpec(addr string, injectedLabels []storepb.Label) StoreSpec {
+	return &grpcStoreSpec{addr: addr, injected: injectedLabels}
 }
 
 func (s *grpcStoreSpec) Addr() string {
@@ -44,14 +49,24 @@ func (s *grpcStoreSpec) Addr() string {
 	return s.addr
 }
 
+func (s *grpcStoreSpec) InjectedLabels() []storepb.Label {
+	return s.injected
+}
+
 // Metadata method for gRPC store API tries to reach host Info method until context timeout. If we are unable to get metadata after
 // that time, we assume that the host is unhealthy and return error.
-func (s *grpcStoreSpec) Metadata(ctx context.Context, client storepb.StoreClient) (labels []storepb.Label, mint int64, maxt int64, err error) {
-	resp, err := client.Info(ctx, &storepb.InfoRequest{}, grpc.FailFast(false))
+// The store only sends its filter of metric names if it changed.
+func (s *grpcStoreSpec) Metadata(ctx context.Context, client storepb.StoreClient, last *storepb.InfoResponse) (*storepb.InfoResponse, error) {
+	resp, err := client.Info(ctx, &storepb.InfoRequest{
+		MetricNamesChecksum: last.MetricNames.Checksum(),
+	}, grpc.FailFast(false))
 	if err != nil {
-		return nil, 0, 0, errors.Wrapf(err, "fetching store info from %s", s.addr)
+		return nil, errors.Wrapf(err, "fetching store info from %s", s.addr)
+	}
+	if resp.MetricNamesUnchanged {
+		resp.MetricNames = last.MetricNames
 	}
-	return resp.Labels, resp.MinTime, resp.MaxTime, nil
+	return resp, nil
 }
 
 // StoreSet maintains a set of active stores. It is backed up by Store Specifications that are dynamically fetched on
@@ -136,26 +151,51 @@ func NewStoreSet(
 
 type storeRef struct {
 	storepb.StoreClient
+	// QueryClient is only implemented by stores advertising it. Others respond with Unimplemented.
+	storepb.QueryClient
 
 	mtx  sync.RWMutex
 	cc   *grpc.ClientConn
 	addr string
 
 	// Meta (can change during runtime).
-	labels  []storepb.Label
-	minTime int64
-	maxTime int64
+	labels      []storepb.Label
+	injected    []storepb.Label
+	minTime     int64
+	maxTime     int64
+	metricNames *storepb.MetricNameFilter
+	matcherSets bool
+	query       bool
 
 	logger log.Logger
 }
 
-func (s *storeRef) Update(labels []storepb.Label, minTime int64, maxTime int64) {
+// Update updates the metadata of the store. The labels of the store are updated with the injected labels.
+func (s *storeRef) Update(info *storepb.InfoResponse, injected []storepb.Label) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.labels = store.InjectLabels(info.Labels, injected)
+	s.injected = injected
+	s.minTime = info.MinTime
+	s.maxTime = info.MaxTime
+	s.metricNames = info.MetricNames
+	s.matcherSets = info.SupportsMatcherSets
+	s.query = info.SupportsQuery
+}
+
+// lastInfo returns the last metadata of the store without its labels.
+func (s *storeRef) lastInfo() *storepb.InfoResponse {
 	s.mtx.RLock()
 	defer s.mtx.RUnlock()
 
-	s.labels = labels
-	s.minTime = minTime
-	s.maxTime = maxTime
+	return &storepb.InfoResponse{
+		MinTime:             s.minTime,
+		MaxTime:             s.maxTime,
+		MetricNames:         s.metricNames,
+		SupportsMatcherSets: s.matcherSets,
+		SupportsQuery:       s.query,
+	}
 }
 
 func (s *storeRef) Labels() []storepb.Label {
@@ -171,6 +211,34 @@ func (s *storeRef) TimeRange() (int64, int64) {
 	return s.minTime, s.maxTime
 }
 
+func (s *storeRef) MetricNames() *storepb.MetricNameFilter {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	return s.metricNames
+}
+
+func (s *storeRef) SupportsMatcherSets() bool {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	return s.matcherSets
+}
+
+func (s *storeRef) SupportsQuery() bool {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	return s.query
+}
+
+func (s *storeRef) InjectedLabels() []storepb.Label {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	return s.injected
+}
+
 func (s *storeRef) String() string {
 	mint, maxt := s.TimeRange()
 	return fmt.Sprintf("Addr: %s Labels: %v Mint: %d Maxt: %d", s.addr, s.Labels(), mint, maxt)
@@ -260,13 +328,13 @@ func (s *StoreSet) getHealthyStores(ctx context.Context) map[string]*storeRef {
 			st, ok := s.stores[addr]
 			if ok {
 				// Check existing store. Is it healthy? What are current metadata?
-				labels, minTime, maxTime, err := spec.Metadata(ctx, st.StoreClient)
+				info, err := spec.Metadata(ctx, st.StoreClient, st.lastInfo())
 				if err != nil {
 					// Peer unhealthy. Do not include in healthy stores.
 					level.Warn(s.logger).Log("msg", "update of store node failed", "err", err, "address", addr)
 					return
 				}
-				st.Update(labels, minTime, maxTime)
+				st.Update(info, spec.InjectedLabels())
 			} else {
 				// New store or was unhealthy and was removed in the past - create new one.
 				conn, err := grpc.DialContext(ctx, addr, s.dialOpts...)
@@ -274,7 +342,7 @@ func (s *StoreSet) getHealthyStores(ctx context.Context) map[string]*storeRef {
 					level.Warn(s.logger).Log("msg", "update of store node failed", "err", errors.Wrap(err, "dialing connection"), "address", addr)
 					return
 				}
-				st = &storeRef{StoreClient: storepb.NewStoreClient(conn), cc: conn, addr: addr, logger: s.logger}
+				st = &storeRef{StoreClient: storepb.NewStoreClient(conn), QueryClient: storepb.NewQueryClient(conn), cc: conn, addr: addr, logger: s.logger}
 
 				// Initial info call for all types of stores (gossip + static) to check gRPC StoreAPI.
 				resp, err := st.StoreClient.Info(ctx, &storepb.InfoRequest{}, grpc.FailFast(false))
@@ -283,7 +351,7 @@ func (s *StoreSet) getHealthyStores(ctx context.Context) map[string]*storeRef {
 					level.Warn(s.logger).Log("msg", "update of store node failed", "err", errors.Wrap(err, "initial store client info fetch"), "address", addr)
 					return
 				}
-				st.Update(resp.Labels, resp.MinTime, resp.MaxTime)
+				st.Update(resp, spec.InjectedLabels())
 			}
 
 			mtx.Lock()
@@ -125,7 +125,7 @@ func (s *testStores) CloseOne(addr string) {
 func specsFromAddrFunc(addrs []string) func() []StoreSpec {
 	return func() (specs []StoreSpec) {
 		for _, addr := range addrs {
-			specs = append(specs, NewGRPCStoreSpec(addr))
+			specs = append(specs, NewGRPCStoreSpec(addr, nil))
 		}
 		return specs
 	}
@@ -292,3 +292,66 @@ func TestStoreSet_AllAvailable_BlockExtLsetDuplicates(t *testing.T) {
 		}
 	}
 }
+
+func TestStoreSet_InjectedLabels(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	// Both stores have the same external labels, which are made unique by injecting labels into one of them.
+	st, err := newTestStores(2, []storepb.Label{{Name: "l1", Value: "v1"}}, []storepb.Label{{Name: "l1", Value: "v1"}})
+	testutil.Ok(t, err)
+	defer st.Close()
+
+	addrs := st.StoreAddresses()
+	injected := []storepb.Label{{Name: "l0", Value: "partner"}, {Name: "l1", Value: "v2"}}
+
+	storeSet := NewStoreSet(nil, nil, func() []StoreSpec {
+		return []StoreSpec{NewGRPCStoreSpec(addrs[0], nil), NewGRPCStoreSpec(addrs[1], injected)}
+	}, testGRPCOpts)
+	storeSet.gRPCInfoCallTimeout = 2 * time.Second
+	defer storeSet.Close()
+
+	storeSet.Update(context.Background())
+	testutil.Equals(t, 2, len(storeSet.stores))
+
+	testutil.Equals(t, []storepb.Label{{Name: "l1", Value: "v1"}}, storeSet.stores[addrs[0]].Labels())
+	testutil.Equals(t, 0, len(storeSet.stores[addrs[0]].InjectedLabels()))
+	testutil.Equals(t, injected, storeSet.stores[addrs[1]].Labels())
+	testutil.Equals(t, injected, storeSet.stores[addrs[1]].InjectedLabels())
+}
+
+type infoClient struct {
+	// This field just exist to pseudo-implement the unused methods of the interface.
+	storepb.StoreClient
+
+	info storepb.InfoResponse
+	reqs []*storepb.InfoRequest
+}
+
+func (c *infoClient) Info(_ context.Context, r *storepb.InfoRequest, _ ...grpc.CallOption) (*storepb.InfoResponse, error) {
+	c.reqs = append(c.reqs, r)
+	res := c.info
+	res.MetricNames = nil
+	res.SetMetricNames(c.info.MetricNames, r)
+	return &res, nil
+}
+
+func TestGRPCStoreSpec_Metadata_MetricNames(t *testing.T) {
+	var (
+		ctx    = context.Background()
+		names  = storepb.NewMetricNameFilter([]string{"up"}, 100)
+		client = &infoClient{info: storepb.InfoResponse{MaxTime: 100, MetricNames: names}}
+		spec   = NewGRPCStoreSpec("addr", nil)
+	)
+	info, err := spec.Metadata(ctx, client, &storepb.InfoResponse{})
+	testutil.Ok(t, err)
+	testutil.Equals(t, names, info.MetricNames)
+	testutil.Equals(t, uint64(0), client.reqs[0].MetricNamesChecksum)
+
+	// The filter is not sent again if it did not change.
+	info, err = spec.Metadata(ctx, client, info)
+	testutil.Ok(t, err)
+	testutil.Equals(t, names.Checksum(), client.reqs[1].MetricNamesChecksum)
+	testutil.Assert(t, info.MetricNamesUnchanged, "expected unchanged metric names")
+	testutil.Assert(t, info.MetricNames == names, "expected metric names of last metadata")
+	testutil.Equals(t, int64(100), info.MaxTime)
+}
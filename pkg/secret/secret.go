@@ -0,0 +1,134 @@
+// Package secret resolves references to secrets kept outside of configuration files and
+// redacts secrets from configurations before they are displayed or logged.
+//
+// Any string value of a configuration can reference a secret using:
+//
+//	$(file:<path>) - replaced by the content of the file, without trailing new lines.
+//	$(env:<name>)  - replaced by the value of the environment variable.
+package secret
+
+import (
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// Redacted replaces secrets in redacted configurations.
+const Redacted = "<secret>"
+
+var refRe = regexp.MustCompile(`\$\((file|env):([^)]+)\)`)
+
+// keys are the names of configuration fields holding secrets.
+var keys = map[string]struct{}{
+	"secret_key":          {},
+	"storage_account_key": {},
+	"password":            {},
+}
+
+// Expand returns the given string with all secret references replaced by the referenced secrets.
+func Expand(s string) (string, error) {
+	var err error
+	res := refRe.ReplaceAllStringFunc(s, func(ref string) string {
+		m := refRe.FindStringSubmatch(ref)
+		switch m[1] {
+		case "file":
+			b, ferr := ioutil.ReadFile(m[2])
+			if ferr != nil {
+				err = errors.Wrapf(ferr, "read secret file %s", m[2])
+				return ""
+			}
+			return strings.TrimRight(string(b), "\r\n")
+		default:
+			v, ok := os.LookupEnv(m[2])
+			if !ok {
+				err = errors.Errorf("secret environment variable %s is not set", m[2])
+				return ""
+			}
+			return v
+		}
+	})
+	if err != nil {
+		return "", err
+	}
+	return res, nil
+}
+
+// ExpandValues replaces secret references in all string values of the given unmarshalled YAML.
+func ExpandValues(v interface{}) (interface{}, error) {
+	return walk(v, func(_ string, s string) (string, error) {
+		return Expand(s)
+	})
+}
+
+// ReadFile returns the value of a secret which can be given either inline or as a path to a file
+// containing it. It is meant for `*_file` variants of configuration fields.
+func ReadFile(value, file string) (string, error) {
+	if file == "" {
+		return value, nil
+	}
+	if value != "" {
+		return "", errors.Errorf("both secret and secret file %s are set", file)
+	}
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", errors.Wrapf(err, "read secret file %s", file)
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+// RedactYAML returns the given YAML configuration with the values of all fields holding secrets
+// replaced. References to secrets are kept, as they do not reveal them.
+func RedactYAML(b []byte) ([]byte, error) {
+	var v yaml.MapSlice
+	if err := yaml.Unmarshal(b, &v); err != nil {
+		return nil, errors.Wrap(err, "parse YAML")
+	}
+	res, err := walk(v, func(key string, s string) (string, error) {
+		if _, ok := keys[key]; !ok || s == "" || refRe.FindString(s) == s {
+			return s, nil
+		}
+		return Redacted, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(res)
+}
+
+// walk calls f for all string values of the given unmarshalled YAML along with the key they are set for
+// and replaces them with the result.
+func walk(v interface{}, f func(key, s string) (string, error)) (interface{}, error) {
+	return walkValue("", v, f)
+}
+
+func walkValue(key string, v interface{}, f func(key, s string) (string, error)) (res interface{}, err error) {
+	switch t := v.(type) {
+	case string:
+		return f(key, t)
+	case yaml.MapSlice:
+		for i, item := range t {
+			k, _ := item.Key.(string)
+			if t[i].Value, err = walkValue(k, item.Value, f); err != nil {
+				return nil, err
+			}
+		}
+	case map[interface{}]interface{}:
+		for k, item := range t {
+			ks, _ := k.(string)
+			if t[k], err = walkValue(ks, item, f); err != nil {
+				return nil, err
+			}
+		}
+	case []interface{}:
+		for i, item := range t {
+			if t[i], err = walkValue(key, item, f); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return v, nil
+}
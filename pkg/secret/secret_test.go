@@ -0,0 +1,109 @@
+package secret
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/improbable-eng/thanos/pkg/testutil"
+)
+
+func TestExpand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secret-test")
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, os.RemoveAll(dir)) }()
+
+	file := filepath.Join(dir, "key")
+	testutil.Ok(t, ioutil.WriteFile(file, []byte("file-secret\n"), 0600))
+	testutil.Ok(t, os.Setenv("THANOS_SECRET_TEST", "env-secret"))
+	defer func() { testutil.Ok(t, os.Unsetenv("THANOS_SECRET_TEST")) }()
+
+	for _, tcase := range []struct {
+		input  string
+		exp    string
+		expErr bool
+	}{
+		{input: "plain", exp: "plain"},
+		{input: "$(file:" + file + ")", exp: "file-secret"},
+		{input: "$(env:THANOS_SECRET_TEST)", exp: "env-secret"},
+		{input: "a-$(env:THANOS_SECRET_TEST)-$(file:" + file + ")", exp: "a-env-secret-file-secret"},
+		{input: "$(env:THANOS_SECRET_TEST_NOT_SET)", expErr: true},
+		{input: "$(file:" + filepath.Join(dir, "not-existing") + ")", expErr: true},
+	} {
+		res, err := Expand(tcase.input)
+		if tcase.expErr {
+			testutil.NotOk(t, err)
+			continue
+		}
+		testutil.Ok(t, err)
+		testutil.Equals(t, tcase.exp, res)
+	}
+}
+
+func TestExpandValues(t *testing.T) {
+	testutil.Ok(t, os.Setenv("THANOS_SECRET_TEST", "env-secret"))
+	defer func() { testutil.Ok(t, os.Unsetenv("THANOS_SECRET_TEST")) }()
+
+	v := map[interface{}]interface{}{
+		"secret_key": "$(env:THANOS_SECRET_TEST)",
+		"nested":     map[interface{}]interface{}{"list": []interface{}{"$(env:THANOS_SECRET_TEST)", 1}},
+		"insecure":   true,
+	}
+	res, err := ExpandValues(v)
+	testutil.Ok(t, err)
+	testutil.Equals(t, map[interface{}]interface{}{
+		"secret_key": "env-secret",
+		"nested":     map[interface{}]interface{}{"list": []interface{}{"env-secret", 1}},
+		"insecure":   true,
+	}, res)
+}
+
+func TestReadFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secret-test")
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, os.RemoveAll(dir)) }()
+
+	file := filepath.Join(dir, "key")
+	testutil.Ok(t, ioutil.WriteFile(file, []byte("file-secret\n"), 0600))
+
+	s, err := ReadFile("inline", "")
+	testutil.Ok(t, err)
+	testutil.Equals(t, "inline", s)
+
+	s, err = ReadFile("", file)
+	testutil.Ok(t, err)
+	testutil.Equals(t, "file-secret", s)
+
+	_, err = ReadFile("inline", file)
+	testutil.NotOk(t, err)
+}
+
+func TestRedactYAML(t *testing.T) {
+	res, err := RedactYAML([]byte(`type: S3
+config:
+  bucket: test
+  access_key: key-id
+  secret_key: very-secret
+audit:
+  prefix: audit
+`))
+	testutil.Ok(t, err)
+	testutil.Equals(t, `type: S3
+config:
+  bucket: test
+  access_key: key-id
+  secret_key: <secret>
+audit:
+  prefix: audit
+`, string(res))
+
+	// References are not secrets themselves.
+	res, err = RedactYAML([]byte(`config:
+  password: $(file:/etc/secrets/password)
+`))
+	testutil.Ok(t, err)
+	testutil.Equals(t, `config:
+  password: $(file:/etc/secrets/password)
+`, string(res))
+}
@@ -10,15 +10,18 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 	"github.com/improbable-eng/thanos/pkg/block"
 	"github.com/improbable-eng/thanos/pkg/objstore"
+	"github.com/improbable-eng/thanos/pkg/objstore/audit"
 	"github.com/improbable-eng/thanos/pkg/runutil"
 	"github.com/oklog/ulid"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/pkg/timestamp"
 	"github.com/prometheus/tsdb/fileutil"
 	"github.com/prometheus/tsdb/labels"
 )
@@ -28,6 +31,11 @@ type metrics struct {
 	dirSyncFailures prometheus.Counter
 	uploads         prometheus.Counter
 	uploadFailures  prometheus.Counter
+
+	reconciliations        prometheus.Counter
+	reconciliationFailures prometheus.Counter
+	reconcileUploads       prometheus.Counter
+	unreconciledBlocks     prometheus.Gauge
 }
 
 func newMetrics(r prometheus.Registerer) *metrics {
@@ -49,6 +57,22 @@ func newMetrics(r prometheus.Registerer) *metrics {
 		Name: "thanos_shipper_upload_failures_total",
 		Help: "Total number of failed object uploads",
 	})
+	m.reconciliations = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "thanos_shipper_reconciliations_total",
+		Help: "Total number of reconciliations of uploaded blocks against the bucket",
+	})
+	m.reconciliationFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "thanos_shipper_reconciliation_failures_total",
+		Help: "Total number of failed reconciliations of uploaded blocks against the bucket",
+	})
+	m.reconcileUploads = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "thanos_shipper_reconcile_uploads_total",
+		Help: "Total number of uploaded blocks uploaded again because they were missing in the bucket",
+	})
+	m.unreconciledBlocks = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "thanos_shipper_unreconciled_blocks",
+		Help: "Number of uploaded blocks missing in the bucket at the last reconciliation",
+	})
 
 	if r != nil {
 		r.MustRegister(
@@ -56,6 +80,10 @@ func newMetrics(r prometheus.Registerer) *metrics {
 			m.dirSyncFailures,
 			m.uploads,
 			m.uploadFailures,
+			m.reconciliations,
+			m.reconciliationFailures,
+			m.reconcileUploads,
+			m.unreconciledBlocks,
 		)
 	}
 	return &m
@@ -70,6 +98,9 @@ type Shipper struct {
 	bucket  objstore.Bucket
 	labels  func() labels.Labels
 	source  block.SourceType
+
+	// Uploaded blocks that were missing in the bucket at the last reconciliation.
+	missing map[ulid.ULID]struct{}
 }
 
 // New creates a new shipper that detects new TSDB blocks in dir and uploads them
@@ -180,6 +211,137 @@ func (s *Shipper) Sync(ctx context.Context) {
 	}
 }
 
+// Reconcile checks that every local block recorded as uploaded is in the bucket, either itself or as a compaction
+// source of another block, e.g. one it was compacted into or split into. Missing blocks are reported in logs and the
+// thanos_shipper_unreconciled_blocks metric. If upload is true, blocks that are still missing at the next
+// reconciliation are uploaded again. Waiting for the next reconciliation avoids uploads of blocks that were being
+// compacted while the bucket was read. Blocks deleted on purpose, i.e. those marked as deleted in the bucket index
+// and those older than the given retention of raw data in the bucket, are not reconciled. A retention of zero means
+// that raw data is retained forever.
+// It is not concurrency-safe and must not run concurrently with Sync.
+func (s *Shipper) Reconcile(ctx context.Context, upload bool, retention time.Duration) error {
+	s.metrics.reconciliations.Inc()
+
+	meta, err := ReadMetaFile(s.dir)
+	if os.IsNotExist(err) {
+		// Nothing was uploaded yet.
+		return nil
+	}
+	if err != nil {
+		s.metrics.reconciliationFailures.Inc()
+		return errors.Wrap(err, "read shipper meta file")
+	}
+	hasUploaded := make(map[ulid.ULID]struct{}, len(meta.Uploaded))
+	for _, id := range meta.Uploaded {
+		hasUploaded[id] = struct{}{}
+	}
+
+	inBucket, deleted, fromIndex, err := s.bucketSources(ctx)
+	if err != nil {
+		s.metrics.reconciliationFailures.Inc()
+		return err
+	}
+
+	missing := map[ulid.ULID]struct{}{}
+	if err := s.iterBlockMetas(func(m *block.Meta) error {
+		// Compacted blocks are never uploaded and unrecorded blocks are uploaded by Sync.
+		if _, ok := hasUploaded[m.ULID]; !ok || m.Compaction.Level > 1 {
+			return nil
+		}
+		if retention > 0 && time.Now().After(timestamp.Time(m.MaxTime).Add(retention)) {
+			return nil
+		}
+		if _, ok := deleted[m.ULID]; ok {
+			return nil
+		}
+		if hasSources(inBucket, m) {
+			return nil
+		}
+		// The bucket index does not list blocks uploaded since it was written.
+		if fromIndex {
+			ok, err := s.bucket.Exists(ctx, path.Join(m.ULID.String(), block.MetaFilename))
+			if err != nil {
+				return errors.Wrap(err, "check exists")
+			}
+			if ok {
+				return nil
+			}
+		}
+		if _, ok := s.missing[m.ULID]; !ok || !upload {
+			level.Error(s.logger).Log("msg", "uploaded block is missing in the bucket", "block", m.ULID)
+			missing[m.ULID] = struct{}{}
+			return nil
+		}
+
+		level.Warn(s.logger).Log("msg", "uploaded block is missing in the bucket, uploading it again", "block", m.ULID)
+		if err := s.sync(ctx, m); err != nil {
+			level.Error(s.logger).Log("msg", "uploading missing block failed", "block", m.ULID, "err", err)
+			missing[m.ULID] = struct{}{}
+			return nil
+		}
+		s.metrics.reconcileUploads.Inc()
+		return nil
+	}); err != nil {
+		s.metrics.reconciliationFailures.Inc()
+		return errors.Wrap(err, "iter block metas")
+	}
+
+	s.missing = missing
+	s.metrics.unreconciledBlocks.Set(float64(len(missing)))
+	return nil
+}
+
+// bucketSources returns the blocks in the bucket along with their compaction sources, and the blocks marked as
+// deleted. They are read from the bucket index maintained by the compactor if there is one, which is reported
+// by the returned bool. Otherwise the bucket is listed and the metas of all blocks are downloaded.
+func (s *Shipper) bucketSources(ctx context.Context) (inBucket, deleted map[ulid.ULID]struct{}, fromIndex bool, err error) {
+	inBucket = map[ulid.ULID]struct{}{}
+	deleted = map[ulid.ULID]struct{}{}
+
+	var metas []*block.Meta
+	idx, err := block.ReadBucketIndex(ctx, s.logger, s.bucket)
+	if err == nil {
+		for i := range idx.Blocks {
+			metas = append(metas, &idx.Blocks[i])
+		}
+		for _, m := range idx.DeletionMarks {
+			deleted[m.ID] = struct{}{}
+		}
+		fromIndex = true
+	} else {
+		if err != block.ErrBucketIndexNotFound {
+			level.Warn(s.logger).Log("msg", "reading bucket index failed, listing the bucket", "err", err)
+		}
+		metas, err = block.BucketMetas(ctx, s.logger, s.bucket, 0)
+		if err != nil {
+			return nil, nil, false, errors.Wrap(err, "get bucket metas")
+		}
+	}
+	for _, m := range metas {
+		inBucket[m.ULID] = struct{}{}
+		for _, id := range m.Compaction.Sources {
+			inBucket[id] = struct{}{}
+		}
+	}
+	return inBucket, deleted, fromIndex, nil
+}
+
+// hasSources returns whether the block or all of its compaction sources are among the given blocks.
+func hasSources(blocks map[ulid.ULID]struct{}, m *block.Meta) bool {
+	if _, ok := blocks[m.ULID]; ok {
+		return true
+	}
+	if len(m.Compaction.Sources) == 0 {
+		return false
+	}
+	for _, id := range m.Compaction.Sources {
+		if _, ok := blocks[id]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
 func (s *Shipper) sync(ctx context.Context, meta *block.Meta) (err error) {
 	dir := filepath.Join(s.dir, meta.ULID.String())
 
@@ -228,7 +390,7 @@ func (s *Shipper) sync(ctx context.Context, meta *block.Meta) (err error) {
 	if err := block.WriteMetaFile(s.logger, updir, meta); err != nil {
 		return errors.Wrap(err, "write meta file")
 	}
-	return block.Upload(ctx, s.logger, s.bucket, updir)
+	return block.Upload(audit.WithReason(ctx, "shipper"), s.logger, s.bucket, updir)
 }
 
 // iterBlockMetas calls f with the block meta for each block found in dir. It logs
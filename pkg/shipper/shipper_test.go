@@ -1,9 +1,13 @@
 package shipper
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"io/ioutil"
 	"os"
 	"testing"
+	"time"
 
 	"math"
 
@@ -11,9 +15,11 @@ import (
 
 	"github.com/go-kit/kit/log"
 	"github.com/improbable-eng/thanos/pkg/block"
+	"github.com/improbable-eng/thanos/pkg/objstore/inmem"
 	"github.com/improbable-eng/thanos/pkg/testutil"
 	"github.com/oklog/ulid"
 	"github.com/prometheus/tsdb"
+	"github.com/prometheus/tsdb/labels"
 )
 
 func TestShipperTimestamps(t *testing.T) {
@@ -79,3 +85,97 @@ func TestShipperTimestamps(t *testing.T) {
 	testutil.Equals(t, int64(1000), mint)
 	testutil.Equals(t, int64(2000), maxt)
 }
+
+func TestShipper_Reconcile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shipper-test")
+	testutil.Ok(t, err)
+	defer func() {
+		testutil.Ok(t, os.RemoveAll(dir))
+	}()
+
+	ctx := context.Background()
+	logger := log.NewNopLogger()
+	bkt := inmem.NewBucket()
+	s := New(logger, nil, dir, bkt, func() labels.Labels { return labels.FromStrings("a", "b") }, block.TestSource)
+
+	// Nothing was uploaded yet.
+	testutil.Ok(t, s.Reconcile(ctx, true, 0))
+
+	var ids []ulid.ULID
+	for i := 0; i < 3; i++ {
+		id := ulid.MustNew(uint64(i+1), nil)
+		ids = append(ids, id)
+
+		bdir := path.Join(dir, id.String())
+		testutil.Ok(t, os.MkdirAll(path.Join(bdir, block.ChunksDirname), os.ModePerm))
+		testutil.Ok(t, ioutil.WriteFile(path.Join(bdir, block.IndexFilename), []byte("index"), os.ModePerm))
+		testutil.Ok(t, block.WriteMetaFile(logger, bdir, &block.Meta{
+			Version: 1,
+			BlockMeta: tsdb.BlockMeta{
+				ULID:       id,
+				MinTime:    int64(i) * 1000,
+				MaxTime:    int64(i+1) * 1000,
+				Compaction: tsdb.BlockMetaCompaction{Level: 1, Sources: []ulid.ULID{id}},
+			},
+		}))
+	}
+	s.Sync(ctx)
+
+	testutil.Ok(t, s.Reconcile(ctx, true, 0))
+	testutil.Equals(t, 0, len(s.missing))
+
+	// The second block was compacted into a new block, the first one got lost.
+	compacted := block.Meta{
+		Version: 1,
+		BlockMeta: tsdb.BlockMeta{
+			ULID:       ulid.MustNew(10, nil),
+			Compaction: tsdb.BlockMetaCompaction{Level: 2, Sources: []ulid.ULID{ids[1]}},
+		},
+	}
+	b, err := json.Marshal(compacted)
+	testutil.Ok(t, err)
+	testutil.Ok(t, bkt.Upload(ctx, path.Join(compacted.ULID.String(), block.MetaFilename), bytes.NewReader(b)))
+	testutil.Ok(t, block.Delete(ctx, bkt, ids[1]))
+	testutil.Ok(t, block.Delete(ctx, bkt, ids[0]))
+
+	lost := path.Join(ids[0].String(), block.MetaFilename)
+
+	// Missing blocks are only reported without uploads.
+	for i := 0; i < 2; i++ {
+		testutil.Ok(t, s.Reconcile(ctx, false, 0))
+		testutil.Equals(t, map[ulid.ULID]struct{}{ids[0]: {}}, s.missing)
+	}
+	ok, err := bkt.Exists(ctx, lost)
+	testutil.Ok(t, err)
+	testutil.Assert(t, !ok, "expected lost block not to be uploaded")
+
+	// Missing blocks are uploaded again if they are still missing at the next reconciliation.
+	testutil.Ok(t, s.Reconcile(ctx, true, 0))
+	testutil.Equals(t, 0, len(s.missing))
+	ok, err = bkt.Exists(ctx, lost)
+	testutil.Ok(t, err)
+	testutil.Assert(t, ok, "expected lost block to be uploaded again")
+
+	ok, err = bkt.Exists(ctx, path.Join(ids[1].String(), block.MetaFilename))
+	testutil.Ok(t, err)
+	testutil.Assert(t, !ok, "expected compacted block not to be uploaded again")
+
+	// With a bucket index, blocks are looked up in it. Blocks uploaded since it was written are checked on their own
+	// and blocks marked as deleted are not reconciled.
+	idx := &block.BucketIndex{Blocks: []block.Meta{compacted}}
+	idx.Update(nil, []ulid.ULID{ids[2]})
+	testutil.Ok(t, block.WriteBucketIndex(ctx, bkt, idx))
+	testutil.Ok(t, block.Delete(ctx, bkt, ids[2]))
+
+	testutil.Ok(t, s.Reconcile(ctx, true, 0))
+	testutil.Equals(t, 0, len(s.missing))
+
+	// Blocks older than the retention of raw data are not reconciled.
+	testutil.Ok(t, block.Delete(ctx, bkt, ids[0]))
+	testutil.Ok(t, block.WriteBucketIndex(ctx, bkt, &block.BucketIndex{Blocks: []block.Meta{compacted}}))
+
+	testutil.Ok(t, s.Reconcile(ctx, false, time.Hour))
+	testutil.Equals(t, 0, len(s.missing))
+	testutil.Ok(t, s.Reconcile(ctx, false, 0))
+	testutil.Equals(t, map[ulid.ULID]struct{}{ids[0]: {}, ids[2]: {}}, s.missing)
+}
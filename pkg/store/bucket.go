@@ -26,10 +26,13 @@ import (
 	"github.com/improbable-eng/thanos/pkg/store/storepb"
 	"github.com/improbable-eng/thanos/pkg/strutil"
 	"github.com/improbable-eng/thanos/pkg/tracing"
+	"github.com/improbable-eng/thanos/pkg/usage"
 	"github.com/oklog/run"
 	"github.com/oklog/ulid"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
+	promlabels "github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/timestamp"
 	"github.com/prometheus/tsdb"
 	"github.com/prometheus/tsdb/chunkenc"
 	"github.com/prometheus/tsdb/chunks"
@@ -166,9 +169,21 @@ type BucketStore struct {
 	mtx       sync.RWMutex
 	blocks    map[ulid.ULID]*bucketBlock
 	blockSets map[uint64]*bucketBlockSet
+	// Filter of the metric names of all loaded blocks. It is nil until the first sync finished,
+	// which advertises that the store may hold any metric.
+	metricNames *storepb.MetricNameFilter
 
 	// Verbose enabled additional logging.
 	debugLogging bool
+
+	// Maximum age of the bucket index before the bucket is listed instead. Zero disables the use of the index.
+	indexMaxStaleness time.Duration
+
+	// Usage of Series calls per client. Nil if disabled.
+	usage *usage.Tracker
+
+	// Fill gaps of the requested resolution with lower resolutions for all requests, not only those asking for it.
+	resolutionFallback bool
 }
 
 // NewBucketStore creates a new bucket backed store that implements the store API against
@@ -181,6 +196,9 @@ func NewBucketStore(
 	indexCacheSizeBytes uint64,
 	maxChunkPoolBytes uint64,
 	debugLogging bool,
+	indexMaxStaleness time.Duration,
+	usageTracker *usage.Tracker,
+	resolutionFallback bool,
 ) (*BucketStore, error) {
 	if logger == nil {
 		logger = log.NewNopLogger()
@@ -194,14 +212,17 @@ func NewBucketStore(
 		return nil, errors.Wrap(err, "create chunk pool")
 	}
 	s := &BucketStore{
-		logger:       logger,
-		bucket:       bucket,
-		dir:          dir,
-		indexCache:   indexCache,
-		chunkPool:    chunkPool,
-		blocks:       map[ulid.ULID]*bucketBlock{},
-		blockSets:    map[uint64]*bucketBlockSet{},
-		debugLogging: debugLogging,
+		logger:             logger,
+		bucket:             bucket,
+		dir:                dir,
+		indexCache:         indexCache,
+		chunkPool:          chunkPool,
+		blocks:             map[ulid.ULID]*bucketBlock{},
+		blockSets:          map[uint64]*bucketBlockSet{},
+		debugLogging:       debugLogging,
+		indexMaxStaleness:  indexMaxStaleness,
+		usage:              usageTracker,
+		resolutionFallback: resolutionFallback,
 	}
 	s.metrics = newBucketStoreMetrics(reg)
 
@@ -247,12 +268,7 @@ func (s *BucketStore) SyncBlocks(ctx context.Context) error {
 
 	allIDs := map[ulid.ULID]struct{}{}
 
-	err := s.bucket.Iter(ctx, "", func(name string) error {
-		// Strip trailing slash indicating a directory.
-		id, err := ulid.Parse(name[:len(name)-1])
-		if err != nil {
-			return nil
-		}
+	err := block.IterBlocks(ctx, s.logger, s.bucket, s.indexMaxStaleness, func(id ulid.ULID, _ *block.Meta) error {
 		allIDs[id] = struct{}{}
 
 		if b := s.getBlock(id); b != nil {
@@ -283,9 +299,36 @@ func (s *BucketStore) SyncBlocks(ctx context.Context) error {
 		s.metrics.blockDrops.Inc()
 	}
 
+	// Rebuild the filter so that names of dropped blocks are no longer advertised and it is sized for the current names.
+	s.mtx.Lock()
+	s.metricNames = storepb.NewMetricNameFilter(s.metricNamesLocked(), s.metricNamesMaxTimeLocked())
+	s.mtx.Unlock()
+
 	return nil
 }
 
+// metricNamesLocked returns the unique metric names of all loaded blocks. The caller must hold the lock.
+func (s *BucketStore) metricNamesLocked() []string {
+	var all [][]string
+	for _, b := range s.blocks {
+		all = append(all, b.lvals[promlabels.MetricName])
+	}
+	return strutil.MergeSlices(all...)
+}
+
+// metricNamesMaxTimeLocked returns the time up to which the metric names of the loaded blocks are complete.
+// Blocks are mostly uploaded after the data they hold, so names of blocks loaded later are found after it.
+// The caller must hold the lock.
+func (s *BucketStore) metricNamesMaxTimeLocked() int64 {
+	maxt := int64(math.MinInt64)
+	for _, b := range s.blocks {
+		if b.meta.MaxTime-1 > maxt {
+			maxt = b.meta.MaxTime - 1
+		}
+	}
+	return maxt
+}
+
 // InitialSync perform blocking sync with extra step at the end to delete locally saved blocks that are no longer
 // present in the bucket. The mismatch of these can only happen between restarts, so we can do that only once per startup.
 func (s *BucketStore) InitialSync(ctx context.Context) error {
@@ -368,6 +411,12 @@ func (s *BucketStore) addBlock(ctx context.Context, id ulid.ULID) (err error) {
 		return errors.Wrap(err, "add block to set")
 	}
 	s.blocks[b.meta.ULID] = b
+	if s.metricNames != nil {
+		s.metricNames.Add(b.lvals[promlabels.MetricName]...)
+		if b.meta.MaxTime-1 > s.metricNames.MaxTime {
+			s.metricNames.MaxTime = b.meta.MaxTime - 1
+		}
+	}
 
 	s.metrics.blocksLoaded.Inc()
 
@@ -415,13 +464,21 @@ func (s *BucketStore) TimeRange() (mint, maxt int64) {
 }
 
 // Info implements the storepb.StoreServer interface.
-func (s *BucketStore) Info(context.Context, *storepb.InfoRequest) (*storepb.InfoResponse, error) {
+func (s *BucketStore) Info(_ context.Context, r *storepb.InfoRequest) (*storepb.InfoResponse, error) {
 	mint, maxt := s.TimeRange()
+
+	s.mtx.RLock()
+	metricNames := s.metricNames.Clone()
+	s.mtx.RUnlock()
+
 	// Store nodes hold global data and thus have no labels.
-	return &storepb.InfoResponse{
-		MinTime: mint,
-		MaxTime: maxt,
-	}, nil
+	res := &storepb.InfoResponse{
+		MinTime:             mint,
+		MaxTime:             maxt,
+		SupportsMatcherSets: true,
+	}
+	res.SetMetricNames(metricNames, r)
+	return res, nil
 }
 
 type seriesEntry struct {
@@ -465,7 +522,7 @@ func (s *BucketStore) blockSeries(
 	extLset map[string]string,
 	indexr *bucketIndexReader,
 	chunkr *bucketChunkReader,
-	matchers []labels.Matcher,
+	matcherSets [][]labels.Matcher,
 	req *storepb.SeriesRequest,
 ) (storepb.SeriesSet, *queryStats, error) {
 	stats := &queryStats{}
@@ -473,20 +530,28 @@ func (s *BucketStore) blockSeries(
 	// The postings to preload are registered within the call to PostingsForMatchers,
 	// when it invokes indexr.Postings for each underlying postings list.
 	// They are ready to use ONLY after preloadPostings was called successfully.
-	lazyPostings, err := tsdb.PostingsForMatchers(indexr, matchers...)
-	if err != nil {
-		return nil, stats, errors.Wrap(err, "get postings for matchers")
+	// The postings of all matcher sets are preloaded together and their union is selected.
+	var lazyPostings []index.Postings
+	for _, matchers := range matcherSets {
+		p, err := tsdb.PostingsForMatchers(indexr, matchers...)
+		if err != nil {
+			return nil, stats, errors.Wrap(err, "get postings for matchers")
+		}
+		if p == index.EmptyPostings() {
+			continue
+		}
+		lazyPostings = append(lazyPostings, p)
 	}
-	// If the tree was reduced to the empty postings list, don't preload the registered
+	// If all trees were reduced to the empty postings list, don't preload the registered
 	// leaf postings and return early with an empty result.
-	if lazyPostings == index.EmptyPostings() {
+	if len(lazyPostings) == 0 {
 		return storepb.EmptySeriesSet(), stats, nil
 	}
 	if err := indexr.preloadPostings(); err != nil {
 		return nil, stats, errors.Wrap(err, "preload postings")
 	}
-	// Get result postings list by resolving the postings tree.
-	ps, err := index.ExpandPostings(lazyPostings)
+	// Get result postings list by resolving the postings trees.
+	ps, err := index.ExpandPostings(index.Merge(lazyPostings...))
 	if err != nil {
 		return nil, stats, errors.Wrap(err, "expand postings")
 	}
@@ -555,8 +620,9 @@ func (s *BucketStore) blockSeries(
 				return nil, stats, errors.Wrap(err, "add chunk preload")
 			}
 			s.chks = append(s.chks, storepb.AggrChunk{
-				MinTime: meta.MinTime,
-				MaxTime: meta.MaxTime,
+				MinTime:    meta.MinTime,
+				MaxTime:    meta.MaxTime,
+				Resolution: indexr.block.meta.Thanos.Downsample.Resolution,
 			})
 			s.refs = append(s.refs, meta.Ref)
 		}
@@ -674,28 +740,43 @@ func debugFoundBlockSetOverview(logger log.Logger, mint, maxt int64, lset labels
 
 // Series implements the storepb.StoreServer interface.
 func (s *BucketStore) Series(req *storepb.SeriesRequest, srv storepb.Store_SeriesServer) error {
-	matchers, err := translateMatchers(req.Matchers)
-	if err != nil {
-		return status.Error(codes.InvalidArgument, err.Error())
+	var matcherSets [][]labels.Matcher
+	for _, ms := range req.AllMatcherSets() {
+		matchers, err := translateMatchers(ms)
+		if err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+		matcherSets = append(matcherSets, matchers)
 	}
 	var (
 		stats = &queryStats{}
 		g     run.Group
 		res   []storepb.SeriesSet
 		mtx   sync.Mutex
+		// Warnings about data served at a lower resolution than requested.
+		warnings []string
 	)
 	s.mtx.RLock()
 
 	for _, bs := range s.blockSets {
-		blockMatchers, ok := bs.labelMatchers(matchers...)
-		if !ok {
+		var blockMatcherSets [][]labels.Matcher
+		for _, matchers := range matcherSets {
+			if blockMatchers, ok := bs.labelMatchers(matchers...); ok {
+				blockMatcherSets = append(blockMatcherSets, blockMatchers)
+			}
+		}
+		if len(blockMatcherSets) == 0 {
 			continue
 		}
-		blocks := bs.getFor(req.MinTime, req.MaxTime, req.MaxResolutionWindow)
+		blocks := bs.getFor(req.MinTime, req.MaxTime, req.MaxResolutionWindow, req.ResolutionFallback || s.resolutionFallback)
 
 		if s.debugLogging {
 			debugFoundBlockSetOverview(s.logger, req.MinTime, req.MaxTime, bs.labels, blocks)
 		}
+		if ranges := downsampledRanges(req.MinTime, req.MaxTime, req.MaxResolutionWindow, blocks); len(ranges) > 0 {
+			warnings = append(warnings, fmt.Sprintf("data of blocks with labels %s served at lower resolution than requested for ranges: %s",
+				bs.labels, strings.Join(ranges, ", ")))
+		}
 
 		for _, b := range blocks {
 			stats.blocksQueried++
@@ -717,7 +798,7 @@ func (s *BucketStore) Series(req *storepb.SeriesRequest, srv storepb.Store_Serie
 					b.meta.Thanos.Labels,
 					indexr,
 					chunkr,
-					blockMatchers,
+					blockMatcherSets,
 					req,
 				)
 				if err != nil {
@@ -781,6 +862,11 @@ func (s *BucketStore) Series(req *storepb.SeriesRequest, srv storepb.Store_Serie
 		if set.Err() != nil {
 			return status.Error(codes.Unknown, errors.Wrap(set.Err(), "expand series set").Error())
 		}
+		for _, w := range warnings {
+			if err := srv.Send(storepb.NewWarnSeriesResponse(errors.New(w))); err != nil {
+				return status.Error(codes.Unknown, errors.Wrap(err, "send warning response").Error())
+			}
+		}
 		stats.mergeDuration = time.Since(begin)
 		s.metrics.seriesMergeDuration.Observe(stats.mergeDuration.Seconds())
 	}
@@ -799,6 +885,10 @@ func (s *BucketStore) Series(req *storepb.SeriesRequest, srv storepb.Store_Serie
 	s.metrics.seriesDataSizeFetched.WithLabelValues("chunks").Observe(float64(stats.chunksFetchedSizeSum))
 	s.metrics.resultSeriesCount.Observe(float64(stats.mergedSeriesCount))
 
+	s.usage.Add(srv.Context(), usage.SeriesRequests, 1)
+	s.usage.Add(srv.Context(), usage.FetchedBytes, float64(stats.postingsFetchedSizeSum+stats.seriesFetchedSizeSum+stats.chunksFetchedSizeSum))
+	s.usage.Add(srv.Context(), usage.Chunks, float64(stats.mergedChunksCount))
+
 	level.Debug(s.logger).Log("msg", "series query processed",
 		"stats", fmt.Sprintf("%+v", stats))
 
@@ -933,11 +1023,8 @@ func int64index(s []int64, x int64) int {
 
 // getFor returns a time-ordered list of blocks that cover date between mint and maxt.
 // Blocks with the lowest resolution possible but not lower than the given resolution are returned.
-func (s *bucketBlockSet) getFor(mint, maxt, minResolution int64) (bs []*bucketBlock) {
-	if mint == maxt {
-		return nil
-	}
-
+// If fallback is set, the remaining gaps are filled with blocks of lower resolutions, the highest resolution first.
+func (s *bucketBlockSet) getFor(mint, maxt, minResolution int64, fallback bool) []*bucketBlock {
 	s.mtx.RLock()
 	defer s.mtx.RUnlock()
 
@@ -946,6 +1033,19 @@ func (s *bucketBlockSet) getFor(mint, maxt, minResolution int64) (bs []*bucketBl
 	for ; i < len(s.resolutions) && s.resolutions[i] > minResolution; i++ {
 	}
 
+	bs := s.getForResolution(mint, maxt, i)
+	if fallback && mint != maxt {
+		bs = s.fillGaps(bs, mint, maxt, i-1)
+	}
+	return bs
+}
+
+// getForResolution returns a time-ordered list of blocks that cover date between mint and maxt with
+// the resolution at index i and, where it does not cover all data, higher resolutions.
+func (s *bucketBlockSet) getForResolution(mint, maxt int64, i int) (bs []*bucketBlock) {
+	if mint == maxt {
+		return nil
+	}
 	// Base case, we fill the given interval with the closest resolution.
 	for _, b := range s.blocks[i] {
 		if b.meta.MaxTime <= mint {
@@ -964,14 +1064,96 @@ func (s *bucketBlockSet) getFor(mint, maxt, minResolution int64) (bs []*bucketBl
 		return bs
 	}
 	if len(bs) == 0 {
-		return s.getFor(mint, maxt, s.resolutions[i])
+		return s.getForResolution(mint, maxt, i)
 	}
-	left := s.getFor(mint, bs[0].meta.MinTime, s.resolutions[i])
-	right := s.getFor(bs[len(bs)-1].meta.MaxTime, maxt, s.resolutions[i])
+	left := s.getForResolution(mint, bs[0].meta.MinTime, i)
+	right := s.getForResolution(bs[len(bs)-1].meta.MaxTime, maxt, i)
 
 	return append(left, append(bs, right...)...)
 }
 
+// fillGaps fills the gaps between the time-ordered blocks within [mint, maxt] with blocks of the resolution
+// at index i and, where it does not cover all gaps, lower resolutions.
+func (s *bucketBlockSet) fillGaps(bs []*bucketBlock, mint, maxt int64, i int) []*bucketBlock {
+	// No lower resolution left, we are done.
+	if i < 0 {
+		return bs
+	}
+	var (
+		res []*bucketBlock
+		cur = mint
+	)
+	fill := func(gapMaxt int64) {
+		if cur >= gapMaxt {
+			return
+		}
+		var gap []*bucketBlock
+		for _, b := range s.blocks[i] {
+			if b.meta.MaxTime <= cur {
+				continue
+			}
+			if b.meta.MinTime >= gapMaxt {
+				break
+			}
+			gap = append(gap, b)
+		}
+		res = append(res, s.fillGaps(gap, cur, gapMaxt, i-1)...)
+	}
+	for _, b := range bs {
+		fill(b.meta.MinTime)
+		res = append(res, b)
+		if b.meta.MaxTime > cur {
+			cur = b.meta.MaxTime
+		}
+	}
+	fill(maxt)
+
+	return res
+}
+
+// downsampledRanges describes the time ranges within [mint, maxt] covered by the time-ordered blocks
+// with a lower resolution than the given maximum resolution window.
+func downsampledRanges(mint, maxt, maxResolutionWindow int64, bs []*bucketBlock) (ranges []string) {
+	var (
+		currRes          = int64(-1)
+		currMin, currMax int64
+	)
+	flush := func() {
+		if currRes == -1 {
+			return
+		}
+		ranges = append(ranges, fmt.Sprintf("%s to %s at resolution %s",
+			timestamp.Time(currMin).UTC().Format(time.RFC3339),
+			timestamp.Time(currMax).UTC().Format(time.RFC3339),
+			time.Duration(currRes)*time.Millisecond,
+		))
+	}
+	for _, b := range bs {
+		res := b.meta.Thanos.Downsample.Resolution
+		if res <= maxResolutionWindow {
+			flush()
+			currRes = -1
+			continue
+		}
+		bmint, bmaxt := b.meta.MinTime, b.meta.MaxTime
+		if bmint < mint {
+			bmint = mint
+		}
+		if bmaxt > maxt {
+			bmaxt = maxt
+		}
+		if res == currRes && bmint <= currMax {
+			currMax = bmaxt
+			continue
+		}
+		flush()
+		currRes, currMin, currMax = res, bmint, bmaxt
+	}
+	flush()
+
+	return ranges
+}
+
 // labelMatchers verifies whether the block set matches the given matchers and returns a new
 // set of matchers that is equivalent when querying data within the block.
 func (s *bucketBlockSet) labelMatchers(matchers ...labels.Matcher) ([]labels.Matcher, bool) {
@@ -78,7 +78,7 @@ func TestBucketStore_e2e(t *testing.T) {
 			testutil.Ok(t, os.RemoveAll(dir2))
 		}
 
-		store, err := NewBucketStore(nil, nil, bkt, dir, 100, 0, false)
+		store, err := NewBucketStore(nil, nil, bkt, dir, 100, 0, false, 0, nil, false)
 		testutil.Ok(t, err)
 
 		go func() {
@@ -104,6 +104,30 @@ func TestBucketStore_e2e(t *testing.T) {
 		testutil.Equals(t, minTime, mint)
 		testutil.Equals(t, maxTime, maxt)
 
+		// The filter of metric names is advertised once a sync finished. None of the series has a metric name.
+		err = runutil.Retry(100*time.Millisecond, ctx.Done(), func() error {
+			info, err := store.Info(ctx, &storepb.InfoRequest{})
+			if err != nil {
+				return err
+			}
+			if info.MetricNames == nil {
+				return errors.New("metric names not advertised")
+			}
+			if info.MetricNames.MayContain("up") {
+				return errors.New("unexpected metric name")
+			}
+			return nil
+		})
+		testutil.Ok(t, err)
+
+		// The filter covers all loaded blocks and is not sent again to clients holding it.
+		info, err := store.Info(ctx, &storepb.InfoRequest{})
+		testutil.Ok(t, err)
+		testutil.Equals(t, maxTime-1, info.MetricNames.MaxTime)
+		info, err = store.Info(ctx, &storepb.InfoRequest{MetricNamesChecksum: info.MetricNames.Checksum()})
+		testutil.Ok(t, err)
+		testutil.Assert(t, info.MetricNamesUnchanged && info.MetricNames == nil, "expected unchanged metric names")
+
 		vals, err := store.LabelValues(ctx, &storepb.LabelValuesRequest{Label: "a"})
 		testutil.Ok(t, err)
 		testutil.Equals(t, []string{"1", "2"}, vals.Values)
@@ -190,6 +214,36 @@ func TestBucketStore_e2e(t *testing.T) {
 		}, srv)
 		testutil.Ok(t, err)
 		testutil.Equals(t, 0, len(srv.SeriesSet))
+
+		// Series matching any of several matcher sets are returned once.
+		pbseries = [][]storepb.Label{
+			{{Name: "a", Value: "1"}, {Name: "b", Value: "2"}, {Name: "ext1", Value: "value1"}},
+			{{Name: "a", Value: "1"}, {Name: "c", Value: "1"}, {Name: "ext2", Value: "value2"}},
+			{{Name: "a", Value: "2"}, {Name: "b", Value: "2"}, {Name: "ext1", Value: "value1"}},
+			{{Name: "a", Value: "2"}, {Name: "c", Value: "1"}, {Name: "ext2", Value: "value2"}},
+		}
+		srv = newStoreSeriesServer(ctx)
+
+		err = store.Series(&storepb.SeriesRequest{
+			MatcherSets: []storepb.LabelMatchers{
+				{Matchers: []storepb.LabelMatcher{{Type: storepb.LabelMatcher_EQ, Name: "b", Value: "2"}}},
+				{Matchers: []storepb.LabelMatcher{{Type: storepb.LabelMatcher_EQ, Name: "c", Value: "1"}}},
+				{Matchers: []storepb.LabelMatcher{
+					{Type: storepb.LabelMatcher_EQ, Name: "a", Value: "1"},
+					{Type: storepb.LabelMatcher_EQ, Name: "b", Value: "2"},
+				}},
+				{Matchers: []storepb.LabelMatcher{{Type: storepb.LabelMatcher_EQ, Name: "ext2", Value: "wrong-value"}}},
+			},
+			MinTime: timestamp.FromTime(start),
+			MaxTime: timestamp.FromTime(now),
+		}, srv)
+		testutil.Ok(t, err)
+		testutil.Equals(t, len(pbseries), len(srv.SeriesSet))
+
+		for i, s := range srv.SeriesSet {
+			testutil.Equals(t, pbseries[i], s.Labels)
+			testutil.Equals(t, 3, len(s.Chunks))
+		}
 	})
 
 }
@@ -108,11 +108,123 @@ func TestBucketBlockSet_addGet(t *testing.T) {
 			m.MaxTime = b.maxt
 			exp = append(exp, &bucketBlock{meta: &m})
 		}
-		res := set.getFor(c.mint, c.maxt, c.minResolution)
+		res := set.getFor(c.mint, c.maxt, c.minResolution, false)
 		testutil.Equals(t, exp, res)
 	}
 }
 
+func TestBucketBlockSet_fallback(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	set := newBucketBlockSet(labels.Labels{})
+
+	type resBlock struct {
+		mint, maxt int64
+		window     int64
+	}
+	input := []resBlock{
+		// Raw data of the first blocks was deleted by retention.
+		{window: downsample.ResLevel0, mint: 300, maxt: 400},
+		{window: downsample.ResLevel0, mint: 400, maxt: 500},
+		{window: downsample.ResLevel1, mint: 0, maxt: 100},
+		{window: downsample.ResLevel1, mint: 200, maxt: 400},
+		{window: downsample.ResLevel2, mint: 0, maxt: 300},
+	}
+	for _, in := range input {
+		var m block.Meta
+		m.Thanos.Downsample.Resolution = in.window
+		m.MinTime = in.mint
+		m.MaxTime = in.maxt
+
+		testutil.Ok(t, set.add(&bucketBlock{meta: &m}))
+	}
+
+	cases := []struct {
+		mint, maxt    int64
+		minResolution int64
+		fallback      bool
+		res           []resBlock
+	}{
+		{
+			mint:          0,
+			maxt:          500,
+			minResolution: 0,
+			res: []resBlock{
+				{window: downsample.ResLevel0, mint: 300, maxt: 400},
+				{window: downsample.ResLevel0, mint: 400, maxt: 500},
+			},
+		}, {
+			mint:          0,
+			maxt:          500,
+			minResolution: 0,
+			fallback:      true,
+			res: []resBlock{
+				{window: downsample.ResLevel1, mint: 0, maxt: 100},
+				{window: downsample.ResLevel2, mint: 0, maxt: 300},
+				{window: downsample.ResLevel1, mint: 200, maxt: 400},
+				{window: downsample.ResLevel0, mint: 300, maxt: 400},
+				{window: downsample.ResLevel0, mint: 400, maxt: 500},
+			},
+		}, {
+			mint:          300,
+			maxt:          500,
+			minResolution: 0,
+			fallback:      true,
+			res: []resBlock{
+				{window: downsample.ResLevel0, mint: 300, maxt: 400},
+				{window: downsample.ResLevel0, mint: 400, maxt: 500},
+			},
+		}, {
+			// The lowest resolution has no lower resolution to fall back to.
+			mint:          0,
+			maxt:          500,
+			minResolution: downsample.ResLevel2,
+			fallback:      true,
+			res: []resBlock{
+				{window: downsample.ResLevel2, mint: 0, maxt: 300},
+				{window: downsample.ResLevel1, mint: 200, maxt: 400},
+				{window: downsample.ResLevel0, mint: 400, maxt: 500},
+			},
+		},
+	}
+	for i, c := range cases {
+		t.Logf("case %d", i)
+
+		var exp []*bucketBlock
+		for _, b := range c.res {
+			var m block.Meta
+			m.Thanos.Downsample.Resolution = b.window
+			m.MinTime = b.mint
+			m.MaxTime = b.maxt
+			exp = append(exp, &bucketBlock{meta: &m})
+		}
+		res := set.getFor(c.mint, c.maxt, c.minResolution, c.fallback)
+		testutil.Equals(t, exp, res)
+	}
+}
+
+func TestDownsampledRanges(t *testing.T) {
+	blocks := func(bs ...[3]int64) (res []*bucketBlock) {
+		for _, b := range bs {
+			var m block.Meta
+			m.MinTime, m.MaxTime, m.Thanos.Downsample.Resolution = b[0], b[1], b[2]
+			res = append(res, &bucketBlock{meta: &m})
+		}
+		return res
+	}
+	testutil.Equals(t, []string(nil), downsampledRanges(0, 10000, 0, blocks([3]int64{0, 10000, 0})))
+
+	testutil.Equals(t, []string{
+		"1970-01-01T00:00:01Z to 1970-01-01T00:00:07Z at resolution 5m0s",
+		"1970-01-01T00:00:07Z to 1970-01-01T00:00:08Z at resolution 1h0m0s",
+	}, downsampledRanges(1000, 8000, 0, blocks(
+		[3]int64{0, 2000, downsample.ResLevel1},
+		[3]int64{2000, 7000, downsample.ResLevel1},
+		[3]int64{7000, 9000, downsample.ResLevel2},
+		[3]int64{8000, 10000, 0},
+	)))
+}
+
 func TestBucketBlockSet_remove(t *testing.T) {
 	defer leaktest.CheckTimeout(t, 10*time.Second)()
 
@@ -136,7 +248,7 @@ func TestBucketBlockSet_remove(t *testing.T) {
 		testutil.Ok(t, set.add(&bucketBlock{meta: &m}))
 	}
 	set.remove(input[1].id)
-	res := set.getFor(0, 300, 0)
+	res := set.getFor(0, 300, 0, false)
 
 	testutil.Equals(t, 2, len(res))
 	testutil.Equals(t, input[0].id, res[0].meta.ULID)
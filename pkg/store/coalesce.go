@@ -0,0 +1,215 @@
+package store
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// seriesCoalescer coalesces identical concurrent Series requests, so that they share a single
+// fan-out to the stores. The responses of the first request are buffered and replayed to all
+// identical requests that arrived while it was running.
+type seriesCoalescer struct {
+	maxBufferSize int
+
+	mtx     sync.Mutex
+	flights map[string]*seriesFlight
+
+	coalescedRequests prometheus.Counter
+	bufferOverflows   prometheus.Counter
+}
+
+// seriesFlight is a running Series request that identical requests may wait for.
+type seriesFlight struct {
+	done chan struct{}
+
+	// Written by the running request only and read by waiting requests after done is closed.
+	resps []*storepb.SeriesResponse
+	size  int
+	// Set if the responses exceeded the buffer or the request failed. Waiting requests have
+	// to run on their own then.
+	incomplete bool
+}
+
+func newSeriesCoalescer(reg prometheus.Registerer, maxBufferSize int) *seriesCoalescer {
+	c := &seriesCoalescer{
+		maxBufferSize: maxBufferSize,
+		flights:       map[string]*seriesFlight{},
+		coalescedRequests: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "thanos_proxy_store_coalesced_requests_total",
+			Help: "Total number of series requests that were answered with the responses of an identical concurrent request.",
+		}),
+		bufferOverflows: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "thanos_proxy_store_coalesce_buffer_overflows_total",
+			Help: "Total number of series requests whose responses exceeded the coalescing buffer, so that identical concurrent requests ran on their own.",
+		}),
+	}
+	if reg != nil {
+		reg.MustRegister(c.coalescedRequests, c.bufferOverflows)
+	}
+	return c
+}
+
+// series runs fn for the request identified by key, unless an identical request is running already.
+// In that case it waits for the running request and sends its responses to srv instead.
+func (c *seriesCoalescer) series(key string, srv storepb.Store_SeriesServer, fn func(storepb.Store_SeriesServer) error) error {
+	c.mtx.Lock()
+	f, ok := c.flights[key]
+	if !ok {
+		f = &seriesFlight{done: make(chan struct{})}
+		c.flights[key] = f
+	}
+	c.mtx.Unlock()
+
+	if !ok {
+		return c.run(key, f, srv, fn)
+	}
+
+	select {
+	case <-f.done:
+	case <-srv.Context().Done():
+		return srv.Context().Err()
+	}
+	if f.incomplete {
+		return fn(srv)
+	}
+	c.coalescedRequests.Inc()
+
+	for _, r := range f.resps {
+		if err := srv.Send(copySeriesResponse(r)); err != nil {
+			return errors.Wrap(err, "send coalesced series response")
+		}
+	}
+	return nil
+}
+
+func (c *seriesCoalescer) run(key string, f *seriesFlight, srv storepb.Store_SeriesServer, fn func(storepb.Store_SeriesServer) error) error {
+	err := fn(&bufferingSeriesServer{Store_SeriesServer: srv, coalescer: c, key: key, flight: f})
+
+	c.mtx.Lock()
+	if c.flights[key] == f {
+		delete(c.flights, key)
+	}
+	c.mtx.Unlock()
+
+	// Responses of cancelled requests may lack series, which were only reported as warnings.
+	if err != nil || srv.Context().Err() != nil {
+		f.incomplete = true
+		f.resps = nil
+	}
+	close(f.done)
+	return err
+}
+
+// bufferingSeriesServer sends responses to the wrapped server and buffers copies of them for
+// identical requests.
+type bufferingSeriesServer struct {
+	storepb.Store_SeriesServer
+
+	coalescer *seriesCoalescer
+	key       string
+	flight    *seriesFlight
+}
+
+func (s *bufferingSeriesServer) Send(r *storepb.SeriesResponse) error {
+	if !s.flight.incomplete {
+		s.flight.size += r.Size()
+		s.flight.resps = append(s.flight.resps, copySeriesResponse(r))
+
+		if s.flight.size > s.coalescer.maxBufferSize {
+			// Release the buffer and let new identical requests start their own flight.
+			s.flight.incomplete = true
+			s.flight.resps = nil
+			s.coalescer.bufferOverflows.Inc()
+
+			s.coalescer.mtx.Lock()
+			if s.coalescer.flights[s.key] == s.flight {
+				delete(s.coalescer.flights, s.key)
+			}
+			s.coalescer.mtx.Unlock()
+		}
+	}
+	return s.Store_SeriesServer.Send(r)
+}
+
+// copySeriesResponse copies the label and chunk slices of the response, as receivers may reorder them.
+func copySeriesResponse(r *storepb.SeriesResponse) *storepb.SeriesResponse {
+	s := r.GetSeries()
+	if s == nil {
+		return r
+	}
+	return storepb.NewSeriesResponse(&storepb.Series{
+		Labels: append([]storepb.Label(nil), s.Labels...),
+		Chunks: append([]storepb.AggrChunk(nil), s.Chunks...),
+	})
+}
+
+// seriesRequestKey returns a key that is equal for requests of the same client selecting the same series from
+// the same stores. The order of matchers, matcher sets, aggregates and stores does not matter.
+// Stores account the usage of a request to the client that sent it, so requests of different clients are not shared.
+func seriesRequestKey(client string, r *storepb.SeriesRequest, matcherSets [][]storepb.LabelMatcher, stores []Client) (string, error) {
+	type keyedSet struct {
+		key []byte
+		set storepb.LabelMatchers
+	}
+	keyed := make([]keyedSet, 0, len(matcherSets))
+	for _, ms := range matcherSets {
+		ms = append([]storepb.LabelMatcher(nil), ms...)
+		sort.Slice(ms, func(i, j int) bool {
+			if ms[i].Name != ms[j].Name {
+				return ms[i].Name < ms[j].Name
+			}
+			if ms[i].Type != ms[j].Type {
+				return ms[i].Type < ms[j].Type
+			}
+			return ms[i].Value < ms[j].Value
+		})
+		set := storepb.LabelMatchers{Matchers: ms}
+		b, err := set.Marshal()
+		if err != nil {
+			return "", errors.Wrap(err, "marshal matchers")
+		}
+		keyed = append(keyed, keyedSet{key: b, set: set})
+	}
+	sort.Slice(keyed, func(i, j int) bool { return bytes.Compare(keyed[i].key, keyed[j].key) < 0 })
+
+	sets := make([]storepb.LabelMatchers, 0, len(keyed))
+	for _, k := range keyed {
+		sets = append(sets, k.set)
+	}
+
+	aggrs := append([]storepb.Aggr(nil), r.Aggregates...)
+	sort.Slice(aggrs, func(i, j int) bool { return aggrs[i] < aggrs[j] })
+
+	b, err := (&storepb.SeriesRequest{
+		MinTime:             r.MinTime,
+		MaxTime:             r.MaxTime,
+		MaxResolutionWindow: r.MaxResolutionWindow,
+		Aggregates:          aggrs,
+		MatcherSets:         sets,
+		ResolutionFallback:  r.ResolutionFallback,
+	}).Marshal()
+	if err != nil {
+		return "", errors.Wrap(err, "marshal request")
+	}
+
+	storeKeys := make([]string, 0, len(stores))
+	for _, st := range stores {
+		storeKeys = append(storeKeys, st.String())
+	}
+	sort.Strings(storeKeys)
+
+	var buf bytes.Buffer
+	buf.WriteString(client)
+	buf.WriteByte(0xff)
+	buf.Write(b)
+	for _, k := range storeKeys {
+		buf.WriteByte(0xff)
+		buf.WriteString(k)
+	}
+	return buf.String(), nil
+}
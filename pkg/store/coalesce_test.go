@@ -0,0 +1,141 @@
+package store
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+	"github.com/improbable-eng/thanos/pkg/testutil"
+	"github.com/improbable-eng/thanos/pkg/usage"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"google.golang.org/grpc"
+)
+
+// blockingStoreClient blocks Series calls until release is closed.
+type blockingStoreClient struct {
+	*storeClient
+	release chan struct{}
+}
+
+func (s *blockingStoreClient) Series(ctx context.Context, req *storepb.SeriesRequest, opts ...grpc.CallOption) (storepb.Store_SeriesClient, error) {
+	<-s.release
+	return s.storeClient.Series(ctx, req, opts...)
+}
+
+func TestSeriesRequestKey(t *testing.T) {
+	var (
+		a   = storepb.LabelMatcher{Name: "a", Value: "1", Type: storepb.LabelMatcher_EQ}
+		b   = storepb.LabelMatcher{Name: "b", Value: "2", Type: storepb.LabelMatcher_RE}
+		c   = storepb.LabelMatcher{Name: "c", Value: "3", Type: storepb.LabelMatcher_NEQ}
+		st1 = &testClient{labels: []storepb.Label{{Name: "cluster", Value: "1"}}}
+		st2 = &testClient{labels: []storepb.Label{{Name: "cluster", Value: "2"}}}
+	)
+	key := func(r *storepb.SeriesRequest, sets [][]storepb.LabelMatcher, stores ...Client) string {
+		k, err := seriesRequestKey("client", r, sets, stores)
+		testutil.Ok(t, err)
+		return k
+	}
+	r := &storepb.SeriesRequest{MinTime: 1, MaxTime: 2, Aggregates: []storepb.Aggr{storepb.Aggr_COUNT, storepb.Aggr_SUM}}
+	exp := key(r, [][]storepb.LabelMatcher{{a, b}, {c}}, st1, st2)
+
+	// The order of matchers, matcher sets, aggregates and stores does not matter.
+	testutil.Equals(t, exp, key(r, [][]storepb.LabelMatcher{{c}, {b, a}}, st2, st1))
+	testutil.Equals(t, exp, key(&storepb.SeriesRequest{MinTime: 1, MaxTime: 2, Aggregates: []storepb.Aggr{storepb.Aggr_SUM, storepb.Aggr_COUNT}},
+		[][]storepb.LabelMatcher{{a, b}, {c}}, st1, st2))
+
+	testutil.Assert(t, exp != key(r, [][]storepb.LabelMatcher{{a, b}}, st1, st2), "different matcher sets share key")
+	testutil.Assert(t, exp != key(r, [][]storepb.LabelMatcher{{a, b}, {c}}, st1), "different stores share key")
+	testutil.Assert(t, exp != key(&storepb.SeriesRequest{MinTime: 1, MaxTime: 3, Aggregates: r.Aggregates},
+		[][]storepb.LabelMatcher{{a, b}, {c}}, st1, st2), "different time ranges share key")
+
+	other, err := seriesRequestKey("other", r, [][]storepb.LabelMatcher{{a, b}, {c}}, []Client{st1, st2})
+	testutil.Ok(t, err)
+	testutil.Assert(t, exp != other, "different clients share key")
+}
+
+func TestQueryStore_Series_Coalesce(t *testing.T) {
+	for _, tcase := range []struct {
+		bufferSize    int
+		clients       []string
+		expReqs       int
+		expCoalesced  float64
+		expOverflowed float64
+	}{
+		{bufferSize: 1e6, clients: []string{"a", "a"}, expReqs: 1, expCoalesced: 1},
+		// Responses exceeding the buffer are not shared.
+		{bufferSize: 10, clients: []string{"a", "a"}, expReqs: 2, expOverflowed: 1},
+		// Requests of different clients are not shared, so that stores account them to each client.
+		{bufferSize: 1e6, clients: []string{"a", "b"}, expReqs: 2},
+	} {
+		cl := &blockingStoreClient{
+			storeClient: &storeClient{RespSet: []*storepb.SeriesResponse{
+				storeSeriesResponse(t, labels.FromStrings("a", "1", "replica", "x"), []sample{{1, 1}}),
+				storeSeriesResponse(t, labels.FromStrings("a", "2", "replica", "x"), []sample{{1, 1}}),
+			}},
+			release: make(chan struct{}),
+		}
+		cls := []Client{&testClient{StoreClient: cl, maxTime: 300}}
+
+		reg := prometheus.NewRegistry()
+		q := NewProxyStore(nil, reg,
+			func(context.Context) ([]Client, error) { return cls, nil },
+			nil, tcase.bufferSize,
+		)
+		req := &storepb.SeriesRequest{
+			MinTime:  1,
+			MaxTime:  300,
+			Matchers: []storepb.LabelMatcher{{Name: "a", Value: ".+", Type: storepb.LabelMatcher_RE}},
+		}
+
+		var (
+			wg   sync.WaitGroup
+			srvs = []*storeSeriesServer{
+				newStoreSeriesServer(usage.WithClient(context.Background(), tcase.clients[0])),
+				newStoreSeriesServer(usage.WithClient(context.Background(), tcase.clients[1])),
+			}
+		)
+		for _, srv := range srvs {
+			wg.Add(1)
+			go func(srv *storeSeriesServer) {
+				defer wg.Done()
+				testutil.Ok(t, q.Series(req, srv))
+			}(srv)
+		}
+		// Let both requests arrive before the store responds.
+		time.Sleep(100 * time.Millisecond)
+		close(cl.release)
+		wg.Wait()
+
+		for _, srv := range srvs {
+			seriesEqual(t, []rawSeries{
+				{lset: []storepb.Label{{Name: "a", Value: "1"}, {Name: "replica", Value: "x"}}, samples: []sample{{1, 1}}},
+				{lset: []storepb.Label{{Name: "a", Value: "2"}, {Name: "replica", Value: "x"}}, samples: []sample{{1, 1}}},
+			}, srv.SeriesSet)
+		}
+		if tcase.expCoalesced > 0 {
+			// Receivers of shared responses may reorder the labels of the series without affecting each other.
+			srvs[0].SeriesSet[0].Labels[0], srvs[0].SeriesSet[0].Labels[1] = srvs[0].SeriesSet[0].Labels[1], srvs[0].SeriesSet[0].Labels[0]
+			testutil.Equals(t, []storepb.Label{{Name: "a", Value: "1"}, {Name: "replica", Value: "x"}}, srvs[1].SeriesSet[0].Labels)
+		}
+
+		testutil.Equals(t, tcase.expReqs, len(cl.Reqs))
+
+		mfs, err := reg.Gather()
+		testutil.Ok(t, err)
+		vals := map[string]float64{}
+		for _, mf := range mfs {
+			vals[mf.GetName()] = mf.GetMetric()[0].GetCounter().GetValue()
+		}
+		testutil.Equals(t, tcase.expCoalesced, vals["thanos_proxy_store_coalesced_requests_total"])
+		testutil.Equals(t, tcase.expOverflowed, vals["thanos_proxy_store_coalesce_buffer_overflows_total"])
+
+		// Later requests do not reuse the finished request.
+		srv := newStoreSeriesServer(context.Background())
+		testutil.Ok(t, q.Series(req, srv))
+		testutil.Equals(t, 2, len(srv.SeriesSet))
+		testutil.Equals(t, tcase.expReqs+1, len(cl.Reqs))
+	}
+}
@@ -0,0 +1,56 @@
+package store
+
+import (
+	"sort"
+
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+	promlabels "github.com/prometheus/prometheus/pkg/labels"
+)
+
+// InjectLabels returns the labels with the injected labels added, replacing labels of the same name.
+// The result is sorted by label name.
+func InjectLabels(lset, injected []storepb.Label) []storepb.Label {
+	if len(injected) == 0 {
+		return lset
+	}
+	res := make([]storepb.Label, 0, len(lset)+len(injected))
+	res = append(res, injected...)
+	for _, l := range lset {
+		if _, ok := injectedLabel(injected, l.Name); !ok {
+			res = append(res, l)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res
+}
+
+func injectedLabel(injected []storepb.Label, name string) (storepb.Label, bool) {
+	for _, l := range injected {
+		if l.Name == name {
+			return l, true
+		}
+	}
+	return storepb.Label{}, false
+}
+
+// stripInjectedMatchers removes the matchers of injected labels from the matcher sets.
+// Matcher sets only consisting of such matchers select all series of the store.
+func stripInjectedMatchers(matcherSets [][]storepb.LabelMatcher, injected []storepb.Label) [][]storepb.LabelMatcher {
+	if len(injected) == 0 {
+		return matcherSets
+	}
+	res := make([][]storepb.LabelMatcher, 0, len(matcherSets))
+	for _, ms := range matcherSets {
+		stripped := make([]storepb.LabelMatcher, 0, len(ms))
+		for _, m := range ms {
+			if _, ok := injectedLabel(injected, m.Name); !ok {
+				stripped = append(stripped, m)
+			}
+		}
+		if len(stripped) == 0 {
+			stripped = append(stripped, storepb.LabelMatcher{Type: storepb.LabelMatcher_RE, Name: promlabels.MetricName, Value: ".+"})
+		}
+		res = append(res, stripped)
+	}
+	return res
+}
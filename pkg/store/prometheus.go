@@ -3,6 +3,7 @@ package store
 import (
 	"bytes"
 	"context"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -10,7 +11,10 @@ import (
 	"net/url"
 	"path"
 	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
@@ -21,6 +25,10 @@ import (
 	"github.com/improbable-eng/thanos/pkg/store/storepb"
 	"github.com/improbable-eng/thanos/pkg/tracing"
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/prometheus/prometheus/promql"
 	"github.com/prometheus/tsdb/chunkenc"
 	"github.com/prometheus/tsdb/labels"
 	"google.golang.org/grpc/codes"
@@ -35,17 +43,36 @@ type PrometheusStore struct {
 	buffers        sync.Pool
 	externalLabels func() labels.Labels
 	timestamps     func() (mint int64, maxt int64)
+
+	// Limits of remote reads against Prometheus. Zero values disable the respective limit.
+	gate       chan struct{}
+	maxWait    time.Duration
+	maxRange   int64
+	maxSamples int
+
+	rejectedRequests *prometheus.CounterVec
+	splitRequests    prometheus.Counter
 }
 
 // NewPrometheusStore returns a new PrometheusStore that uses the given HTTP client
 // to talk to Prometheus.
 // It attaches the provided external labels to all results.
+// At most maxConcurrent remote reads and queries are sent to Prometheus at a time, further requests are rejected
+// once they waited longer than maxWait for a free slot. Series requests with a time range
+// longer than maxRange are split into several remote reads and requests selecting more than maxSamples samples fail.
+// Queries cannot be split, so queries selecting a longer time range or returning more samples fail.
+// Zero values disable the respective limit.
 func NewPrometheusStore(
 	logger log.Logger,
+	reg prometheus.Registerer,
 	client *http.Client,
 	baseURL *url.URL,
 	externalLabels func() labels.Labels,
 	timestamps func() (mint int64, maxt int64),
+	maxConcurrent int,
+	maxWait time.Duration,
+	maxRange time.Duration,
+	maxSamples int,
 ) (*PrometheusStore, error) {
 	if logger == nil {
 		logger = log.NewNopLogger()
@@ -55,12 +82,33 @@ func NewPrometheusStore(
 			Transport: tracing.HTTPTripperware(logger, http.DefaultTransport),
 		}
 	}
+	if maxConcurrent < 0 || maxWait < 0 || maxRange < 0 || maxSamples < 0 {
+		return nil, errors.New("remote read limits must not be negative")
+	}
 	p := &PrometheusStore{
 		logger:         logger,
 		base:           baseURL,
 		client:         client,
 		externalLabels: externalLabels,
 		timestamps:     timestamps,
+		maxWait:        maxWait,
+		maxRange:       int64(maxRange / time.Millisecond),
+		maxSamples:     maxSamples,
+	}
+	if maxConcurrent > 0 {
+		p.gate = make(chan struct{}, maxConcurrent)
+	}
+
+	p.rejectedRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "thanos_sidecar_remote_read_rejected_requests_total",
+		Help: "Total number of series requests and queries rejected because they exceeded a remote read limit.",
+	}, []string{"reason"})
+	p.splitRequests = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "thanos_sidecar_remote_read_split_requests_total",
+		Help: "Total number of series requests split into several remote reads because of their time range.",
+	})
+	if reg != nil {
+		reg.MustRegister(p.rejectedRequests, p.splitRequests)
 	}
 	return p, nil
 }
@@ -73,9 +121,11 @@ func (p *PrometheusStore) Info(ctx context.Context, r *storepb.InfoRequest) (*st
 	mint, maxt := p.timestamps()
 
 	res := &storepb.InfoResponse{
-		MinTime: mint,
-		MaxTime: maxt,
-		Labels:  make([]storepb.Label, 0, len(lset)),
+		MinTime:             mint,
+		MaxTime:             maxt,
+		Labels:              make([]storepb.Label, 0, len(lset)),
+		SupportsMatcherSets: true,
+		SupportsQuery:       true,
 	}
 	for _, l := range lset {
 		res.Labels = append(res.Labels, storepb.Label{
@@ -102,46 +152,144 @@ func (p *PrometheusStore) putBuffer(b []byte) {
 func (p *PrometheusStore) Series(r *storepb.SeriesRequest, s storepb.Store_SeriesServer) error {
 	ext := p.externalLabels()
 
-	match, newMatchers, err := labelsMatches(ext, r.Matchers)
+	matcherSets, err := labelsMatchesSets(ext, r.AllMatcherSets())
 	if err != nil {
 		return status.Error(codes.InvalidArgument, err.Error())
 	}
-	if !match {
+	if len(matcherSets) == 0 {
 		return nil
 	}
-	q := prompb.Query{StartTimestampMs: r.MinTime, EndTimestampMs: r.MaxTime}
-
-	// TODO(fabxc): import common definitions from prompb once we have a stable gRPC
-	// query API there.
-	for _, m := range newMatchers {
-		pm := prompb.LabelMatcher{Name: m.Name, Value: m.Value}
-
-		switch m.Type {
-		case storepb.LabelMatcher_EQ:
-			pm.Type = prompb.LabelMatcher_EQ
-		case storepb.LabelMatcher_NEQ:
-			pm.Type = prompb.LabelMatcher_NEQ
-		case storepb.LabelMatcher_RE:
-			pm.Type = prompb.LabelMatcher_RE
-		case storepb.LabelMatcher_NRE:
-			pm.Type = prompb.LabelMatcher_NRE
-		default:
-			return errors.New("unrecognized matcher type")
+
+	// Every matcher set is sent as a query of its own within a single remote read request.
+	queries := make([]prompb.Query, 0, len(matcherSets))
+	for _, newMatchers := range matcherSets {
+		q := prompb.Query{StartTimestampMs: r.MinTime, EndTimestampMs: r.MaxTime}
+
+		// TODO(fabxc): import common definitions from prompb once we have a stable gRPC
+		// query API there.
+		for _, m := range newMatchers {
+			pm := prompb.LabelMatcher{Name: m.Name, Value: m.Value}
+
+			switch m.Type {
+			case storepb.LabelMatcher_EQ:
+				pm.Type = prompb.LabelMatcher_EQ
+			case storepb.LabelMatcher_NEQ:
+				pm.Type = prompb.LabelMatcher_NEQ
+			case storepb.LabelMatcher_RE:
+				pm.Type = prompb.LabelMatcher_RE
+			case storepb.LabelMatcher_NRE:
+				pm.Type = prompb.LabelMatcher_NRE
+			default:
+				return errors.New("unrecognized matcher type")
+			}
+			q.Matchers = append(q.Matchers, pm)
+		}
+		queries = append(queries, q)
+	}
+
+	// Do not ask for data Prometheus cannot hold. Requests without a time range, e.g. for series metadata,
+	// select the entire time range and would otherwise be split into countless remote reads.
+	mint, maxt := r.MinTime, r.MaxTime
+	if p.timestamps != nil {
+		pmint, pmaxt := p.timestamps()
+		if mint < pmint {
+			mint = pmint
+		}
+		if maxt > pmaxt {
+			maxt = pmaxt
+		}
+	}
+	if p.maxRange > 0 {
+		// Prometheus holds no samples newer than the current time.
+		if now := timestamp.FromTime(time.Now()); maxt > now {
+			maxt = now
+		}
+	}
+	if mint > maxt {
+		return nil
+	}
+
+	split := p.maxRange > 0 && maxt-mint >= p.maxRange
+	if split {
+		p.splitRequests.Inc()
+	}
+
+	// Series of a single remote read are sent as they are read. Split remote reads are not streamed: every series
+	// must be sent once, in the order of the series labels, with a chunk per time range. Chunks of all time ranges
+	// are therefore kept in memory until the last time range was read, which is bounded by the sample limit only.
+	var (
+		sets    []storepb.SeriesSet
+		samples int
+	)
+	for start := mint; ; {
+		end := maxt
+		if split && maxt-start >= p.maxRange {
+			end = start + p.maxRange - 1
+		}
+		for i := range queries {
+			queries[i].StartTimestampMs, queries[i].EndTimestampMs = start, end
+		}
+
+		var series []seriesEntry
+		err := p.seriesInRange(s.Context(), queries, start, end, ext, &samples, func(lset []storepb.Label, chk storepb.AggrChunk) error {
+			if !split {
+				return s.Send(storepb.NewSeriesResponse(&storepb.Series{Labels: lset, Chunks: []storepb.AggrChunk{chk}}))
+			}
+			series = append(series, seriesEntry{lset: lset, chks: []storepb.AggrChunk{chk}})
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if split {
+			sets = append(sets, newBucketSeriesSet(series))
 		}
-		q.Matchers = append(q.Matchers, pm)
+		if end >= maxt {
+			break
+		}
+		start = end + 1
+	}
+	if !split {
+		return nil
 	}
 
-	resp, err := p.promSeries(s.Context(), q)
+	span, _ := tracing.StartSpan(s.Context(), "respond")
+	defer span.Finish()
+
+	set := storepb.MergeSeriesSets(sets...)
+	for set.Next() {
+		lset, chks := set.At()
+		if err := s.Send(storepb.NewSeriesResponse(&storepb.Series{Labels: lset, Chunks: chks})); err != nil {
+			return err
+		}
+	}
+	return set.Err()
+}
+
+// seriesInRange reads the series of the queries within the inclusive time range from Prometheus and calls f
+// with the labels and a chunk of the samples of every series in the order of their labels.
+// The number of samples returned by Prometheus is added to samples and the read fails if it exceeds the sample limit.
+func (p *PrometheusStore) seriesInRange(
+	ctx context.Context,
+	queries []prompb.Query,
+	mint, maxt int64,
+	ext labels.Labels,
+	samples *int,
+	f func([]storepb.Label, storepb.AggrChunk) error,
+) error {
+	resp, err := p.promSeries(ctx, samples, queries...)
 	if err != nil {
+		// Rejections by the remote read limits keep their status code.
+		if _, ok := status.FromError(err); ok {
+			return err
+		}
 		return errors.Wrap(err, "query Prometheus")
 	}
 
-	span, _ := tracing.StartSpan(s.Context(), "transform_and_respond")
+	span, _ := tracing.StartSpan(ctx, "transform_chunks")
 	defer span.Finish()
 
-	for _, e := range resp.Results[0].Timeseries {
-		lset := p.translateAndExtendLabels(e.Labels, ext)
-
+	for _, e := range unionTimeseries(resp.Results) {
 		if len(e.Samples) == 0 {
 			// As found in https://github.com/improbable-eng/thanos/issues/381
 			// Prometheus can give us completely empty time series. Ignore these with log until we figure out that
@@ -150,37 +298,107 @@ func (p *PrometheusStore) Series(r *storepb.SeriesRequest, s storepb.Store_Serie
 				"msg",
 				"found timeseries without any chunk. See https://github.com/improbable-eng/thanos/issues/381 for details",
 				"lset",
-				fmt.Sprintf("%v", lset),
+				fmt.Sprintf("%v", p.translateAndExtendLabels(e.Labels, ext)),
 			)
 			continue
 		}
+		// Drop samples outside of the requested range, so that split ranges never return a sample twice.
+		smpls := e.Samples[:0]
+		for _, smpl := range e.Samples {
+			if smpl.Timestamp >= mint && smpl.Timestamp <= maxt {
+				smpls = append(smpls, smpl)
+			}
+		}
+		if len(smpls) == 0 {
+			continue
+		}
 
 		// We generally expect all samples of the requested range to be traversed
 		// so we just encode all samples into one big chunk regardless of size.
-		enc, cb, err := p.encodeChunk(e.Samples)
+		enc, cb, err := p.encodeChunk(smpls)
 		if err != nil {
 			return status.Error(codes.Unknown, err.Error())
 		}
-		resp := storepb.NewSeriesResponse(&storepb.Series{
-			Labels: lset,
-			Chunks: []storepb.AggrChunk{{
-				MinTime: int64(e.Samples[0].Timestamp),
-				MaxTime: int64(e.Samples[len(e.Samples)-1].Timestamp),
-				Raw:     &storepb.Chunk{Type: enc, Data: cb},
-			}},
-		})
-		if err := s.Send(resp); err != nil {
+		if err := f(p.translateAndExtendLabels(e.Labels, ext), storepb.AggrChunk{
+			MinTime: smpls[0].Timestamp,
+			MaxTime: smpls[len(smpls)-1].Timestamp,
+			Raw:     &storepb.Chunk{Type: enc, Data: cb},
+		}); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (p *PrometheusStore) promSeries(ctx context.Context, q prompb.Query) (*prompb.ReadResponse, error) {
+// unionTimeseries returns the time series of all results sorted by their labels. Time series returned
+// for several results are returned once.
+func unionTimeseries(results []prompb.QueryResult) []prompb.TimeSeries {
+	var all []prompb.TimeSeries
+	for _, r := range results {
+		all = append(all, r.Timeseries...)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return comparePromLabels(all[i].Labels, all[j].Labels) < 0
+	})
+	res := all[:0]
+	for i := range all {
+		if i > 0 && comparePromLabels(all[i-1].Labels, all[i].Labels) == 0 {
+			continue
+		}
+		res = append(res, all[i])
+	}
+	return res
+}
+
+func comparePromLabels(a, b []prompb.Label) int {
+	l := len(a)
+	if len(b) < l {
+		l = len(b)
+	}
+	for i := 0; i < l; i++ {
+		if d := strings.Compare(a[i].Name, b[i].Name); d != 0 {
+			return d
+		}
+		if d := strings.Compare(a[i].Value, b[i].Value); d != 0 {
+			return d
+		}
+	}
+	return len(a) - len(b)
+}
+
+// acquire waits until fewer than the maximum number of concurrent requests are sent to Prometheus.
+// The returned function must be called once the request is done.
+func (p *PrometheusStore) acquire(ctx context.Context) (func(), error) {
+	if p.gate == nil {
+		return func() {}, nil
+	}
+	if p.maxWait > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.maxWait)
+		defer cancel()
+	}
+	select {
+	case p.gate <- struct{}{}:
+		return func() { <-p.gate }, nil
+	case <-ctx.Done():
+		p.rejectedRequests.WithLabelValues("concurrency").Inc()
+		return nil, errors.Wrap(ctx.Err(), "wait for concurrent remote reads")
+	}
+}
+
+// promSeries sends a remote read with the given queries to Prometheus. The number of samples in the response
+// is added to samples and checked against the sample limit before the response is decoded.
+func (p *PrometheusStore) promSeries(ctx context.Context, samples *int, queries ...prompb.Query) (*prompb.ReadResponse, error) {
+	release, err := p.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
 	span, ctx := tracing.StartSpan(ctx, "query_prometheus")
 	defer span.Finish()
 
-	reqb, err := proto.Marshal(&prompb.ReadRequest{Queries: []prompb.Query{q}})
+	reqb, err := proto.Marshal(&prompb.ReadRequest{Queries: queries})
 	if err != nil {
 		return nil, errors.Wrap(err, "marshal read request")
 	}
@@ -221,16 +439,82 @@ func (p *PrometheusStore) promSeries(ctx context.Context, q prompb.Query) (*prom
 		return nil, errors.Wrap(err, "decompress response")
 	}
 
+	// Decoding allocates far more memory than the encoded response takes, so samples are counted on the latter.
+	n, err := countSamples(decomp)
+	if err != nil {
+		return nil, errors.Wrap(err, "count samples of response")
+	}
+	*samples += n
+	if p.maxSamples > 0 && *samples > p.maxSamples {
+		p.rejectedRequests.WithLabelValues("samples").Inc()
+		return nil, status.Errorf(codes.ResourceExhausted, "remote read exceeded the limit of %d samples, select less series or a shorter time range", p.maxSamples)
+	}
+
 	var data prompb.ReadResponse
 	if err := proto.Unmarshal(decomp, &data); err != nil {
 		return nil, errors.Wrap(err, "unmarshal response")
 	}
-	if len(data.Results) != 1 {
+	if len(data.Results) != len(queries) {
 		return nil, errors.Errorf("unexepected result size %d", len(data.Results))
 	}
 	return &data, nil
 }
 
+// countSamples returns the number of samples in the encoded remote read response without decoding it.
+func countSamples(resp []byte) (n int, err error) {
+	err = forEachField(resp, 1, func(result []byte) error {
+		return forEachField(result, 1, func(series []byte) error {
+			return forEachField(series, 2, func([]byte) error {
+				n++
+				return nil
+			})
+		})
+	})
+	return n, err
+}
+
+// forEachField calls f with the content of every length-delimited field with the given number of the encoded
+// protobuf message. Fields of other numbers and types are skipped.
+func forEachField(msg []byte, num uint64, f func([]byte) error) error {
+	for len(msg) > 0 {
+		key, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return errors.New("invalid field key")
+		}
+		msg = msg[n:]
+
+		var l uint64
+		switch key & 7 {
+		case proto.WireVarint:
+			if _, n = binary.Uvarint(msg); n <= 0 {
+				return errors.New("invalid varint")
+			}
+			l = uint64(n)
+		case proto.WireFixed64:
+			l = 8
+		case proto.WireFixed32:
+			l = 4
+		case proto.WireBytes:
+			if l, n = binary.Uvarint(msg); n <= 0 {
+				return errors.New("invalid length")
+			}
+			msg = msg[n:]
+		default:
+			return errors.Errorf("unsupported wire type %d", key&7)
+		}
+		if uint64(len(msg)) < l {
+			return errors.New("unexpected end of message")
+		}
+		if key&7 == proto.WireBytes && key>>3 == num {
+			if err := f(msg[:l]); err != nil {
+				return err
+			}
+		}
+		msg = msg[l:]
+	}
+	return nil
+}
+
 func labelsMatches(lset labels.Labels, ms []storepb.LabelMatcher) (bool, []storepb.LabelMatcher, error) {
 	if len(lset) == 0 {
 		return true, ms, nil
@@ -260,6 +544,22 @@ func labelsMatches(lset labels.Labels, ms []storepb.LabelMatcher) (bool, []store
 	return true, newMatcher, nil
 }
 
+// labelsMatchesSets returns the matcher sets that may match series with the given external labels.
+// Matchers on external labels are removed from the returned sets.
+func labelsMatchesSets(lset labels.Labels, sets [][]storepb.LabelMatcher) ([][]storepb.LabelMatcher, error) {
+	var res [][]storepb.LabelMatcher
+	for _, ms := range sets {
+		match, newMatchers, err := labelsMatches(lset, ms)
+		if err != nil {
+			return nil, err
+		}
+		if match {
+			res = append(res, newMatchers)
+		}
+	}
+	return res, nil
+}
+
 // encodeChunk translates the sample pairs into a chunk.
 func (p *PrometheusStore) encodeChunk(ss []prompb.Sample) (storepb.Chunk_Encoding, []byte, error) {
 	c := chunkenc.NewXORChunk()
@@ -343,3 +643,150 @@ func (p *PrometheusStore) LabelValues(ctx context.Context, r *storepb.LabelValue
 
 	return &storepb.LabelValuesResponse{Values: m.Data}, nil
 }
+
+// Query evaluates the given PromQL query on Prometheus using its HTTP API.
+// Matchers on external labels are removed from the query, as Prometheus does not know about them. The external labels
+// kept by the query are attached to all resulting series.
+// Queries are subject to the same limits as remote reads.
+func (p *PrometheusStore) Query(ctx context.Context, r *storepb.QueryRequest) (*storepb.QueryResponse, error) {
+	expr, err := promql.ParseExpr(r.Query)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	end := r.End
+	if r.Step == 0 {
+		end = r.Start
+	}
+	if mint, maxt := selectedRange(expr, r.Start, end); p.maxRange > 0 && maxt-mint >= p.maxRange {
+		p.rejectedRequests.WithLabelValues("range").Inc()
+		return nil, status.Errorf(codes.ResourceExhausted, "query selects a time range longer than the remote read limit of %s", time.Duration(p.maxRange)*time.Millisecond)
+	}
+	ext, err := rewriteExternalLabels(expr, p.externalLabels())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	release, err := p.acquire(ctx)
+	if err != nil {
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	}
+	defer release()
+
+	u := *p.base
+	q := url.Values{}
+	q.Set("query", expr.String())
+	if r.Step > 0 {
+		u.Path = path.Join(u.Path, "/api/v1/query_range")
+		q.Set("start", formatPromTime(r.Start))
+		q.Set("end", formatPromTime(r.End))
+		q.Set("step", formatPromTime(r.Step))
+	} else {
+		u.Path = path.Join(u.Path, "/api/v1/query")
+		q.Set("time", formatPromTime(r.Start))
+	}
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, status.Error(codes.Unknown, err.Error())
+	}
+
+	span, ctx := tracing.StartSpan(ctx, "/prom_query HTTP[client]")
+	defer span.Finish()
+
+	resp, err := p.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, status.Error(codes.Unknown, err.Error())
+	}
+	defer runutil.CloseWithLogOnErr(p.logger, resp.Body, "query request body")
+
+	var m struct {
+		Status string `json:"status"`
+		Error  string `json:"error"`
+		Data   struct {
+			ResultType string          `json:"resultType"`
+			Result     json.RawMessage `json:"result"`
+		} `json:"data"`
+		Warnings []string `json:"warnings"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&m); err != nil {
+		return nil, status.Error(codes.Unknown, errors.Wrapf(err, "decode response with code %s", resp.Status).Error())
+	}
+	if m.Status != "success" {
+		return nil, status.Error(codes.Unknown, errors.Errorf("query failed with code %s: %s", resp.Status, m.Error).Error())
+	}
+
+	res := &storepb.QueryResponse{Warnings: m.Warnings}
+	switch m.Data.ResultType {
+	case "vector":
+		var v model.Vector
+		if err := json.Unmarshal(m.Data.Result, &v); err != nil {
+			return nil, status.Error(codes.Unknown, errors.Wrap(err, "decode vector").Error())
+		}
+		if err := p.checkQuerySamples(len(v)); err != nil {
+			return nil, err
+		}
+		for _, s := range v {
+			res.Series = append(res.Series, storepb.QuerySeries{
+				Labels:  translateAndExtendMetric(s.Metric, ext),
+				Samples: []storepb.Sample{{Timestamp: int64(s.Timestamp), Value: float64(s.Value)}},
+			})
+		}
+	case "matrix":
+		var mat model.Matrix
+		if err := json.Unmarshal(m.Data.Result, &mat); err != nil {
+			return nil, status.Error(codes.Unknown, errors.Wrap(err, "decode matrix").Error())
+		}
+		var samples int
+		for _, ss := range mat {
+			samples += len(ss.Values)
+		}
+		if err := p.checkQuerySamples(samples); err != nil {
+			return nil, err
+		}
+		for _, ss := range mat {
+			series := storepb.QuerySeries{
+				Labels:  translateAndExtendMetric(ss.Metric, ext),
+				Samples: make([]storepb.Sample, 0, len(ss.Values)),
+			}
+			for _, s := range ss.Values {
+				series.Samples = append(series.Samples, storepb.Sample{Timestamp: int64(s.Timestamp), Value: float64(s.Value)})
+			}
+			res.Series = append(res.Series, series)
+		}
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "unsupported result type %q", m.Data.ResultType)
+	}
+	return res, nil
+}
+
+// checkQuerySamples returns an error if a query result with the given number of samples exceeds the sample limit.
+func (p *PrometheusStore) checkQuerySamples(n int) error {
+	if p.maxSamples > 0 && n > p.maxSamples {
+		p.rejectedRequests.WithLabelValues("samples").Inc()
+		return status.Errorf(codes.ResourceExhausted, "query exceeded the remote read limit of %d samples", p.maxSamples)
+	}
+	return nil
+}
+
+// formatPromTime formats the given milliseconds as seconds accepted by the Prometheus HTTP API.
+func formatPromTime(ms int64) string {
+	return strconv.FormatFloat(float64(ms)/1000, 'f', -1, 64)
+}
+
+// translateAndExtendMetric transforms a metric into a protobuf label set. It additionally
+// attaches the given labels to it, overwriting existing ones on collision.
+func translateAndExtendMetric(m model.Metric, extend labels.Labels) []storepb.Label {
+	lset := make([]storepb.Label, 0, len(m)+len(extend))
+
+	for n, v := range m {
+		if extend.Get(string(n)) != "" {
+			continue
+		}
+		lset = append(lset, storepb.Label{
+			Name:  string(n),
+			Value: string(v),
+		})
+	}
+	return extendLset(lset, extend)
+}
@@ -3,16 +3,27 @@ package store
 import (
 	"context"
 	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/fortytw2/leaktest"
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/improbable-eng/thanos/pkg/store/prompb"
 	"github.com/improbable-eng/thanos/pkg/store/storepb"
 	"github.com/improbable-eng/thanos/pkg/testutil"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/prometheus/pkg/timestamp"
 	"github.com/prometheus/tsdb/chunkenc"
 	"github.com/prometheus/tsdb/labels"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func TestPrometheusStore_Series_e2e(t *testing.T) {
@@ -52,10 +63,10 @@ func testPrometheusStoreSeriesE2e(t *testing.T, prefix string) {
 	u, err := url.Parse(fmt.Sprintf("http://%s", p.Addr()))
 	testutil.Ok(t, err)
 
-	proxy, err := NewPrometheusStore(nil, nil, u,
+	proxy, err := NewPrometheusStore(nil, nil, nil, u,
 		func() labels.Labels {
 			return labels.FromStrings("region", "eu-west")
-		}, nil)
+		}, nil, 0, 0, 0, 0)
 	testutil.Ok(t, err)
 
 	// Query all three samples except for the first one. Since we round up queried data
@@ -126,7 +137,7 @@ func TestPrometheusStore_LabelValues_e2e(t *testing.T) {
 	u, err := url.Parse(fmt.Sprintf("http://%s", p.Addr()))
 	testutil.Ok(t, err)
 
-	proxy, err := NewPrometheusStore(nil, nil, u, nil, nil)
+	proxy, err := NewPrometheusStore(nil, nil, nil, u, nil, nil, 0, 0, 0, 0)
 	testutil.Ok(t, err)
 
 	resp, err := proxy.LabelValues(ctx, &storepb.LabelValuesRequest{
@@ -163,10 +174,10 @@ func TestPrometheusStore_Series_MatchExternalLabel_e2e(t *testing.T) {
 	u, err := url.Parse(fmt.Sprintf("http://%s", p.Addr()))
 	testutil.Ok(t, err)
 
-	proxy, err := NewPrometheusStore(nil, nil, u,
+	proxy, err := NewPrometheusStore(nil, nil, nil, u,
 		func() labels.Labels {
 			return labels.FromStrings("region", "eu-west")
-		}, nil)
+		}, nil, 0, 0, 0, 0)
 	testutil.Ok(t, err)
 	srv := newStoreSeriesServer(ctx)
 
@@ -209,13 +220,13 @@ func TestPrometheusStore_Info(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	proxy, err := NewPrometheusStore(nil, nil, nil,
+	proxy, err := NewPrometheusStore(nil, nil, nil, nil,
 		func() labels.Labels {
 			return labels.FromStrings("region", "eu-west")
 		},
 		func() (int64, int64) {
 			return 123, 456
-		})
+		}, 0, 0, 0, 0)
 	testutil.Ok(t, err)
 
 	resp, err := proxy.Info(ctx, &storepb.InfoRequest{})
@@ -224,4 +235,237 @@ func TestPrometheusStore_Info(t *testing.T) {
 	testutil.Equals(t, []storepb.Label{{Name: "region", Value: "eu-west"}}, resp.Labels)
 	testutil.Equals(t, int64(123), resp.MinTime)
 	testutil.Equals(t, int64(456), resp.MaxTime)
+	testutil.Assert(t, resp.SupportsQuery, "expected sidecar to support queries")
+}
+
+func TestPrometheusStore_Query(t *testing.T) {
+	var reqs []url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		testutil.Ok(t, r.ParseForm())
+		reqs = append(reqs, r.Form)
+
+		switch r.URL.Path {
+		case "/api/v1/query":
+			fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{"a":"b"},"value":[1.5,"2"]}]}}`)
+		case "/api/v1/query_range":
+			fmt.Fprint(w, `{"status":"success","data":{"resultType":"matrix","result":[{"metric":{"a":"b","region":"x"},"values":[[1,"1"],[2,"3"]]}]}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	testutil.Ok(t, err)
+
+	proxy, err := NewPrometheusStore(nil, nil, nil, u,
+		func() labels.Labels {
+			return labels.FromStrings("region", "eu-west")
+		}, nil, 0, 0, 0, 0)
+	testutil.Ok(t, err)
+
+	ctx := context.Background()
+
+	resp, err := proxy.Query(ctx, &storepb.QueryRequest{Query: `rate(up{region="eu-west"}[5m])`, Start: 1500})
+	testutil.Ok(t, err)
+	testutil.Equals(t, "rate(up[5m])", reqs[0].Get("query"))
+	testutil.Equals(t, "1.5", reqs[0].Get("time"))
+	testutil.Equals(t, []storepb.QuerySeries{{
+		Labels:  []storepb.Label{{Name: "a", Value: "b"}, {Name: "region", Value: "eu-west"}},
+		Samples: []storepb.Sample{{Timestamp: 1500, Value: 2}},
+	}}, resp.Series)
+
+	resp, err = proxy.Query(ctx, &storepb.QueryRequest{Query: `up`, Start: 1000, End: 2000, Step: 1000})
+	testutil.Ok(t, err)
+	testutil.Equals(t, "1", reqs[1].Get("start"))
+	testutil.Equals(t, "2", reqs[1].Get("end"))
+	testutil.Equals(t, "1", reqs[1].Get("step"))
+	testutil.Equals(t, []storepb.QuerySeries{{
+		Labels:  []storepb.Label{{Name: "a", Value: "b"}, {Name: "region", Value: "eu-west"}},
+		Samples: []storepb.Sample{{Timestamp: 1000, Value: 1}, {Timestamp: 2000, Value: 3}},
+	}}, resp.Series)
+
+	// External labels are not kept by the aggregation.
+	_, err = proxy.Query(ctx, &storepb.QueryRequest{Query: `sum(up)`, Start: 1500})
+	testutil.Ok(t, err)
+
+	// Matcher not matching external labels.
+	_, err = proxy.Query(ctx, &storepb.QueryRequest{Query: `up{region="us"}`, Start: 1500})
+	testutil.NotOk(t, err)
+	testutil.Equals(t, 3, len(reqs))
+
+	// Queries are subject to the remote read limits.
+	proxy, err = NewPrometheusStore(nil, nil, nil, u,
+		func() labels.Labels {
+			return labels.FromStrings("region", "eu-west")
+		}, nil, 1, 0, 10*time.Minute, 1)
+	testutil.Ok(t, err)
+
+	_, err = proxy.Query(ctx, &storepb.QueryRequest{Query: `rate(up[1h])`, Start: 1500})
+	testutil.Equals(t, codes.ResourceExhausted, status.Code(err))
+	testutil.Equals(t, 3, len(reqs))
+
+	_, err = proxy.Query(ctx, &storepb.QueryRequest{Query: `up`, Start: 1000, End: 2000, Step: 1000})
+	testutil.Equals(t, codes.ResourceExhausted, status.Code(err))
+	testutil.Equals(t, 4, len(reqs))
+
+	proxy.gate <- struct{}{}
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = proxy.Query(cctx, &storepb.QueryRequest{Query: `up`, Start: 1500})
+	testutil.Equals(t, codes.ResourceExhausted, status.Code(err))
+	testutil.Equals(t, 4, len(reqs))
+}
+
+func TestPrometheusStore_Series_Limits(t *testing.T) {
+	var (
+		mtx   sync.Mutex
+		calls [][2]int64
+	)
+	// Fake remote read API returning a sample every 100ms from 0 to 1s for every query, regardless of its time range.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := ioutil.ReadAll(r.Body)
+		testutil.Ok(t, err)
+		b, err = snappy.Decode(nil, b)
+		testutil.Ok(t, err)
+
+		var req prompb.ReadRequest
+		testutil.Ok(t, proto.Unmarshal(b, &req))
+
+		var resp prompb.ReadResponse
+		for _, q := range req.Queries {
+			mtx.Lock()
+			calls = append(calls, [2]int64{q.StartTimestampMs, q.EndTimestampMs})
+			mtx.Unlock()
+
+			ts := prompb.TimeSeries{Labels: []prompb.Label{{Name: "a", Value: "b"}}}
+			for st := int64(0); st <= 1000; st += 100 {
+				ts.Samples = append(ts.Samples, prompb.Sample{Timestamp: st, Value: float64(st)})
+			}
+			resp.Results = append(resp.Results, prompb.QueryResult{Timeseries: []prompb.TimeSeries{ts}})
+		}
+		b, err = proto.Marshal(&resp)
+		testutil.Ok(t, err)
+		_, err = w.Write(snappy.Encode(nil, b))
+		testutil.Ok(t, err)
+	}))
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	testutil.Ok(t, err)
+	ext := func() labels.Labels { return labels.FromStrings("region", "eu-west") }
+	req := &storepb.SeriesRequest{
+		MinTime:  50,
+		MaxTime:  1000,
+		Matchers: []storepb.LabelMatcher{{Type: storepb.LabelMatcher_EQ, Name: "a", Value: "b"}},
+	}
+	counter := func(reg *prometheus.Registry, name string) (res float64) {
+		mfs, err := reg.Gather()
+		testutil.Ok(t, err)
+		for _, mf := range mfs {
+			if mf.GetName() != name {
+				continue
+			}
+			for _, m := range mf.GetMetric() {
+				res += m.GetCounter().GetValue()
+			}
+		}
+		return res
+	}
+	expSamples := []sample{{100, 100}, {200, 200}, {300, 300}, {400, 400}, {500, 500}, {600, 600}, {700, 700}, {800, 800}, {900, 900}, {1000, 1000}}
+
+	// Short enough requests are not split.
+	reg := prometheus.NewRegistry()
+	proxy, err := NewPrometheusStore(nil, reg, nil, u, ext, nil, 1, 0, 2*time.Second, 0)
+	testutil.Ok(t, err)
+
+	s := newStoreSeriesServer(context.Background())
+	testutil.Ok(t, proxy.Series(req, s))
+	testutil.Equals(t, [][2]int64{{50, 1000}}, calls)
+	testutil.Equals(t, 1, len(s.SeriesSet))
+	testutil.Equals(t, 1, len(s.SeriesSet[0].Chunks))
+	testutil.Equals(t, 0.0, counter(reg, "thanos_sidecar_remote_read_split_requests_total"))
+
+	// Longer requests are split into several remote reads, each returning a chunk of the series.
+	calls = nil
+	reg = prometheus.NewRegistry()
+	proxy, err = NewPrometheusStore(nil, reg, nil, u, ext, nil, 1, 0, 400*time.Millisecond, 0)
+	testutil.Ok(t, err)
+
+	s = newStoreSeriesServer(context.Background())
+	testutil.Ok(t, proxy.Series(req, s))
+	testutil.Equals(t, [][2]int64{{50, 449}, {450, 849}, {850, 1000}}, calls)
+	testutil.Equals(t, 1, len(s.SeriesSet))
+	testutil.Equals(t, []storepb.Label{{Name: "a", Value: "b"}, {Name: "region", Value: "eu-west"}}, s.SeriesSet[0].Labels)
+	testutil.Equals(t, 3, len(s.SeriesSet[0].Chunks))
+
+	var samples []sample
+	for _, c := range s.SeriesSet[0].Chunks {
+		chk, err := chunkenc.FromData(chunkenc.EncXOR, c.Raw.Data)
+		testutil.Ok(t, err)
+		samples = append(samples, expandChunk(chk.Iterator())...)
+	}
+	testutil.Equals(t, expSamples, samples)
+	testutil.Equals(t, 1.0, counter(reg, "thanos_sidecar_remote_read_split_requests_total"))
+
+	// Requests are limited to the time range of Prometheus before they are split.
+	calls = nil
+	proxy, err = NewPrometheusStore(nil, nil, nil, u, ext, func() (int64, int64) { return 100, 900 }, 1, 0, 400*time.Millisecond, 0)
+	testutil.Ok(t, err)
+
+	s = newStoreSeriesServer(context.Background())
+	testutil.Ok(t, proxy.Series(&storepb.SeriesRequest{
+		MinTime:  math.MinInt64,
+		MaxTime:  math.MaxInt64,
+		Matchers: req.Matchers,
+	}, s))
+	testutil.Equals(t, [][2]int64{{100, 499}, {500, 899}, {900, 900}}, calls)
+	testutil.Equals(t, 1, len(s.SeriesSet))
+	testutil.Equals(t, 3, len(s.SeriesSet[0].Chunks))
+
+	// Requests exceeding the samples budget fail.
+	reg = prometheus.NewRegistry()
+	proxy, err = NewPrometheusStore(nil, reg, nil, u, ext, nil, 1, 0, 400*time.Millisecond, 5)
+	testutil.Ok(t, err)
+
+	err = proxy.Series(req, newStoreSeriesServer(context.Background()))
+	testutil.NotOk(t, err)
+	testutil.Equals(t, codes.ResourceExhausted, status.Code(err))
+	testutil.Equals(t, 1.0, counter(reg, "thanos_sidecar_remote_read_rejected_requests_total"))
+
+	// Requests waiting for a free remote read slot fail once they are canceled or waited too long.
+	reg = prometheus.NewRegistry()
+	proxy, err = NewPrometheusStore(nil, reg, nil, u, ext, nil, 1, 100*time.Millisecond, 0, 0)
+	testutil.Ok(t, err)
+	proxy.gate <- struct{}{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	testutil.NotOk(t, proxy.Series(req, newStoreSeriesServer(ctx)))
+	testutil.Equals(t, 1.0, counter(reg, "thanos_sidecar_remote_read_rejected_requests_total"))
+
+	testutil.NotOk(t, proxy.Series(req, newStoreSeriesServer(context.Background())))
+	testutil.Equals(t, 2.0, counter(reg, "thanos_sidecar_remote_read_rejected_requests_total"))
+}
+
+func TestCountSamples(t *testing.T) {
+	resp := &prompb.ReadResponse{Results: []prompb.QueryResult{
+		{Timeseries: []prompb.TimeSeries{
+			{Labels: []prompb.Label{{Name: "a", Value: "b"}}, Samples: []prompb.Sample{{Timestamp: 1, Value: 1}, {Timestamp: 2, Value: 2}}},
+			{Labels: []prompb.Label{{Name: "a", Value: "c"}}, Samples: []prompb.Sample{{Timestamp: 1, Value: 1}}},
+		}},
+		{},
+		{Timeseries: []prompb.TimeSeries{
+			{Labels: []prompb.Label{{Name: "a", Value: "d"}}, Samples: []prompb.Sample{{Timestamp: -1, Value: 0}, {Timestamp: 0, Value: 1}, {Timestamp: 5, Value: 7}}},
+		}},
+	}}
+	b, err := proto.Marshal(resp)
+	testutil.Ok(t, err)
+
+	n, err := countSamples(b)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 6, n)
+
+	_, err = countSamples(b[:len(b)-1])
+	testutil.NotOk(t, err)
 }
@@ -0,0 +1,197 @@
+package store
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql"
+	tsdblabels "github.com/prometheus/tsdb/labels"
+)
+
+// rewriteExternalLabels prepares the given PromQL expression for evaluation by a Prometheus with the given
+// external labels. Prometheus does not know about its external labels, so matchers on them are validated and
+// removed from all selectors. It returns the external labels that the expression keeps in its result, which
+// have to be attached to all resulting series.
+// An error is returned if the result of the expression would differ from its evaluation on the querier.
+func rewriteExternalLabels(expr promql.Expr, ext tsdblabels.Labels) (tsdblabels.Labels, error) {
+	var err error
+	promql.Inspect(expr, func(node promql.Node, _ []promql.Node) error {
+		if err != nil {
+			return err
+		}
+		switch n := node.(type) {
+		case *promql.VectorSelector:
+			n.LabelMatchers, err = removeExternalLabelMatchers(n.LabelMatchers, ext)
+		case *promql.MatrixSelector:
+			n.LabelMatchers, err = removeExternalLabelMatchers(n.LabelMatchers, ext)
+		case *promql.Call:
+			err = validateCall(n, ext)
+		case *promql.AggregateExpr:
+			if p, ok := n.Param.(*promql.StringLiteral); ok && ext.Get(p.Val) != "" {
+				err = errors.Errorf("%s on external label %q is not supported", n.Op, p.Val)
+			}
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var keep tsdblabels.Labels
+	for _, l := range ext {
+		ok, err := labelKept(expr, l.Name)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			keep = append(keep, l)
+		}
+	}
+	return keep, nil
+}
+
+// selectedRange returns the time range of the samples the expression selects when evaluated from start to end.
+func selectedRange(expr promql.Expr, start, end int64) (mint, maxt int64) {
+	mint, maxt = start, end
+	promql.Inspect(expr, func(node promql.Node, _ []promql.Node) error {
+		var offset, rng time.Duration
+		switch n := node.(type) {
+		case *promql.VectorSelector:
+			offset, rng = n.Offset, promql.LookbackDelta
+		case *promql.MatrixSelector:
+			offset, rng = n.Offset, n.Range
+		default:
+			return nil
+		}
+		if smint := start - int64((offset+rng)/time.Millisecond); smint < mint {
+			mint = smint
+		}
+		if smaxt := end - int64(offset/time.Millisecond); smaxt > maxt {
+			maxt = smaxt
+		}
+		return nil
+	})
+	return mint, maxt
+}
+
+func removeExternalLabelMatchers(ms []*labels.Matcher, ext tsdblabels.Labels) ([]*labels.Matcher, error) {
+	res := make([]*labels.Matcher, 0, len(ms))
+	for _, m := range ms {
+		v := ext.Get(m.Name)
+		if v == "" {
+			res = append(res, m)
+			continue
+		}
+		if !m.Matches(v) {
+			return nil, errors.Errorf("matcher %s does not match external labels", m)
+		}
+	}
+	return res, nil
+}
+
+// validateCall returns an error for function calls whose result depends on labels Prometheus does not know about.
+func validateCall(c *promql.Call, ext tsdblabels.Labels) error {
+	var names []promql.Expr
+	switch c.Func.Name {
+	case "absent":
+		return errors.New("absent is not supported")
+	case "label_replace":
+		names = []promql.Expr{c.Args[1], c.Args[3]}
+	case "label_join":
+		names = append([]promql.Expr{c.Args[1]}, c.Args[3:]...)
+	}
+	for _, n := range names {
+		if s, ok := n.(*promql.StringLiteral); ok && ext.Get(s.Val) != "" {
+			return errors.Errorf("%s on external label %q is not supported", c.Func.Name, s.Val)
+		}
+	}
+	return nil
+}
+
+// labelKept returns whether all series resulting from the expression keep the label with the given name
+// of the selected series. An error is returned if only some of them keep it.
+func labelKept(expr promql.Expr, name string) (bool, error) {
+	if t := expr.Type(); t != promql.ValueTypeVector && t != promql.ValueTypeMatrix {
+		return false, nil
+	}
+
+	switch e := expr.(type) {
+	case *promql.VectorSelector, *promql.MatrixSelector:
+		return true, nil
+
+	case *promql.ParenExpr:
+		return labelKept(e.Expr, name)
+
+	case *promql.UnaryExpr:
+		return labelKept(e.Expr, name)
+
+	case *promql.Call:
+		// Functions keep the labels of their first vector argument.
+		for _, a := range e.Args {
+			if t := a.Type(); t == promql.ValueTypeVector || t == promql.ValueTypeMatrix {
+				return labelKept(a, name)
+			}
+		}
+		return false, nil
+
+	case *promql.AggregateExpr:
+		switch e.Op.String() {
+		case "topk", "bottomk":
+			return labelKept(e.Expr, name)
+		}
+		if contains(e.Grouping, name) == e.Without {
+			return false, nil
+		}
+		return labelKept(e.Expr, name)
+
+	case *promql.BinaryExpr:
+		if e.LHS.Type() == promql.ValueTypeScalar {
+			return labelKept(e.RHS, name)
+		}
+		if e.RHS.Type() == promql.ValueTypeScalar {
+			return labelKept(e.LHS, name)
+		}
+
+		switch e.Op.String() {
+		case "and", "unless":
+			return labelKept(e.LHS, name)
+		case "or":
+			l, err := labelKept(e.LHS, name)
+			if err != nil {
+				return false, err
+			}
+			r, err := labelKept(e.RHS, name)
+			if err != nil {
+				return false, err
+			}
+			if l != r {
+				return false, errors.Errorf("label %q is kept by only one operand of %s", name, e)
+			}
+			return l, nil
+		}
+
+		vm := e.VectorMatching
+		many, one := e.LHS, e.RHS
+		if vm.Card == promql.CardOneToMany {
+			many, one = one, many
+		}
+		if contains(vm.Include, name) {
+			return labelKept(one, name)
+		}
+		if vm.Card == promql.CardOneToOne && contains(vm.MatchingLabels, name) != vm.On {
+			return false, nil
+		}
+		return labelKept(many, name)
+	}
+	return false, errors.Errorf("unsupported expression %s", expr)
+}
+
+func contains(s []string, name string) bool {
+	for _, n := range s {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,61 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/improbable-eng/thanos/pkg/testutil"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/tsdb/labels"
+)
+
+func TestRewriteExternalLabels(t *testing.T) {
+	ext := labels.FromStrings("cluster", "a", "replica", "1")
+
+	for _, tcase := range []struct {
+		query    string
+		expQuery string
+		expKeep  labels.Labels
+		expErr   bool
+	}{
+		{query: `up`, expKeep: ext},
+		{query: `up{cluster="a",job="x"}`, expQuery: `up{job="x"}`, expKeep: ext},
+		{query: `rate(up{cluster=~"a|b"}[5m])`, expQuery: `rate(up[5m])`, expKeep: ext},
+		{query: `up{cluster="b"}`, expErr: true},
+		{query: `sum(up)`},
+		{query: `sum by(cluster, job) (up)`, expKeep: labels.FromStrings("cluster", "a")},
+		{query: `sum without(replica) (up)`, expKeep: labels.FromStrings("cluster", "a")},
+		{query: `topk(3, up)`, expKeep: ext},
+		{query: `count_values("cluster", up)`, expErr: true},
+		{query: `up / on(job) up`},
+		{query: `up / ignoring(replica) up`, expKeep: labels.FromStrings("cluster", "a")},
+		{query: `up * on(job) group_left(cluster) sum by(job) (up)`, expKeep: labels.FromStrings("replica", "1")},
+		{query: `up or sum(up)`, expErr: true},
+		{query: `up and sum(up)`, expKeep: ext},
+		{query: `2 * up`, expKeep: ext},
+		{query: `scalar(up)`},
+		{query: `absent(up)`, expErr: true},
+		{query: `label_replace(up, "x", "$1", "cluster", "(.*)")`, expErr: true},
+		{query: `label_replace(up, "x", "$1", "job", "(.*)")`, expKeep: ext},
+	} {
+		t.Run(tcase.query, func(t *testing.T) {
+			expr, err := promql.ParseExpr(tcase.query)
+			testutil.Ok(t, err)
+
+			keep, err := rewriteExternalLabels(expr, ext)
+			if tcase.expErr {
+				testutil.NotOk(t, err)
+				return
+			}
+			testutil.Ok(t, err)
+
+			// Queries without expected rewrite must stay the same.
+			if tcase.expQuery == "" {
+				tcase.expQuery = tcase.query
+			}
+			exp, err := promql.ParseExpr(tcase.expQuery)
+			testutil.Ok(t, err)
+			testutil.Equals(t, exp.String(), expr.String())
+			testutil.Equals(t, tcase.expKeep, keep)
+		})
+	}
+}
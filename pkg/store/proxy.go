@@ -4,6 +4,7 @@ import (
 	"context"
 	"io"
 	"math"
+	"sort"
 	"strings"
 	"sync"
 
@@ -13,7 +14,10 @@ import (
 	"github.com/go-kit/kit/log/level"
 	"github.com/improbable-eng/thanos/pkg/store/storepb"
 	"github.com/improbable-eng/thanos/pkg/strutil"
+	"github.com/improbable-eng/thanos/pkg/usage"
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	promlabels "github.com/prometheus/prometheus/pkg/labels"
 	"github.com/prometheus/tsdb/labels"
 	"golang.org/x/sync/errgroup"
 	"google.golang.org/grpc/codes"
@@ -31,6 +35,19 @@ type Client interface {
 	// Minimum and maximum time range of data in the store.
 	TimeRange() (mint int64, maxt int64)
 
+	// Filter of the metric names in the store. Nil if the store may hold any metric.
+	MetricNames() *storepb.MetricNameFilter
+
+	// Whether the store understands matcher sets of series requests.
+	SupportsMatcherSets() bool
+
+	// Whether the store evaluates PromQL queries through the Query API.
+	SupportsQuery() bool
+
+	// Labels injected into all series of the store, replacing labels of the same name.
+	// They are already part of the labels returned by Labels.
+	InjectedLabels() []storepb.Label
+
 	String() string
 }
 
@@ -39,14 +56,22 @@ type ProxyStore struct {
 	logger         log.Logger
 	stores         func(context.Context) ([]Client, error)
 	selectorLabels labels.Labels
+
+	skippedRequests prometheus.Counter
+	// Coalescer of identical concurrent Series requests. Nil if disabled.
+	coalescer *seriesCoalescer
 }
 
 // NewProxyStore returns a new ProxyStore that uses the given clients that implements storeAPI to fan-in all series to the client.
 // Note that there is no deduplication support. Deduplication should be done on the highest level (just before PromQL)
+// If coalesceBufferSize is positive, identical concurrent Series requests share a single fan-out, as long as the
+// responses do not exceed the given number of bytes.
 func NewProxyStore(
 	logger log.Logger,
+	reg prometheus.Registerer,
 	stores func(context.Context) ([]Client, error),
 	selectorLabels labels.Labels,
+	coalesceBufferSize int,
 ) *ProxyStore {
 	if logger == nil {
 		logger = log.NewNopLogger()
@@ -55,6 +80,16 @@ func NewProxyStore(
 		logger:         logger,
 		stores:         stores,
 		selectorLabels: selectorLabels,
+		skippedRequests: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "thanos_proxy_store_metric_name_skipped_requests_total",
+			Help: "Total number of series requests to stores that were skipped because the stores do not hold the requested metric names.",
+		}),
+	}
+	if reg != nil {
+		reg.MustRegister(s.skippedRequests)
+	}
+	if coalesceBufferSize > 0 {
+		s.coalescer = newSeriesCoalescer(reg, coalesceBufferSize)
 	}
 	return s
 }
@@ -62,9 +97,10 @@ func NewProxyStore(
 // Info returns store information about the external labels this store have.
 func (s *ProxyStore) Info(ctx context.Context, r *storepb.InfoRequest) (*storepb.InfoResponse, error) {
 	res := &storepb.InfoResponse{
-		MinTime: 0,
-		MaxTime: math.MaxInt64,
-		Labels:  make([]storepb.Label, 0, len(s.selectorLabels)),
+		MinTime:             0,
+		MaxTime:             math.MaxInt64,
+		Labels:              make([]storepb.Label, 0, len(s.selectorLabels)),
+		SupportsMatcherSets: true,
 	}
 	for _, l := range s.selectorLabels {
 		res.Labels = append(res.Labels, storepb.Label{
@@ -78,11 +114,11 @@ func (s *ProxyStore) Info(ctx context.Context, r *storepb.InfoRequest) (*storepb
 // Series returns all series for a requested time range and label matcher. Requested series are taken from other
 // stores and proxied to RPC client. NOTE: Resulted data are not trimmed exactly to min and max time range.
 func (s *ProxyStore) Series(r *storepb.SeriesRequest, srv storepb.Store_SeriesServer) error {
-	match, newMatchers, err := labelsMatches(s.selectorLabels, r.Matchers)
+	matcherSets, err := labelsMatchesSets(s.selectorLabels, r.AllMatcherSets())
 	if err != nil {
 		return status.Error(codes.InvalidArgument, err.Error())
 	}
-	if !match {
+	if len(matcherSets) == 0 {
 		return nil
 	}
 
@@ -93,45 +129,114 @@ func (s *ProxyStore) Series(r *storepb.SeriesRequest, srv storepb.Store_SeriesSe
 		return status.Errorf(codes.Unknown, err.Error())
 	}
 
+	if s.coalescer == nil {
+		return s.series(r, matcherSets, stores, srv)
+	}
+	key, err := seriesRequestKey(usage.ClientFromContext(srv.Context()), r, matcherSets, stores)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	return s.coalescer.series(key, srv, func(srv storepb.Store_SeriesServer) error {
+		return s.series(r, matcherSets, stores, srv)
+	})
+}
+
+func (s *ProxyStore) series(r *storepb.SeriesRequest, matcherSets [][]storepb.LabelMatcher, stores []Client, srv storepb.Store_SeriesServer) error {
 	var (
 		seriesSet []storepb.SeriesSet
 		respCh    = make(chan *storepb.SeriesResponse, len(stores)+1)
 		g         errgroup.Group
+		// Propagate the identity of the client, so the usage of stores can be accounted to it.
+		ctx = usage.OutgoingContext(srv.Context())
 	)
 
-	var storeDebugMsgs []string
+	var (
+		storeDebugMsgs []string
+		// Number of stores that were skipped because they do not hold the requested metric names.
+		namesSkipped int
+		failed       int
+	)
 
 	for _, st := range stores {
 		// We might be able to skip the store if its meta information indicates
-		// it cannot have series matching our query.
+		// it cannot have series matching any of the matcher sets of our query.
 		// NOTE: all matchers are validated in labelsMatches method so we explicitly ignore error.
-		if ok, _ := storeMatches(st, r.MinTime, r.MaxTime, newMatchers...); !ok {
+		var (
+			storeMatcherSets [][]storepb.LabelMatcher
+			nameMismatch     bool
+		)
+		for _, newMatchers := range matcherSets {
+			if ok, _ := storeMatches(st, r.MinTime, r.MaxTime, newMatchers...); !ok {
+				continue
+			}
+			if !storeHasMetricName(st, r.MaxTime, newMatchers...) {
+				nameMismatch = true
+				continue
+			}
+			storeMatcherSets = append(storeMatcherSets, newMatchers)
+		}
+		if len(storeMatcherSets) == 0 {
+			if nameMismatch {
+				namesSkipped++
+				s.skippedRequests.Inc()
+				storeDebugMsgs = append(storeDebugMsgs, fmt.Sprintf("store %s filtered out by metric name", st))
+				continue
+			}
 			storeDebugMsgs = append(storeDebugMsgs, fmt.Sprintf("store %s filtered out", st))
 			continue
 		}
 		storeDebugMsgs = append(storeDebugMsgs, fmt.Sprintf("store %s queried", st))
 
-		sc, err := st.Series(srv.Context(), &storepb.SeriesRequest{
-			MinTime:             r.MinTime,
-			MaxTime:             r.MaxTime,
-			Matchers:            newMatchers,
-			Aggregates:          r.Aggregates,
-			MaxResolutionWindow: r.MaxResolutionWindow,
-		})
-		if err != nil {
-			storeID := fmt.Sprintf("%v", st.Labels())
-			if storeID == "" {
-				storeID = "Store Gateway"
+		// Matchers of injected labels were checked against the store labels already and are unknown to the store.
+		storeMatcherSets = stripInjectedMatchers(storeMatcherSets, st.InjectedLabels())
+
+		// Stores that do not understand matcher sets would ignore them, so they get a request for each set.
+		reqMatcherSets := [][][]storepb.LabelMatcher{storeMatcherSets}
+		if len(storeMatcherSets) > 1 && !st.SupportsMatcherSets() {
+			reqMatcherSets = reqMatcherSets[:0]
+			for _, ms := range storeMatcherSets {
+				reqMatcherSets = append(reqMatcherSets, [][]storepb.LabelMatcher{ms})
 			}
-			err = errors.Wrapf(err, "fetch series for %s", storeID)
-			level.Error(s.logger).Log("err", err)
-			respCh <- storepb.NewWarnSeriesResponse(err)
-			continue
 		}
 
-		seriesSet = append(seriesSet, startStreamSeriesSet(sc, respCh, 10))
+		var storeSets []storepb.SeriesSet
+		for _, sets := range reqMatcherSets {
+			req := &storepb.SeriesRequest{
+				MinTime:             r.MinTime,
+				MaxTime:             r.MaxTime,
+				Aggregates:          r.Aggregates,
+				MaxResolutionWindow: r.MaxResolutionWindow,
+				ResolutionFallback:  r.ResolutionFallback,
+			}
+			req.SetMatcherSets(sets...)
+
+			sc, err := st.Series(ctx, req)
+			if err != nil {
+				storeID := fmt.Sprintf("%v", st.Labels())
+				if storeID == "" {
+					storeID = "Store Gateway"
+				}
+				err = errors.Wrapf(err, "fetch series for %s", storeID)
+				level.Error(s.logger).Log("err", err)
+				respCh <- storepb.NewWarnSeriesResponse(err)
+				break
+			}
+			storeSets = append(storeSets, startStreamSeriesSet(sc, respCh, 10, st.InjectedLabels()))
+		}
+		if len(storeSets) < len(reqMatcherSets) {
+			failed++
+		}
+		if len(storeSets) > 0 {
+			// Series matching several of the matcher sets are returned by each request, but must be returned once.
+			seriesSet = append(seriesSet, uniqueSeriesSets(storeSets...))
+		}
 	}
 	if len(seriesSet) == 0 {
+		if namesSkipped > 0 && failed == 0 {
+			// None of the stores holds the requested metrics, so the result is known to be empty.
+			level.Debug(s.logger).Log("msg", strings.Join(storeDebugMsgs, ";"))
+			return nil
+		}
 		err := errors.New("No store matched for this query")
 		level.Warn(s.logger).Log("err", err, "stores", strings.Join(storeDebugMsgs, ";"))
 		respCh <- storepb.NewWarnSeriesResponse(err)
@@ -169,8 +274,11 @@ func (s *ProxyStore) Series(r *storepb.SeriesRequest, srv storepb.Store_SeriesSe
 // streamSeriesSet iterates over incoming stream of series.
 // All errors are sent out of band via warning channel.
 type streamSeriesSet struct {
-	stream storepb.Store_SeriesClient
-	warnCh chan<- *storepb.SeriesResponse
+	stream   storepb.Store_SeriesClient
+	warnCh   chan<- *storepb.SeriesResponse
+	injected []storepb.Label
+	// Smallest name of the injected labels.
+	minInjected string
 
 	currSeries *storepb.Series
 	recvCh     chan *storepb.Series
@@ -180,11 +288,18 @@ func startStreamSeriesSet(
 	stream storepb.Store_SeriesClient,
 	warnCh chan<- *storepb.SeriesResponse,
 	bufferSize int,
+	injected []storepb.Label,
 ) *streamSeriesSet {
 	s := &streamSeriesSet{
-		stream: stream,
-		warnCh: warnCh,
-		recvCh: make(chan *storepb.Series, bufferSize),
+		stream:   stream,
+		warnCh:   warnCh,
+		injected: injected,
+		recvCh:   make(chan *storepb.Series, bufferSize),
+	}
+	for i, l := range injected {
+		if i == 0 || l.Name < s.minInjected {
+			s.minInjected = l.Name
+		}
 	}
 	go s.fetchLoop()
 	return s
@@ -192,6 +307,27 @@ func startStreamSeriesSet(
 
 func (s *streamSeriesSet) fetchLoop() {
 	defer close(s.recvCh)
+
+	// Injected labels sorting after all labels of the series keep the order of series, except that series
+	// now sort after the series extending their labels. Such series are held back until a series that does
+	// not extend them is received.
+	// Injected labels sorting before or replacing labels of a series may change the order of series and make
+	// different series equal. The remaining series of the store are thus only sent once all of them were
+	// received, sorted and merged.
+	var (
+		held     []heldSeries
+		buffered []*storepb.Series
+		buffer   bool
+	)
+	defer func() {
+		for i := len(held) - 1; i >= 0; i-- {
+			s.recvCh <- held[i].series
+		}
+		for _, series := range sortMergeSeries(buffered) {
+			s.recvCh <- series
+		}
+	}()
+
 	for {
 		r, err := s.stream.Recv()
 		if err == io.EOF {
@@ -206,8 +342,72 @@ func (s *streamSeriesSet) fetchLoop() {
 			s.warnCh <- storepb.NewWarnSeriesResponse(errors.New(w))
 			continue
 		}
-		s.recvCh <- r.GetSeries()
+		series := r.GetSeries()
+		if len(s.injected) == 0 {
+			s.recvCh <- series
+			continue
+		}
+
+		lset := series.Labels
+		series.Labels = InjectLabels(lset, s.injected)
+		if !buffer && (len(lset) == 0 || lset[len(lset)-1].Name < s.minInjected) {
+			for len(held) > 0 && !hasLabelsPrefix(lset, held[len(held)-1].lset) {
+				s.recvCh <- held[len(held)-1].series
+				held = held[:len(held)-1]
+			}
+			held = append(held, heldSeries{lset: lset, series: series})
+			continue
+		}
+		if !buffer {
+			buffer = true
+			for _, h := range held {
+				buffered = append(buffered, h.series)
+			}
+			held = nil
+		}
+		buffered = append(buffered, series)
+	}
+}
+
+// heldSeries is a series with injected labels and its labels as received from the store.
+type heldSeries struct {
+	lset   []storepb.Label
+	series *storepb.Series
+}
+
+// hasLabelsPrefix returns true if lset starts with all labels of prefix.
+func hasLabelsPrefix(lset, prefix []storepb.Label) bool {
+	if len(prefix) > len(lset) {
+		return false
+	}
+	for i, l := range prefix {
+		if lset[i] != l {
+			return false
+		}
+	}
+	return true
+}
+
+// sortMergeSeries sorts the series by their labels and merges series of equal labels into one.
+func sortMergeSeries(series []*storepb.Series) []*storepb.Series {
+	if len(series) == 0 {
+		return nil
+	}
+	sort.SliceStable(series, func(i, j int) bool {
+		return storepb.CompareLabels(series[i].Labels, series[j].Labels) < 0
+	})
+
+	res := series[:1]
+	for _, s := range series[1:] {
+		last := res[len(res)-1]
+		if storepb.CompareLabels(last.Labels, s.Labels) != 0 {
+			res = append(res, s)
+			continue
+		}
+		// Chunks of merged series may overlap and be out of order, like those of the merged series set.
+		last.Chunks = append(last.Chunks, s.Chunks...)
 	}
+	return res
 }
 
 // Next blocks until new message is received or stream is closed.
@@ -226,6 +426,69 @@ func (s *streamSeriesSet) Err() error {
 	return nil
 }
 
+// uniqueSeriesSets merges series sets of a single store. Series returned in several of the sets have the
+// same chunks, so only those of the first set are kept.
+func uniqueSeriesSets(all ...storepb.SeriesSet) storepb.SeriesSet {
+	if len(all) == 1 {
+		return all[0]
+	}
+	h := len(all) / 2
+	return newUniqueSeriesSet(uniqueSeriesSets(all[:h]...), uniqueSeriesSets(all[h:]...))
+}
+
+// uniqueSeriesSet merges two series sets, keeping the series of a if a series is contained in both.
+type uniqueSeriesSet struct {
+	a, b storepb.SeriesSet
+
+	lset         []storepb.Label
+	chunks       []storepb.AggrChunk
+	adone, bdone bool
+}
+
+func newUniqueSeriesSet(a, b storepb.SeriesSet) *uniqueSeriesSet {
+	s := &uniqueSeriesSet{a: a, b: b}
+	s.adone = !s.a.Next()
+	s.bdone = !s.b.Next()
+	return s
+}
+
+func (s *uniqueSeriesSet) Next() bool {
+	if s.adone && s.bdone || s.Err() != nil {
+		return false
+	}
+	d := -1
+	if s.adone {
+		d = 1
+	} else if !s.bdone {
+		lsetA, _ := s.a.At()
+		lsetB, _ := s.b.At()
+		d = storepb.CompareLabels(lsetA, lsetB)
+	}
+
+	if d > 0 {
+		s.lset, s.chunks = s.b.At()
+		s.bdone = !s.b.Next()
+		return true
+	}
+	s.lset, s.chunks = s.a.At()
+	s.adone = !s.a.Next()
+	if d == 0 {
+		s.bdone = !s.b.Next()
+	}
+	return true
+}
+
+func (s *uniqueSeriesSet) At() ([]storepb.Label, []storepb.AggrChunk) {
+	return s.lset, s.chunks
+}
+
+func (s *uniqueSeriesSet) Err() error {
+	if err := s.a.Err(); err != nil {
+		return err
+	}
+	return s.b.Err()
+}
+
 // matchStore returns true if the given store may hold data for the given label matchers.
 func storeMatches(s Client, mint, maxt int64, matchers ...storepb.LabelMatcher) (bool, error) {
 	storeMinTime, storeMaxTime := s.TimeRange()
@@ -251,6 +514,37 @@ func storeMatches(s Client, mint, maxt int64, matchers ...storepb.LabelMatcher)
 	return true, nil
 }
 
+// storeHasMetricName returns false if the store definitely holds no series of the metric name
+// the given label matchers select by equality up to maxt. Stores may have started writing series of
+// new names since their filter was refreshed, so the filter is only used if it covers maxt.
+func storeHasMetricName(s Client, maxt int64, matchers ...storepb.LabelMatcher) bool {
+	names := s.MetricNames()
+	if !names.Covers(maxt) {
+		return true
+	}
+	for _, m := range matchers {
+		if m.Name == promlabels.MetricName && m.Type == storepb.LabelMatcher_EQ && m.Value != "" {
+			return names.MayContain(m.Value)
+		}
+	}
+	return true
+}
+
+// MatchingStores returns the stores that may contain series matching the given matchers within the given time range.
+func MatchingStores(stores []Client, mint, maxt int64, matchers ...storepb.LabelMatcher) ([]Client, error) {
+	var res []Client
+	for _, st := range stores {
+		ok, err := storeMatches(st, mint, maxt, matchers...)
+		if err != nil {
+			return nil, err
+		}
+		if ok && storeHasMetricName(st, maxt, matchers...) {
+			res = append(res, st)
+		}
+	}
+	return res, nil
+}
+
 // LabelNames returns all known label names.
 func (s *ProxyStore) LabelNames(ctx context.Context, r *storepb.LabelNamesRequest) (
 	*storepb.LabelNamesResponse, error,
@@ -273,6 +567,13 @@ func (s *ProxyStore) LabelValues(ctx context.Context, r *storepb.LabelValuesRequ
 		return nil, status.Errorf(codes.Unknown, err.Error())
 	}
 	for _, st := range stores {
+		// Stores know nothing about the labels injected into their series.
+		if l, ok := injectedLabel(st.InjectedLabels(), r.Label); ok {
+			mtx.Lock()
+			all = append(all, []string{l.Value})
+			mtx.Unlock()
+			continue
+		}
 		wg.Add(1)
 		go func(s Client) {
 			defer wg.Done()
@@ -11,6 +11,7 @@ import (
 	"github.com/improbable-eng/thanos/pkg/store/storepb"
 	"github.com/improbable-eng/thanos/pkg/testutil"
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/prometheus/pkg/labels"
 	"github.com/prometheus/tsdb/chunkenc"
 	tlabels "github.com/prometheus/tsdb/labels"
@@ -23,9 +24,12 @@ type testClient struct {
 	// Just to pass interface check.
 	storepb.StoreClient
 
-	labels  []storepb.Label
-	minTime int64
-	maxTime int64
+	labels      []storepb.Label
+	minTime     int64
+	maxTime     int64
+	metricNames *storepb.MetricNameFilter
+	matcherSets bool
+	injected    []storepb.Label
 }
 
 func (c *testClient) Labels() []storepb.Label {
@@ -36,6 +40,22 @@ func (c *testClient) TimeRange() (int64, int64) {
 	return c.minTime, c.maxTime
 }
 
+func (c *testClient) MetricNames() *storepb.MetricNameFilter {
+	return c.metricNames
+}
+
+func (c *testClient) SupportsMatcherSets() bool {
+	return c.matcherSets
+}
+
+func (c *testClient) SupportsQuery() bool {
+	return false
+}
+
+func (c *testClient) InjectedLabels() []storepb.Label {
+	return c.injected
+}
+
 func (c *testClient) String() string {
 	return "test"
 }
@@ -93,9 +113,9 @@ func TestQueryStore_Series(t *testing.T) {
 			maxTime: 302,
 		},
 	}
-	q := NewProxyStore(nil,
+	q := NewProxyStore(nil, nil,
 		func(context.Context) ([]Client, error) { return cls, nil },
-		tlabels.FromStrings("fed", "a"),
+		tlabels.FromStrings("fed", "a"), 0,
 	)
 
 	ctx := context.Background()
@@ -170,9 +190,9 @@ func TestQueryStore_Series_SameExtSet(t *testing.T) {
 			maxTime: 300,
 		},
 	}
-	q := NewProxyStore(nil,
+	q := NewProxyStore(nil, nil,
 		func(context.Context) ([]Client, error) { return cls, nil },
-		nil,
+		nil, 0,
 	)
 
 	ctx := context.Background()
@@ -217,9 +237,9 @@ func TestQueryStore_Series_FillResponseChannel(t *testing.T) {
 		})
 	}
 
-	q := NewProxyStore(nil,
+	q := NewProxyStore(nil, nil,
 		func(context.Context) ([]Client, error) { return cls, nil },
-		tlabels.FromStrings("fed", "a"),
+		tlabels.FromStrings("fed", "a"), 0,
 	)
 
 	ctx := context.Background()
@@ -268,6 +288,312 @@ func seriesEqual(t *testing.T, expected []rawSeries, got []storepb.Series) {
 	}
 }
 
+func TestQueryStore_Series_MatcherSets(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	var (
+		a = &storeClient{RespSet: []*storepb.SeriesResponse{
+			storeSeriesResponse(t, labels.FromStrings("cluster", "a", "job", "x"), []sample{{1, 1}}),
+		}}
+		b = &storeClient{RespSet: []*storepb.SeriesResponse{
+			storeSeriesResponse(t, labels.FromStrings("cluster", "b", "job", "y"), []sample{{1, 1}}),
+		}}
+		c = &storeClient{}
+	)
+	cls := []Client{
+		&testClient{StoreClient: a, labels: []storepb.Label{{Name: "cluster", Value: "a"}}, maxTime: 300, matcherSets: true},
+		&testClient{StoreClient: b, labels: []storepb.Label{{Name: "cluster", Value: "b"}}, maxTime: 300, matcherSets: true},
+		&testClient{StoreClient: c, labels: []storepb.Label{{Name: "cluster", Value: "c"}}, maxTime: 300, matcherSets: true},
+	}
+	q := NewProxyStore(nil, nil,
+		func(context.Context) ([]Client, error) { return cls, nil },
+		nil, 0,
+	)
+
+	var (
+		setA  = []storepb.LabelMatcher{{Name: "cluster", Value: "a", Type: storepb.LabelMatcher_EQ}, {Name: "job", Value: "x", Type: storepb.LabelMatcher_EQ}}
+		setB  = []storepb.LabelMatcher{{Name: "cluster", Value: "b", Type: storepb.LabelMatcher_EQ}}
+		setAB = []storepb.LabelMatcher{{Name: "cluster", Value: "a|b", Type: storepb.LabelMatcher_RE}, {Name: "job", Value: "y", Type: storepb.LabelMatcher_EQ}}
+	)
+	srv := newStoreSeriesServer(context.Background())
+	err := q.Series(&storepb.SeriesRequest{
+		MinTime:     1,
+		MaxTime:     300,
+		MatcherSets: []storepb.LabelMatchers{{Matchers: setA}, {Matchers: setB}, {Matchers: setAB}},
+	}, srv)
+	testutil.Ok(t, err)
+
+	seriesEqual(t, []rawSeries{
+		{lset: []storepb.Label{{Name: "cluster", Value: "a"}, {Name: "job", Value: "x"}}, samples: []sample{{1, 1}}},
+		{lset: []storepb.Label{{Name: "cluster", Value: "b"}, {Name: "job", Value: "y"}}, samples: []sample{{1, 1}}},
+	}, srv.SeriesSet)
+
+	// Every store is only asked for the matcher sets it may have series for.
+	testutil.Equals(t, []*storepb.SeriesRequest{{
+		MinTime:     1,
+		MaxTime:     300,
+		MatcherSets: []storepb.LabelMatchers{{Matchers: setA}, {Matchers: setAB}},
+	}}, a.Reqs)
+	testutil.Equals(t, []*storepb.SeriesRequest{{
+		MinTime:     1,
+		MaxTime:     300,
+		MatcherSets: []storepb.LabelMatchers{{Matchers: setB}, {Matchers: setAB}},
+	}}, b.Reqs)
+	testutil.Equals(t, 0, len(c.Reqs))
+}
+
+func TestQueryStore_Series_MatcherSetsUnsupported(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	// The store ignores the matchers and returns the same series for every request.
+	a := &storeClient{RespSet: []*storepb.SeriesResponse{
+		storeSeriesResponse(t, labels.FromStrings("job", "x"), []sample{{1, 1}}),
+		storeSeriesResponse(t, labels.FromStrings("job", "y"), []sample{{1, 2}}),
+	}}
+	cls := []Client{&testClient{StoreClient: a, maxTime: 300}}
+	q := NewProxyStore(nil, nil,
+		func(context.Context) ([]Client, error) { return cls, nil },
+		nil, 0,
+	)
+
+	var (
+		setX = []storepb.LabelMatcher{{Name: "job", Value: "x", Type: storepb.LabelMatcher_EQ}}
+		setY = []storepb.LabelMatcher{{Name: "job", Value: "y", Type: storepb.LabelMatcher_EQ}}
+		setZ = []storepb.LabelMatcher{{Name: "job", Value: "z", Type: storepb.LabelMatcher_EQ}}
+	)
+	srv := newStoreSeriesServer(context.Background())
+	testutil.Ok(t, q.Series(&storepb.SeriesRequest{
+		MinTime:     1,
+		MaxTime:     300,
+		MatcherSets: []storepb.LabelMatchers{{Matchers: setX}, {Matchers: setY}, {Matchers: setZ}},
+	}, srv))
+
+	// Series returned for several matcher sets are returned once.
+	seriesEqual(t, []rawSeries{
+		{lset: []storepb.Label{{Name: "job", Value: "x"}}, samples: []sample{{1, 1}}},
+		{lset: []storepb.Label{{Name: "job", Value: "y"}}, samples: []sample{{1, 2}}},
+	}, srv.SeriesSet)
+	testutil.Equals(t, []*storepb.SeriesRequest{
+		{MinTime: 1, MaxTime: 300, Matchers: setX},
+		{MinTime: 1, MaxTime: 300, Matchers: setY},
+		{MinTime: 1, MaxTime: 300, Matchers: setZ},
+	}, a.Reqs)
+}
+
+func TestQueryStore_Series_MetricNames(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	var (
+		a = &storeClient{RespSet: []*storepb.SeriesResponse{
+			storeSeriesResponse(t, labels.FromStrings("__name__", "up", "cluster", "a"), []sample{{1, 1}}),
+		}}
+		b = &storeClient{}
+		c = &storeClient{RespSet: []*storepb.SeriesResponse{
+			storeSeriesResponse(t, labels.FromStrings("__name__", "up", "cluster", "c"), []sample{{1, 1}}),
+		}}
+	)
+	cls := []Client{
+		&testClient{StoreClient: a, maxTime: 300, metricNames: storepb.NewMetricNameFilter([]string{"up"}, 300)},
+		&testClient{StoreClient: b, maxTime: 300, metricNames: storepb.NewMetricNameFilter([]string{"down"}, 300)},
+		// Stores without a filter may hold any metric.
+		&testClient{StoreClient: c, maxTime: 300, matcherSets: true},
+	}
+	reg := prometheus.NewRegistry()
+	q := NewProxyStore(nil, reg,
+		func(context.Context) ([]Client, error) { return cls, nil },
+		nil, 0,
+	)
+
+	var (
+		upMatchers    = []storepb.LabelMatcher{{Name: "__name__", Value: "up", Type: storepb.LabelMatcher_EQ}}
+		otherMatchers = []storepb.LabelMatcher{{Name: "__name__", Value: "other", Type: storepb.LabelMatcher_EQ}}
+	)
+	srv := newStoreSeriesServer(context.Background())
+	testutil.Ok(t, q.Series(&storepb.SeriesRequest{MinTime: 1, MaxTime: 300, Matchers: upMatchers}, srv))
+	seriesEqual(t, []rawSeries{
+		{lset: []storepb.Label{{Name: "__name__", Value: "up"}, {Name: "cluster", Value: "a"}}, samples: []sample{{1, 1}}},
+		{lset: []storepb.Label{{Name: "__name__", Value: "up"}, {Name: "cluster", Value: "c"}}, samples: []sample{{1, 1}}},
+	}, srv.SeriesSet)
+	testutil.Equals(t, 1, len(a.Reqs))
+	testutil.Equals(t, 0, len(b.Reqs))
+	testutil.Equals(t, 1, len(c.Reqs))
+
+	// Matcher sets selecting other metrics are only sent to the stores that may hold them.
+	srv = newStoreSeriesServer(context.Background())
+	testutil.Ok(t, q.Series(&storepb.SeriesRequest{
+		MinTime:     1,
+		MaxTime:     300,
+		MatcherSets: []storepb.LabelMatchers{{Matchers: upMatchers}, {Matchers: otherMatchers}},
+	}, srv))
+	testutil.Equals(t, &storepb.SeriesRequest{MinTime: 1, MaxTime: 300, Matchers: upMatchers}, a.Reqs[1])
+	testutil.Equals(t, 0, len(b.Reqs))
+	testutil.Equals(t, &storepb.SeriesRequest{
+		MinTime:     1,
+		MaxTime:     300,
+		MatcherSets: []storepb.LabelMatchers{{Matchers: upMatchers}, {Matchers: otherMatchers}},
+	}, c.Reqs[1])
+
+	// No warning is returned if no store holds the metric.
+	cls = cls[:2]
+	srv = newStoreSeriesServer(context.Background())
+	testutil.Ok(t, q.Series(&storepb.SeriesRequest{MinTime: 1, MaxTime: 300, Matchers: otherMatchers}, srv))
+	testutil.Equals(t, 0, len(srv.SeriesSet))
+	testutil.Equals(t, 0, len(srv.Warnings))
+	testutil.Equals(t, 2, len(a.Reqs))
+
+	// Stores may have started writing other metrics after the time their filters cover.
+	srv = newStoreSeriesServer(context.Background())
+	testutil.Ok(t, q.Series(&storepb.SeriesRequest{MinTime: 1, MaxTime: 301, Matchers: otherMatchers}, srv))
+	testutil.Equals(t, 3, len(a.Reqs))
+	testutil.Equals(t, 1, len(b.Reqs))
+
+	mfs, err := reg.Gather()
+	testutil.Ok(t, err)
+	testutil.Equals(t, 1, len(mfs))
+	// Store b was skipped three times, store a once.
+	testutil.Equals(t, 4.0, mfs[0].GetMetric()[0].GetCounter().GetValue())
+}
+
+func TestQueryStore_Series_InjectedLabels(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	var (
+		a = &storeClient{RespSet: []*storepb.SeriesResponse{
+			storeSeriesResponse(t, labels.FromStrings("__name__", "up", "cluster", "a"), []sample{{1, 1}}),
+		}}
+		// Partner store with the same external labels.
+		b = &storeClient{
+			RespSet: []*storepb.SeriesResponse{
+				storeSeriesResponse(t, labels.FromStrings("__name__", "up", "cluster", "a"), []sample{{1, 2}}),
+			},
+			Values: map[string][]string{"cluster": {"a"}, "team": {"other"}},
+		}
+		injected = []storepb.Label{{Name: "cluster", Value: "b"}, {Name: "team", Value: "partner"}}
+	)
+	cls := []Client{
+		&testClient{StoreClient: a, maxTime: 300, labels: []storepb.Label{{Name: "cluster", Value: "a"}}},
+		&testClient{StoreClient: b, maxTime: 300, labels: InjectLabels([]storepb.Label{{Name: "cluster", Value: "a"}}, injected), injected: injected},
+	}
+	q := NewProxyStore(nil, nil,
+		func(context.Context) ([]Client, error) { return cls, nil },
+		nil, 0,
+	)
+
+	srv := newStoreSeriesServer(context.Background())
+	testutil.Ok(t, q.Series(&storepb.SeriesRequest{
+		MinTime:  1,
+		MaxTime:  300,
+		Matchers: []storepb.LabelMatcher{{Name: "__name__", Value: "up", Type: storepb.LabelMatcher_EQ}},
+	}, srv))
+	seriesEqual(t, []rawSeries{
+		{lset: []storepb.Label{{Name: "__name__", Value: "up"}, {Name: "cluster", Value: "a"}}, samples: []sample{{1, 1}}},
+		{lset: []storepb.Label{{Name: "__name__", Value: "up"}, {Name: "cluster", Value: "b"}, {Name: "team", Value: "partner"}}, samples: []sample{{1, 2}}},
+	}, srv.SeriesSet)
+
+	// Matchers of injected labels select the store by its effective labels and are not sent to it.
+	srv = newStoreSeriesServer(context.Background())
+	testutil.Ok(t, q.Series(&storepb.SeriesRequest{
+		MinTime: 1,
+		MaxTime: 300,
+		Matchers: []storepb.LabelMatcher{
+			{Name: "__name__", Value: "up", Type: storepb.LabelMatcher_EQ},
+			{Name: "cluster", Value: "b", Type: storepb.LabelMatcher_EQ},
+		},
+	}, srv))
+	testutil.Equals(t, 1, len(srv.SeriesSet))
+	testutil.Equals(t, 1, len(a.Reqs))
+	testutil.Equals(t, []storepb.LabelMatcher{{Name: "__name__", Value: "up", Type: storepb.LabelMatcher_EQ}}, b.Reqs[1].Matchers)
+
+	srv = newStoreSeriesServer(context.Background())
+	testutil.Ok(t, q.Series(&storepb.SeriesRequest{
+		MinTime:  1,
+		MaxTime:  300,
+		Matchers: []storepb.LabelMatcher{{Name: "team", Value: "partner", Type: storepb.LabelMatcher_EQ}},
+	}, srv))
+	// Other stores still receive the matchers.
+	testutil.Equals(t, []storepb.LabelMatcher{{Name: "team", Value: "partner", Type: storepb.LabelMatcher_EQ}}, a.Reqs[1].Matchers)
+	testutil.Equals(t, []storepb.LabelMatcher{{Name: "__name__", Value: ".+", Type: storepb.LabelMatcher_RE}}, b.Reqs[2].Matchers)
+
+	// Values of injected labels are not requested from the store.
+	resp, err := q.LabelValues(context.Background(), &storepb.LabelValuesRequest{Label: "team"})
+	testutil.Ok(t, err)
+	testutil.Equals(t, []string{"partner"}, resp.Values)
+}
+
+func TestQueryStore_Series_InjectedLabelsOrder(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	// Replacing the differing label makes both series equal, adding a label changes their order.
+	a := &storeClient{RespSet: []*storepb.SeriesResponse{
+		storeSeriesResponse(t, labels.FromStrings("a", "1"), []sample{{1, 1}}),
+		storeSeriesResponse(t, labels.FromStrings("a", "1", "b", "1", "replica", "x"), []sample{{1, 1}}),
+		storeSeriesResponse(t, labels.FromStrings("a", "1", "b", "1", "replica", "y"), []sample{{2, 2}}),
+	}}
+	injected := []storepb.Label{{Name: "c", Value: "1"}, {Name: "replica", Value: "z"}}
+	cls := []Client{&testClient{StoreClient: a, maxTime: 300, labels: injected, injected: injected}}
+	q := NewProxyStore(nil, nil,
+		func(context.Context) ([]Client, error) { return cls, nil },
+		nil, 0,
+	)
+
+	srv := newStoreSeriesServer(context.Background())
+	testutil.Ok(t, q.Series(&storepb.SeriesRequest{
+		MinTime:  1,
+		MaxTime:  300,
+		Matchers: []storepb.LabelMatcher{{Name: "a", Value: "1", Type: storepb.LabelMatcher_EQ}},
+	}, srv))
+	seriesEqual(t, []rawSeries{
+		{lset: []storepb.Label{{Name: "a", Value: "1"}, {Name: "b", Value: "1"}, {Name: "c", Value: "1"}, {Name: "replica", Value: "z"}}, samples: []sample{{1, 1}, {2, 2}}},
+		{lset: []storepb.Label{{Name: "a", Value: "1"}, {Name: "c", Value: "1"}, {Name: "replica", Value: "z"}}, samples: []sample{{1, 1}}},
+	}, srv.SeriesSet)
+}
+
+// blockingSeriesClient blocks the end of the stream until release is closed.
+type blockingSeriesClient struct {
+	StoreSeriesClient
+	release chan struct{}
+}
+
+func (c *blockingSeriesClient) Recv() (*storepb.SeriesResponse, error) {
+	if c.i >= len(c.respSet) {
+		<-c.release
+	}
+	return c.StoreSeriesClient.Recv()
+}
+
+func TestStreamSeriesSet_InjectedLabelsStreamed(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	// Series of labels sorting before the injected labels are sent before the stream ends, series extended
+	// by the following series after them.
+	cl := &blockingSeriesClient{
+		StoreSeriesClient: StoreSeriesClient{ctx: context.Background(), respSet: []*storepb.SeriesResponse{
+			storeSeriesResponse(t, labels.FromStrings("a", "1"), []sample{{1, 1}}),
+			storeSeriesResponse(t, labels.FromStrings("a", "1", "b", "1"), []sample{{1, 1}}),
+			storeSeriesResponse(t, labels.FromStrings("a", "2"), []sample{{1, 1}}),
+		}},
+		release: make(chan struct{}),
+	}
+	set := startStreamSeriesSet(cl, make(chan *storepb.SeriesResponse, 1), 10, []storepb.Label{{Name: "replica", Value: "x"}, {Name: "c", Value: "1"}})
+
+	var got [][]storepb.Label
+	for i := 0; i < 2; i++ {
+		testutil.Assert(t, set.Next(), "expected series before the end of the stream")
+		lset, _ := set.At()
+		got = append(got, lset)
+	}
+	close(cl.release)
+	for set.Next() {
+		lset, _ := set.At()
+		got = append(got, lset)
+	}
+	testutil.Equals(t, [][]storepb.Label{
+		{{Name: "a", Value: "1"}, {Name: "b", Value: "1"}, {Name: "c", Value: "1"}, {Name: "replica", Value: "x"}},
+		{{Name: "a", Value: "1"}, {Name: "c", Value: "1"}, {Name: "replica", Value: "x"}},
+		{{Name: "a", Value: "2"}, {Name: "c", Value: "1"}, {Name: "replica", Value: "x"}},
+	}, got)
+}
+
 func TestStoreMatches(t *testing.T) {
 	defer leaktest.CheckTimeout(t, 10*time.Second)()
 
@@ -382,6 +708,8 @@ type storeClient struct {
 
 	RespSet   []*storepb.SeriesResponse
 	RespError error
+
+	Reqs []*storepb.SeriesRequest
 }
 
 func (s *storeClient) Info(ctx context.Context, req *storepb.InfoRequest, _ ...grpc.CallOption) (*storepb.InfoResponse, error) {
@@ -389,6 +717,7 @@ func (s *storeClient) Info(ctx context.Context, req *storepb.InfoRequest, _ ...g
 }
 
 func (s *storeClient) Series(ctx context.Context, req *storepb.SeriesRequest, _ ...grpc.CallOption) (storepb.Store_SeriesClient, error) {
+	s.Reqs = append(s.Reqs, req)
 	return &StoreSeriesClient{ctx: ctx, respSet: s.RespSet}, s.RespError
 }
 
@@ -20,6 +20,32 @@ func NewSeriesResponse(series *Series) *SeriesResponse {
 	}
 }
 
+// AllMatcherSets returns all OR-ed matcher sets of the request. The matchers form a set of their own
+// if they are given or if there are no matcher sets.
+func (m *SeriesRequest) AllMatcherSets() [][]LabelMatcher {
+	res := make([][]LabelMatcher, 0, len(m.MatcherSets)+1)
+	if len(m.Matchers) > 0 || len(m.MatcherSets) == 0 {
+		res = append(res, m.Matchers)
+	}
+	for _, s := range m.MatcherSets {
+		res = append(res, s.Matchers)
+	}
+	return res
+}
+
+// SetMatcherSets sets the OR-ed matcher sets of the request. A single set is set as the matchers
+// of the request, so that it is understood by stores that do not support matcher sets.
+func (m *SeriesRequest) SetMatcherSets(sets ...[]LabelMatcher) {
+	m.Matchers, m.MatcherSets = nil, nil
+	if len(sets) == 1 {
+		m.Matchers = sets[0]
+		return
+	}
+	for _, s := range sets {
+		m.MatcherSets = append(m.MatcherSets, LabelMatchers{Matchers: s})
+	}
+}
+
 // CompareLabels compares two sets of labels.
 func CompareLabels(a, b []Label) int {
 	l := len(a)
@@ -0,0 +1,117 @@
+package storepb
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+)
+
+const (
+	// metricNameFilterBitsPerName and metricNameFilterHashes give a false positive rate of about 1%.
+	metricNameFilterBitsPerName = 10
+	metricNameFilterHashes      = 7
+)
+
+// NewMetricNameFilter returns a filter sized for the given metric names that contains all of them. The names must
+// include those of all series with samples up to maxTime.
+func NewMetricNameFilter(names []string, maxTime int64) *MetricNameFilter {
+	n := len(names)
+	if n == 0 {
+		n = 1
+	}
+	f := &MetricNameFilter{
+		Bits:    make([]byte, (n*metricNameFilterBitsPerName+7)/8),
+		Hashes:  metricNameFilterHashes,
+		MaxTime: maxTime,
+	}
+	f.Add(names...)
+	return f
+}
+
+// Add adds the given metric names to the filter. The false positive rate grows
+// as more names are added than the filter was sized for.
+func (m *MetricNameFilter) Add(names ...string) {
+	if len(m.Bits) == 0 {
+		return
+	}
+	for _, name := range names {
+		h1, h2 := metricNameHashes(name)
+		for i := uint32(0); i < m.Hashes; i++ {
+			bit := (h1 + uint64(i)*h2) % uint64(len(m.Bits)*8)
+			m.Bits[bit/8] |= 1 << (bit % 8)
+		}
+	}
+}
+
+// MayContain returns false if the metric name was definitely not added to the filter.
+// A nil or empty filter may contain any name.
+func (m *MetricNameFilter) MayContain(name string) bool {
+	if m == nil || len(m.Bits) == 0 {
+		return true
+	}
+	h1, h2 := metricNameHashes(name)
+	for i := uint32(0); i < m.Hashes; i++ {
+		bit := (h1 + uint64(i)*h2) % uint64(len(m.Bits)*8)
+		if m.Bits[bit/8]&(1<<(bit%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Covers returns true if the filter may be used for requests of data up to maxt. Stores may hold series of names
+// the filter does not contain after its max time. A nil filter covers no data.
+func (m *MetricNameFilter) Covers(maxt int64) bool {
+	return m != nil && maxt <= m.MaxTime
+}
+
+// Checksum returns the checksum of the filter that clients send to learn whether it changed.
+// It is zero for a nil filter.
+func (m *MetricNameFilter) Checksum() uint64 {
+	if m == nil {
+		return 0
+	}
+	var b [12]byte
+	binary.BigEndian.PutUint32(b[:4], m.Hashes)
+	binary.BigEndian.PutUint64(b[4:], uint64(m.MaxTime))
+
+	h := fnv.New64a()
+	_, _ = h.Write(m.Bits)
+	_, _ = h.Write(b[:])
+	if sum := h.Sum64(); sum != 0 {
+		return sum
+	}
+	return 1
+}
+
+// SetMetricNames sets the metric name filter of the response. It is left out if it matches the checksum
+// of the filter the client holds.
+func (r *InfoResponse) SetMetricNames(f *MetricNameFilter, req *InfoRequest) {
+	if f != nil && req != nil && req.MetricNamesChecksum != 0 && req.MetricNamesChecksum == f.Checksum() {
+		r.MetricNamesUnchanged = true
+		return
+	}
+	r.MetricNames = f
+}
+
+// Clone returns a deep copy of the filter.
+func (m *MetricNameFilter) Clone() *MetricNameFilter {
+	if m == nil {
+		return nil
+	}
+	return &MetricNameFilter{
+		Bits:    append([]byte(nil), m.Bits...),
+		Hashes:  m.Hashes,
+		MaxTime: m.MaxTime,
+	}
+}
+
+// metricNameHashes returns the two hashes of the name from which the bit positions are
+// derived through double hashing.
+func metricNameHashes(name string) (uint64, uint64) {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(name))
+	sum := h.Sum64()
+	// The second hash must be odd, so that it does not repeat positions for filters of
+	// power of two sizes.
+	return sum, (sum>>32 | sum<<32) | 1
+}
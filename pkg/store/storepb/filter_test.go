@@ -0,0 +1,67 @@
+package storepb
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/improbable-eng/thanos/pkg/testutil"
+)
+
+func TestMetricNameFilter(t *testing.T) {
+	var names []string
+	for i := 0; i < 1000; i++ {
+		names = append(names, fmt.Sprintf("metric_%d", i))
+	}
+	f := NewMetricNameFilter(names[:500], 100)
+
+	// Filters are sent between stores and queriers.
+	b, err := f.Marshal()
+	testutil.Ok(t, err)
+	var decoded MetricNameFilter
+	testutil.Ok(t, decoded.Unmarshal(b))
+	decoded.Add(names[500:900]...)
+
+	for _, n := range names[:900] {
+		testutil.Assert(t, decoded.MayContain(n), "added name %s not contained", n)
+	}
+	falsePositives := 0
+	for _, n := range names[900:] {
+		if decoded.MayContain(n) {
+			falsePositives++
+		}
+	}
+	// Adding more names than the filter was sized for raises the false positive rate.
+	testutil.Assert(t, falsePositives < 50, "too many false positives: %d", falsePositives)
+
+	var nilFilter *MetricNameFilter
+	testutil.Assert(t, nilFilter.MayContain("metric_1"), "nil filter must contain all names")
+	testutil.Assert(t, (&MetricNameFilter{}).MayContain("metric_1"), "empty filter must contain all names")
+	testutil.Assert(t, !NewMetricNameFilter(nil, 100).MayContain("metric_1"), "filter of no names contains name")
+
+	// Filters are only used for data up to their max time.
+	testutil.Equals(t, int64(100), decoded.MaxTime)
+	testutil.Assert(t, decoded.Covers(100), "filter must cover its max time")
+	testutil.Assert(t, !decoded.Covers(101), "filter must not cover data after its max time")
+	testutil.Assert(t, !nilFilter.Covers(100), "nil filter must not cover any data")
+}
+
+func TestInfoResponse_SetMetricNames(t *testing.T) {
+	f := NewMetricNameFilter([]string{"up"}, 100)
+
+	// Clients without a filter get the filter.
+	var res InfoResponse
+	res.SetMetricNames(f, &InfoRequest{})
+	testutil.Equals(t, &InfoResponse{MetricNames: f}, &res)
+
+	// Clients holding the same filter do not get it again.
+	res = InfoResponse{}
+	res.SetMetricNames(f, &InfoRequest{MetricNamesChecksum: f.Clone().Checksum()})
+	testutil.Equals(t, InfoResponse{MetricNamesUnchanged: true}, res)
+
+	// Clients holding a filter of other names or of another max time get the filter.
+	for _, other := range []*MetricNameFilter{NewMetricNameFilter([]string{"down"}, 100), NewMetricNameFilter([]string{"up"}, 200)} {
+		res = InfoResponse{}
+		res.SetMetricNames(f, &InfoRequest{MetricNamesChecksum: other.Checksum()})
+		testutil.Equals(t, InfoResponse{MetricNames: f}, res)
+	}
+}
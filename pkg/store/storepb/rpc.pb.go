@@ -11,12 +11,18 @@
 	It has these top-level messages:
 		InfoRequest
 		InfoResponse
+		MetricNameFilter
 		SeriesRequest
+		LabelMatchers
 		SeriesResponse
 		LabelNamesRequest
 		LabelNamesResponse
 		LabelValuesRequest
 		LabelValuesResponse
+		QueryRequest
+		QueryResponse
+		QuerySeries
+		Sample
 		Label
 		Chunk
 		Series
@@ -33,6 +39,8 @@ import _ "github.com/gogo/protobuf/gogoproto"
 import context "golang.org/x/net/context"
 import grpc "google.golang.org/grpc"
 
+import binary "encoding/binary"
+
 import io "io"
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -80,6 +88,9 @@ func (x Aggr) String() string {
 func (Aggr) EnumDescriptor() ([]byte, []int) { return fileDescriptorRpc, []int{0} }
 
 type InfoRequest struct {
+	// / metric_names_checksum is the checksum of the metric name filter the client holds, if any.
+	// / Stores leave out the filter from the response if it did not change.
+	MetricNamesChecksum uint64 `protobuf:"varint,1,opt,name=metric_names_checksum,json=metricNamesChecksum,proto3" json:"metric_names_checksum,omitempty"`
 }
 
 func (m *InfoRequest) Reset()                    { *m = InfoRequest{} }
@@ -91,6 +102,18 @@ type InfoResponse struct {
 	Labels  []Label `protobuf:"bytes,1,rep,name=labels" json:"labels"`
 	MinTime int64   `protobuf:"varint,2,opt,name=min_time,json=minTime,proto3" json:"min_time,omitempty"`
 	MaxTime int64   `protobuf:"varint,3,opt,name=max_time,json=maxTime,proto3" json:"max_time,omitempty"`
+	// / metric_names summarizes the metric names of the series the store holds. Stores that do not
+	// / advertise it may hold series of any metric name.
+	MetricNames *MetricNameFilter `protobuf:"bytes,4,opt,name=metric_names,json=metricNames" json:"metric_names,omitempty"`
+	// / supports_matcher_sets is true if the store understands the matcher_sets of series requests. Stores that do not
+	// / advertise it must be sent a separate request for each matcher set.
+	SupportsMatcherSets bool `protobuf:"varint,5,opt,name=supports_matcher_sets,json=supportsMatcherSets,proto3" json:"supports_matcher_sets,omitempty"`
+	// / metric_names_unchanged is true if metric_names is left out because the filter of the store still matches
+	// / the metric_names_checksum of the request.
+	MetricNamesUnchanged bool `protobuf:"varint,6,opt,name=metric_names_unchanged,json=metricNamesUnchanged,proto3" json:"metric_names_unchanged,omitempty"`
+	// / supports_query is true if the store evaluates PromQL queries through the Query service. Stores that do not
+	// / advertise it respond with Unimplemented.
+	SupportsQuery bool `protobuf:"varint,7,opt,name=supports_query,json=supportsQuery,proto3" json:"supports_query,omitempty"`
 }
 
 func (m *InfoResponse) Reset()                    { *m = InfoResponse{} }
@@ -98,18 +121,47 @@ func (m *InfoResponse) String() string            { return proto.CompactTextStri
 func (*InfoResponse) ProtoMessage()               {}
 func (*InfoResponse) Descriptor() ([]byte, []int) { return fileDescriptorRpc, []int{1} }
 
+// / MetricNameFilter is a bloom filter of metric names. It may report names the store does not hold,
+// / but never misses a name of series the store holds samples of up to max_time. Stores may start writing
+// / series of new names after max_time at any time, so requests for later data must not be filtered by it.
+type MetricNameFilter struct {
+	Bits    []byte `protobuf:"bytes,1,opt,name=bits,proto3" json:"bits,omitempty"`
+	Hashes  uint32 `protobuf:"varint,2,opt,name=hashes,proto3" json:"hashes,omitempty"`
+	MaxTime int64  `protobuf:"varint,3,opt,name=max_time,json=maxTime,proto3" json:"max_time,omitempty"`
+}
+
+func (m *MetricNameFilter) Reset()                    { *m = MetricNameFilter{} }
+func (m *MetricNameFilter) String() string            { return proto.CompactTextString(m) }
+func (*MetricNameFilter) ProtoMessage()               {}
+func (*MetricNameFilter) Descriptor() ([]byte, []int) { return fileDescriptorRpc, []int{2} }
+
 type SeriesRequest struct {
 	MinTime             int64          `protobuf:"varint,1,opt,name=min_time,json=minTime,proto3" json:"min_time,omitempty"`
 	MaxTime             int64          `protobuf:"varint,2,opt,name=max_time,json=maxTime,proto3" json:"max_time,omitempty"`
 	Matchers            []LabelMatcher `protobuf:"bytes,3,rep,name=matchers" json:"matchers"`
 	MaxResolutionWindow int64          `protobuf:"varint,4,opt,name=max_resolution_window,json=maxResolutionWindow,proto3" json:"max_resolution_window,omitempty"`
 	Aggregates          []Aggr         `protobuf:"varint,5,rep,packed,name=aggregates,enum=thanos.Aggr" json:"aggregates,omitempty"`
+	// / matcher_sets are additional sets of matchers. Series matching any of the sets, or the matchers if given, are returned.
+	// / It allows stores to select series of many selectors at once.
+	MatcherSets []LabelMatchers `protobuf:"bytes,6,rep,name=matcher_sets,json=matcherSets" json:"matcher_sets"`
+	// / resolution_fallback allows stores to fill time ranges without data of max_resolution_window or a finer
+	// / resolution with data of coarser resolutions. Stores return a warning with the ranges served that way.
+	ResolutionFallback bool `protobuf:"varint,7,opt,name=resolution_fallback,json=resolutionFallback,proto3" json:"resolution_fallback,omitempty"`
 }
 
 func (m *SeriesRequest) Reset()                    { *m = SeriesRequest{} }
 func (m *SeriesRequest) String() string            { return proto.CompactTextString(m) }
 func (*SeriesRequest) ProtoMessage()               {}
-func (*SeriesRequest) Descriptor() ([]byte, []int) { return fileDescriptorRpc, []int{2} }
+func (*SeriesRequest) Descriptor() ([]byte, []int) { return fileDescriptorRpc, []int{3} }
+
+type LabelMatchers struct {
+	Matchers []LabelMatcher `protobuf:"bytes,1,rep,name=matchers" json:"matchers"`
+}
+
+func (m *LabelMatchers) Reset()                    { *m = LabelMatchers{} }
+func (m *LabelMatchers) String() string            { return proto.CompactTextString(m) }
+func (*LabelMatchers) ProtoMessage()               {}
+func (*LabelMatchers) Descriptor() ([]byte, []int) { return fileDescriptorRpc, []int{4} }
 
 type SeriesResponse struct {
 	// Types that are valid to be assigned to Result:
@@ -121,7 +173,7 @@ type SeriesResponse struct {
 func (m *SeriesResponse) Reset()                    { *m = SeriesResponse{} }
 func (m *SeriesResponse) String() string            { return proto.CompactTextString(m) }
 func (*SeriesResponse) ProtoMessage()               {}
-func (*SeriesResponse) Descriptor() ([]byte, []int) { return fileDescriptorRpc, []int{3} }
+func (*SeriesResponse) Descriptor() ([]byte, []int) { return fileDescriptorRpc, []int{5} }
 
 type isSeriesResponse_Result interface {
 	isSeriesResponse_Result()
@@ -236,7 +288,7 @@ type LabelNamesRequest struct {
 func (m *LabelNamesRequest) Reset()                    { *m = LabelNamesRequest{} }
 func (m *LabelNamesRequest) String() string            { return proto.CompactTextString(m) }
 func (*LabelNamesRequest) ProtoMessage()               {}
-func (*LabelNamesRequest) Descriptor() ([]byte, []int) { return fileDescriptorRpc, []int{4} }
+func (*LabelNamesRequest) Descriptor() ([]byte, []int) { return fileDescriptorRpc, []int{6} }
 
 type LabelNamesResponse struct {
 	Names    []string `protobuf:"bytes,1,rep,name=names" json:"names,omitempty"`
@@ -246,7 +298,7 @@ type LabelNamesResponse struct {
 func (m *LabelNamesResponse) Reset()                    { *m = LabelNamesResponse{} }
 func (m *LabelNamesResponse) String() string            { return proto.CompactTextString(m) }
 func (*LabelNamesResponse) ProtoMessage()               {}
-func (*LabelNamesResponse) Descriptor() ([]byte, []int) { return fileDescriptorRpc, []int{5} }
+func (*LabelNamesResponse) Descriptor() ([]byte, []int) { return fileDescriptorRpc, []int{7} }
 
 type LabelValuesRequest struct {
 	Label string `protobuf:"bytes,1,opt,name=label,proto3" json:"label,omitempty"`
@@ -255,7 +307,7 @@ type LabelValuesRequest struct {
 func (m *LabelValuesRequest) Reset()                    { *m = LabelValuesRequest{} }
 func (m *LabelValuesRequest) String() string            { return proto.CompactTextString(m) }
 func (*LabelValuesRequest) ProtoMessage()               {}
-func (*LabelValuesRequest) Descriptor() ([]byte, []int) { return fileDescriptorRpc, []int{6} }
+func (*LabelValuesRequest) Descriptor() ([]byte, []int) { return fileDescriptorRpc, []int{8} }
 
 type LabelValuesResponse struct {
 	Values   []string `protobuf:"bytes,1,rep,name=values" json:"values,omitempty"`
@@ -265,17 +317,67 @@ type LabelValuesResponse struct {
 func (m *LabelValuesResponse) Reset()                    { *m = LabelValuesResponse{} }
 func (m *LabelValuesResponse) String() string            { return proto.CompactTextString(m) }
 func (*LabelValuesResponse) ProtoMessage()               {}
-func (*LabelValuesResponse) Descriptor() ([]byte, []int) { return fileDescriptorRpc, []int{7} }
+func (*LabelValuesResponse) Descriptor() ([]byte, []int) { return fileDescriptorRpc, []int{9} }
+
+type QueryRequest struct {
+	Query string `protobuf:"bytes,1,opt,name=query,proto3" json:"query,omitempty"`
+	// / Evaluation time of an instant query or start time of a range query in milliseconds.
+	Start int64 `protobuf:"varint,2,opt,name=start,proto3" json:"start,omitempty"`
+	End   int64 `protobuf:"varint,3,opt,name=end,proto3" json:"end,omitempty"`
+	// / Resolution step of a range query in milliseconds. Zero for instant queries.
+	Step int64 `protobuf:"varint,4,opt,name=step,proto3" json:"step,omitempty"`
+}
+
+func (m *QueryRequest) Reset()                    { *m = QueryRequest{} }
+func (m *QueryRequest) String() string            { return proto.CompactTextString(m) }
+func (*QueryRequest) ProtoMessage()               {}
+func (*QueryRequest) Descriptor() ([]byte, []int) { return fileDescriptorRpc, []int{10} }
+
+type QueryResponse struct {
+	Series   []QuerySeries `protobuf:"bytes,1,rep,name=series" json:"series"`
+	Warnings []string      `protobuf:"bytes,2,rep,name=warnings" json:"warnings,omitempty"`
+}
+
+func (m *QueryResponse) Reset()                    { *m = QueryResponse{} }
+func (m *QueryResponse) String() string            { return proto.CompactTextString(m) }
+func (*QueryResponse) ProtoMessage()               {}
+func (*QueryResponse) Descriptor() ([]byte, []int) { return fileDescriptorRpc, []int{11} }
+
+type QuerySeries struct {
+	Labels  []Label  `protobuf:"bytes,1,rep,name=labels" json:"labels"`
+	Samples []Sample `protobuf:"bytes,2,rep,name=samples" json:"samples"`
+}
+
+func (m *QuerySeries) Reset()                    { *m = QuerySeries{} }
+func (m *QuerySeries) String() string            { return proto.CompactTextString(m) }
+func (*QuerySeries) ProtoMessage()               {}
+func (*QuerySeries) Descriptor() ([]byte, []int) { return fileDescriptorRpc, []int{12} }
+
+type Sample struct {
+	Timestamp int64   `protobuf:"varint,1,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	Value     float64 `protobuf:"fixed64,2,opt,name=value,proto3" json:"value,omitempty"`
+}
+
+func (m *Sample) Reset()                    { *m = Sample{} }
+func (m *Sample) String() string            { return proto.CompactTextString(m) }
+func (*Sample) ProtoMessage()               {}
+func (*Sample) Descriptor() ([]byte, []int) { return fileDescriptorRpc, []int{13} }
 
 func init() {
 	proto.RegisterType((*InfoRequest)(nil), "thanos.InfoRequest")
 	proto.RegisterType((*InfoResponse)(nil), "thanos.InfoResponse")
+	proto.RegisterType((*MetricNameFilter)(nil), "thanos.MetricNameFilter")
 	proto.RegisterType((*SeriesRequest)(nil), "thanos.SeriesRequest")
+	proto.RegisterType((*LabelMatchers)(nil), "thanos.LabelMatchers")
 	proto.RegisterType((*SeriesResponse)(nil), "thanos.SeriesResponse")
 	proto.RegisterType((*LabelNamesRequest)(nil), "thanos.LabelNamesRequest")
 	proto.RegisterType((*LabelNamesResponse)(nil), "thanos.LabelNamesResponse")
 	proto.RegisterType((*LabelValuesRequest)(nil), "thanos.LabelValuesRequest")
 	proto.RegisterType((*LabelValuesResponse)(nil), "thanos.LabelValuesResponse")
+	proto.RegisterType((*QueryRequest)(nil), "thanos.QueryRequest")
+	proto.RegisterType((*QueryResponse)(nil), "thanos.QueryResponse")
+	proto.RegisterType((*QuerySeries)(nil), "thanos.QuerySeries")
+	proto.RegisterType((*Sample)(nil), "thanos.Sample")
 	proto.RegisterEnum("thanos.Aggr", Aggr_name, Aggr_value)
 }
 
@@ -480,6 +582,70 @@ var _Store_serviceDesc = grpc.ServiceDesc{
 	Metadata: "rpc.proto",
 }
 
+// Client API for Query service
+
+type QueryClient interface {
+	Query(ctx context.Context, in *QueryRequest, opts ...grpc.CallOption) (*QueryResponse, error)
+}
+
+type queryClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewQueryClient(cc *grpc.ClientConn) QueryClient {
+	return &queryClient{cc}
+}
+
+func (c *queryClient) Query(ctx context.Context, in *QueryRequest, opts ...grpc.CallOption) (*QueryResponse, error) {
+	out := new(QueryResponse)
+	err := grpc.Invoke(ctx, "/thanos.Query/Query", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Server API for Query service
+
+type QueryServer interface {
+	Query(context.Context, *QueryRequest) (*QueryResponse, error)
+}
+
+func RegisterQueryServer(s *grpc.Server, srv QueryServer) {
+	s.RegisterService(&_Query_serviceDesc, srv)
+}
+
+func _Query_Query_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(QueryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(QueryServer).Query(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/thanos.Query/Query",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(QueryServer).Query(ctx, req.(*QueryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _Query_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "thanos.Query",
+	HandlerType: (*QueryServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Query",
+			Handler:    _Query_Query_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "rpc.proto",
+}
+
 func (m *InfoRequest) Marshal() (dAtA []byte, err error) {
 	size := m.Size()
 	dAtA = make([]byte, size)
@@ -495,6 +661,11 @@ func (m *InfoRequest) MarshalTo(dAtA []byte) (int, error) {
 	_ = i
 	var l int
 	_ = l
+	if m.MetricNamesChecksum != 0 {
+		dAtA[i] = 0x8
+		i++
+		i = encodeVarintRpc(dAtA, i, uint64(m.MetricNamesChecksum))
+	}
 	return i, nil
 }
 
@@ -535,6 +706,80 @@ func (m *InfoResponse) MarshalTo(dAtA []byte) (int, error) {
 		i++
 		i = encodeVarintRpc(dAtA, i, uint64(m.MaxTime))
 	}
+	if m.MetricNames != nil {
+		dAtA[i] = 0x22
+		i++
+		i = encodeVarintRpc(dAtA, i, uint64(m.MetricNames.Size()))
+		n1, err := m.MetricNames.MarshalTo(dAtA[i:])
+		if err != nil {
+			return 0, err
+		}
+		i += n1
+	}
+	if m.SupportsMatcherSets {
+		dAtA[i] = 0x28
+		i++
+		if m.SupportsMatcherSets {
+			dAtA[i] = 1
+		} else {
+			dAtA[i] = 0
+		}
+		i++
+	}
+	if m.MetricNamesUnchanged {
+		dAtA[i] = 0x30
+		i++
+		if m.MetricNamesUnchanged {
+			dAtA[i] = 1
+		} else {
+			dAtA[i] = 0
+		}
+		i++
+	}
+	if m.SupportsQuery {
+		dAtA[i] = 0x38
+		i++
+		if m.SupportsQuery {
+			dAtA[i] = 1
+		} else {
+			dAtA[i] = 0
+		}
+		i++
+	}
+	return i, nil
+}
+
+func (m *MetricNameFilter) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *MetricNameFilter) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	if len(m.Bits) > 0 {
+		dAtA[i] = 0xa
+		i++
+		i = encodeVarintRpc(dAtA, i, uint64(len(m.Bits)))
+		i += copy(dAtA[i:], m.Bits)
+	}
+	if m.Hashes != 0 {
+		dAtA[i] = 0x10
+		i++
+		i = encodeVarintRpc(dAtA, i, uint64(m.Hashes))
+	}
+	if m.MaxTime != 0 {
+		dAtA[i] = 0x18
+		i++
+		i = encodeVarintRpc(dAtA, i, uint64(m.MaxTime))
+	}
 	return i, nil
 }
 
@@ -581,21 +826,73 @@ func (m *SeriesRequest) MarshalTo(dAtA []byte) (int, error) {
 		i = encodeVarintRpc(dAtA, i, uint64(m.MaxResolutionWindow))
 	}
 	if len(m.Aggregates) > 0 {
-		dAtA2 := make([]byte, len(m.Aggregates)*10)
-		var j1 int
+		dAtA3 := make([]byte, len(m.Aggregates)*10)
+		var j2 int
 		for _, num := range m.Aggregates {
 			for num >= 1<<7 {
-				dAtA2[j1] = uint8(uint64(num)&0x7f | 0x80)
+				dAtA3[j2] = uint8(uint64(num)&0x7f | 0x80)
 				num >>= 7
-				j1++
+				j2++
 			}
-			dAtA2[j1] = uint8(num)
-			j1++
+			dAtA3[j2] = uint8(num)
+			j2++
 		}
 		dAtA[i] = 0x2a
 		i++
-		i = encodeVarintRpc(dAtA, i, uint64(j1))
-		i += copy(dAtA[i:], dAtA2[:j1])
+		i = encodeVarintRpc(dAtA, i, uint64(j2))
+		i += copy(dAtA[i:], dAtA3[:j2])
+	}
+	if len(m.MatcherSets) > 0 {
+		for _, msg := range m.MatcherSets {
+			dAtA[i] = 0x32
+			i++
+			i = encodeVarintRpc(dAtA, i, uint64(msg.Size()))
+			n, err := msg.MarshalTo(dAtA[i:])
+			if err != nil {
+				return 0, err
+			}
+			i += n
+		}
+	}
+	if m.ResolutionFallback {
+		dAtA[i] = 0x38
+		i++
+		if m.ResolutionFallback {
+			dAtA[i] = 1
+		} else {
+			dAtA[i] = 0
+		}
+		i++
+	}
+	return i, nil
+}
+
+func (m *LabelMatchers) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *LabelMatchers) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	if len(m.Matchers) > 0 {
+		for _, msg := range m.Matchers {
+			dAtA[i] = 0xa
+			i++
+			i = encodeVarintRpc(dAtA, i, uint64(msg.Size()))
+			n, err := msg.MarshalTo(dAtA[i:])
+			if err != nil {
+				return 0, err
+			}
+			i += n
+		}
 	}
 	return i, nil
 }
@@ -616,11 +913,11 @@ func (m *SeriesResponse) MarshalTo(dAtA []byte) (int, error) {
 	var l int
 	_ = l
 	if m.Result != nil {
-		nn3, err := m.Result.MarshalTo(dAtA[i:])
+		nn4, err := m.Result.MarshalTo(dAtA[i:])
 		if err != nil {
 			return 0, err
 		}
-		i += nn3
+		i += nn4
 	}
 	return i, nil
 }
@@ -631,11 +928,11 @@ func (m *SeriesResponse_Series) MarshalTo(dAtA []byte) (int, error) {
 		dAtA[i] = 0xa
 		i++
 		i = encodeVarintRpc(dAtA, i, uint64(m.Series.Size()))
-		n4, err := m.Series.MarshalTo(dAtA[i:])
+		n5, err := m.Series.MarshalTo(dAtA[i:])
 		if err != nil {
 			return 0, err
 		}
-		i += n4
+		i += n5
 	}
 	return i, nil
 }
@@ -785,80 +1082,288 @@ func (m *LabelValuesResponse) MarshalTo(dAtA []byte) (int, error) {
 	return i, nil
 }
 
-func encodeVarintRpc(dAtA []byte, offset int, v uint64) int {
-	for v >= 1<<7 {
-		dAtA[offset] = uint8(v&0x7f | 0x80)
-		v >>= 7
-		offset++
+func (m *QueryRequest) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
 	}
-	dAtA[offset] = uint8(v)
-	return offset + 1
-}
-func (m *InfoRequest) Size() (n int) {
-	var l int
-	_ = l
-	return n
+	return dAtA[:n], nil
 }
 
-func (m *InfoResponse) Size() (n int) {
+func (m *QueryRequest) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
 	var l int
 	_ = l
-	if len(m.Labels) > 0 {
-		for _, e := range m.Labels {
-			l = e.Size()
-			n += 1 + l + sovRpc(uint64(l))
-		}
+	if len(m.Query) > 0 {
+		dAtA[i] = 0xa
+		i++
+		i = encodeVarintRpc(dAtA, i, uint64(len(m.Query)))
+		i += copy(dAtA[i:], m.Query)
 	}
-	if m.MinTime != 0 {
-		n += 1 + sovRpc(uint64(m.MinTime))
+	if m.Start != 0 {
+		dAtA[i] = 0x10
+		i++
+		i = encodeVarintRpc(dAtA, i, uint64(m.Start))
 	}
-	if m.MaxTime != 0 {
-		n += 1 + sovRpc(uint64(m.MaxTime))
+	if m.End != 0 {
+		dAtA[i] = 0x18
+		i++
+		i = encodeVarintRpc(dAtA, i, uint64(m.End))
 	}
-	return n
+	if m.Step != 0 {
+		dAtA[i] = 0x20
+		i++
+		i = encodeVarintRpc(dAtA, i, uint64(m.Step))
+	}
+	return i, nil
 }
 
-func (m *SeriesRequest) Size() (n int) {
+func (m *QueryResponse) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *QueryResponse) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
 	var l int
 	_ = l
-	if m.MinTime != 0 {
-		n += 1 + sovRpc(uint64(m.MinTime))
-	}
-	if m.MaxTime != 0 {
-		n += 1 + sovRpc(uint64(m.MaxTime))
-	}
-	if len(m.Matchers) > 0 {
-		for _, e := range m.Matchers {
-			l = e.Size()
-			n += 1 + l + sovRpc(uint64(l))
+	if len(m.Series) > 0 {
+		for _, msg := range m.Series {
+			dAtA[i] = 0xa
+			i++
+			i = encodeVarintRpc(dAtA, i, uint64(msg.Size()))
+			n, err := msg.MarshalTo(dAtA[i:])
+			if err != nil {
+				return 0, err
+			}
+			i += n
 		}
 	}
-	if m.MaxResolutionWindow != 0 {
-		n += 1 + sovRpc(uint64(m.MaxResolutionWindow))
-	}
-	if len(m.Aggregates) > 0 {
-		l = 0
-		for _, e := range m.Aggregates {
-			l += sovRpc(uint64(e))
+	if len(m.Warnings) > 0 {
+		for _, s := range m.Warnings {
+			dAtA[i] = 0x12
+			i++
+			l = len(s)
+			for l >= 1<<7 {
+				dAtA[i] = uint8(uint64(l)&0x7f | 0x80)
+				l >>= 7
+				i++
+			}
+			dAtA[i] = uint8(l)
+			i++
+			i += copy(dAtA[i:], s)
 		}
-		n += 1 + sovRpc(uint64(l)) + l
 	}
-	return n
+	return i, nil
 }
 
-func (m *SeriesResponse) Size() (n int) {
-	var l int
-	_ = l
-	if m.Result != nil {
-		n += m.Result.Size()
+func (m *QuerySeries) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
 	}
-	return n
+	return dAtA[:n], nil
 }
 
-func (m *SeriesResponse_Series) Size() (n int) {
+func (m *QuerySeries) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
 	var l int
 	_ = l
-	if m.Series != nil {
+	if len(m.Labels) > 0 {
+		for _, msg := range m.Labels {
+			dAtA[i] = 0xa
+			i++
+			i = encodeVarintRpc(dAtA, i, uint64(msg.Size()))
+			n, err := msg.MarshalTo(dAtA[i:])
+			if err != nil {
+				return 0, err
+			}
+			i += n
+		}
+	}
+	if len(m.Samples) > 0 {
+		for _, msg := range m.Samples {
+			dAtA[i] = 0x12
+			i++
+			i = encodeVarintRpc(dAtA, i, uint64(msg.Size()))
+			n, err := msg.MarshalTo(dAtA[i:])
+			if err != nil {
+				return 0, err
+			}
+			i += n
+		}
+	}
+	return i, nil
+}
+
+func (m *Sample) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *Sample) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	if m.Timestamp != 0 {
+		dAtA[i] = 0x8
+		i++
+		i = encodeVarintRpc(dAtA, i, uint64(m.Timestamp))
+	}
+	if m.Value != 0 {
+		dAtA[i] = 0x11
+		i++
+		binary.LittleEndian.PutUint64(dAtA[i:], uint64(math.Float64bits(float64(m.Value))))
+		i += 8
+	}
+	return i, nil
+}
+
+func encodeVarintRpc(dAtA []byte, offset int, v uint64) int {
+	for v >= 1<<7 {
+		dAtA[offset] = uint8(v&0x7f | 0x80)
+		v >>= 7
+		offset++
+	}
+	dAtA[offset] = uint8(v)
+	return offset + 1
+}
+func (m *InfoRequest) Size() (n int) {
+	var l int
+	_ = l
+	if m.MetricNamesChecksum != 0 {
+		n += 1 + sovRpc(uint64(m.MetricNamesChecksum))
+	}
+	return n
+}
+
+func (m *InfoResponse) Size() (n int) {
+	var l int
+	_ = l
+	if len(m.Labels) > 0 {
+		for _, e := range m.Labels {
+			l = e.Size()
+			n += 1 + l + sovRpc(uint64(l))
+		}
+	}
+	if m.MinTime != 0 {
+		n += 1 + sovRpc(uint64(m.MinTime))
+	}
+	if m.MaxTime != 0 {
+		n += 1 + sovRpc(uint64(m.MaxTime))
+	}
+	if m.MetricNames != nil {
+		l = m.MetricNames.Size()
+		n += 1 + l + sovRpc(uint64(l))
+	}
+	if m.SupportsMatcherSets {
+		n += 2
+	}
+	if m.MetricNamesUnchanged {
+		n += 2
+	}
+	if m.SupportsQuery {
+		n += 2
+	}
+	return n
+}
+
+func (m *MetricNameFilter) Size() (n int) {
+	var l int
+	_ = l
+	l = len(m.Bits)
+	if l > 0 {
+		n += 1 + l + sovRpc(uint64(l))
+	}
+	if m.Hashes != 0 {
+		n += 1 + sovRpc(uint64(m.Hashes))
+	}
+	if m.MaxTime != 0 {
+		n += 1 + sovRpc(uint64(m.MaxTime))
+	}
+	return n
+}
+
+func (m *SeriesRequest) Size() (n int) {
+	var l int
+	_ = l
+	if m.MinTime != 0 {
+		n += 1 + sovRpc(uint64(m.MinTime))
+	}
+	if m.MaxTime != 0 {
+		n += 1 + sovRpc(uint64(m.MaxTime))
+	}
+	if len(m.Matchers) > 0 {
+		for _, e := range m.Matchers {
+			l = e.Size()
+			n += 1 + l + sovRpc(uint64(l))
+		}
+	}
+	if m.MaxResolutionWindow != 0 {
+		n += 1 + sovRpc(uint64(m.MaxResolutionWindow))
+	}
+	if len(m.Aggregates) > 0 {
+		l = 0
+		for _, e := range m.Aggregates {
+			l += sovRpc(uint64(e))
+		}
+		n += 1 + sovRpc(uint64(l)) + l
+	}
+	if len(m.MatcherSets) > 0 {
+		for _, e := range m.MatcherSets {
+			l = e.Size()
+			n += 1 + l + sovRpc(uint64(l))
+		}
+	}
+	if m.ResolutionFallback {
+		n += 2
+	}
+	return n
+}
+
+func (m *LabelMatchers) Size() (n int) {
+	var l int
+	_ = l
+	if len(m.Matchers) > 0 {
+		for _, e := range m.Matchers {
+			l = e.Size()
+			n += 1 + l + sovRpc(uint64(l))
+		}
+	}
+	return n
+}
+
+func (m *SeriesResponse) Size() (n int) {
+	var l int
+	_ = l
+	if m.Result != nil {
+		n += m.Result.Size()
+	}
+	return n
+}
+
+func (m *SeriesResponse_Series) Size() (n int) {
+	var l int
+	_ = l
+	if m.Series != nil {
 		l = m.Series.Size()
 		n += 1 + l + sovRpc(uint64(l))
 	}
@@ -923,6 +1428,73 @@ func (m *LabelValuesResponse) Size() (n int) {
 	return n
 }
 
+func (m *QueryRequest) Size() (n int) {
+	var l int
+	_ = l
+	l = len(m.Query)
+	if l > 0 {
+		n += 1 + l + sovRpc(uint64(l))
+	}
+	if m.Start != 0 {
+		n += 1 + sovRpc(uint64(m.Start))
+	}
+	if m.End != 0 {
+		n += 1 + sovRpc(uint64(m.End))
+	}
+	if m.Step != 0 {
+		n += 1 + sovRpc(uint64(m.Step))
+	}
+	return n
+}
+
+func (m *QueryResponse) Size() (n int) {
+	var l int
+	_ = l
+	if len(m.Series) > 0 {
+		for _, e := range m.Series {
+			l = e.Size()
+			n += 1 + l + sovRpc(uint64(l))
+		}
+	}
+	if len(m.Warnings) > 0 {
+		for _, s := range m.Warnings {
+			l = len(s)
+			n += 1 + l + sovRpc(uint64(l))
+		}
+	}
+	return n
+}
+
+func (m *QuerySeries) Size() (n int) {
+	var l int
+	_ = l
+	if len(m.Labels) > 0 {
+		for _, e := range m.Labels {
+			l = e.Size()
+			n += 1 + l + sovRpc(uint64(l))
+		}
+	}
+	if len(m.Samples) > 0 {
+		for _, e := range m.Samples {
+			l = e.Size()
+			n += 1 + l + sovRpc(uint64(l))
+		}
+	}
+	return n
+}
+
+func (m *Sample) Size() (n int) {
+	var l int
+	_ = l
+	if m.Timestamp != 0 {
+		n += 1 + sovRpc(uint64(m.Timestamp))
+	}
+	if m.Value != 0 {
+		n += 9
+	}
+	return n
+}
+
 func sovRpc(x uint64) (n int) {
 	for {
 		n++
@@ -965,6 +1537,25 @@ func (m *InfoRequest) Unmarshal(dAtA []byte) error {
 			return fmt.Errorf("proto: InfoRequest: illegal tag %d (wire type %d)", fieldNum, wire)
 		}
 		switch fieldNum {
+		case 1:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field MetricNamesChecksum", wireType)
+			}
+			m.MetricNamesChecksum = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.MetricNamesChecksum |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
 		default:
 			iNdEx = preIndex
 			skippy, err := skipRpc(dAtA[iNdEx:])
@@ -1084,29 +1675,241 @@ func (m *InfoResponse) Unmarshal(dAtA []byte) error {
 					break
 				}
 			}
-		default:
-			iNdEx = preIndex
-			skippy, err := skipRpc(dAtA[iNdEx:])
-			if err != nil {
-				return err
+		case 4:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field MetricNames", wireType)
 			}
-			if skippy < 0 {
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
 				return ErrInvalidLengthRpc
 			}
-			if (iNdEx + skippy) > l {
+			postIndex := iNdEx + msglen
+			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
-			iNdEx += skippy
-		}
-	}
-
-	if iNdEx > l {
-		return io.ErrUnexpectedEOF
-	}
-	return nil
-}
-func (m *SeriesRequest) Unmarshal(dAtA []byte) error {
-	l := len(dAtA)
+			if m.MetricNames == nil {
+				m.MetricNames = &MetricNameFilter{}
+			}
+			if err := m.MetricNames.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 5:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field SupportsMatcherSets", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				v |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.SupportsMatcherSets = bool(v != 0)
+		case 6:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field MetricNamesUnchanged", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				v |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.MetricNamesUnchanged = bool(v != 0)
+		case 7:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field SupportsQuery", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				v |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.SupportsQuery = bool(v != 0)
+		default:
+			iNdEx = preIndex
+			skippy, err := skipRpc(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthRpc
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *MetricNameFilter) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowRpc
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= (uint64(b) & 0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: MetricNameFilter: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: MetricNameFilter: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Bits", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthRpc
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Bits = append(m.Bits[:0], dAtA[iNdEx:postIndex]...)
+			if m.Bits == nil {
+				m.Bits = []byte{}
+			}
+			iNdEx = postIndex
+		case 2:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Hashes", wireType)
+			}
+			m.Hashes = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Hashes |= (uint32(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 3:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field MaxTime", wireType)
+			}
+			m.MaxTime = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.MaxTime |= (int64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		default:
+			iNdEx = preIndex
+			skippy, err := skipRpc(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthRpc
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *SeriesRequest) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
 	iNdEx := 0
 	for iNdEx < l {
 		preIndex := iNdEx
@@ -1259,31 +2062,511 @@ func (m *SeriesRequest) Unmarshal(dAtA []byte) error {
 				if packedLen < 0 {
 					return ErrInvalidLengthRpc
 				}
-				postIndex := iNdEx + packedLen
-				if postIndex > l {
+				postIndex := iNdEx + packedLen
+				if postIndex > l {
+					return io.ErrUnexpectedEOF
+				}
+				for iNdEx < postIndex {
+					var v Aggr
+					for shift := uint(0); ; shift += 7 {
+						if shift >= 64 {
+							return ErrIntOverflowRpc
+						}
+						if iNdEx >= l {
+							return io.ErrUnexpectedEOF
+						}
+						b := dAtA[iNdEx]
+						iNdEx++
+						v |= (Aggr(b) & 0x7F) << shift
+						if b < 0x80 {
+							break
+						}
+					}
+					m.Aggregates = append(m.Aggregates, v)
+				}
+			} else {
+				return fmt.Errorf("proto: wrong wireType = %d for field Aggregates", wireType)
+			}
+		case 6:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field MatcherSets", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthRpc
+			}
+			postIndex := iNdEx + msglen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.MatcherSets = append(m.MatcherSets, LabelMatchers{})
+			if err := m.MatcherSets[len(m.MatcherSets)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 7:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ResolutionFallback", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				v |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.ResolutionFallback = bool(v != 0)
+		default:
+			iNdEx = preIndex
+			skippy, err := skipRpc(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthRpc
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *LabelMatchers) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowRpc
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= (uint64(b) & 0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: LabelMatchers: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: LabelMatchers: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Matchers", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthRpc
+			}
+			postIndex := iNdEx + msglen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Matchers = append(m.Matchers, LabelMatcher{})
+			if err := m.Matchers[len(m.Matchers)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipRpc(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthRpc
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *SeriesResponse) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowRpc
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= (uint64(b) & 0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: SeriesResponse: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: SeriesResponse: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Series", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthRpc
+			}
+			postIndex := iNdEx + msglen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			v := &Series{}
+			if err := v.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			m.Result = &SeriesResponse_Series{v}
+			iNdEx = postIndex
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Warning", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthRpc
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Result = &SeriesResponse_Warning{string(dAtA[iNdEx:postIndex])}
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipRpc(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthRpc
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *LabelNamesRequest) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowRpc
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= (uint64(b) & 0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: LabelNamesRequest: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: LabelNamesRequest: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		default:
+			iNdEx = preIndex
+			skippy, err := skipRpc(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthRpc
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *LabelNamesResponse) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowRpc
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= (uint64(b) & 0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: LabelNamesResponse: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: LabelNamesResponse: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Names", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthRpc
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Names = append(m.Names, string(dAtA[iNdEx:postIndex]))
+			iNdEx = postIndex
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Warnings", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthRpc
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Warnings = append(m.Warnings, string(dAtA[iNdEx:postIndex]))
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipRpc(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthRpc
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *LabelValuesRequest) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowRpc
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= (uint64(b) & 0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: LabelValuesRequest: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: LabelValuesRequest: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Label", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
 					return io.ErrUnexpectedEOF
 				}
-				for iNdEx < postIndex {
-					var v Aggr
-					for shift := uint(0); ; shift += 7 {
-						if shift >= 64 {
-							return ErrIntOverflowRpc
-						}
-						if iNdEx >= l {
-							return io.ErrUnexpectedEOF
-						}
-						b := dAtA[iNdEx]
-						iNdEx++
-						v |= (Aggr(b) & 0x7F) << shift
-						if b < 0x80 {
-							break
-						}
-					}
-					m.Aggregates = append(m.Aggregates, v)
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
 				}
-			} else {
-				return fmt.Errorf("proto: wrong wireType = %d for field Aggregates", wireType)
 			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthRpc
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Label = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipRpc(dAtA[iNdEx:])
@@ -1305,7 +2588,7 @@ func (m *SeriesRequest) Unmarshal(dAtA []byte) error {
 	}
 	return nil
 }
-func (m *SeriesResponse) Unmarshal(dAtA []byte) error {
+func (m *LabelValuesResponse) Unmarshal(dAtA []byte) error {
 	l := len(dAtA)
 	iNdEx := 0
 	for iNdEx < l {
@@ -1328,17 +2611,17 @@ func (m *SeriesResponse) Unmarshal(dAtA []byte) error {
 		fieldNum := int32(wire >> 3)
 		wireType := int(wire & 0x7)
 		if wireType == 4 {
-			return fmt.Errorf("proto: SeriesResponse: wiretype end group for non-group")
+			return fmt.Errorf("proto: LabelValuesResponse: wiretype end group for non-group")
 		}
 		if fieldNum <= 0 {
-			return fmt.Errorf("proto: SeriesResponse: illegal tag %d (wire type %d)", fieldNum, wire)
+			return fmt.Errorf("proto: LabelValuesResponse: illegal tag %d (wire type %d)", fieldNum, wire)
 		}
 		switch fieldNum {
 		case 1:
 			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Series", wireType)
+				return fmt.Errorf("proto: wrong wireType = %d for field Values", wireType)
 			}
-			var msglen int
+			var stringLen uint64
 			for shift := uint(0); ; shift += 7 {
 				if shift >= 64 {
 					return ErrIntOverflowRpc
@@ -1348,27 +2631,24 @@ func (m *SeriesResponse) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				msglen |= (int(b) & 0x7F) << shift
+				stringLen |= (uint64(b) & 0x7F) << shift
 				if b < 0x80 {
 					break
 				}
 			}
-			if msglen < 0 {
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
 				return ErrInvalidLengthRpc
 			}
-			postIndex := iNdEx + msglen
+			postIndex := iNdEx + intStringLen
 			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
-			v := &Series{}
-			if err := v.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
-				return err
-			}
-			m.Result = &SeriesResponse_Series{v}
+			m.Values = append(m.Values, string(dAtA[iNdEx:postIndex]))
 			iNdEx = postIndex
 		case 2:
 			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Warning", wireType)
+				return fmt.Errorf("proto: wrong wireType = %d for field Warnings", wireType)
 			}
 			var stringLen uint64
 			for shift := uint(0); ; shift += 7 {
@@ -1393,7 +2673,7 @@ func (m *SeriesResponse) Unmarshal(dAtA []byte) error {
 			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
-			m.Result = &SeriesResponse_Warning{string(dAtA[iNdEx:postIndex])}
+			m.Warnings = append(m.Warnings, string(dAtA[iNdEx:postIndex]))
 			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
@@ -1416,7 +2696,7 @@ func (m *SeriesResponse) Unmarshal(dAtA []byte) error {
 	}
 	return nil
 }
-func (m *LabelNamesRequest) Unmarshal(dAtA []byte) error {
+func (m *QueryRequest) Unmarshal(dAtA []byte) error {
 	l := len(dAtA)
 	iNdEx := 0
 	for iNdEx < l {
@@ -1439,12 +2719,98 @@ func (m *LabelNamesRequest) Unmarshal(dAtA []byte) error {
 		fieldNum := int32(wire >> 3)
 		wireType := int(wire & 0x7)
 		if wireType == 4 {
-			return fmt.Errorf("proto: LabelNamesRequest: wiretype end group for non-group")
+			return fmt.Errorf("proto: QueryRequest: wiretype end group for non-group")
 		}
 		if fieldNum <= 0 {
-			return fmt.Errorf("proto: LabelNamesRequest: illegal tag %d (wire type %d)", fieldNum, wire)
+			return fmt.Errorf("proto: QueryRequest: illegal tag %d (wire type %d)", fieldNum, wire)
 		}
 		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Query", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthRpc
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Query = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 2:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Start", wireType)
+			}
+			m.Start = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Start |= (int64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 3:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field End", wireType)
+			}
+			m.End = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.End |= (int64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 4:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Step", wireType)
+			}
+			m.Step = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Step |= (int64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
 		default:
 			iNdEx = preIndex
 			skippy, err := skipRpc(dAtA[iNdEx:])
@@ -1466,7 +2832,7 @@ func (m *LabelNamesRequest) Unmarshal(dAtA []byte) error {
 	}
 	return nil
 }
-func (m *LabelNamesResponse) Unmarshal(dAtA []byte) error {
+func (m *QueryResponse) Unmarshal(dAtA []byte) error {
 	l := len(dAtA)
 	iNdEx := 0
 	for iNdEx < l {
@@ -1489,17 +2855,17 @@ func (m *LabelNamesResponse) Unmarshal(dAtA []byte) error {
 		fieldNum := int32(wire >> 3)
 		wireType := int(wire & 0x7)
 		if wireType == 4 {
-			return fmt.Errorf("proto: LabelNamesResponse: wiretype end group for non-group")
+			return fmt.Errorf("proto: QueryResponse: wiretype end group for non-group")
 		}
 		if fieldNum <= 0 {
-			return fmt.Errorf("proto: LabelNamesResponse: illegal tag %d (wire type %d)", fieldNum, wire)
+			return fmt.Errorf("proto: QueryResponse: illegal tag %d (wire type %d)", fieldNum, wire)
 		}
 		switch fieldNum {
 		case 1:
 			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Names", wireType)
+				return fmt.Errorf("proto: wrong wireType = %d for field Series", wireType)
 			}
-			var stringLen uint64
+			var msglen int
 			for shift := uint(0); ; shift += 7 {
 				if shift >= 64 {
 					return ErrIntOverflowRpc
@@ -1509,20 +2875,22 @@ func (m *LabelNamesResponse) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				stringLen |= (uint64(b) & 0x7F) << shift
+				msglen |= (int(b) & 0x7F) << shift
 				if b < 0x80 {
 					break
 				}
 			}
-			intStringLen := int(stringLen)
-			if intStringLen < 0 {
+			if msglen < 0 {
 				return ErrInvalidLengthRpc
 			}
-			postIndex := iNdEx + intStringLen
+			postIndex := iNdEx + msglen
 			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
-			m.Names = append(m.Names, string(dAtA[iNdEx:postIndex]))
+			m.Series = append(m.Series, QuerySeries{})
+			if err := m.Series[len(m.Series)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
 			iNdEx = postIndex
 		case 2:
 			if wireType != 2 {
@@ -1574,7 +2942,7 @@ func (m *LabelNamesResponse) Unmarshal(dAtA []byte) error {
 	}
 	return nil
 }
-func (m *LabelValuesRequest) Unmarshal(dAtA []byte) error {
+func (m *QuerySeries) Unmarshal(dAtA []byte) error {
 	l := len(dAtA)
 	iNdEx := 0
 	for iNdEx < l {
@@ -1597,17 +2965,17 @@ func (m *LabelValuesRequest) Unmarshal(dAtA []byte) error {
 		fieldNum := int32(wire >> 3)
 		wireType := int(wire & 0x7)
 		if wireType == 4 {
-			return fmt.Errorf("proto: LabelValuesRequest: wiretype end group for non-group")
+			return fmt.Errorf("proto: QuerySeries: wiretype end group for non-group")
 		}
 		if fieldNum <= 0 {
-			return fmt.Errorf("proto: LabelValuesRequest: illegal tag %d (wire type %d)", fieldNum, wire)
+			return fmt.Errorf("proto: QuerySeries: illegal tag %d (wire type %d)", fieldNum, wire)
 		}
 		switch fieldNum {
 		case 1:
 			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Label", wireType)
+				return fmt.Errorf("proto: wrong wireType = %d for field Labels", wireType)
 			}
-			var stringLen uint64
+			var msglen int
 			for shift := uint(0); ; shift += 7 {
 				if shift >= 64 {
 					return ErrIntOverflowRpc
@@ -1617,20 +2985,53 @@ func (m *LabelValuesRequest) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				stringLen |= (uint64(b) & 0x7F) << shift
+				msglen |= (int(b) & 0x7F) << shift
 				if b < 0x80 {
 					break
 				}
 			}
-			intStringLen := int(stringLen)
-			if intStringLen < 0 {
+			if msglen < 0 {
 				return ErrInvalidLengthRpc
 			}
-			postIndex := iNdEx + intStringLen
+			postIndex := iNdEx + msglen
 			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
-			m.Label = string(dAtA[iNdEx:postIndex])
+			m.Labels = append(m.Labels, Label{})
+			if err := m.Labels[len(m.Labels)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Samples", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthRpc
+			}
+			postIndex := iNdEx + msglen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Samples = append(m.Samples, Sample{})
+			if err := m.Samples[len(m.Samples)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
 			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
@@ -1653,7 +3054,7 @@ func (m *LabelValuesRequest) Unmarshal(dAtA []byte) error {
 	}
 	return nil
 }
-func (m *LabelValuesResponse) Unmarshal(dAtA []byte) error {
+func (m *Sample) Unmarshal(dAtA []byte) error {
 	l := len(dAtA)
 	iNdEx := 0
 	for iNdEx < l {
@@ -1676,17 +3077,17 @@ func (m *LabelValuesResponse) Unmarshal(dAtA []byte) error {
 		fieldNum := int32(wire >> 3)
 		wireType := int(wire & 0x7)
 		if wireType == 4 {
-			return fmt.Errorf("proto: LabelValuesResponse: wiretype end group for non-group")
+			return fmt.Errorf("proto: Sample: wiretype end group for non-group")
 		}
 		if fieldNum <= 0 {
-			return fmt.Errorf("proto: LabelValuesResponse: illegal tag %d (wire type %d)", fieldNum, wire)
+			return fmt.Errorf("proto: Sample: illegal tag %d (wire type %d)", fieldNum, wire)
 		}
 		switch fieldNum {
 		case 1:
-			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Values", wireType)
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Timestamp", wireType)
 			}
-			var stringLen uint64
+			m.Timestamp = 0
 			for shift := uint(0); ; shift += 7 {
 				if shift >= 64 {
 					return ErrIntOverflowRpc
@@ -1696,50 +3097,22 @@ func (m *LabelValuesResponse) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				stringLen |= (uint64(b) & 0x7F) << shift
+				m.Timestamp |= (int64(b) & 0x7F) << shift
 				if b < 0x80 {
 					break
 				}
 			}
-			intStringLen := int(stringLen)
-			if intStringLen < 0 {
-				return ErrInvalidLengthRpc
-			}
-			postIndex := iNdEx + intStringLen
-			if postIndex > l {
-				return io.ErrUnexpectedEOF
-			}
-			m.Values = append(m.Values, string(dAtA[iNdEx:postIndex]))
-			iNdEx = postIndex
 		case 2:
-			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Warnings", wireType)
-			}
-			var stringLen uint64
-			for shift := uint(0); ; shift += 7 {
-				if shift >= 64 {
-					return ErrIntOverflowRpc
-				}
-				if iNdEx >= l {
-					return io.ErrUnexpectedEOF
-				}
-				b := dAtA[iNdEx]
-				iNdEx++
-				stringLen |= (uint64(b) & 0x7F) << shift
-				if b < 0x80 {
-					break
-				}
-			}
-			intStringLen := int(stringLen)
-			if intStringLen < 0 {
-				return ErrInvalidLengthRpc
+			if wireType != 1 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Value", wireType)
 			}
-			postIndex := iNdEx + intStringLen
-			if postIndex > l {
+			var v uint64
+			if (iNdEx + 8) > l {
 				return io.ErrUnexpectedEOF
 			}
-			m.Warnings = append(m.Warnings, string(dAtA[iNdEx:postIndex]))
-			iNdEx = postIndex
+			v = uint64(binary.LittleEndian.Uint64(dAtA[iNdEx:]))
+			iNdEx += 8
+			m.Value = float64(math.Float64frombits(v))
 		default:
 			iNdEx = preIndex
 			skippy, err := skipRpc(dAtA[iNdEx:])
@@ -1869,40 +3242,62 @@ var (
 func init() { proto.RegisterFile("rpc.proto", fileDescriptorRpc) }
 
 var fileDescriptorRpc = []byte{
-	// 555 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x7c, 0x54, 0x5d, 0x6f, 0xd2, 0x50,
-	0x18, 0xa6, 0x94, 0x16, 0x78, 0x3b, 0x48, 0x3d, 0xb0, 0xa5, 0xd4, 0x04, 0x49, 0xaf, 0xc8, 0x34,
-	0xa8, 0x98, 0x98, 0x78, 0x09, 0x8b, 0xcb, 0x48, 0x04, 0x93, 0xc3, 0xe6, 0x8c, 0x37, 0xb3, 0xcc,
-	0x63, 0xd7, 0x84, 0xf6, 0xb0, 0x9e, 0x22, 0x78, 0xeb, 0xaf, 0xe3, 0xd2, 0x5f, 0xe0, 0x07, 0xbf,
-	0xc4, 0x9c, 0x8f, 0x32, 0x6a, 0xa6, 0x77, 0xef, 0xfb, 0x3c, 0x6f, 0x9f, 0xf7, 0xe3, 0x39, 0x29,
-	0x54, 0x93, 0xc5, 0x75, 0x6f, 0x91, 0xd0, 0x94, 0x22, 0x33, 0xbd, 0xf1, 0x63, 0xca, 0x5c, 0x2b,
-	0xfd, 0xba, 0x20, 0x4c, 0x82, 0x6e, 0x33, 0xa0, 0x01, 0x15, 0xe1, 0x53, 0x1e, 0x49, 0xd4, 0xab,
-	0x81, 0x35, 0x8a, 0x3f, 0x53, 0x4c, 0x6e, 0x97, 0x84, 0xa5, 0xde, 0x2d, 0x1c, 0xc8, 0x94, 0x2d,
-	0x68, 0xcc, 0x08, 0x7a, 0x0c, 0xe6, 0xdc, 0x9f, 0x91, 0x39, 0x73, 0xb4, 0x8e, 0xde, 0xb5, 0xfa,
-	0xb5, 0x9e, 0x94, 0xee, 0xbd, 0xe1, 0xe8, 0xb0, 0xb4, 0xf9, 0xf1, 0xa8, 0x80, 0x55, 0x09, 0x6a,
-	0x41, 0x25, 0x0a, 0xe3, 0xab, 0x34, 0x8c, 0x88, 0x53, 0xec, 0x68, 0x5d, 0x1d, 0x97, 0xa3, 0x30,
-	0x3e, 0x0f, 0x23, 0x22, 0x28, 0x7f, 0x2d, 0x29, 0x5d, 0x51, 0xfe, 0x9a, 0x53, 0xde, 0x4f, 0x0d,
-	0x6a, 0x53, 0x92, 0x84, 0x84, 0xa9, 0x21, 0x72, 0x3a, 0xda, 0xbf, 0x75, 0x8a, 0x39, 0x1d, 0xf4,
-	0x92, 0x53, 0xe9, 0xf5, 0x0d, 0x49, 0x98, 0xa3, 0x8b, 0x61, 0x9b, 0xb9, 0x61, 0xc7, 0x92, 0x54,
-	0x33, 0xef, 0x6a, 0x51, 0x1f, 0x0e, 0xb9, 0x64, 0x42, 0x18, 0x9d, 0x2f, 0xd3, 0x90, 0xc6, 0x57,
-	0xab, 0x30, 0xfe, 0x44, 0x57, 0x4e, 0x49, 0xe8, 0x37, 0x22, 0x7f, 0x8d, 0x77, 0xdc, 0xa5, 0xa0,
-	0xd0, 0x13, 0x00, 0x3f, 0x08, 0x12, 0x12, 0xf8, 0x29, 0x61, 0x8e, 0xd1, 0xd1, 0xbb, 0xf5, 0xfe,
-	0x41, 0xd6, 0x6d, 0x10, 0x04, 0x09, 0xde, 0xe3, 0xbd, 0x8f, 0x50, 0xcf, 0x16, 0x54, 0x67, 0xed,
-	0x82, 0xc9, 0x04, 0x22, 0xf6, 0xb3, 0xfa, 0xf5, 0xec, 0x5b, 0x59, 0x77, 0x56, 0xc0, 0x8a, 0x47,
-	0x2e, 0x94, 0x57, 0x7e, 0x12, 0x87, 0x71, 0x20, 0xf6, 0xad, 0x9e, 0x15, 0x70, 0x06, 0x0c, 0x2b,
-	0x60, 0x26, 0x84, 0x2d, 0xe7, 0xa9, 0xd7, 0x80, 0x07, 0x62, 0xc7, 0x89, 0x1f, 0xed, 0xce, 0xe8,
-	0x9d, 0x02, 0xda, 0x07, 0x55, 0xeb, 0x26, 0x18, 0x31, 0x07, 0x84, 0xa1, 0x55, 0x2c, 0x13, 0xe4,
-	0x42, 0x45, 0xa9, 0x32, 0xa7, 0x28, 0x88, 0x5d, 0xee, 0x1d, 0x2b, 0x9d, 0x77, 0xfe, 0x7c, 0x79,
-	0x67, 0x52, 0x13, 0x0c, 0x61, 0xbb, 0xd8, 0xa0, 0x8a, 0x65, 0xe2, 0x8d, 0xa0, 0x91, 0xab, 0x55,
-	0x4d, 0x8f, 0xc0, 0xfc, 0x22, 0x10, 0xd5, 0x55, 0x65, 0xff, 0x6b, 0x7b, 0x3c, 0x84, 0x12, 0xbf,
-	0x24, 0x2a, 0x83, 0x8e, 0x07, 0x97, 0x76, 0x01, 0x55, 0xc1, 0x38, 0x79, 0x7b, 0x31, 0x39, 0xb7,
-	0x35, 0x8e, 0x4d, 0x2f, 0xc6, 0x76, 0x91, 0x07, 0xe3, 0xd1, 0xc4, 0xd6, 0x45, 0x30, 0x78, 0x6f,
-	0x97, 0x90, 0x05, 0x65, 0x51, 0xf5, 0x1a, 0xdb, 0x46, 0xff, 0x5b, 0x11, 0x8c, 0x69, 0x4a, 0x13,
-	0x82, 0x9e, 0x43, 0x89, 0x3f, 0x6c, 0xd4, 0xc8, 0x2e, 0xbd, 0xf7, 0xea, 0xdd, 0x66, 0x1e, 0x54,
-	0x43, 0xbf, 0x02, 0x53, 0xda, 0x81, 0x0e, 0xf3, 0xf6, 0x64, 0x9f, 0x1d, 0xfd, 0x0d, 0xcb, 0x0f,
-	0x9f, 0x69, 0xe8, 0x04, 0xe0, 0xee, 0xf4, 0xa8, 0x95, 0x7b, 0x87, 0xfb, 0x1e, 0xb9, 0xee, 0x7d,
-	0x94, 0xea, 0x7f, 0x0a, 0xd6, 0xde, 0x2d, 0x51, 0xbe, 0x34, 0x67, 0x86, 0xfb, 0xf0, 0x5e, 0x4e,
-	0xea, 0x0c, 0x5b, 0x9b, 0xdf, 0xed, 0xc2, 0x66, 0xdb, 0xd6, 0xbe, 0x6f, 0xdb, 0xda, 0xaf, 0x6d,
-	0x5b, 0xfb, 0x50, 0x66, 0xfc, 0x26, 0x8b, 0xd9, 0xcc, 0x14, 0x3f, 0x81, 0x17, 0x7f, 0x02, 0x00,
-	0x00, 0xff, 0xff, 0x4c, 0xf3, 0x8b, 0x29, 0x3c, 0x04, 0x00, 0x00,
+	// 899 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x94, 0x55, 0x5d, 0x6f, 0xe3, 0x44,
+	0x14, 0x8d, 0xe3, 0xc4, 0x69, 0xae, 0x93, 0x2a, 0x4c, 0xd2, 0xca, 0x0d, 0xa8, 0x44, 0x96, 0x90,
+	0xa2, 0x05, 0x75, 0x21, 0xac, 0x90, 0x10, 0x1f, 0x52, 0x5b, 0x51, 0xb6, 0x12, 0x2d, 0x62, 0xba,
+	0x65, 0x81, 0x07, 0xb2, 0x93, 0x74, 0xd6, 0x31, 0xeb, 0xaf, 0xf5, 0x8c, 0x69, 0xf7, 0x95, 0x3f,
+	0xc6, 0x6b, 0x1f, 0xf7, 0x17, 0x20, 0xe8, 0x2f, 0x41, 0x73, 0x67, 0x9c, 0xda, 0x55, 0x77, 0x05,
+	0x6f, 0x33, 0xe7, 0xdc, 0x99, 0x7b, 0xe7, 0x9c, 0x7b, 0x6d, 0xe8, 0xe6, 0xd9, 0x72, 0x2f, 0xcb,
+	0x53, 0x99, 0x12, 0x47, 0xae, 0x58, 0x92, 0x8a, 0xb1, 0x2b, 0x5f, 0x65, 0x5c, 0x68, 0x70, 0x3c,
+	0x0a, 0xd2, 0x20, 0xc5, 0xe5, 0x43, 0xb5, 0xd2, 0xa8, 0xbf, 0x0f, 0xee, 0x71, 0xf2, 0x3c, 0xa5,
+	0xfc, 0x65, 0xc1, 0x85, 0x24, 0x33, 0xd8, 0x8a, 0xb9, 0xcc, 0xc3, 0xe5, 0x3c, 0x61, 0x31, 0x17,
+	0xf3, 0xe5, 0x8a, 0x2f, 0x5f, 0x88, 0x22, 0xf6, 0xac, 0x89, 0x35, 0x6d, 0xd1, 0xa1, 0x26, 0x4f,
+	0x15, 0x77, 0x68, 0x28, 0xff, 0xcf, 0x26, 0xf4, 0xf4, 0x1d, 0x22, 0x4b, 0x13, 0xc1, 0xc9, 0x87,
+	0xe0, 0x44, 0x6c, 0xc1, 0x23, 0xe1, 0x59, 0x13, 0x7b, 0xea, 0xce, 0xfa, 0x7b, 0xba, 0x9e, 0xbd,
+	0xef, 0x14, 0x7a, 0xd0, 0xba, 0xfe, 0xeb, 0xfd, 0x06, 0x35, 0x21, 0x64, 0x07, 0x36, 0xe2, 0x30,
+	0x99, 0xcb, 0x30, 0xe6, 0x5e, 0x73, 0x62, 0x4d, 0x6d, 0xda, 0x89, 0xc3, 0xe4, 0x49, 0x18, 0x73,
+	0xa4, 0xd8, 0x95, 0xa6, 0x6c, 0x43, 0xb1, 0x2b, 0xa4, 0xbe, 0x80, 0x5e, 0xb5, 0x4e, 0xaf, 0x35,
+	0xb1, 0xa6, 0xee, 0xcc, 0x2b, 0x13, 0x9d, 0xac, 0xcb, 0x3c, 0x0a, 0x23, 0xc9, 0x73, 0xea, 0x56,
+	0x0a, 0x57, 0x8f, 0x14, 0x45, 0x96, 0xa5, 0xb9, 0x14, 0xf3, 0x98, 0xc9, 0xe5, 0x8a, 0xe7, 0x73,
+	0xc1, 0xa5, 0xf0, 0xda, 0x13, 0x6b, 0xba, 0x41, 0x87, 0x25, 0x79, 0xa2, 0xb9, 0x33, 0x2e, 0x05,
+	0x79, 0x04, 0xdb, 0x35, 0x61, 0x8a, 0x64, 0xb9, 0x62, 0x49, 0xc0, 0x2f, 0x3c, 0x07, 0x0f, 0x8d,
+	0x2a, 0x09, 0xce, 0x4b, 0x8e, 0x7c, 0x00, 0x9b, 0xeb, 0x4c, 0x2f, 0x0b, 0x9e, 0xbf, 0xf2, 0x3a,
+	0x18, 0xdd, 0x2f, 0xd1, 0x1f, 0x14, 0xe8, 0xff, 0x0c, 0x83, 0xbb, 0x15, 0x13, 0x02, 0xad, 0x45,
+	0x28, 0x05, 0x0a, 0xdf, 0xa3, 0xb8, 0x26, 0xdb, 0xe0, 0xac, 0x98, 0x58, 0x71, 0x81, 0x4a, 0xf5,
+	0xa9, 0xd9, 0xbd, 0x45, 0x28, 0xff, 0x75, 0x13, 0xfa, 0x67, 0x3c, 0x0f, 0xb9, 0x28, 0x2d, 0xae,
+	0x0a, 0x6e, 0xbd, 0x59, 0xf0, 0x66, 0x5d, 0xf0, 0xcf, 0x14, 0x85, 0x72, 0x08, 0xcf, 0x46, 0x57,
+	0x47, 0x35, 0x57, 0x8d, 0x56, 0xc6, 0xdc, 0x75, 0x2c, 0x36, 0x14, 0xbb, 0x9a, 0xe7, 0x5c, 0xa4,
+	0x51, 0x21, 0xc3, 0x34, 0x99, 0x5f, 0x86, 0xc9, 0x45, 0x7a, 0x89, 0x8e, 0xd9, 0x74, 0x18, 0xb3,
+	0x2b, 0xba, 0xe6, 0x9e, 0x22, 0x45, 0x3e, 0x02, 0x60, 0x41, 0x90, 0xf3, 0x80, 0x49, 0xae, 0x4c,
+	0xb1, 0xa7, 0x9b, 0xb3, 0x5e, 0x99, 0x6d, 0x3f, 0x08, 0x72, 0x5a, 0xe1, 0xc9, 0xd7, 0xd0, 0xab,
+	0x99, 0xe8, 0x60, 0x75, 0x5b, 0xf7, 0x55, 0x27, 0x4c, 0x79, 0x6e, 0x5c, 0x71, 0xf6, 0x21, 0x0c,
+	0x2b, 0xd5, 0x3d, 0x67, 0x51, 0xb4, 0x60, 0xcb, 0x17, 0xc6, 0x28, 0x72, 0x4b, 0x1d, 0x19, 0xc6,
+	0xff, 0x16, 0xfa, 0xb5, 0x4b, 0x6b, 0xda, 0x58, 0xff, 0x5d, 0x1b, 0xff, 0x19, 0x6c, 0x96, 0xd6,
+	0x98, 0xc9, 0x99, 0x82, 0x23, 0x10, 0x41, 0x67, 0xdc, 0xd9, 0x66, 0x79, 0x8f, 0x8e, 0x7b, 0xdc,
+	0xa0, 0x86, 0x27, 0x63, 0xe8, 0x5c, 0xb2, 0x3c, 0x09, 0x93, 0x00, 0x9d, 0xea, 0x3e, 0x6e, 0xd0,
+	0x12, 0x38, 0xd8, 0x00, 0x27, 0xe7, 0xa2, 0x88, 0xa4, 0x3f, 0x84, 0x77, 0xb0, 0x02, 0x6c, 0x4b,
+	0xd3, 0x00, 0xfe, 0x11, 0x90, 0x2a, 0x68, 0x52, 0x8f, 0xa0, 0xad, 0x47, 0x49, 0xbd, 0xa0, 0x4b,
+	0xf5, 0x86, 0x8c, 0x61, 0xc3, 0xdc, 0xaa, 0x7a, 0x4e, 0x11, 0xeb, 0xbd, 0xff, 0xc0, 0xdc, 0xf3,
+	0x23, 0x8b, 0x8a, 0xdb, 0xf6, 0x1a, 0x41, 0x1b, 0x27, 0x1b, 0x5f, 0xd0, 0xa5, 0x7a, 0xe3, 0x1f,
+	0xc3, 0xb0, 0x16, 0x6b, 0x92, 0x6e, 0x83, 0xf3, 0x3b, 0x22, 0x26, 0xab, 0xd9, 0xbd, 0x35, 0xed,
+	0x33, 0xe8, 0xe1, 0xd4, 0x54, 0x12, 0xea, 0xd1, 0x32, 0x09, 0x71, 0xa3, 0x50, 0x21, 0x59, 0x2e,
+	0x4d, 0x1f, 0xeb, 0x0d, 0x19, 0x80, 0xcd, 0x93, 0x0b, 0x33, 0x23, 0x6a, 0xa9, 0xc6, 0x4c, 0x48,
+	0x9e, 0x99, 0x76, 0xc4, 0xb5, 0xff, 0x2b, 0xf4, 0x4d, 0x06, 0x53, 0xe6, 0x27, 0x15, 0x5b, 0x94,
+	0xbd, 0xc3, 0xd2, 0x16, 0x0c, 0xd3, 0xde, 0x94, 0x9f, 0xb5, 0xb5, 0x3f, 0x6f, 0x7e, 0xc1, 0x6f,
+	0xe0, 0x56, 0x0e, 0xfe, 0xbf, 0xcf, 0xe5, 0x1e, 0x74, 0x04, 0x8b, 0xb3, 0x88, 0xeb, 0x6b, 0xab,
+	0x2d, 0x82, 0xb0, 0x09, 0x2f, 0x83, 0xfc, 0x2f, 0xc1, 0xd1, 0x04, 0x79, 0x0f, 0xba, 0x6a, 0xb0,
+	0x85, 0x64, 0x71, 0x66, 0x06, 0xff, 0x16, 0x50, 0x7a, 0xa1, 0xf6, 0xa8, 0x97, 0x45, 0xf5, 0xe6,
+	0xc1, 0x01, 0xb4, 0xd4, 0xbc, 0x91, 0x0e, 0xd8, 0x74, 0xff, 0xe9, 0xa0, 0x41, 0xba, 0xd0, 0x3e,
+	0xfc, 0xfe, 0xfc, 0xf4, 0xc9, 0xc0, 0x52, 0xd8, 0xd9, 0xf9, 0xc9, 0xa0, 0xa9, 0x16, 0x27, 0xc7,
+	0xa7, 0x03, 0x1b, 0x17, 0xfb, 0x3f, 0x0d, 0x5a, 0xc4, 0x85, 0x0e, 0x46, 0x7d, 0x43, 0x07, 0xed,
+	0xd9, 0x1f, 0x4d, 0x68, 0x9f, 0xc9, 0x34, 0x57, 0x32, 0xb6, 0xd4, 0x7f, 0x82, 0xac, 0xe5, 0xab,
+	0xfc, 0x79, 0xc6, 0xa3, 0x3a, 0x68, 0x94, 0xff, 0x1c, 0x1c, 0xa3, 0xd2, 0x56, 0x7d, 0x14, 0xca,
+	0x63, 0xdb, 0x77, 0x61, 0x7d, 0xf0, 0x63, 0x8b, 0x1c, 0x02, 0xdc, 0xb6, 0x39, 0xd9, 0xa9, 0x89,
+	0x5a, 0x9d, 0x87, 0xf1, 0xf8, 0x3e, 0xca, 0xe4, 0x3f, 0x02, 0xb7, 0xd2, 0xb7, 0xa4, 0x1e, 0x5a,
+	0x6b, 0xfc, 0xf1, 0xbb, 0xf7, 0x72, 0xfa, 0x9e, 0xd9, 0x57, 0xd0, 0x46, 0xcb, 0xc9, 0xa3, 0x72,
+	0x31, 0xaa, 0xf5, 0x50, 0x79, 0xc9, 0xd6, 0x1d, 0x54, 0x1f, 0x3f, 0xd8, 0xb9, 0xfe, 0x67, 0xb7,
+	0x71, 0x7d, 0xb3, 0x6b, 0xbd, 0xbe, 0xd9, 0xb5, 0xfe, 0xbe, 0xd9, 0xb5, 0x7e, 0xe9, 0x08, 0x25,
+	0x69, 0xb6, 0x58, 0x38, 0xf8, 0x1f, 0xff, 0xf4, 0xdf, 0x01, 0x00, 0x5f, 0x04, 0xa8, 0x6b, 0xff,
+	0x07, 0x00, 0x00,
 }
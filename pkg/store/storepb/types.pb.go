@@ -99,6 +99,8 @@ type AggrChunk struct {
 	Min     *Chunk `protobuf:"bytes,6,opt,name=min" json:"min,omitempty"`
 	Max     *Chunk `protobuf:"bytes,7,opt,name=max" json:"max,omitempty"`
 	Counter *Chunk `protobuf:"bytes,8,opt,name=counter" json:"counter,omitempty"`
+	// / resolution is the resolution of the downsampled data of the chunk in milliseconds. It is zero for raw data.
+	Resolution int64 `protobuf:"varint,9,opt,name=resolution,proto3" json:"resolution,omitempty"`
 }
 
 func (m *AggrChunk) Reset()                    { *m = AggrChunk{} }
@@ -313,6 +315,11 @@ func (m *AggrChunk) MarshalTo(dAtA []byte) (int, error) {
 		}
 		i += n6
 	}
+	if m.Resolution != 0 {
+		dAtA[i] = 0x48
+		i++
+		i = encodeVarintTypes(dAtA, i, uint64(m.Resolution))
+	}
 	return i, nil
 }
 
@@ -438,6 +445,9 @@ func (m *AggrChunk) Size() (n int) {
 		l = m.Counter.Size()
 		n += 1 + l + sovTypes(uint64(l))
 	}
+	if m.Resolution != 0 {
+		n += 1 + sovTypes(uint64(m.Resolution))
+	}
 	return n
 }
 
@@ -1056,6 +1066,25 @@ func (m *AggrChunk) Unmarshal(dAtA []byte) error {
 				return err
 			}
 			iNdEx = postIndex
+		case 9:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Resolution", wireType)
+			}
+			m.Resolution = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowTypes
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Resolution |= (int64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
 		default:
 			iNdEx = preIndex
 			skippy, err := skipTypes(dAtA[iNdEx:])
@@ -1312,32 +1341,33 @@ var (
 func init() { proto.RegisterFile("types.proto", fileDescriptorTypes) }
 
 var fileDescriptorTypes = []byte{
-	// 432 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x6c, 0x92, 0xdd, 0x6e, 0xd3, 0x30,
-	0x14, 0xc7, 0xeb, 0x7c, 0x76, 0x67, 0x03, 0x05, 0x33, 0x21, 0x97, 0x8b, 0xae, 0x0a, 0x17, 0x54,
-	0x20, 0x32, 0x31, 0x9e, 0x80, 0xa1, 0xdc, 0xf1, 0xa1, 0x99, 0x5d, 0x20, 0x84, 0x84, 0xdc, 0xce,
-	0xa4, 0x11, 0x8d, 0x5d, 0xc5, 0x0e, 0x74, 0x8f, 0x81, 0x78, 0xa9, 0x5e, 0xf2, 0x04, 0x08, 0xfa,
-	0x24, 0xc8, 0x27, 0x0d, 0x5b, 0xa5, 0xdc, 0x1d, 0x9f, 0xff, 0xef, 0x7c, 0xc8, 0xe7, 0x0f, 0x87,
-	0xf6, 0x7a, 0x25, 0x4d, 0xb6, 0xaa, 0xb5, 0xd5, 0x34, 0xb2, 0x0b, 0xa1, 0xb4, 0x79, 0x78, 0x5c,
-	0xe8, 0x42, 0x63, 0xea, 0xd4, 0x45, 0xad, 0x9a, 0x3e, 0x87, 0xf0, 0xb5, 0x98, 0xc9, 0x25, 0xa5,
-	0x10, 0x28, 0x51, 0x49, 0x46, 0x26, 0x64, 0x7a, 0xc0, 0x31, 0xa6, 0xc7, 0x10, 0x7e, 0x13, 0xcb,
-	0x46, 0x32, 0x0f, 0x93, 0xed, 0x23, 0xfd, 0x04, 0xe1, 0xab, 0x45, 0xa3, 0xbe, 0xd2, 0x27, 0x10,
-	0xb8, 0x41, 0x58, 0x72, 0xf7, 0xec, 0x41, 0xd6, 0x0e, 0xca, 0x50, 0xcc, 0x72, 0x35, 0xd7, 0x57,
-	0xa5, 0x2a, 0x38, 0x32, 0xae, 0xfd, 0x95, 0xb0, 0x02, 0x3b, 0x1d, 0x71, 0x8c, 0xd3, 0xfb, 0x30,
-	0xec, 0x28, 0x1a, 0x83, 0xff, 0xe1, 0x1d, 0x4f, 0x06, 0xe9, 0x17, 0x88, 0xde, 0xcb, 0xba, 0x94,
-	0x86, 0x3e, 0x85, 0x68, 0xe9, 0x56, 0x33, 0x8c, 0x4c, 0xfc, 0xe9, 0xe1, 0xd9, 0x9d, 0x6e, 0x00,
-	0x2e, 0x7c, 0x1e, 0x6c, 0x7e, 0x9f, 0x0c, 0xf8, 0x0e, 0xa1, 0xa7, 0x10, 0xcd, 0xdd, 0x5c, 0xc3,
-	0x3c, 0x84, 0xef, 0x75, 0xf0, 0xcb, 0xa2, 0xa8, 0x71, 0xa3, 0xae, 0xa0, 0xc5, 0xd2, 0x9f, 0x1e,
-	0x1c, 0xfc, 0xd7, 0xe8, 0x08, 0x86, 0x55, 0xa9, 0x3e, 0xdb, 0x72, 0xf7, 0x03, 0x3e, 0x8f, 0xab,
-	0x52, 0x5d, 0x96, 0x95, 0x44, 0x49, 0xac, 0x5b, 0xc9, 0xdb, 0x49, 0x62, 0x8d, 0xd2, 0x09, 0xf8,
-	0xb5, 0xf8, 0xce, 0xfc, 0x09, 0xb9, 0xbd, 0x1e, 0x76, 0xe4, 0x4e, 0xa1, 0x8f, 0x20, 0x9c, 0xeb,
-	0x46, 0x59, 0x16, 0xf4, 0x21, 0xad, 0xe6, 0xba, 0x98, 0xa6, 0x62, 0x61, 0x6f, 0x17, 0xd3, 0x54,
-	0x0e, 0xa8, 0x4a, 0xc5, 0xa2, 0x5e, 0xa0, 0x2a, 0x15, 0x02, 0x62, 0xcd, 0xe2, 0x7e, 0x40, 0xac,
-	0xe9, 0x63, 0x88, 0x71, 0x96, 0xac, 0xd9, 0xb0, 0x0f, 0xea, 0xd4, 0xf4, 0x07, 0x81, 0x23, 0xfc,
-	0xde, 0x37, 0xc2, 0xce, 0x17, 0xb2, 0xa6, 0xcf, 0xf6, 0x6e, 0x3c, 0xda, 0x3b, 0xc1, 0x8e, 0xc9,
-	0x2e, 0xaf, 0x57, 0xf2, 0xe6, 0xcc, 0xe8, 0x22, 0xaf, 0xcf, 0x45, 0xfe, 0x6d, 0x17, 0x4d, 0x21,
-	0x70, 0x75, 0x34, 0x02, 0x2f, 0xbf, 0x48, 0x06, 0xce, 0x00, 0x6f, 0xf3, 0x8b, 0x84, 0xb8, 0x04,
-	0xcf, 0x13, 0x0f, 0x13, 0x3c, 0x4f, 0xfc, 0xf3, 0xd1, 0xe6, 0xef, 0x78, 0xb0, 0xd9, 0x8e, 0xc9,
-	0xaf, 0xed, 0x98, 0xfc, 0xd9, 0x8e, 0xc9, 0xc7, 0xd8, 0x58, 0x5d, 0xcb, 0xd5, 0x6c, 0x16, 0xa1,
-	0x89, 0x5f, 0xfc, 0x0b, 0x00, 0x00, 0xff, 0xff, 0x46, 0x5a, 0xe2, 0x68, 0xf1, 0x02, 0x00, 0x00,
+	// 445 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x6c, 0x92, 0x4d, 0x6e, 0xd3, 0x40,
+	0x14, 0xc7, 0xe3, 0xef, 0xe6, 0xb5, 0x20, 0x33, 0x54, 0x68, 0xc2, 0xc2, 0x8d, 0xcc, 0x82, 0x08,
+	0x84, 0x2b, 0xca, 0x09, 0x28, 0xf2, 0x8e, 0x0f, 0xd5, 0x74, 0x81, 0x10, 0x12, 0x9a, 0xa4, 0x83,
+	0x33, 0x22, 0x9e, 0x89, 0x3c, 0x63, 0x48, 0x8f, 0xc1, 0x59, 0xb8, 0x44, 0x96, 0x9c, 0x00, 0x41,
+	0x4e, 0x82, 0xe6, 0xd9, 0x86, 0x54, 0x78, 0xf7, 0xfc, 0xfe, 0xbf, 0xf7, 0xe1, 0x37, 0x7f, 0x38,
+	0x34, 0xd7, 0x6b, 0xae, 0xb3, 0x75, 0xad, 0x8c, 0x22, 0xa1, 0x59, 0x32, 0xa9, 0xf4, 0xfd, 0xe3,
+	0x52, 0x95, 0x0a, 0x53, 0xa7, 0x36, 0x6a, 0xd5, 0xf4, 0x29, 0x04, 0x2f, 0xd9, 0x9c, 0xaf, 0x08,
+	0x01, 0x5f, 0xb2, 0x8a, 0x53, 0x67, 0xea, 0xcc, 0xc6, 0x05, 0xc6, 0xe4, 0x18, 0x82, 0x2f, 0x6c,
+	0xd5, 0x70, 0xea, 0x62, 0xb2, 0xfd, 0x48, 0x3f, 0x40, 0xf0, 0x62, 0xd9, 0xc8, 0xcf, 0xe4, 0x11,
+	0xf8, 0x76, 0x10, 0x96, 0xdc, 0x3e, 0xbb, 0x97, 0xb5, 0x83, 0x32, 0x14, 0xb3, 0x5c, 0x2e, 0xd4,
+	0x95, 0x90, 0x65, 0x81, 0x8c, 0x6d, 0x7f, 0xc5, 0x0c, 0xc3, 0x4e, 0x47, 0x05, 0xc6, 0xe9, 0x5d,
+	0x38, 0xe8, 0x29, 0x12, 0x81, 0xf7, 0xee, 0x4d, 0x11, 0x8f, 0xd2, 0x4f, 0x10, 0xbe, 0xe5, 0xb5,
+	0xe0, 0x9a, 0x3c, 0x86, 0x70, 0x65, 0x57, 0xd3, 0xd4, 0x99, 0x7a, 0xb3, 0xc3, 0xb3, 0x5b, 0xfd,
+	0x00, 0x5c, 0xf8, 0xdc, 0xdf, 0xfe, 0x3c, 0x19, 0x15, 0x1d, 0x42, 0x4e, 0x21, 0x5c, 0xd8, 0xb9,
+	0x9a, 0xba, 0x08, 0xdf, 0xe9, 0xe1, 0xe7, 0x65, 0x59, 0xe3, 0x46, 0x7d, 0x41, 0x8b, 0xa5, 0xdf,
+	0x5d, 0x18, 0xff, 0xd5, 0xc8, 0x04, 0x0e, 0x2a, 0x21, 0x3f, 0x1a, 0xd1, 0x5d, 0xc0, 0x2b, 0xa2,
+	0x4a, 0xc8, 0x4b, 0x51, 0x71, 0x94, 0xd8, 0xa6, 0x95, 0xdc, 0x4e, 0x62, 0x1b, 0x94, 0x4e, 0xc0,
+	0xab, 0xd9, 0x57, 0xea, 0x4d, 0x9d, 0xfd, 0xf5, 0xb0, 0x63, 0x61, 0x15, 0xf2, 0x00, 0x82, 0x85,
+	0x6a, 0xa4, 0xa1, 0xfe, 0x10, 0xd2, 0x6a, 0xb6, 0x8b, 0x6e, 0x2a, 0x1a, 0x0c, 0x76, 0xd1, 0x4d,
+	0x65, 0x81, 0x4a, 0x48, 0x1a, 0x0e, 0x02, 0x95, 0x90, 0x08, 0xb0, 0x0d, 0x8d, 0x86, 0x01, 0xb6,
+	0x21, 0x0f, 0x21, 0xc2, 0x59, 0xbc, 0xa6, 0x07, 0x43, 0x50, 0xaf, 0x92, 0x04, 0xa0, 0xe6, 0x5a,
+	0xad, 0x1a, 0x23, 0x94, 0xa4, 0x63, 0xfc, 0xdd, 0xbd, 0x4c, 0xfa, 0xcd, 0x81, 0x23, 0x3c, 0xff,
+	0x2b, 0x66, 0x16, 0x4b, 0x5e, 0x93, 0x27, 0x37, 0x3c, 0x30, 0xb9, 0xf1, 0x44, 0x1d, 0x93, 0x5d,
+	0x5e, 0xaf, 0xf9, 0x3f, 0x1b, 0x48, 0xd6, 0x1d, 0xf2, 0x3f, 0x97, 0x79, 0xfb, 0x2e, 0x9b, 0x81,
+	0x6f, 0xeb, 0x48, 0x08, 0x6e, 0x7e, 0x11, 0x8f, 0xac, 0x41, 0x5e, 0xe7, 0x17, 0xb1, 0x63, 0x13,
+	0x45, 0x1e, 0xbb, 0x98, 0x28, 0xf2, 0xd8, 0x3b, 0x9f, 0x6c, 0x7f, 0x27, 0xa3, 0xed, 0x2e, 0x71,
+	0x7e, 0xec, 0x12, 0xe7, 0xd7, 0x2e, 0x71, 0xde, 0x47, 0xda, 0xa8, 0x9a, 0xaf, 0xe7, 0xf3, 0x10,
+	0x4d, 0xfe, 0xec, 0xcf, 0x00, 0xb3, 0x12, 0x6e, 0x4e, 0x11, 0x03, 0x00, 0x00,
 }
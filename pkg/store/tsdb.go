@@ -6,10 +6,12 @@ import (
 	"sort"
 
 	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
 	"github.com/improbable-eng/thanos/pkg/runutil"
 	"github.com/improbable-eng/thanos/pkg/store/storepb"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
+	promlabels "github.com/prometheus/prometheus/pkg/labels"
 	"github.com/prometheus/tsdb"
 	"github.com/prometheus/tsdb/chunkenc"
 	"github.com/prometheus/tsdb/labels"
@@ -41,9 +43,10 @@ func NewTSDBStore(logger log.Logger, reg prometheus.Registerer, db *tsdb.DB, ext
 // Info returns store information about the Prometheus instance.
 func (s *TSDBStore) Info(ctx context.Context, r *storepb.InfoRequest) (*storepb.InfoResponse, error) {
 	res := &storepb.InfoResponse{
-		MinTime: 0,
-		MaxTime: math.MaxInt64,
-		Labels:  make([]storepb.Label, 0, len(s.labels)),
+		MinTime:             0,
+		MaxTime:             math.MaxInt64,
+		Labels:              make([]storepb.Label, 0, len(s.labels)),
+		SupportsMatcherSets: true,
 	}
 	if blocks := s.db.Blocks(); len(blocks) > 0 {
 		res.MinTime = blocks[0].Meta().MinTime
@@ -54,23 +57,42 @@ func (s *TSDBStore) Info(ctx context.Context, r *storepb.InfoRequest) (*storepb.
 			Value: l.Value,
 		})
 	}
+	// The names are read on every call, so that newly written metrics are advertised
+	// as soon as clients refresh the store information. Samples can no longer be appended before the start of the
+	// head, so series of new names are only written after it. The start is read first as it only moves forward.
+	maxTime := int64(math.MinInt64)
+	if headMin := s.db.Head().MinTime(); headMin != math.MinInt64 {
+		maxTime = headMin - 1
+	}
+	names, err := s.metricNames()
+	if err != nil {
+		level.Warn(s.logger).Log("msg", "reading metric names failed, not advertising them", "err", err)
+	} else {
+		res.SetMetricNames(storepb.NewMetricNameFilter(names, maxTime), r)
+	}
 	return res, nil
 }
 
+func (s *TSDBStore) metricNames() ([]string, error) {
+	q, err := s.db.Querier(math.MinInt64, math.MaxInt64)
+	if err != nil {
+		return nil, errors.Wrap(err, "create querier")
+	}
+	defer runutil.CloseWithLogOnErr(s.logger, q, "close tsdb querier metric names")
+
+	return q.LabelValues(promlabels.MetricName)
+}
+
 // Series returns all series for a requested time range and label matcher. The returned data may
 // exceed the requested time bounds.
 func (s *TSDBStore) Series(r *storepb.SeriesRequest, srv storepb.Store_SeriesServer) error {
-	match, newMatchers, err := labelsMatches(s.labels, r.Matchers)
+	matcherSets, err := labelsMatchesSets(s.labels, r.AllMatcherSets())
 	if err != nil {
 		return status.Error(codes.InvalidArgument, err.Error())
 	}
-	if !match {
+	if len(matcherSets) == 0 {
 		return nil
 	}
-	matchers, err := translateMatchers(newMatchers)
-	if err != nil {
-		return status.Error(codes.InvalidArgument, err.Error())
-	}
 
 	// TODO(fabxc): An improvement over this trivial approach would be to directly
 	// use the chunks provided by TSDB in the response.
@@ -82,13 +104,23 @@ func (s *TSDBStore) Series(r *storepb.SeriesRequest, srv storepb.Store_SeriesSer
 	}
 	defer runutil.CloseWithLogOnErr(s.logger, q, "close tsdb querier series")
 
-	set, err := q.Select(matchers...)
-	if err != nil {
-		return status.Error(codes.Internal, err.Error())
+	var sets []tsdb.SeriesSet
+	for _, newMatchers := range matcherSets {
+		matchers, err := translateMatchers(newMatchers)
+		if err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+		set, err := q.Select(matchers...)
+		if err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+		sets = append(sets, set)
 	}
 
 	var respSeries storepb.Series
 
+	// Series matching several matcher sets are selected by each of them, but only sent once.
+	set := newDedupTSDBSeriesSet(sets...)
 	for set.Next() {
 		series := set.At()
 
@@ -104,6 +136,61 @@ func (s *TSDBStore) Series(r *storepb.SeriesRequest, srv storepb.Store_SeriesSer
 			return status.Error(codes.Aborted, err.Error())
 		}
 	}
+	if set.Err() != nil {
+		return status.Error(codes.Internal, set.Err().Error())
+	}
+	return nil
+}
+
+// dedupTSDBSeriesSet is the union of sorted series sets. Series contained in several sets are returned
+// from the first set containing them only.
+type dedupTSDBSeriesSet struct {
+	sets []tsdb.SeriesSet
+	ok   []bool
+	cur  tsdb.Series
+}
+
+func newDedupTSDBSeriesSet(sets ...tsdb.SeriesSet) *dedupTSDBSeriesSet {
+	s := &dedupTSDBSeriesSet{sets: sets, ok: make([]bool, len(sets))}
+	for i, set := range sets {
+		s.ok[i] = set.Next()
+	}
+	return s
+}
+
+func (s *dedupTSDBSeriesSet) Next() bool {
+	min := -1
+	for i, set := range s.sets {
+		if !s.ok[i] {
+			continue
+		}
+		if min < 0 || labels.Compare(set.At().Labels(), s.sets[min].At().Labels()) < 0 {
+			min = i
+		}
+	}
+	if min < 0 {
+		return false
+	}
+	s.cur = s.sets[min].At()
+
+	for i, set := range s.sets {
+		if s.ok[i] && set.At().Labels().Equals(s.cur.Labels()) {
+			s.ok[i] = set.Next()
+		}
+	}
+	return true
+}
+
+func (s *dedupTSDBSeriesSet) At() tsdb.Series {
+	return s.cur
+}
+
+func (s *dedupTSDBSeriesSet) Err() error {
+	for _, set := range s.sets {
+		if err := set.Err(); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -4,6 +4,7 @@
 // pkg/ui/templates/alerts.html
 // pkg/ui/templates/flags.html
 // pkg/ui/templates/graph.html
+// pkg/ui/templates/notifications.html
 // pkg/ui/templates/query_menu.html
 // pkg/ui/templates/rule_menu.html
 // pkg/ui/templates/rules.html
@@ -186,6 +187,26 @@ func pkgUiTemplatesGraphHtml() (*asset, error) {
 	return a, nil
 }
 
+var _pkgUiTemplatesNotificationsHtml = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x02\xff\x9d\x54\x4d\x8f\x9c\x30\x0c\xbd\xf3\x2b\x52\xee\x80\xd4\x43\x4f\x2c\xd2\x76\xdb\xdb\x6a\xa5\xee\xb6\xbd\x67\x88\x19\x22\x65\x12\x94\x84\x51\x11\xe2\xbf\xd7\x0e\x1f\x03\x53\x66\xb6\xed\x05\xe2\xd8\x79\xb6\xdf\x73\xd2\xf7\x02\x2a\xa9\x81\xc5\x35\x70\x11\x0f\x43\xfe\x21\x49\x98\x96\xbf\x58\x92\x14\x7d\x0f\x5a\x0c\x43\x14\xf5\x4b\x54\x69\xb4\x07\xed\x31\x30\x62\x2c\x17\xf2\xcc\x4a\xc5\x9d\x7b\x08\x0e\x8e\x21\x36\xa9\x54\x2b\x45\x5c\xa0\x1f\x23\xea\x8f\xc5\x8b\xf1\xb2\x92\x25\xf7\xd2\x68\xf6\xad\x85\x16\xf2\x0c\xb7\x47\xbf\xe7\x07\x05\x33\xc6\x68\x84\x6f\x82\x78\x02\xb4\x03\x31\xd9\x07\x63\x05\xd8\xc5\x74\xde\xca\x66\xb1\x6a\x73\x06\x3b\xa5\x24\xd0\x83\x11\xdd\x6c\x91\x6d\x2f\x06\x99\x75\xf1\x0c\xfa\xe8\xeb\x3c\xc3\xe5\xc6\x23\xb0\xe7\x34\xd4\x38\x46\x20\x1f\x19\x6e\x5e\xa0\xb2\x35\xd6\x0e\xf0\x13\x6f\x78\x29\x7d\x77\x0f\x7a\x8e\xf9\x67\xf0\x2f\xd6\x34\xd4\xf4\xa3\x02\xeb\xdd\x8d\x14\x53\xd0\x3d\x70\x5c\x5f\x18\x42\x83\x38\x2c\xa2\x45\xb0\x57\x28\x51\x62\xd5\xb1\x37\xfc\x2d\xc9\xde\x97\xec\x4a\xa2\x20\xca\xb5\x9a\x2b\x91\x36\xbd\xa2\x24\xfc\x00\xea\xaa\x29\xda\x7f\xf3\xdc\xc3\xce\x36\x8e\xe6\x4e\xf4\x33\x77\x9e\xea\x16\x7f\xba\x1e\xcb\x12\x1a\x8f\xe5\x7d\xee\x6e\x9c\xfb\x6a\xad\xb1\x6b\xdf\x9a\xb4\xbe\xb7\x5c\x1f\x81\xa5\x23\x21\xe1\x02\x4c\x8d\xcc\x64\xf4\xbd\xac\x58\x4a\x50\x01\x69\x18\x04\x9d\xb0\xd3\x35\x8a\xd7\x19\xc5\x5a\xb9\x05\x7a\xe4\x60\x81\x9e\x82\x5d\xc3\xf5\x9c\x42\x51\x04\x0b\xdf\xa4\xb1\xf2\xc4\x6d\x17\x93\xea\x2f\xfc\x04\xc3\x40\x25\xa4\x3f\xb9\x6a\x71\x1d\xe7\x19\x1d\xdc\xe6\x19\xef\xf3\x6a\x26\xc4\xb6\xa8\xb1\x83\x57\x70\x46\x9d\x69\x86\xec\xb4\xc2\x93\xca\x21\x68\x25\xad\xd4\xc7\x09\x67\xef\x78\x1a\x74\xb9\xe1\x23\x66\xc8\x9f\xfe\xf8\xfe\xb4\x17\xb2\x47\x49\x60\xfb\xc4\x35\x47\x22\xff\x9a\x19\xd7\xa2\xd6\xce\x05\x66\x28\xd1\xff\x10\xb1\xd6\x71\xed\xdf\xce\xc4\x48\xcb\xfe\x4c\x0b\x56\x1a\x45\xa9\x1f\xe2\x4f\x31\x3e\x83\x8c\x87\xc9\x61\x8e\xae\x55\x07\xfe\x0e\xea\x52\xdd\x72\x3b\x69\x89\xcf\x6d\x11\xcd\xde\xdf\x99\x09\x80\x65\xba\x05\x00\x00")
+
+func pkgUiTemplatesNotificationsHtmlBytes() ([]byte, error) {
+	return bindataRead(
+		_pkgUiTemplatesNotificationsHtml,
+		"pkg/ui/templates/notifications.html",
+	)
+}
+
+func pkgUiTemplatesNotificationsHtml() (*asset, error) {
+	bytes, err := pkgUiTemplatesNotificationsHtmlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "pkg/ui/templates/notifications.html", size: 1466, mode: os.FileMode(436), modTime: time.Unix(1792212685, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
 var _pkgUiTemplatesQuery_menuHtml = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\xbc\x54\x3d\x8f\x9c\x30\x10\xed\xef\x57\x8c\x7c\x52\x3a\xe2\x3e\x01\x8a\x44\xca\x87\x94\x22\x4a\xae\x8f\x06\x3c\x80\x15\x33\xb6\x6c\x43\xf6\x84\xf8\xef\x11\xb0\x20\x60\x6f\x73\x5d\x2a\xaf\x47\x6f\x66\xde\xbe\xf7\xf0\x30\x28\xaa\x34\x13\x08\xc6\x5e\x8c\xe3\x03\x00\x40\xca\xd8\x43\x69\x30\x84\x6c\x2a\x17\xe8\x61\x39\x12\xcd\x3d\xf9\x40\xeb\xb5\xd2\x17\x52\x49\xb4\x4e\xe4\x73\x23\x40\xaa\xf4\xd6\x5a\x5a\x8e\xa8\x99\x7c\x52\x99\x4e\xab\x0d\x73\x44\x5d\x47\x35\x84\x8a\xfc\x0e\x03\x90\x16\x5d\x8c\x96\x21\x3e\x3b\xca\xc4\x72\x11\xa7\xb6\x68\xeb\xda\x10\x94\xd6\x18\x74\x81\x94\x00\x85\x11\xaf\xe5\x89\xc2\x52\x5f\xcb\xe8\x6b\x8a\x99\x78\x5c\xba\x05\xa0\xd7\x98\xd0\xc5\x21\x2b\x52\x99\xa8\xd0\x4c\xd8\xb9\x3a\xb1\xf7\xd6\x6c\xab\x0e\xd4\x00\xd2\xe0\x90\x57\x32\xc1\x27\x96\xcd\xb3\xc8\x9f\x16\x3a\x8c\xbd\xae\x31\x6a\xcb\xa9\x9c\x70\xff\x68\xd5\xa5\xe5\x64\x1e\xff\xbf\xa0\xa9\x5c\xa4\x3c\xd4\xf0\xa4\x6b\xe1\x91\x95\x80\xc6\x53\x95\x89\x61\x00\x87\xb1\xf9\xee\xa9\xd2\x17\x18\x47\x29\xf2\xa7\x06\xd9\x86\x54\xe2\xce\x54\xa9\x74\x7f\xf2\x58\xab\x4d\xbe\xd3\x82\xd5\x99\xcd\xba\xa3\xf5\x9d\xd9\xe1\xd7\xb8\xed\x7e\x1a\xaa\xe2\xd9\x11\xa3\xf3\x14\xef\x51\xae\x3d\xba\x46\xe4\x9f\xa7\x63\xa2\x9d\x4a\xa3\x6f\xfa\xd7\x9d\xca\x5b\xa7\xec\x1f\x3e\x6d\x98\x75\x5a\xe6\x3f\x8a\x33\xf6\x9a\xb9\x53\x00\xb7\x49\xe0\xad\xd9\xa5\x78\x8e\x58\x83\xc1\x59\xd7\xb9\x4c\x44\xdf\xd1\x9d\x34\xe6\x3f\x23\xc6\x2e\x1c\xfd\x2d\xd1\x53\xdc\xcc\x3d\xd8\x70\x23\xe0\x46\xb0\x25\xee\x6e\xfe\xd1\x6b\xba\x85\x79\xbb\xc8\x7f\x74\x1c\x75\x4b\xf0\x06\x5b\xf7\x1e\x3e\x74\xda\x28\xf8\xca\x95\xf5\xed\x35\xe7\x2f\x49\xfa\xfa\xf8\xca\x60\x1d\x44\xfe\xd1\xb6\x2d\xb2\x4a\xbe\x4d\x4f\xd1\xa7\xa9\x76\x6f\x60\x2a\x3b\x73\xf2\xed\x25\x27\xef\x1a\xd7\xc4\xe8\xc2\x3b\x29\x6b\x1d\x9b\xae\x78\x5b\xda\x56\xea\xd6\x79\x5b\x60\x61\x28\x21\xae\x65\x9c\xa3\x2d\x60\x7d\x2c\x7e\x15\x06\xf9\xb7\xc8\xbf\x90\x71\x37\x4a\x9f\x97\x1f\xe9\x1d\x3e\x89\xdd\x25\x95\x8c\x7d\xfe\x30\x0c\xc4\x6a\x1c\x1f\xfe\x06\x00\x00\xff\xff\x8e\xc5\x0f\x01\x85\x05\x00\x00")
 
 func pkgUiTemplatesQuery_menuHtmlBytes() ([]byte, error) {
@@ -206,7 +227,7 @@ func pkgUiTemplatesQuery_menuHtml() (*asset, error) {
 	return a, nil
 }
 
-var _pkgUiTemplatesRule_menuHtml = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\xbc\x52\x41\xab\x9d\x3c\x10\xdd\xdf\x5f\x31\xe4\x5b\xfb\x65\x5f\xa2\xd0\x5d\x97\xa5\xbc\x7d\x19\xcd\xa8\xa1\xf3\x26\x21\x89\x72\x1f\xe2\x7f\x2f\xea\x53\x54\xb8\x85\x6e\xba\x8a\x73\x38\x67\xe6\x70\x8e\xd3\x64\xa9\x75\x42\xa0\x04\x47\x35\xcf\x0f\x00\x00\x23\x38\x42\xc3\x98\x52\xb9\xc0\x35\x46\xd8\x9e\xc2\xc9\x48\x31\xd1\x3e\xb6\xee\x49\xb6\xc8\x3e\xa8\x6a\x15\x02\x18\xeb\x0e\x69\xe3\x25\xa3\x13\x8a\x45\xcb\x83\xb3\x07\xe7\xca\xfa\x5c\xd5\x13\x5a\x8a\x27\x0e\x80\xa9\x87\x9c\xbd\x40\xfe\x08\x54\xaa\x6d\x50\x37\x59\xf6\x5d\xc7\x04\x8d\x67\xc6\x90\xc8\x2a\xb0\x98\xf1\x13\x5e\x2c\x6c\xf8\x0e\x63\xec\x28\x97\xea\xbf\x4d\xad\x00\xa3\xc3\x82\x9e\x01\xc5\x92\x2d\x55\x8b\xbc\x70\x57\x74\x71\x1f\x3d\x1f\xa7\x2e\xd6\x00\x4c\x0a\x28\xbb\x99\x14\x0b\x2f\xfc\xa1\xaa\xb7\xcd\x8e\xe0\xe8\x3a\xcc\xce\x8b\xd1\x0b\xef\x0f\x52\xd7\x78\x29\xd6\xf5\xff\x8a\x6a\xf4\x16\xe5\x05\xc3\x5b\xae\x75\x44\xb1\x0a\xfa\x48\x6d\xa9\xa6\x09\x02\xe6\xfe\x7b\xa4\xd6\x3d\x61\x9e\xb5\xaa\xde\x7a\x14\x9f\x8c\xc6\x53\xa9\xda\xba\xf1\xd6\xb1\xb3\x47\x7c\xb7\x03\x7b\x33\x47\x75\xd7\xea\x07\x3e\xf1\xf7\xdf\xed\xf4\xc9\xd4\xe6\x7b\x23\xec\x2a\x83\xaf\x2c\x23\x53\xcc\x49\x55\x5f\xd7\x77\x31\x6e\x34\xbb\xbf\xd9\x10\x07\xa6\xa4\xaa\x1f\xcb\xf3\x5a\x7f\x01\xd6\x60\xb7\x75\x7d\xce\x21\x7d\xd1\xba\x73\xb9\x1f\xea\xff\x1b\xff\xae\xdd\x7b\x88\xbe\xc6\x9a\xa9\x20\xe9\x74\x5e\x23\x55\xb0\xff\xa4\x3f\x6b\x46\xf9\xa5\xaa\x6f\xc4\xe1\x12\xf4\x16\xf6\xf5\x96\xd1\x03\xbf\xa8\xe2\x34\x18\x2d\x38\x56\x8f\x69\x22\xb1\xf3\xfc\xf8\x1d\x00\x00\xff\xff\x08\x17\xe5\x5b\xfd\x03\x00\x00")
+var _pkgUiTemplatesRule_menuHtml = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x02\xff\xbd\x54\x41\x6b\xa6\x30\x10\xbd\xf7\x57\x84\xf4\xec\xe6\xbe\xa8\xb0\xb7\x3d\x95\x52\x7a\x5f\x46\x33\x6a\xe8\x34\x91\x64\xfc\xf8\x8a\x7c\xff\xbd\xa3\x56\x51\xe1\x5b\xd8\x3d\xd4\x4b\x32\xc3\x7b\x6f\x5e\xf2\x82\xe3\x68\xb1\x71\x1e\x95\xf6\x70\xd1\xb7\xdb\x83\x92\x2f\x97\xbd\xaa\x09\x52\x2a\xa6\x76\x05\x51\x2d\x4b\xe6\xfc\x05\x63\xc2\xb5\x6c\xdc\x15\x6d\xc6\xa1\xd7\xe5\x4c\x14\xaa\x75\x1b\xb5\x0e\x9e\x41\xb4\x05\x47\x83\xb3\x1b\xe6\x88\xfa\x92\xea\x10\x2c\xc6\x1d\x46\x50\xd5\xc0\x1c\xbc\xe2\x8f\x1e\x0b\xbd\x14\xfa\x44\xe3\xd0\xb6\x84\xaa\x0e\x44\xd0\x27\xb4\x5a\x59\x60\xf8\x6a\x4f\x16\x96\xfe\xda\x86\xd8\x22\x17\xfa\x71\x61\x6b\x05\xd1\x41\x86\xd7\x1e\xbc\x45\x5b\xe8\x06\x68\xc2\xce\xdd\xc9\x7d\x0c\xb4\x8d\x3a\x58\x13\x73\x49\x48\xab\x99\x14\xb3\xe0\xe9\x43\x97\xaf\x8b\x1d\x61\xb8\x16\xd8\x05\x9f\x9b\x09\xf7\x17\xaa\x93\x39\xd9\x2c\xff\x5d\xd0\xdc\x2c\x57\x79\xe8\xc1\xe9\x5e\xab\x28\x57\xa2\x55\x17\xb1\x29\xf4\x38\xaa\x1e\xb8\x7b\x96\xc2\x5d\xd5\xed\x66\xe4\xa0\x1d\xf8\x90\x72\x03\xbb\x50\x8d\xa4\x7a\xca\xd8\xd9\xed\xfa\x4e\x03\xd6\x64\xb6\xe8\x8e\xd1\x0f\xb4\xc3\xaf\xcf\x6d\xb7\x25\x6c\xf8\x9c\x08\xb9\x52\xce\x71\xc7\x32\x10\x46\x4e\xba\xfc\x35\xaf\x93\xf1\xdc\x08\xe1\x1f\x14\xe2\x40\x28\x02\x2f\xd3\xf2\x3f\x7c\x1f\xd8\x35\xae\x9e\x9f\x85\xe8\x3c\xed\xcb\xfb\x7a\x87\xc6\x1c\xd4\x22\xdf\x31\xf7\xe9\xa7\x31\xad\xe3\x6e\xa8\x7e\xd4\xe1\xdd\xb8\xf7\x3e\x86\x0a\x2a\xc2\x0c\x7d\x6b\x78\x8e\x48\xab\xf5\xd1\xff\xa9\x08\xfc\x9b\x2e\x7f\x23\xf5\x87\xe0\x96\xf0\x8e\xb3\x72\x33\xd0\x9d\x68\x77\x45\x6e\x24\x8e\xf2\x61\x1c\xd1\x5b\xf9\x77\x7c\x02\xb3\x15\xf7\x52\x4d\x04\x00\x00")
 
 func pkgUiTemplatesRule_menuHtmlBytes() ([]byte, error) {
 	return bindataRead(
@@ -221,7 +242,7 @@ func pkgUiTemplatesRule_menuHtml() (*asset, error) {
 		return nil, err
 	}
 
-	info := bindataFileInfo{name: "pkg/ui/templates/rule_menu.html", size: 1021, mode: os.FileMode(436), modTime: time.Unix(1534430711, 0)}
+	info := bindataFileInfo{name: "pkg/ui/templates/rule_menu.html", size: 1101, mode: os.FileMode(436), modTime: time.Unix(1792212678, 0)}
 	a := &asset{bytes: bytes, info: info}
 	return a, nil
 }
@@ -942,6 +963,7 @@ var _bindata = map[string]func() (*asset, error){
 	"pkg/ui/templates/alerts.html":                                                            pkgUiTemplatesAlertsHtml,
 	"pkg/ui/templates/flags.html":                                                             pkgUiTemplatesFlagsHtml,
 	"pkg/ui/templates/graph.html":                                                             pkgUiTemplatesGraphHtml,
+	"pkg/ui/templates/notifications.html":                                                     pkgUiTemplatesNotificationsHtml,
 	"pkg/ui/templates/query_menu.html":                                                        pkgUiTemplatesQuery_menuHtml,
 	"pkg/ui/templates/rule_menu.html":                                                         pkgUiTemplatesRule_menuHtml,
 	"pkg/ui/templates/rules.html":                                                             pkgUiTemplatesRulesHtml,
@@ -1088,14 +1110,15 @@ var _bintree = &bintree{nil, map[string]*bintree{
 				}},
 			}},
 			"templates": &bintree{nil, map[string]*bintree{
-				"_base.html":      &bintree{pkgUiTemplates_baseHtml, map[string]*bintree{}},
-				"alerts.html":     &bintree{pkgUiTemplatesAlertsHtml, map[string]*bintree{}},
-				"flags.html":      &bintree{pkgUiTemplatesFlagsHtml, map[string]*bintree{}},
-				"graph.html":      &bintree{pkgUiTemplatesGraphHtml, map[string]*bintree{}},
-				"query_menu.html": &bintree{pkgUiTemplatesQuery_menuHtml, map[string]*bintree{}},
-				"rule_menu.html":  &bintree{pkgUiTemplatesRule_menuHtml, map[string]*bintree{}},
-				"rules.html":      &bintree{pkgUiTemplatesRulesHtml, map[string]*bintree{}},
-				"status.html":     &bintree{pkgUiTemplatesStatusHtml, map[string]*bintree{}},
+				"_base.html":         &bintree{pkgUiTemplates_baseHtml, map[string]*bintree{}},
+				"alerts.html":        &bintree{pkgUiTemplatesAlertsHtml, map[string]*bintree{}},
+				"flags.html":         &bintree{pkgUiTemplatesFlagsHtml, map[string]*bintree{}},
+				"graph.html":         &bintree{pkgUiTemplatesGraphHtml, map[string]*bintree{}},
+				"notifications.html": &bintree{pkgUiTemplatesNotificationsHtml, map[string]*bintree{}},
+				"query_menu.html":    &bintree{pkgUiTemplatesQuery_menuHtml, map[string]*bintree{}},
+				"rule_menu.html":     &bintree{pkgUiTemplatesRule_menuHtml, map[string]*bintree{}},
+				"rules.html":         &bintree{pkgUiTemplatesRulesHtml, map[string]*bintree{}},
+				"status.html":        &bintree{pkgUiTemplatesStatusHtml, map[string]*bintree{}},
 			}},
 		}},
 	}},
@@ -1,6 +1,7 @@
 package ui
 
 import (
+	"encoding/json"
 	"fmt"
 	"html/template"
 	"math"
@@ -9,6 +10,8 @@ import (
 	"sort"
 
 	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/improbable-eng/thanos/pkg/alert"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/route"
 	"github.com/prometheus/prometheus/rules"
@@ -19,13 +22,17 @@ type Rule struct {
 
 	ruleManager *rules.Manager
 	queryURL    string
+	alertQueue  *alert.Queue
+	deliveries  *alert.DeliveryStatuses
 }
 
-func NewRuleUI(logger log.Logger, ruleManager *rules.Manager, queryURL string) *Rule {
+func NewRuleUI(logger log.Logger, ruleManager *rules.Manager, queryURL string, alertQueue *alert.Queue, deliveries *alert.DeliveryStatuses) *Rule {
 	return &Rule{
 		BaseUI:      NewBaseUI(logger, "rule_menu.html", ruleTmplFuncs(queryURL)),
 		ruleManager: ruleManager,
 		queryURL:    queryURL,
+		alertQueue:  alertQueue,
+		deliveries:  deliveries,
 	}
 }
 
@@ -111,6 +118,41 @@ func (ru *Rule) rules(w http.ResponseWriter, r *http.Request) {
 	ru.executeTemplate(w, "rules.html", ru.ruleManager)
 }
 
+// NotificationStatus is the status of the alert notification queue and the delivery status of recently sent alerts.
+type NotificationStatus struct {
+	QueueLength   int                    `json:"queueLength"`
+	QueueCapacity int                    `json:"queueCapacity"`
+	Dropped       int                    `json:"dropped"`
+	Alerts        []alert.DeliveryStatus `json:"alerts"`
+}
+
+func (ru *Rule) notificationStatus() NotificationStatus {
+	return NotificationStatus{
+		QueueLength:   ru.alertQueue.Len(),
+		QueueCapacity: ru.alertQueue.Cap(),
+		Dropped:       ru.alertQueue.Dropped(),
+		Alerts:        ru.deliveries.Get(),
+	}
+}
+
+func (ru *Rule) notifications(w http.ResponseWriter, r *http.Request) {
+	ru.executeTemplate(w, "notifications.html", ru.notificationStatus())
+}
+
+// notificationsAPI responds with the notification status in the format of the Prometheus HTTP API.
+func (ru *Rule) notificationsAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(struct {
+		Status string             `json:"status"`
+		Data   NotificationStatus `json:"data"`
+	}{
+		Status: "success",
+		Data:   ru.notificationStatus(),
+	}); err != nil {
+		level.Error(ru.logger).Log("msg", "error writing response", "err", err)
+	}
+}
+
 func (ru *Rule) Register(r *route.Router) {
 	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/alerts", http.StatusFound)
@@ -120,6 +162,8 @@ func (ru *Rule) Register(r *route.Router) {
 
 	r.Get("/alerts", instrf("alerts", ru.alerts))
 	r.Get("/rules", instrf("rules", ru.rules))
+	r.Get("/notifications", instrf("notifications", ru.notifications))
+	r.Get("/api/v1/notifications", instrf("api_notifications", ru.notificationsAPI))
 
 	r.Get("/static/*filepath", instrf("static", ru.serveStaticAsset))
 }
@@ -0,0 +1,213 @@
+// Package usage records resource usage of queries per client identity for chargeback and abuse detection.
+//
+// The identity of a client is taken from a HTTP header or the subject of its TLS client certificate and
+// propagated to store APIs through gRPC metadata.
+package usage
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// MetadataKey is the gRPC metadata key carrying the client identity.
+const MetadataKey = "thanos-client"
+
+const (
+	// UnknownClient is the identity of clients that did not identify themselves.
+	UnknownClient = "unknown"
+	// OtherClient is the identity all clients are accounted to once the maximum number of tracked clients is reached.
+	OtherClient = "other"
+)
+
+// Resource is a type of resource used by queries.
+type Resource string
+
+const (
+	Queries         Resource = "queries"
+	Series          Resource = "series"
+	Samples         Resource = "samples"
+	EvalWallSeconds Resource = "eval_wall_seconds"
+	SeriesRequests  Resource = "series_requests"
+	FetchedBytes    Resource = "fetched_bytes"
+	Chunks          Resource = "chunks"
+)
+
+var resourceHelp = map[Resource]string{
+	Queries:         "Total number of queries executed.",
+	Series:          "Total number of series returned.",
+	Samples:         "Total number of samples returned.",
+	EvalWallSeconds: "Total wall-clock time spent evaluating queries.",
+	SeriesRequests:  "Total number of Series calls served.",
+	FetchedBytes:    "Total number of bytes fetched from the bucket.",
+	Chunks:          "Total number of chunks served.",
+}
+
+type clientKey struct{}
+
+// WithClient returns a context carrying the given client identity.
+func WithClient(ctx context.Context, client string) context.Context {
+	return context.WithValue(ctx, clientKey{}, client)
+}
+
+// ClientFromContext returns the client identity of the context. It is taken from the context itself,
+// from incoming gRPC metadata or from the subject of the client's TLS certificate, in that order.
+func ClientFromContext(ctx context.Context) string {
+	if c, ok := ctx.Value(clientKey{}).(string); ok && c != "" {
+		return c
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if c := md.Get(MetadataKey); len(c) > 0 && c[0] != "" {
+			return c[0]
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(info.State.PeerCertificates) > 0 {
+			return info.State.PeerCertificates[0].Subject.CommonName
+		}
+	}
+	return ""
+}
+
+// OutgoingContext returns a context which propagates the client identity of the given context through
+// outgoing gRPC metadata.
+func OutgoingContext(ctx context.Context) context.Context {
+	c := ClientFromContext(ctx)
+	if c == "" {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, MetadataKey, c)
+}
+
+// ClientFromHTTP returns the client identity of the HTTP request taken from the given header or from
+// the subject of the client's TLS certificate.
+func ClientFromHTTP(r *http.Request, header string) string {
+	if header != "" {
+		if c := r.Header.Get(header); c != "" {
+			return c
+		}
+	}
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		return r.TLS.PeerCertificates[0].Subject.CommonName
+	}
+	return ""
+}
+
+// Tracker accounts resource usage to client identities. Usage is exposed as metrics and periodically logged
+// as a report. To bound the cardinality of metrics, only the first maxClients identities are tracked on their own,
+// all others are accounted to OtherClient.
+// A nil Tracker does not track anything.
+type Tracker struct {
+	logger     log.Logger
+	maxClients int
+
+	mtx     sync.Mutex
+	clients map[string]struct{}
+	report  map[string]map[Resource]float64
+
+	usage map[Resource]*prometheus.CounterVec
+}
+
+// NewTracker returns a new Tracker of the given resources. Its metrics are prefixed by the given subsystem, e.g.
+// thanos_<subsystem>_usage_<resource>_total.
+func NewTracker(logger log.Logger, reg prometheus.Registerer, subsystem string, maxClients int, resources ...Resource) *Tracker {
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+	t := &Tracker{
+		logger:     logger,
+		maxClients: maxClients,
+		clients:    map[string]struct{}{},
+		report:     map[string]map[Resource]float64{},
+		usage:      map[Resource]*prometheus.CounterVec{},
+	}
+	for _, r := range resources {
+		t.usage[r] = prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "thanos",
+			Subsystem: subsystem,
+			Name:      "usage_" + string(r) + "_total",
+			Help:      resourceHelp[r],
+		}, []string{"client"})
+
+		if reg != nil {
+			reg.MustRegister(t.usage[r])
+		}
+	}
+	return t
+}
+
+// client returns the identity the given client is accounted to.
+func (t *Tracker) client(c string) string {
+	if c == "" {
+		return UnknownClient
+	}
+	if _, ok := t.clients[c]; ok {
+		return c
+	}
+	if len(t.clients) >= t.maxClients {
+		return OtherClient
+	}
+	t.clients[c] = struct{}{}
+	return c
+}
+
+// Add accounts the given amount of the resource to the client of the context.
+func (t *Tracker) Add(ctx context.Context, r Resource, v float64) {
+	if t == nil {
+		return
+	}
+	c, ok := t.usage[r]
+	if !ok {
+		return
+	}
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	client := t.client(ClientFromContext(ctx))
+	c.WithLabelValues(client).Add(v)
+
+	if _, ok := t.report[client]; !ok {
+		t.report[client] = map[Resource]float64{}
+	}
+	t.report[client][r] += v
+}
+
+// Report logs the usage of all clients since the last report.
+func (t *Tracker) Report() {
+	if t == nil {
+		return
+	}
+	t.mtx.Lock()
+	report := t.report
+	t.report = map[string]map[Resource]float64{}
+	t.mtx.Unlock()
+
+	clients := make([]string, 0, len(report))
+	for c := range report {
+		clients = append(clients, c)
+	}
+	sort.Strings(clients)
+
+	for _, c := range clients {
+		resources := make([]string, 0, len(report[c]))
+		for r := range report[c] {
+			resources = append(resources, string(r))
+		}
+		sort.Strings(resources)
+
+		kv := []interface{}{"msg", "usage report", "client", c}
+		for _, r := range resources {
+			kv = append(kv, r, report[c][Resource(r)])
+		}
+		level.Info(t.logger).Log(kv...)
+	}
+}
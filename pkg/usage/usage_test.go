@@ -0,0 +1,72 @@
+package usage
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/improbable-eng/thanos/pkg/testutil"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestClientFromContext(t *testing.T) {
+	ctx := context.Background()
+	testutil.Equals(t, "", ClientFromContext(ctx))
+
+	in := metadata.NewIncomingContext(ctx, metadata.Pairs(MetadataKey, "grafana"))
+	testutil.Equals(t, "grafana", ClientFromContext(in))
+	testutil.Equals(t, "alice", ClientFromContext(WithClient(in, "alice")))
+
+	out := OutgoingContext(WithClient(ctx, "alice"))
+	md, ok := metadata.FromOutgoingContext(out)
+	testutil.Assert(t, ok, "expected outgoing metadata")
+	testutil.Equals(t, []string{"alice"}, md.Get(MetadataKey))
+
+	// Contexts without identity are passed through as they are.
+	testutil.Equals(t, ctx, OutgoingContext(ctx))
+}
+
+func TestClientFromHTTP(t *testing.T) {
+	r := httptest.NewRequest("GET", "/api/v1/query", nil)
+	testutil.Equals(t, "", ClientFromHTTP(r, "X-Thanos-Client"))
+
+	r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: "cert-client"}}}}
+	testutil.Equals(t, "cert-client", ClientFromHTTP(r, "X-Thanos-Client"))
+
+	r.Header.Set("X-Thanos-Client", "grafana")
+	testutil.Equals(t, "grafana", ClientFromHTTP(r, "X-Thanos-Client"))
+	testutil.Equals(t, "cert-client", ClientFromHTTP(r, ""))
+}
+
+func TestTracker_Add(t *testing.T) {
+	tr := NewTracker(nil, prometheus.NewRegistry(), "test", 2, Queries, Samples)
+
+	ctx := context.Background()
+	tr.Add(WithClient(ctx, "a"), Queries, 1)
+	tr.Add(WithClient(ctx, "a"), Samples, 10)
+	tr.Add(WithClient(ctx, "b"), Queries, 1)
+	tr.Add(WithClient(ctx, "c"), Queries, 1)
+	tr.Add(WithClient(ctx, "d"), Queries, 1)
+	tr.Add(ctx, Queries, 1)
+	// Resources not tracked are ignored.
+	tr.Add(WithClient(ctx, "a"), Chunks, 1)
+
+	testutil.Equals(t, map[string]map[Resource]float64{
+		"a":           {Queries: 1, Samples: 10},
+		"b":           {Queries: 1},
+		OtherClient:   {Queries: 2},
+		UnknownClient: {Queries: 1},
+	}, tr.report)
+
+	tr.Report()
+	testutil.Equals(t, 0, len(tr.report))
+
+	// A nil tracker does not track anything.
+	var nilTracker *Tracker
+	nilTracker.Add(ctx, Queries, 1)
+	nilTracker.Report()
+}
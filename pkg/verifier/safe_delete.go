@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
@@ -41,11 +42,13 @@ func SafeDelete(ctx context.Context, logger log.Logger, bkt objstore.Bucket, bac
 		}
 	}()
 
-	if err := block.Download(ctx, logger, bkt, id, dir); err != nil {
+	// Block upload requires the directory name to be the block ID.
+	bdir := filepath.Join(dir, id.String())
+	if err := block.Download(ctx, logger, bkt, id, bdir); err != nil {
 		return errors.Wrap(err, "download from source")
 	}
 
-	if err := block.Upload(ctx, logger, backupBkt, dir); err != nil {
+	if err := block.Upload(ctx, logger, backupBkt, bdir); err != nil {
 		return errors.Wrap(err, "upload to backup")
 	}
 
@@ -11,17 +11,27 @@ fi
 
 CHECK=${1:-}
 
-commands=("compact" "query" "rule" "sidecar" "store" "bucket")
+commands=("compact" "query" "rule" "sidecar" "store" "bucket" "check" "tools")
 
 for x in "${commands[@]}"; do
     ./thanos "${x}" --help &> "docs/components/flags/${x}.txt"
 done
 
-bucketCommands=("verify" "ls")
+bucketCommands=("verify" "ls" "audit" "split" "compact")
 for x in "${bucketCommands[@]}"; do
     ./thanos bucket "${x}" --help &> "docs/components/flags/bucket_${x}.txt"
 done
 
+checkCommands=("rules" "objstore-config")
+for x in "${checkCommands[@]}"; do
+    ./thanos check "${x}" --help &> "docs/components/flags/check_${x}.txt"
+done
+
+toolsBlockCommands=("meta" "series" "dump" "index-stats")
+for x in "${toolsBlockCommands[@]}"; do
+    ./thanos tools block "${x}" --help &> "docs/components/flags/tools_block_${x}.txt"
+done
+
 # Change dir so embedmd understand the local references made in our markdown doc.
 pushd "docs/components" > /dev/null
 
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/improbable-eng/thanos/pkg/runutil"
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+)
+
+// queryResult is the result of a query against the HTTP API of Prometheus or the querier.
+type queryResult struct {
+	value    model.Value
+	warnings []string
+	// err is the error returned by the API for the query.
+	err error
+}
+
+func instantQuery(ctx context.Context, base string, q string, t time.Time) (queryResult, error) {
+	return query(ctx, base, "/api/v1/query", url.Values{
+		"query": []string{q},
+		"time":  []string{formatTime(t)},
+	})
+}
+
+func rangeQuery(ctx context.Context, base string, q string, start, end time.Time, step time.Duration) (queryResult, error) {
+	return query(ctx, base, "/api/v1/query_range", url.Values{
+		"query": []string{q},
+		"start": []string{formatTime(start)},
+		"end":   []string{formatTime(end)},
+		"step":  []string{strconv.FormatFloat(step.Seconds(), 'f', -1, 64)},
+	})
+}
+
+func formatTime(t time.Time) string {
+	return strconv.FormatFloat(float64(t.UnixNano())/1e9, 'f', -1, 64)
+}
+
+// query runs a query against the API. Parameters of the querier are set explicitly, Prometheus ignores them.
+// The returned error is only set if the request failed, errors of the query are part of the result.
+func query(ctx context.Context, base, path string, params url.Values) (queryResult, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return queryResult{}, err
+	}
+	params.Set("dedup", "true")
+	params.Set("max_source_resolution", "0s")
+
+	u.Path += path
+	u.RawQuery = params.Encode()
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return queryResult{}, err
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return queryResult{}, errors.Wrapf(err, "query %s", base)
+	}
+	defer runutil.CloseWithLogOnErr(nil, resp.Body, "query response body")
+
+	var r struct {
+		Status    string   `json:"status"`
+		ErrorType string   `json:"errorType"`
+		Error     string   `json:"error"`
+		Warnings  []string `json:"warnings"`
+		Data      struct {
+			ResultType model.ValueType `json:"resultType"`
+			Result     json.RawMessage `json:"result"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return queryResult{}, errors.Wrapf(err, "decode response of %s with status %d", base, resp.StatusCode)
+	}
+	if r.Status != "success" {
+		return queryResult{err: errors.Errorf("%s: %s", r.ErrorType, r.Error), warnings: r.Warnings}, nil
+	}
+
+	res := queryResult{warnings: r.Warnings}
+	switch r.Data.ResultType {
+	case model.ValScalar:
+		var v model.Scalar
+		err = json.Unmarshal(r.Data.Result, &v)
+		res.value = &v
+	case model.ValString:
+		var v model.String
+		err = json.Unmarshal(r.Data.Result, &v)
+		res.value = &v
+	case model.ValVector:
+		var v model.Vector
+		err = json.Unmarshal(r.Data.Result, &v)
+		res.value = v
+	case model.ValMatrix:
+		var v model.Matrix
+		err = json.Unmarshal(r.Data.Result, &v)
+		res.value = v
+	default:
+		err = fmt.Errorf("unknown result type %s", r.Data.ResultType)
+	}
+	return res, errors.Wrapf(err, "decode result of %s", base)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/common/model"
+)
+
+// compareResults returns a description of the first difference between the expected result of Prometheus and
+// the result of the querier or an empty string if they are compatible.
+// Warnings of the querier are differences as they indicate partial responses.
+func compareResults(exp, got queryResult, tolerance float64) string {
+	if len(got.warnings) > 0 {
+		return fmt.Sprintf("querier returned warnings: %s", strings.Join(got.warnings, "; "))
+	}
+	if (exp.err == nil) != (got.err == nil) {
+		return fmt.Sprintf("expected error %v, got %v", exp.err, got.err)
+	}
+	if exp.err != nil {
+		return ""
+	}
+	if exp.value == nil || got.value == nil {
+		if exp.value != got.value {
+			return fmt.Sprintf("expected %v, got %v", exp.value, got.value)
+		}
+		return ""
+	}
+	if exp.value.Type() != got.value.Type() {
+		return fmt.Sprintf("expected result type %s, got %s", exp.value.Type(), got.value.Type())
+	}
+
+	switch e := exp.value.(type) {
+	case *model.Scalar:
+		g := got.value.(*model.Scalar)
+		return compareSample("scalar", e.Timestamp, e.Value, g.Timestamp, g.Value, tolerance)
+	case *model.String:
+		g := got.value.(*model.String)
+		if e.Value != g.Value {
+			return fmt.Sprintf("expected string %q, got %q", e.Value, g.Value)
+		}
+		return ""
+	case model.Vector:
+		return compareVectors(e, got.value.(model.Vector), tolerance)
+	case model.Matrix:
+		return compareMatrices(e, got.value.(model.Matrix), tolerance)
+	}
+	return fmt.Sprintf("unknown result type %s", exp.value.Type())
+}
+
+func compareVectors(exp, got model.Vector, tolerance float64) string {
+	sort.Slice(exp, func(i, j int) bool { return exp[i].Metric.String() < exp[j].Metric.String() })
+	sort.Slice(got, func(i, j int) bool { return got[i].Metric.String() < got[j].Metric.String() })
+
+	if len(exp) != len(got) {
+		return fmt.Sprintf("expected %d series, got %d", len(exp), len(got))
+	}
+	for i := range exp {
+		if !exp[i].Metric.Equal(got[i].Metric) {
+			return fmt.Sprintf("expected series %s, got %s", exp[i].Metric, got[i].Metric)
+		}
+		if diff := compareSample(exp[i].Metric.String(), exp[i].Timestamp, exp[i].Value, got[i].Timestamp, got[i].Value, tolerance); diff != "" {
+			return diff
+		}
+	}
+	return ""
+}
+
+func compareMatrices(exp, got model.Matrix, tolerance float64) string {
+	sort.Slice(exp, func(i, j int) bool { return exp[i].Metric.String() < exp[j].Metric.String() })
+	sort.Slice(got, func(i, j int) bool { return got[i].Metric.String() < got[j].Metric.String() })
+
+	if len(exp) != len(got) {
+		return fmt.Sprintf("expected %d series, got %d", len(exp), len(got))
+	}
+	for i := range exp {
+		if !exp[i].Metric.Equal(got[i].Metric) {
+			return fmt.Sprintf("expected series %s, got %s", exp[i].Metric, got[i].Metric)
+		}
+		if len(exp[i].Values) != len(got[i].Values) {
+			return fmt.Sprintf("expected %d samples for %s, got %d", len(exp[i].Values), exp[i].Metric, len(got[i].Values))
+		}
+		for j, e := range exp[i].Values {
+			g := got[i].Values[j]
+			if diff := compareSample(exp[i].Metric.String(), e.Timestamp, e.Value, g.Timestamp, g.Value, tolerance); diff != "" {
+				return diff
+			}
+		}
+	}
+	return ""
+}
+
+func compareSample(name string, et model.Time, ev model.SampleValue, gt model.Time, gv model.SampleValue, tolerance float64) string {
+	if et != gt {
+		return fmt.Sprintf("expected sample of %s at %d, got one at %d", name, et, gt)
+	}
+	if !valuesEqual(float64(ev), float64(gv), tolerance) {
+		return fmt.Sprintf("expected value %v for %s at %d, got %v", ev, name, et, gv)
+	}
+	return ""
+}
+
+// valuesEqual returns whether the values are equal within the relative tolerance. NaN equals NaN and
+// infinite values must match exactly.
+func valuesEqual(a, b, tolerance float64) bool {
+	if math.IsNaN(a) || math.IsNaN(b) {
+		return math.IsNaN(a) && math.IsNaN(b)
+	}
+	if a == b {
+		return true
+	}
+	if math.IsInf(a, 0) || math.IsInf(b, 0) {
+		return false
+	}
+	return math.Abs(a-b) <= tolerance*math.Max(math.Abs(a), math.Abs(b))
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/improbable-eng/thanos/pkg/testutil"
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+)
+
+func TestCompareResults(t *testing.T) {
+	vector := func(vals ...float64) model.Vector {
+		var v model.Vector
+		for i, val := range vals {
+			v = append(v, &model.Sample{
+				Metric:    model.Metric{"a": model.LabelValue(fmt.Sprintf("%d", i))},
+				Timestamp: 1000,
+				Value:     model.SampleValue(val),
+			})
+		}
+		return v
+	}
+
+	for _, tcase := range []struct {
+		name       string
+		exp, got   queryResult
+		compatible bool
+	}{
+		{
+			name:       "equal vectors in different order",
+			exp:        queryResult{value: vector(1, 2)},
+			got:        queryResult{value: model.Vector{vector(1, 2)[1], vector(1, 2)[0]}},
+			compatible: true,
+		},
+		{
+			name:       "values within tolerance",
+			exp:        queryResult{value: vector(1, math.NaN())},
+			got:        queryResult{value: vector(1+1e-12, math.NaN())},
+			compatible: true,
+		},
+		{
+			name: "values outside of tolerance",
+			exp:  queryResult{value: vector(1)},
+			got:  queryResult{value: vector(1.1)},
+		},
+		{
+			name: "missing series",
+			exp:  queryResult{value: vector(1, 2)},
+			got:  queryResult{value: vector(1)},
+		},
+		{
+			name: "different labels",
+			exp:  queryResult{value: vector(1)},
+			got:  queryResult{value: model.Vector{{Metric: model.Metric{"a": "x"}, Timestamp: 1000, Value: 1}}},
+		},
+		{
+			name: "different types",
+			exp:  queryResult{value: &model.Scalar{Timestamp: 1000, Value: 1}},
+			got:  queryResult{value: vector(1)},
+		},
+		{
+			name:       "both failed",
+			exp:        queryResult{err: errors.New("a")},
+			got:        queryResult{err: errors.New("b")},
+			compatible: true,
+		},
+		{
+			name: "only querier failed",
+			exp:  queryResult{value: vector(1)},
+			got:  queryResult{err: errors.New("b")},
+		},
+		{
+			name: "partial response",
+			exp:  queryResult{value: vector(1)},
+			got:  queryResult{value: vector(1), warnings: []string{"store unavailable"}},
+		},
+		{
+			name: "matrices with different samples",
+			exp: queryResult{value: model.Matrix{{
+				Metric: model.Metric{"a": "a"},
+				Values: []model.SamplePair{{Timestamp: 1, Value: 1}, {Timestamp: 2, Value: 2}},
+			}}},
+			got: queryResult{value: model.Matrix{{
+				Metric: model.Metric{"a": "a"},
+				Values: []model.SamplePair{{Timestamp: 1, Value: 1}, {Timestamp: 3, Value: 2}},
+			}}},
+		},
+	} {
+		if tcase.compatible {
+			testutil.Equals(t, "", compareResults(tcase.exp, tcase.got, 1e-9))
+			continue
+		}
+		testutil.Assert(t, compareResults(tcase.exp, tcase.got, 1e-9) != "", "%s: expected incompatibility", tcase.name)
+	}
+}
@@ -0,0 +1,407 @@
+// Command compat checks that PromQL queries through the Thanos querier return the same results as querying
+// Prometheus directly. It starts a Prometheus server with generated data and a sidecar and querier in front of it,
+// runs a corpus of queries against both and reports the queries whose results differ.
+//
+// Like the e2e tests, it expects the thanos binary in the PATH and the Prometheus binary as configured for pkg/testutil.
+// Queries are run through the querier with deduplication enabled, without downsampling and strictly failing on partial responses.
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/improbable-eng/thanos/pkg/runutil"
+	"github.com/improbable-eng/thanos/pkg/testutil"
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/prometheus/tsdb"
+	"github.com/prometheus/tsdb/labels"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// defaultQueries is the corpus of queries run if no query file is given.
+var defaultQueries = []string{
+	`compat_temperature_celsius`,
+	`compat_requests_total`,
+	`{__name__=~"compat_.*", job="api"}`,
+	`compat_temperature_celsius offset 1h`,
+	`compat_temperature_celsius > 20`,
+	`rate(compat_requests_total[5m])`,
+	`irate(compat_requests_total[5m])`,
+	`increase(compat_requests_total[1h])`,
+	`resets(compat_requests_total[1h])`,
+	`sum by (job) (rate(compat_requests_total[5m]))`,
+	`sum without (instance) (rate(compat_requests_total[5m]))`,
+	`sum by (job) (rate(compat_requests_total{code="500"}[5m])) / sum by (job) (rate(compat_requests_total[5m]))`,
+	`compat_requests_total{code="500"} / ignoring(code) compat_requests_total{code="200"}`,
+	`max_over_time(compat_temperature_celsius[10m])`,
+	`avg_over_time(compat_temperature_celsius[10m])`,
+	`quantile_over_time(0.9, compat_temperature_celsius[30m])`,
+	`deriv(compat_temperature_celsius[10m])`,
+	`delta(compat_temperature_celsius[10m])`,
+	`predict_linear(compat_temperature_celsius[30m], 3600)`,
+	`histogram_quantile(0.9, sum by (le) (rate(compat_request_duration_seconds_bucket[5m])))`,
+	`rate(compat_request_duration_seconds_sum[5m]) / rate(compat_request_duration_seconds_count[5m])`,
+	`topk(3, compat_temperature_celsius)`,
+	`bottomk(2, rate(compat_requests_total[5m]))`,
+	`stddev(compat_temperature_celsius)`,
+	`count_values("value", round(compat_temperature_celsius))`,
+	`count(compat_flaky)`,
+	`absent(compat_nonexistent)`,
+	`label_replace(compat_temperature_celsius, "host", "$1", "instance", "(.*)")`,
+	`timestamp(compat_temperature_celsius)`,
+	`scalar(sum(compat_temperature_celsius))`,
+	`vector(1)`,
+}
+
+func main() {
+	app := kingpin.New(filepath.Base(os.Args[0]), "Checks that PromQL queries through the Thanos querier return the same results as Prometheus.")
+	queryFile := app.Flag("queries", "File with one PromQL query per line. Empty lines and lines starting with '#' are ignored. A built-in corpus is used if empty.").
+		Default("").String()
+	thanosBin := app.Flag("thanos.binary", "Thanos binary to run the sidecar and querier with.").
+		Default("thanos").String()
+	tolerance := app.Flag("tolerance", "Maximum relative difference between sample values considered equal.").
+		Default("1e-9").Float64()
+	dataRange := app.Flag("data.range", "Time range of the generated data, ending now.").
+		Default("6h").Duration()
+	dataInterval := app.Flag("data.interval", "Interval between generated samples.").
+		Default("15s").Duration()
+	step := app.Flag("query.step", "Step of range queries, which span the whole data range.").
+		Default("1m").Duration()
+	kingpin.MustParse(app.Parse(os.Args[1:]))
+
+	queries := defaultQueries
+	if *queryFile != "" {
+		var err error
+		if queries, err = readQueries(*queryFile); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+
+	failed, err := run(os.Stdout, *thanosBin, queries, *tolerance, *dataRange, *dataInterval, *step)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+func readQueries(fn string) ([]string, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, errors.Wrap(err, "open query file")
+	}
+	defer runutil.CloseWithLogOnErr(nil, f, "query file")
+
+	var queries []string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		q := strings.TrimSpace(s.Text())
+		if q == "" || strings.HasPrefix(q, "#") {
+			continue
+		}
+		queries = append(queries, q)
+	}
+	return queries, errors.Wrap(s.Err(), "read query file")
+}
+
+// run sets up Prometheus, sidecar and querier and runs all queries against Prometheus and the querier.
+// It returns the number of incompatible queries.
+func run(w io.Writer, thanosBin string, queries []string, tolerance float64, dataRange, dataInterval, step time.Duration) (failed int, err error) {
+	dir, err := ioutil.TempDir("", "thanos-compat")
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "logs of the sidecar and querier are kept in", dir)
+			return
+		}
+		if rerr := os.RemoveAll(dir); rerr != nil {
+			fmt.Fprintln(os.Stderr, "remove working directory", rerr)
+		}
+	}()
+
+	maxt := timestamp.FromTime(time.Now())
+	mint := maxt - int64(dataRange/time.Millisecond)
+
+	p, err := testutil.NewPrometheus()
+	if err != nil {
+		return 0, errors.Wrap(err, "create Prometheus")
+	}
+	// The replica label is removed by the querier's deduplication, so that its results have the same labels.
+	if err := p.SetConfig("global:\n  external_labels:\n    replica: compat\n"); err != nil {
+		return 0, errors.Wrap(err, "configure Prometheus")
+	}
+	if err := generateData(p.Appender(), mint, maxt, int64(dataInterval/time.Millisecond)); err != nil {
+		return 0, errors.Wrap(err, "generate data")
+	}
+	if err := p.Start(); err != nil {
+		return 0, errors.Wrap(err, "start Prometheus")
+	}
+	defer func() {
+		if serr := p.Stop(); serr != nil && err == nil {
+			err = errors.Wrap(serr, "stop Prometheus")
+		}
+	}()
+
+	addrs, err := freeAddrs(6)
+	if err != nil {
+		return 0, err
+	}
+	sidecarGRPC, queryHTTP := addrs[0], addrs[1]
+
+	stop, err := startThanos(dir, thanosBin,
+		[]string{"sidecar",
+			"--prometheus.url", "http://" + p.Addr(),
+			"--tsdb.path", filepath.Join(dir, "sidecar"),
+			"--grpc-address", sidecarGRPC,
+			"--http-address", addrs[2],
+			"--cluster.address", addrs[3],
+		},
+		[]string{"query",
+			"--store", sidecarGRPC,
+			"--query.replica-label", "replica",
+			"--grpc-address", addrs[4],
+			"--http-address", queryHTTP,
+			"--cluster.address", addrs[5],
+		},
+	)
+	if err != nil {
+		return 0, err
+	}
+	defer stop()
+
+	var (
+		promURL  = "http://" + p.Addr()
+		queryURL = "http://" + queryHTTP
+	)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	// Wait until the querier sees the data of the sidecar.
+	if err := runutil.Retry(time.Second, ctx.Done(), func() error {
+		res, err := instantQuery(ctx, queryURL, "count(compat_temperature_celsius)", timestamp.Time(maxt))
+		if err != nil {
+			return err
+		}
+		if v, ok := res.value.(model.Vector); res.err != nil || !ok || v.Len() == 0 {
+			return errors.New("querier has no data yet")
+		}
+		return nil
+	}); err != nil {
+		return 0, errors.Wrap(err, "wait for querier")
+	}
+
+	var (
+		start = timestamp.Time(mint)
+		end   = timestamp.Time(maxt)
+		times = []time.Time{start.Add(dataRange / 4), start.Add(dataRange / 2), end}
+	)
+	for _, q := range queries {
+		diff, err := compareQuery(context.Background(), promURL, queryURL, q, times, start, end, step, tolerance)
+		if err != nil {
+			return failed, errors.Wrapf(err, "query %q", q)
+		}
+		if diff != "" {
+			failed++
+			fmt.Fprintf(w, "FAIL %s: %s\n", q, diff)
+			continue
+		}
+		fmt.Fprintf(w, "ok   %s\n", q)
+	}
+	fmt.Fprintf(w, "%d of %d queries compatible\n", len(queries)-failed, len(queries))
+	return failed, nil
+}
+
+// compareQuery runs the query as instant query at the given times and as range query against Prometheus and
+// the querier. It returns a description of the first incompatibility or an empty string.
+func compareQuery(ctx context.Context, promURL, queryURL string, q string, times []time.Time, start, end time.Time, step time.Duration, tolerance float64) (string, error) {
+	for _, t := range times {
+		exp, err := instantQuery(ctx, promURL, q, t)
+		if err != nil {
+			return "", err
+		}
+		got, err := instantQuery(ctx, queryURL, q, t)
+		if err != nil {
+			return "", err
+		}
+		if diff := compareResults(exp, got, tolerance); diff != "" {
+			return fmt.Sprintf("instant query at %s: %s", t.Format(time.RFC3339), diff), nil
+		}
+	}
+
+	exp, err := rangeQuery(ctx, promURL, q, start, end, step)
+	if err != nil {
+		return "", err
+	}
+	got, err := rangeQuery(ctx, queryURL, q, start, end, step)
+	if err != nil {
+		return "", err
+	}
+	if diff := compareResults(exp, got, tolerance); diff != "" {
+		return "range query: " + diff, nil
+	}
+	return "", nil
+}
+
+// startThanos starts a thanos process for each of the given argument lists. Their output is written to log files in dir.
+// The returned function stops all processes.
+func startThanos(dir string, bin string, args ...[]string) (stop func(), err error) {
+	var cmds []*exec.Cmd
+	stop = func() {
+		for _, c := range cmds {
+			if err := c.Process.Signal(syscall.SIGTERM); err != nil {
+				fmt.Fprintln(os.Stderr, "stop", c.Args[1], err)
+				continue
+			}
+			_ = c.Wait()
+		}
+	}
+	for _, a := range args {
+		f, err := os.Create(filepath.Join(dir, a[0]+".log"))
+		if err != nil {
+			stop()
+			return nil, err
+		}
+		c := exec.Command(bin, a...)
+		c.Stdout, c.Stderr = f, f
+		if err := c.Start(); err != nil {
+			stop()
+			return nil, errors.Wrapf(err, "start %s", a[0])
+		}
+		cmds = append(cmds, c)
+	}
+	return stop, nil
+}
+
+func freeAddrs(n int) (addrs []string, err error) {
+	seen := map[int]struct{}{}
+	for len(addrs) < n {
+		port, err := testutil.FreePort()
+		if err != nil {
+			return nil, errors.Wrap(err, "find free port")
+		}
+		if _, ok := seen[port]; ok {
+			continue
+		}
+		seen[port] = struct{}{}
+		addrs = append(addrs, fmt.Sprintf("127.0.0.1:%d", port))
+	}
+	return addrs, nil
+}
+
+// generateData appends counters, gauges, histograms and series with gaps in the given time range.
+// Data is generated from a fixed seed, so that it is the same on every run.
+func generateData(app tsdb.Appender, mint, maxt, interval int64) error {
+	r := rand.New(rand.NewSource(1))
+
+	type series struct {
+		lset  labels.Labels
+		value func(t int64, prev float64) float64
+		// present returns whether the series has a sample at the time. It always has one if nil.
+		present func(t int64) bool
+	}
+	var all []series
+
+	counter := func(rate float64, resetAt int64) func(int64, float64) float64 {
+		return func(t int64, prev float64) float64 {
+			if resetAt > 0 && t >= resetAt && t-interval < resetAt {
+				return 0
+			}
+			return prev + rate*r.Float64()
+		}
+	}
+	for _, job := range []string{"api", "web"} {
+		for i := 0; i < 3; i++ {
+			instance := fmt.Sprintf("host-%d", i)
+			for _, code := range []string{"200", "500"} {
+				var resetAt int64
+				if i == 1 {
+					resetAt = mint + (maxt-mint)/2
+				}
+				all = append(all, series{
+					lset:  labels.FromStrings("__name__", "compat_requests_total", "job", job, "instance", instance, "code", code),
+					value: counter(10, resetAt),
+				})
+			}
+
+			phase := r.Float64() * math.Pi
+			all = append(all, series{
+				lset: labels.FromStrings("__name__", "compat_temperature_celsius", "job", job, "instance", instance),
+				value: func(t int64, _ float64) float64 {
+					return 20 + 5*math.Sin(phase+float64(t)/float64(time.Hour/time.Millisecond)) + r.Float64()
+				},
+			})
+
+			// Bucket counts are cumulative, so every bucket grows at least as much as the previous one.
+			var bucketIncs []float64
+			for j, le := range []string{"0.1", "0.5", "1", "+Inf"} {
+				j := j
+				all = append(all, series{
+					lset: labels.FromStrings("__name__", "compat_request_duration_seconds_bucket", "job", job, "instance", instance, "le", le),
+					value: func(t int64, prev float64) float64 {
+						if j == 0 {
+							bucketIncs = bucketIncs[:0]
+						}
+						inc := r.Float64() * 5
+						if j > 0 {
+							inc += bucketIncs[j-1]
+						}
+						bucketIncs = append(bucketIncs, inc)
+						return prev + inc
+					},
+				})
+			}
+			all = append(all,
+				series{
+					lset:  labels.FromStrings("__name__", "compat_request_duration_seconds_sum", "job", job, "instance", instance),
+					value: counter(3, 0),
+				},
+				series{
+					lset:  labels.FromStrings("__name__", "compat_request_duration_seconds_count", "job", job, "instance", instance),
+					value: counter(15, 0),
+				},
+			)
+		}
+	}
+	// Flaky series are only present in every other hour, alternating between instances.
+	hour := int64(time.Hour / time.Millisecond)
+	for i := int64(0); i < 2; i++ {
+		i := i
+		all = append(all, series{
+			lset:    labels.FromStrings("__name__", "compat_flaky", "instance", fmt.Sprintf("host-%d", i)),
+			value:   func(_ int64, _ float64) float64 { return 1 },
+			present: func(t int64) bool { return ((t-mint)/hour)%2 == i },
+		})
+	}
+
+	prev := make([]float64, len(all))
+	for t := mint; t <= maxt; t += interval {
+		for i, s := range all {
+			if s.present != nil && !s.present(t) {
+				continue
+			}
+			prev[i] = s.value(t, prev[i])
+			if _, err := app.Add(s.lset, t, prev[i]); err != nil {
+				return errors.Wrapf(err, "add sample of %s", s.lset)
+			}
+		}
+	}
+	return app.Commit()
+}